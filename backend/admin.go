@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/subtle"
+	"debugagent/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadConfigResponse is the body answered by POST /admin/reload-config.
+type ReloadConfigResponse struct {
+	Status          string   `json:"status"`
+	RestartRequired []string `json:"restart_required,omitempty"` // Changed settings that only take effect after a restart
+}
+
+// authorizeAdmin checks the bearer token of an /admin request against
+// server.admin_token and answers the error itself when it does not match. The
+// endpoints do not exist while no token is configured.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := config.Get().Server.AdminToken
+	if token == "" {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "Admin endpoints are disabled, set server.admin_token to enable them")
+		return false
+	}
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid admin token")
+		return false
+	}
+	return true
+}
+
+// reloadConfigHandler loads the configuration files and environment again and
+// applies them without restarting the server. An invalid configuration is
+// rejected and the current one stays in effect. Analyses already running keep
+// the configuration they started with.
+func reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	previous, err := config.Reload()
+	if err != nil {
+		logrus.Warnf("Configuration reload rejected: %v", err)
+		writeJSONError(w, http.StatusUnprocessableEntity, ErrCodeInvalidConfig, fmt.Sprintf("Configuration not reloaded: %v", err))
+		return
+	}
+
+	restart := restartRequiredSettings(previous, config.Get())
+	if len(restart) > 0 {
+		logrus.Warnf("Configuration reloaded, restart the server to apply: %s", strings.Join(restart, ", "))
+	} else {
+		logrus.Info("Configuration reloaded.")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReloadConfigResponse{Status: "reloaded", RestartRequired: restart})
+}
+
+// restartRequiredSettings lists the settings that differ between previous and
+// current but are only read at startup.
+func restartRequiredSettings(previous, current *config.Config) []string {
+	var changed []string
+	if previous.Server.Port != current.Server.Port {
+		changed = append(changed, "server.port")
+	}
+	if previous.Server.MaxConcurrentAnalyses != current.Server.MaxConcurrentAnalyses {
+		changed = append(changed, "server.max_concurrent_analyses")
+	}
+	if !reflect.DeepEqual(previous.Logging, current.Logging) {
+		changed = append(changed, "logging")
+	}
+	return changed
+}
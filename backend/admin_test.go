@@ -0,0 +1,115 @@
+package main
+
+import (
+	"debugagent/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupAdminTest installs the default configuration with the given admin
+// token, restored after the test.
+func setupAdminTest(t *testing.T, token string) *config.Config {
+	previous := config.AppConfig
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load the configuration: %v", err)
+	}
+	cfg.Server.AdminToken = token
+	config.AppConfig = cfg
+	t.Cleanup(func() {
+		config.AppConfig = previous
+	})
+	return cfg
+}
+
+func newReloadRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload-config", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestReloadConfigHandler_RequiresToken(t *testing.T) {
+	testCases := []struct {
+		name       string
+		configured string
+		provided   string
+		status     int
+		code       string
+	}{
+		{"disabled", "", "secret", http.StatusNotFound, ErrCodeNotFound},
+		{"missing token", "secret", "", http.StatusUnauthorized, ErrCodeUnauthorized},
+		{"wrong token", "secret", "guess", http.StatusUnauthorized, ErrCodeUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := setupAdminTest(t, tc.configured)
+			rr := httptest.NewRecorder()
+			reloadConfigHandler(rr, newReloadRequest(tc.provided))
+
+			var body apiErrorResponse
+			json.Unmarshal(rr.Body.Bytes(), &body)
+			if rr.Code != tc.status || body.Error.Code != tc.code {
+				t.Errorf("expected %d %s, got %d %q", tc.status, tc.code, rr.Code, rr.Body.String())
+			}
+			if config.AppConfig != cfg {
+				t.Error("expected the configuration to be left untouched")
+			}
+		})
+	}
+}
+
+func TestReloadConfigHandler_SwapsConfig(t *testing.T) {
+	previous := setupAdminTest(t, "secret")
+	previous.Analysis.MaxExplorationIterations = 3
+	t.Setenv("DEBUGAGENT_SERVER_ADMIN_TOKEN", "secret")
+	t.Setenv("DEBUGAGENT_SERVER_PORT", "9090")
+	t.Setenv("DEBUGAGENT_ANALYSIS_MAX_EXPLORATION_ITERATIONS", "9")
+	engine := NewAnalysisEngineWithClient(t.Context(), AnalyzeRequest{ProjectPath: t.TempDir()}, &fakeLLMClient{})
+
+	rr := httptest.NewRecorder()
+	reloadConfigHandler(rr, newReloadRequest("secret"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response ReloadConfigResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.RestartRequired) != 1 || response.RestartRequired[0] != "server.port" {
+		t.Errorf("expected server.port to require a restart, got %v", response.RestartRequired)
+	}
+
+	if got := config.AppConfig.Analysis.MaxExplorationIterations; got != 9 {
+		t.Errorf("expected the reloaded max_exploration_iterations 9, got %d", got)
+	}
+	if got := explorationIterations(engine.request, engine.cfg); got != 3 {
+		t.Errorf("expected the running analysis to keep 3 iterations, got %d", got)
+	}
+	if previous.Analysis.MaxExplorationIterations != 3 {
+		t.Error("expected the previous configuration to be left untouched")
+	}
+}
+
+func TestReloadConfigHandler_KeepsConfigWhenInvalid(t *testing.T) {
+	cfg := setupAdminTest(t, "secret")
+	t.Setenv("DEBUGAGENT_SERVER_ADMIN_TOKEN", "secret")
+	t.Setenv("DEBUGAGENT_ANALYSIS_MAX_EXPLORATION_ITERATIONS", "-1")
+
+	rr := httptest.NewRecorder()
+	reloadConfigHandler(rr, newReloadRequest("secret"))
+
+	var body apiErrorResponse
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if rr.Code != http.StatusUnprocessableEntity || body.Error.Code != ErrCodeInvalidConfig {
+		t.Errorf("expected 422 %s, got %d %q", ErrCodeInvalidConfig, rr.Code, rr.Body.String())
+	}
+	if config.AppConfig != cfg {
+		t.Error("expected the current configuration to stay in effect")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Machine-readable codes of the API errors. They are part of the API: clients
+// branch on them, so existing codes must not be renamed.
+const (
+	ErrCodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	ErrCodeInvalidRequest      = "INVALID_REQUEST"
+	ErrCodeMissingQuestion     = "MISSING_QUESTION"
+	ErrCodeNoFiles             = "NO_FILES"
+	ErrCodeUploadTooLarge      = "UPLOAD_TOO_LARGE"
+	ErrCodeRequestTooLarge     = "REQUEST_TOO_LARGE"
+	ErrCodeInvalidFileName     = "INVALID_FILE_NAME"
+	ErrCodeInvalidArchive      = "INVALID_ARCHIVE"
+	ErrCodeInvalidRepository   = "INVALID_REPOSITORY"
+	ErrCodeRepositoryTooLarge  = "REPOSITORY_TOO_LARGE"
+	ErrCodeCloneTimeout        = "CLONE_TIMEOUT"
+	ErrCodeCloneFailed         = "CLONE_FAILED"
+	ErrCodeNotFound            = "NOT_FOUND"
+	ErrCodeSessionBusy         = "SESSION_BUSY"
+	ErrCodeSessionsDisabled    = "SESSIONS_DISABLED"
+	ErrCodeServerBusy          = "SERVER_BUSY"
+	ErrCodeOllamaUnavailable   = "OLLAMA_UNAVAILABLE"
+	ErrCodeAnalysisFailed      = "ANALYSIS_FAILED"
+	ErrCodeCancelled           = "CANCELLED"
+	ErrCodeInternal            = "INTERNAL_ERROR"
+	ErrCodeUnsupportedProtocol = "UNSUPPORTED_PROTOCOL"
+	ErrCodeUnauthorized        = "UNAUTHORIZED"
+	ErrCodeInvalidConfig       = "INVALID_CONFIG"
+	ErrCodePathNotAllowed      = "PATH_NOT_ALLOWED"
+)
+
+// APIError is the body of every error answered by the HTTP handlers, wrapped
+// in an "error" object: {"error": {"code": "NO_FILES", "message": "..."}}.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"` // Human-readable, may change between versions
+}
+
+// apiErrorResponse is the JSON envelope of an APIError.
+type apiErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeJSONError answers status with the JSON envelope of code and message,
+// in place of http.Error.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// writeMethodNotAllowed answers a request whose method the handler does not
+// serve; allowed is the method it expects.
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only "+allowed+" method is allowed")
+}
+
+// decodeJSONRequest decodes the JSON body of r, of at most maxBytes, into v.
+// It answers 413 when the body is larger and 400 when it is not valid JSON,
+// and then returns false.
+func decodeJSONRequest(w http.ResponseWriter, r *http.Request, maxBytes int64, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes)).Decode(v)
+	if err == nil {
+		return true
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Request body too large: the limit is %d bytes", maxBytes))
+	} else {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+	}
+	return false
+}
+
+// requireQuestion trims *question and answers 400 MISSING_QUESTION when
+// nothing is left, in which case it returns false.
+func requireQuestion(w http.ResponseWriter, question *string) bool {
+	if *question = strings.TrimSpace(*question); *question == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeMissingQuestion, "Missing 'question' field")
+		return false
+	}
+	return true
+}
+
+// uploadErrorCode returns the code of a parseUploadForm failure from its status.
+func uploadErrorCode(status int) string {
+	if status == http.StatusRequestEntityTooLarge {
+		return ErrCodeUploadTooLarge
+	}
+	return ErrCodeInvalidRequest
+}
+
+// archiveErrorCode returns the code of an extractArchive error, answered with
+// archiveErrorStatus.
+func archiveErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrArchiveTooLarge):
+		return ErrCodeUploadTooLarge
+	case errors.Is(err, ErrInvalidArchive):
+		return ErrCodeInvalidArchive
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// cloneErrorCode returns the code of a cloneRepository error, answered with
+// cloneErrorStatus.
+func cloneErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidRepository):
+		return ErrCodeInvalidRepository
+	case errors.Is(err, ErrCloneTooLarge):
+		return ErrCodeRepositoryTooLarge
+	case errors.Is(err, ErrCloneTimeout):
+		return ErrCodeCloneTimeout
+	default:
+		return ErrCodeCloneFailed
+	}
+}
+
+// cloneErrorMessage returns the message of a cloneRepository error answered
+// to the client. The error output of git is left out: it would tell a caller
+// what lies behind the URLs it tries.
+func cloneErrorMessage(err error) string {
+	if cloneErrorCode(err) == ErrCodeCloneFailed {
+		return "Error cloning repository: clone failed"
+	}
+	return fmt.Sprintf("Error cloning repository: %v", err)
+}
+
+// analysisError returns the status and code of an analysis that failed with
+// err: the model server being unreachable or overloaded is told apart from
+// the other failures.
+func analysisError(err error) (int, string) {
+	if errors.Is(err, errCancelledByClient) {
+		return http.StatusConflict, ErrCodeCancelled
+	}
+	if isRetryableOllamaError(err) {
+		return http.StatusBadGateway, ErrCodeOllamaUnavailable
+	}
+	return http.StatusInternalServerError, ErrCodeAnalysisFailed
+}
+
+// writeAnalysisError answers an analysis failure with the status and code of
+// analysisError; message describes the failed step.
+func writeAnalysisError(w http.ResponseWriter, message string, err error) {
+	status, code := analysisError(err)
+	writeJSONError(w, status, code, message+": "+err.Error())
+}
+
+// writeSessionError answers a failure to create or open an analysis session.
+func writeSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSessionsDisabled):
+		writeJSONError(w, http.StatusNotImplemented, ErrCodeSessionsDisabled, err.Error())
+	case errors.Is(err, ErrSessionNotFound):
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrSessionBusy):
+		writeJSONError(w, http.StatusConflict, ErrCodeSessionBusy, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "Error opening analysis session: "+err.Error())
+	}
+}
+
+// writeServerBusy answers a request that waited too long for an analysis slot.
+func writeServerBusy(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusServiceUnavailable, ErrCodeServerBusy, "Server busy: too many analyses in progress, try again later")
+}
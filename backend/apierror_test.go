@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteJSONError_Envelope(t *testing.T) {
+	setupUploadTest(0, 0)
+	testCases := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"wrong method", httptest.NewRequest(http.MethodGet, "/analyze", nil), http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"missing question", newUploadRequestWithoutQuestion(), http.StatusBadRequest, ErrCodeMissingQuestion},
+		{"no files", newUploadRequest("/analyze", nil), http.StatusBadRequest, ErrCodeNoFiles},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			analyzeHandler(rr, tc.req)
+
+			var body apiErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON error, got %q (%v)", rr.Body.String(), err)
+			}
+			if rr.Code != tc.status || body.Error.Code != tc.code || body.Error.Message == "" {
+				t.Errorf("expected %d %s, got %d %+v", tc.status, tc.code, rr.Code, body.Error)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("expected a JSON content type, got %q", contentType)
+			}
+		})
+	}
+}
+
+// newUploadRequestWithoutQuestion builds an /analyze request with a file but
+// no question field.
+func newUploadRequestWithoutQuestion() *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("files", "main.go")
+	part.Write([]byte("package main"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestAnalyzeHandlers_RejectBlankQuestion(t *testing.T) {
+	setupUploadTest(0, 0)
+	for name, handler := range map[string]http.HandlerFunc{
+		"analyze-stream":      analyzeStreamHandler,
+		"analyze-incremental": analyzeIncrementalHandler,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			writer.WriteField("question", "  \n\t")
+			part, _ := writer.CreateFormFile("files", "main.go")
+			part.Write([]byte("package main"))
+			writer.Close()
+			req := httptest.NewRequest(http.MethodPost, "/"+name, &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Missing 'question' field") {
+				t.Errorf("expected a blank question to be rejected, got %d %q", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnalysisError(t *testing.T) {
+	testCases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("project type: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), http.StatusBadGateway, ErrCodeOllamaUnavailable},
+		{errors.New("invalid plan"), http.StatusInternalServerError, ErrCodeAnalysisFailed},
+		{errCancelledByClient, http.StatusConflict, ErrCodeCancelled},
+	}
+	for _, tc := range testCases {
+		if status, code := analysisError(tc.err); status != tc.status || code != tc.code {
+			t.Errorf("analysisError(%v) = %d %s, expected %d %s", tc.err, status, code, tc.status, tc.code)
+		}
+	}
+}
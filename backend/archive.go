@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"debugagent/config"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// archiveExpansionRatio bounds the extracted size of an archive relative to
+// server.max_upload_bytes, so that a small zip bomb cannot fill the disk.
+const archiveExpansionRatio = 20
+
+var (
+	// ErrInvalidArchive is returned for archives that are corrupted, of an
+	// unsupported type, or that contain entries escaping the extraction directory.
+	ErrInvalidArchive = errors.New("invalid archive")
+	// ErrArchiveTooLarge is returned when an archive exceeds the upload limits once extracted.
+	ErrArchiveTooLarge = errors.New("archive too large")
+
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// extractionLimits tracks the files and bytes written while extracting an archive.
+type extractionLimits struct {
+	maxFiles int   // 0 means unlimited
+	maxBytes int64 // 0 means unlimited
+	files    int
+	bytes    int64
+	skipped  int // Files not extracted because of isIgnoredUpload
+}
+
+// newExtractionLimits derives the extraction limits from the server configuration.
+func newExtractionLimits() *extractionLimits {
+	return &extractionLimits{
+		maxFiles: config.Get().Server.MaxUploadFiles,
+		maxBytes: config.Get().Server.MaxUploadBytes * archiveExpansionRatio,
+	}
+}
+
+// extractArchive extracts an uploaded .zip or .tar.gz into destDir. The type is
+// detected from the magic bytes rather than the file name, and entries are
+// streamed to disk one at a time. Entries matched by isIgnoredUpload are not
+// extracted; it returns how many were skipped.
+func extractArchive(fileHeader *multipart.FileHeader, destDir string) (int, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return 0, fmt.Errorf("could not open uploaded archive: %w", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(file, magic)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not rewind uploaded archive: %w", err)
+	}
+
+	limits := newExtractionLimits()
+	switch {
+	case bytes.HasPrefix(magic[:n], zipMagic):
+		err = extractZip(file, fileHeader.Size, destDir, limits)
+	case bytes.HasPrefix(magic[:n], gzipMagic):
+		err = extractTarGz(file, destDir, limits)
+	default:
+		err = fmt.Errorf("%w: '%s' is not a .zip or .tar.gz file", ErrInvalidArchive, fileHeader.Filename)
+	}
+	return limits.skipped, err
+}
+
+// archiveErrorStatus maps an extraction error to the HTTP status to answer with.
+func archiveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrArchiveTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidArchive):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func extractZip(file io.ReaderAt, size int64, destDir string, limits *extractionLimits) error {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	for _, entry := range reader.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			if err := createArchiveDir(destDir, entry.Name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if isIgnoredUpload(entry.Name) {
+				limits.skipped++
+				continue
+			}
+			content, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("%w: could not read '%s': %v", ErrInvalidArchive, entry.Name, err)
+			}
+			err = writeArchiveFile(destDir, entry.Name, content, limits)
+			content.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			// Symlinks and special files are skipped, they could point outside destDir
+		}
+	}
+	return nil
+}
+
+func extractTarGz(file io.Reader, destDir string, limits *extractionLimits) error {
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := createArchiveDir(destDir, header.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if isIgnoredUpload(header.Name) {
+				limits.skipped++
+				continue
+			}
+			if err := writeArchiveFile(destDir, header.Name, reader, limits); err != nil {
+				return err
+			}
+		default:
+			// Symlinks, hard links and special files are skipped, they could point outside destDir
+		}
+	}
+}
+
+// createArchiveDir creates a directory entry, which keeps empty directories visible to the agent.
+func createArchiveDir(destDir, name string) error {
+	if filepath.Clean(filepath.FromSlash(name)) == "." {
+		return nil // The archive root itself, e.g. "./" in tarballs
+	}
+	if isIgnoredUpload(path.Join(name, "_")) {
+		return nil // Inside an ignored directory such as node_modules
+	}
+	destPath, err := safeUploadPath(destDir, name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return os.MkdirAll(destPath, os.ModePerm)
+}
+
+// writeArchiveFile writes a single archive entry under destDir, closing it right away.
+func writeArchiveFile(destDir, name string, content io.Reader, limits *extractionLimits) error {
+	destPath, err := safeUploadPath(destDir, name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	limits.files++
+	if limits.maxFiles > 0 && limits.files > limits.maxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrArchiveTooLarge, limits.maxFiles)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return fmt.Errorf("could not create directory for '%s': %w", name, err)
+	}
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("could not create '%s': %w", name, err)
+	}
+	defer destFile.Close()
+
+	if limits.maxBytes > 0 {
+		// Read one byte past the remaining budget to detect an overflow
+		content = io.LimitReader(content, limits.maxBytes-limits.bytes+1)
+	}
+	written, err := io.Copy(destFile, content)
+	limits.bytes += written
+	if err != nil {
+		return fmt.Errorf("%w: could not extract '%s': %v", ErrInvalidArchive, name, err)
+	}
+	if limits.maxBytes > 0 && limits.bytes > limits.maxBytes {
+		return fmt.Errorf("%w: more than %d bytes once extracted", ErrArchiveTooLarge, limits.maxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"debugagent/config"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry describes a file (or a directory when the name ends with "/") to put in a test archive.
+type archiveEntry struct {
+	name    string
+	content string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := writer.Create(entry.name)
+		if err != nil {
+			t.Fatalf("failed to add '%s' to zip: %v", entry.name, err)
+		}
+		w.Write([]byte(entry.content))
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(entry.name, "/") {
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("failed to add '%s' to tarball: %v", entry.name, err)
+		}
+		writer.Write([]byte(entry.content))
+	}
+	writer.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// newArchiveRequest builds a multipart analysis request carrying a single archive.
+func newArchiveRequest(target, fileName string, data []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	part, _ := writer.CreateFormFile("archive", fileName)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// archiveFileHeader returns the multipart header of an uploaded archive, as the handlers see it.
+func archiveFileHeader(t *testing.T, fileName string, data []byte) *multipart.FileHeader {
+	req := newArchiveRequest("/analyze", fileName, data)
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		t.Fatalf("failed to parse multipart form: %v", err)
+	}
+	return req.MultipartForm.File["archive"][0]
+}
+
+func TestExtractArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{"cmd/main.go", "package main"},
+		{"docs/", ""},
+		{"README.md", "# Project"},
+	}
+
+	testCases := []struct {
+		name     string
+		fileName string
+		data     []byte
+	}{
+		// The names are deliberately misleading: the type comes from the magic bytes
+		{"zip", "project.bin", buildZip(t, entries)},
+		{"tar.gz", "project.zip", buildTarGz(t, entries)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setupUploadTest(0, 0)
+			destDir := t.TempDir()
+
+			if _, err := extractArchive(archiveFileHeader(t, tc.fileName, tc.data), destDir); err != nil {
+				t.Fatalf("extractArchive() returned error: %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(destDir, "cmd", "main.go"))
+			if err != nil || string(content) != "package main" {
+				t.Errorf("expected cmd/main.go to be extracted, got '%s' (%v)", content, err)
+			}
+			if info, err := os.Stat(filepath.Join(destDir, "docs")); err != nil || !info.IsDir() {
+				t.Errorf("expected the empty docs directory to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractArchive_SkipsIgnoredFiles(t *testing.T) {
+	entries := []archiveEntry{
+		{"main.go", "package main"},
+		{"node_modules/", ""},
+		{"node_modules/left-pad/index.js", "module.exports = {}"},
+		{"debug.log", "trace"},
+	}
+
+	for name, data := range map[string][]byte{
+		"zip":    buildZip(t, entries),
+		"tar.gz": buildTarGz(t, entries),
+	} {
+		t.Run(name, func(t *testing.T) {
+			setupUploadTest(0, 1) // The skipped files don't count against the limit
+			config.AppConfig.Explorer.IgnoreDirs = []string{"node_modules"}
+			config.AppConfig.Explorer.IgnoreExtensions = []string{".log"}
+			destDir := t.TempDir()
+
+			skipped, err := extractArchive(archiveFileHeader(t, "project", data), destDir)
+			if err != nil || skipped != 2 {
+				t.Fatalf("expected 2 skipped files, got %d (%v)", skipped, err)
+			}
+			if _, err := os.Stat(filepath.Join(destDir, "main.go")); err != nil {
+				t.Errorf("expected main.go to be extracted, got %v", err)
+			}
+			for _, ignored := range []string{"node_modules", "debug.log"} {
+				if _, err := os.Stat(filepath.Join(destDir, ignored)); !os.IsNotExist(err) {
+					t.Errorf("expected %s not to be extracted, got %v", ignored, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchive_KeepsDebugagentignore(t *testing.T) {
+	setupUploadTest(0, 0)
+	config.AppConfig.Explorer.IgnorePrefixes = []string{"."}
+	destDir := t.TempDir()
+
+	data := buildZip(t, []archiveEntry{{"main.go", "package main"}, {".debugagentignore", "generated/\n"}})
+	if _, err := extractArchive(archiveFileHeader(t, "project.zip", data), destDir); err != nil {
+		t.Fatalf("extractArchive() returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, ".debugagentignore")); err != nil {
+		t.Errorf("expected .debugagentignore to be extracted, got %v", err)
+	}
+}
+
+func TestExtractArchive_RejectsTraversal(t *testing.T) {
+	setupUploadTest(0, 0)
+	parentDir := t.TempDir()
+	destDir := filepath.Join(parentDir, "upload")
+	os.Mkdir(destDir, 0755)
+
+	for name, data := range map[string][]byte{
+		"zip":    buildZip(t, []archiveEntry{{"../evil.txt", "pwned"}}),
+		"tar.gz": buildTarGz(t, []archiveEntry{{"src/../../evil.txt", "pwned"}}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := extractArchive(archiveFileHeader(t, "project", data), destDir)
+			if !errors.Is(err, ErrInvalidArchive) {
+				t.Fatalf("expected ErrInvalidArchive, got: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(parentDir, "evil.txt")); !os.IsNotExist(err) {
+				t.Error("a file was written outside of the extraction directory")
+			}
+		})
+	}
+}
+
+func TestExtractArchive_Limits(t *testing.T) {
+	t.Run("too many files", func(t *testing.T) {
+		setupUploadTest(0, 2)
+		data := buildZip(t, []archiveEntry{{"a.go", "a"}, {"b.go", "b"}, {"c.go", "c"}})
+
+		_, err := extractArchive(archiveFileHeader(t, "project.zip", data), t.TempDir())
+		if !errors.Is(err, ErrArchiveTooLarge) {
+			t.Errorf("expected ErrArchiveTooLarge, got: %v", err)
+		}
+	})
+
+	t.Run("too many bytes once extracted", func(t *testing.T) {
+		setupUploadTest(10, 0) // 10 bytes upload, 200 bytes once extracted
+		data := buildTarGz(t, []archiveEntry{{"big.txt", strings.Repeat("x", 500)}})
+
+		_, err := extractArchive(archiveFileHeader(t, "project.tar.gz", data), t.TempDir())
+		if !errors.Is(err, ErrArchiveTooLarge) {
+			t.Errorf("expected ErrArchiveTooLarge, got: %v", err)
+		}
+	})
+}
+
+func TestAnalyzeHandler_RejectsUnsupportedArchive(t *testing.T) {
+	setupUploadTest(0, 0)
+	req := newArchiveRequest("/analyze", "project.rar", []byte("Rar!\x1a\x07\x00"))
+	rr := httptest.NewRecorder()
+
+	analyzeHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d (%s)", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "not a .zip or .tar.gz file") {
+		t.Errorf("unexpected error message: %s", rr.Body.String())
+	}
+}
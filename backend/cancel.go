@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errCancelledByClient is the cause of an analysis context cancelled through
+// POST /cancel/{id}.
+var errCancelledByClient = errors.New("analysis cancelled by the client")
+
+// runningAnalyses holds the context.CancelCauseFunc of each streaming
+// analysis in progress, by analysis ID (the request ID).
+var runningAnalyses sync.Map
+
+// registerAnalysis makes the analysis id cancellable through POST /cancel/{id}
+// until the returned function is called.
+func registerAnalysis(id string, cancel context.CancelCauseFunc) (unregister func()) {
+	runningAnalyses.Store(id, cancel)
+	return func() { runningAnalyses.Delete(id) }
+}
+
+// cancelAnalysis cancels the analysis id and reports whether it was running.
+func cancelAnalysis(id string) bool {
+	cancel, ok := runningAnalyses.Load(id)
+	if !ok {
+		return false
+	}
+	cancel.(context.CancelCauseFunc)(errCancelledByClient)
+	return true
+}
+
+// cancelledByClient reports whether ctx was cancelled through POST /cancel/{id}.
+func cancelledByClient(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCancelledByClient)
+}
+
+// cancelHandler cancels the streaming analysis whose ID was sent in the
+// "started" event. The analysis then ends with a "cancelled" event.
+func cancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id := r.PathValue("id")
+	if !cancelAnalysis(id) {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "No running analysis with this ID")
+		return
+	}
+	requestLogger(r.Context()).Infof("Cancellation requested for analysis %s", id)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCancelHandler_UnknownAnalysis(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cancel/{id}", cancelHandler)
+	rr := httptest.NewRecorder()
+
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/cancel/0123456789abcdef", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestAnalyzeStreamHandler_CancelByID(t *testing.T) {
+	// The model never answers: only the cancellation can end the analysis
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // The server only notices the client leaving once the body is read
+		<-r.Context().Done()
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/analyze-stream", requestIDMiddleware(analyzeStreamHandler))
+	mux.HandleFunc("/cancel/{id}", cancelHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	part, _ := writer.CreateFormFile("files", "main.go")
+	part.Write([]byte("package main"))
+	writer.Close()
+	resp, err := http.Post(server.URL+"/analyze-stream", writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var types []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event ProgressEvent
+		json.Unmarshal([]byte(data), &event)
+		types = append(types, event.Type)
+
+		if event.Type == "started" {
+			cancelResp, err := http.Post(server.URL+"/cancel/"+event.Data, "", nil)
+			if err != nil || cancelResp.StatusCode != http.StatusAccepted {
+				t.Fatalf("expected the cancellation to be accepted, got %v (%v)", cancelResp, err)
+			}
+		}
+	}
+
+	if types[0] != "started" || types[len(types)-1] != "cancelled" {
+		t.Errorf("expected the stream to start with 'started' and end with 'cancelled', got %v", types)
+	}
+	if _, running := runningAnalyses.Load(resp.Header.Get(requestIDHeader)); running {
+		t.Error("expected the analysis to be unregistered")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxCommandOutput caps the command output kept in a note. The end of the
+// output is kept, since that is where test failures and build errors show up.
+const maxCommandOutput = 4000
+
+// ErrCommandNotAllowed is returned for RUN_COMMAND steps refused by the configuration.
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+// commandsEnabled reports whether RUN_COMMAND is available to the planner.
+func commandsEnabled(cfg *config.Config) bool {
+	analysis := cfg.Analysis
+	return analysis.EnableCommands && len(analysis.AllowedCommands) > 0
+}
+
+// checkCommandAllowed splits command into its arguments and checks it against
+// analysis.allowed_commands: the command must have the same words as an
+// allowed entry, e.g. "go test ./..." is only allowed by "go test ./...".
+// Extra arguments are refused, since options such as "go test -exec sh" or
+// "npm test --script-shell=sh" would run any program. No shell is involved, so
+// shell operators are refused rather than silently passed along.
+func checkCommandAllowed(cfg *config.Config, command string) ([]string, error) {
+	if !commandsEnabled(cfg) {
+		return nil, fmt.Errorf("%w: RUN_COMMAND is disabled (see analysis.enable_commands)", ErrCommandNotAllowed)
+	}
+	if strings.ContainsAny(command, ";|&$`<>\n") {
+		return nil, fmt.Errorf("%w: shell operators are not supported in '%s'", ErrCommandNotAllowed, command)
+	}
+
+	args := strings.Fields(command)
+	for _, allowed := range cfg.Analysis.AllowedCommands {
+		if len(args) > 0 && slices.Equal(args, strings.Fields(allowed)) {
+			return args, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s' is not in analysis.allowed_commands", ErrCommandNotAllowed, command)
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit     int
+	data      []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// commandEnv returns the environment of a command: only PATH is kept from
+// the server, whose environment holds the LLM API key and the admin token,
+// and the home and tool caches point into home. The command runs the
+// project's code, which could otherwise print them.
+func commandEnv(home string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + home,
+		"TMPDIR=" + home,
+		"GOCACHE=" + filepath.Join(home, "go-build"),
+		"npm_config_cache=" + filepath.Join(home, "npm-cache"),
+	}
+}
+
+// commandNote runs an allowed command in the project directory and summarizes
+// its exit status and output, with the secrets it printed redacted. A failing
+// command is not an error: its output is precisely what the agent needs.
+func commandNote(ctx context.Context, projectPath, command string) (string, error) {
+	command = strings.Trim(command, "\"'`")
+	cfg := config.FromContext(ctx)
+	args, err := checkCommandAllowed(cfg, command)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(cfg.Analysis.CommandTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	home, err := os.MkdirTemp("", "debugagent-command-")
+	if err != nil {
+		return "", fmt.Errorf("could not run '%s': %w", command, err)
+	}
+	defer os.RemoveAll(home)
+
+	output := &tailBuffer{limit: maxCommandOutput}
+	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...)
+	cmd.Dir = projectPath
+	cmd.Env = commandEnv(home)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = 2 * time.Second // Don't hang on pipes kept open by child processes
+
+	status := "exit status 0"
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			return "", fmt.Errorf("command interrupted: %w", ctx.Err())
+		case errors.Is(cmdCtx.Err(), context.DeadlineExceeded):
+			status = fmt.Sprintf("timed out after %s", timeout)
+		case errors.As(err, &exitErr):
+			status = exitErr.Error()
+		default:
+			return "", fmt.Errorf("could not run '%s': %w", command, err)
+		}
+	}
+
+	var note strings.Builder
+	note.WriteString(fmt.Sprintf("Command '%s' (%s):", command, status))
+	if output.truncated {
+		note.WriteString("\n...(output truncated)")
+	}
+	if text := strings.TrimSpace(string(output.data)); text != "" {
+		text, _ = redactSecrets(cfg, text)
+		note.WriteString("\n" + text)
+	} else {
+		note.WriteString(" no output.")
+	}
+	return note.String(), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The final answer ends with a self-assessment of the model: how confident
+// it is, and what it could not determine from the files it saw. Both are
+// parsed out of the answer and returned as separate fields, so that users
+// can tell a grounded answer from a guess.
+
+// Confidence levels of the self-assessment.
+const (
+	confidenceHigh   = "high"
+	confidenceMedium = "medium"
+	confidenceLow    = "low"
+)
+
+var (
+	// confidenceLine matches the "Confidence: <level>" line of the
+	// self-assessment, with the markdown decorations models tend to add.
+	confidenceLine = regexp.MustCompile(`(?im)^[\s>*_#-]*confidence[\s*_]*:[\s*_]*(high|medium|low)\b.*$`)
+	// unresolvedLine matches the "Not determined: ..." line that follows it.
+	unresolvedLine = regexp.MustCompile(`(?im)^[\s>*_#-]*not determined[\s*_]*:[\s*_]*`)
+	// nothingUnresolved matches the answers meaning that nothing is left.
+	nothingUnresolved = regexp.MustCompile(`(?i)^(nothing|none|n/a|-)\.?$`)
+)
+
+// selfAssessmentInstruction is the part of the synthesis prompt asking for
+// the self-assessment. cutShort tells the model that the exploration ended on
+// errors or a budget rather than because it was complete.
+func selfAssessmentInstruction(cutShort bool) string {
+	instruction := `After the answer, end with exactly these two lines, labels in English:
+Confidence: high, medium or low (how well the files seen support the answer)
+Not determined: what you could not determine from the context, or "nothing"`
+	if cutShort {
+		instruction += "\nThe exploration was cut short before it was complete: the confidence cannot be high."
+	}
+	return instruction
+}
+
+// parseSelfAssessment splits a final answer into the answer proper and its
+// self-assessment. The confidence is empty when the model did not give one,
+// in which case the answer is returned unchanged.
+func parseSelfAssessment(answer string) (body, confidence, unresolved string) {
+	matches := confidenceLine.FindAllStringSubmatchIndex(answer, -1)
+	if len(matches) == 0 {
+		return answer, "", ""
+	}
+	last := matches[len(matches)-1]
+	confidence = strings.ToLower(answer[last[2]:last[3]])
+
+	// The self-assessment is the end of the answer
+	body = strings.TrimRight(answer[:last[0]], " \t\n")
+	body = strings.TrimRight(strings.TrimSuffix(body, "---"), " \t\n")
+
+	if loc := unresolvedLine.FindStringIndex(answer[last[1]:]); loc != nil {
+		unresolved = strings.TrimSpace(strings.Trim(strings.TrimSpace(answer[last[1]+loc[1]:]), "*_"))
+		if nothingUnresolved.MatchString(unresolved) {
+			unresolved = ""
+		}
+	}
+	return body, confidence, unresolved
+}
+
+// capConfidence lowers a high confidence to medium when the exploration was
+// cut short: the model is told so, but does not always take it into account.
+func capConfidence(confidence string, cutShort bool) string {
+	if cutShort && confidence == confidenceHigh {
+		return confidenceMedium
+	}
+	return confidence
+}
+
+// selfAssessmentStream forwards the tokens of a streamed final answer to emit,
+// holding back what parseSelfAssessment would strip: the text from a line that
+// is, or may still become, the "Confidence:" line, and the blank lines and
+// separator before it. Streaming clients thus never see the self-assessment,
+// which they get apart in the "result" event.
+type selfAssessmentStream struct {
+	emit    func(token string)
+	answer  strings.Builder
+	emitted int // Bytes of answer already passed to emit
+}
+
+// write adds a token to the answer and emits what can no longer be part of
+// the self-assessment.
+func (s *selfAssessmentStream) write(token string) {
+	s.answer.WriteString(token)
+	text := s.answer.String()
+	if end := selfAssessmentSafeEnd(text, s.emitted); end > s.emitted {
+		s.emit(text[s.emitted:end])
+		s.emitted = end
+	}
+}
+
+// finish emits the rest of the answer once the stream is over: what was held
+// back for a self-assessment that did not come.
+func (s *selfAssessmentStream) finish() {
+	text := s.answer.String()
+	body, _, _ := parseSelfAssessment(text)
+	if len(body) > s.emitted && strings.HasPrefix(body, text[:s.emitted]) {
+		s.emit(body[s.emitted:])
+		s.emitted = len(body)
+	}
+}
+
+// String returns the whole answer received, self-assessment included.
+func (s *selfAssessmentStream) String() string {
+	return s.answer.String()
+}
+
+// selfAssessmentSafeEnd returns how much of the streamed text can be emitted
+// whatever follows: the text before the last confidence line, or before the
+// last line if it may still become one, without the blank lines and the
+// "---" that parseSelfAssessment trims before it. Confidence lines can only
+// appear after the line of from, since nothing past one is ever emitted.
+func selfAssessmentSafeEnd(text string, from int) int {
+	lineStart := strings.LastIndex(text[:from], "\n") + 1
+	cut := len(text)
+	if matches := confidenceLine.FindAllStringIndex(text[lineStart:], -1); len(matches) > 0 {
+		cut = lineStart + matches[len(matches)-1][0]
+	} else if last := strings.LastIndex(text, "\n") + 1; mayStartSelfAssessment(text[last:]) {
+		cut = last
+	}
+	body := strings.TrimRight(text[:cut], " \t\n")
+	return len(strings.TrimRight(strings.TrimSuffix(body, "---"), " \t\n"))
+}
+
+// mayStartSelfAssessment reports whether the unfinished line may still turn
+// out to be the "Confidence:" line matched by confidenceLine.
+func mayStartSelfAssessment(line string) bool {
+	const label = "confidence"
+	rest := strings.ToLower(strings.TrimLeft(line, " \t\r>*_#-"))
+	if len(rest) <= len(label) {
+		return strings.HasPrefix(label, rest)
+	}
+	if !strings.HasPrefix(rest, label) {
+		return false
+	}
+	rest = strings.TrimLeft(rest[len(label):], " \t*_")
+	return rest == "" || strings.HasPrefix(rest, ":")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSelfAssessment(t *testing.T) {
+	tests := []struct {
+		name       string
+		answer     string
+		body       string
+		confidence string
+		unresolved string
+	}{
+		{
+			name:       "plain lines",
+			answer:     "The token expires.\n\nConfidence: high\nNot determined: the DB schema",
+			body:       "The token expires.",
+			confidence: confidenceHigh,
+			unresolved: "the DB schema",
+		},
+		{
+			name:       "markdown decorations",
+			answer:     "The token expires.\n\n---\n**Confidence:** Medium\n**Not determined:** nothing.",
+			body:       "The token expires.",
+			confidence: confidenceMedium,
+		},
+		{
+			name:       "last line wins",
+			answer:     "Confidence: high is what the comment claims.\nConfidence: low (no test covers it)\nNot determined: where it is called",
+			body:       "Confidence: high is what the comment claims.",
+			confidence: confidenceLow,
+			unresolved: "where it is called",
+		},
+		{
+			name:   "no self-assessment",
+			answer: "The token expires.\nNot determined: none",
+			body:   "The token expires.\nNot determined: none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, confidence, unresolved := parseSelfAssessment(tt.answer)
+			if body != tt.body || confidence != tt.confidence || unresolved != tt.unresolved {
+				t.Errorf("parseSelfAssessment() = (%q, %q, %q), want (%q, %q, %q)", body, confidence, unresolved, tt.body, tt.confidence, tt.unresolved)
+			}
+		})
+	}
+}
+
+func TestSelfAssessmentStream(t *testing.T) {
+	answers := []string{
+		"The token expires.\n\nConfidence: high\nNot determined: the DB schema",
+		"The token expires.\n\n---\n**Confidence:** Medium\n**Not determined:** nothing.",
+		"Confidence: high is what the comment claims.\nConfidence: low (no test covers it)\nNot determined: where it is called",
+		"The config is confidential:\n- Configure it with `confidence: 3`\n\n---\nEnd.",
+		"The token expires.\nConf",
+	}
+	for _, answer := range answers {
+		body, _, _ := parseSelfAssessment(answer)
+		// Every split of the answer into tokens of a given size
+		for size := 1; size <= 8; size++ {
+			var emitted strings.Builder
+			stream := &selfAssessmentStream{emit: func(token string) { emitted.WriteString(token) }}
+			for i := 0; i < len(answer); i += size {
+				stream.write(answer[i:min(i+size, len(answer))])
+			}
+			stream.finish()
+			if emitted.String() != body {
+				t.Errorf("tokens of %d bytes of %q: emitted %q, want %q", size, answer, emitted.String(), body)
+			}
+			if stream.String() != answer {
+				t.Errorf("expected the whole answer to be kept, got %q", stream.String())
+			}
+		}
+	}
+}
+
+func TestCapConfidence(t *testing.T) {
+	if got := capConfidence(confidenceHigh, true); got != confidenceMedium {
+		t.Errorf("expected a cut short exploration to cap high to medium, got %s", got)
+	}
+	for _, level := range []string{confidenceHigh, confidenceMedium, confidenceLow, ""} {
+		if got := capConfidence(level, false); got != level {
+			t.Errorf("expected %q to be kept after a complete exploration, got %q", level, got)
+		}
+	}
+	if got := capConfidence(confidenceLow, true); got != confidenceLow {
+		t.Errorf("expected low to be kept, got %s", got)
+	}
+}
+
+func TestRunAnalysis_SelfAssessment(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		answer:      "The entry point is in main.go\n\nConfidence: high\nNot determined: the DB schema",
+	}
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"}, client)
+
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+	if result.Answer != "The entry point is in main.go" || result.Confidence != confidenceHigh || result.Unresolved != "the DB schema" {
+		t.Errorf("expected the self-assessment apart from the answer, got %+v", result)
+	}
+	if !strings.Contains(client.finalPrompt, "Confidence: high, medium or low") || strings.Contains(client.finalPrompt, "cut short") {
+		t.Errorf("expected the self-assessment instruction in the final prompt, got:\n%s", client.finalPrompt)
+	}
+}
+
+func TestRunAnalysis_SelfAssessmentCutShort(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	config.AppConfig.Analysis.MaxExplorationIterations = 1
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		answer:      "The entry point is in main.go\nConfidence: high\nNot determined: nothing",
+		plans:       []string{`[{"action": "READ_FILE", "argument": "main.go"}]`},
+	}
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"}, client)
+
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+	// The iterations ran out before the planner finished
+	if result.Confidence != confidenceMedium || result.Unresolved != "" {
+		t.Errorf("expected the confidence to be capped to medium, got %+v", result)
+	}
+	if !strings.Contains(client.finalPrompt, "The exploration was cut short") {
+		t.Errorf("expected the model to be told the exploration was cut short, got:\n%s", client.finalPrompt)
+	}
+}
+
+func TestRunStreamingAnalysis_SelfAssessment(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	engine := NewStreamingAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"},
+		&fakeLLMClient{projectType: "Go CLI", chunks: []string{"In main.go\n", "Confidence: low\n", "Not determined: the flags"}})
+	rr := httptest.NewRecorder()
+	engine.RunStreamingAnalysis(sseSink{rr})
+
+	body := rr.Body.String()
+	for _, expected := range []string{`"data":"In main.go"`, `"confidence":"low"`, `"unresolved":"the flags"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %s in the result event, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestRunStreamingAnalysis_SelfAssessmentNotStreamed(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	_, events := runStreamingEngine(t, projectDir,
+		&fakeLLMClient{projectType: "Go CLI", chunks: []string{"In main", ".go\n\nConf", "idence: lo", "w\nNot determined", ": the flags"}})
+
+	var streamed strings.Builder
+	for _, event := range events {
+		if event.Type == "token" {
+			streamed.WriteString(event.Data)
+		}
+	}
+	if streamed.String() != "In main.go" {
+		t.Errorf("expected the self-assessment to be held back from the tokens, got %q", streamed.String())
+	}
+}
@@ -2,31 +2,161 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // ServerConfig defines the server configuration.
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port                  int      `yaml:"port"`
+	AllowedRoots          []string `yaml:"allowed_roots"`           // Base directories local project paths must live under
+	AllowLocalPaths       bool     `yaml:"allow_local_paths"`       // Whether /analyze accepts a project_path instead of an upload
+	MaxUploadBytes        int64    `yaml:"max_upload_bytes"`        // Maximum size of an upload request body, 0 means unlimited
+	MaxUploadFiles        int      `yaml:"max_upload_files"`        // Maximum number of files per upload, 0 means unlimited
+	MaxConcurrentAnalyses int      `yaml:"max_concurrent_analyses"` // Analyses running at once, the others are queued; 0 means unlimited
+	QueueTimeoutSeconds   int      `yaml:"queue_timeout_seconds"`   // Maximum time spent queued before answering 503, 0 waits indefinitely
+	JobRetentionMinutes   int      `yaml:"job_retention_minutes"`   // How long finished /analyze-async jobs stay queryable, 0 keeps them until restart
+	MaxQueuedJobs         int      `yaml:"max_queued_jobs"`         // /analyze-async jobs waiting for a slot, further ones get a 503; 0 means unlimited
+	CallbackAllowedHosts  []string `yaml:"callback_allowed_hosts"`  // Hosts a callback_url may target even on a private or loopback address
+	AdminToken            string   `yaml:"admin_token"`             // Bearer token of the /admin endpoints, empty disables them
 }
 
 // OllamaConfig defines the Ollama configuration.
 type OllamaConfig struct {
-	Host  string `yaml:"host"`
-	Model string `yaml:"model"`
+	Host                  string        `yaml:"host"`
+	Model                 string        `yaml:"model"`
+	RequestTimeoutSeconds int           `yaml:"request_timeout_seconds"` // 0 disables the timeout
+	MaxRetries            int           `yaml:"max_retries"`             // Retries for transient failures
+	KeepAlive             string        `yaml:"keep_alive"`              // How long the model stays loaded after a request, e.g. "30m"; -1 keeps it loaded, empty uses the server default
+	Options               OptionsConfig `yaml:"options"`                 // Generation parameters
+}
+
+// KeepAliveDuration returns keep_alive in the form sent to Ollama: a plain
+// number of seconds such as -1 gets an "s" suffix, durations are kept as is.
+// It fails for values that are neither.
+func (o OllamaConfig) KeepAliveDuration() (string, error) {
+	keepAlive := strings.TrimSpace(o.KeepAlive)
+	if keepAlive == "" {
+		return "", nil
+	}
+	if _, err := strconv.Atoi(keepAlive); err == nil {
+		return keepAlive + "s", nil
+	}
+	if _, err := time.ParseDuration(keepAlive); err != nil {
+		return "", fmt.Errorf("%q is neither a number of seconds nor a duration such as \"30m\"", o.KeepAlive)
+	}
+	return keepAlive, nil
+}
+
+// GenerationOptions are the sampling parameters sent to Ollama. A nil field
+// keeps the model's own default.
+type GenerationOptions struct {
+	Temperature *float64 `yaml:"temperature"`
+	TopP        *float64 `yaml:"top_p"`
+	NumPredict  *int     `yaml:"num_predict"` // Maximum number of generated tokens
+	Seed        *int     `yaml:"seed"`        // Fixed seed for reproducible answers
+}
+
+// OptionsConfig holds the generation parameters of every request and their
+// overrides for the planning and final synthesis phases.
+type OptionsConfig struct {
+	GenerationOptions `yaml:",inline"`
+	Planning          GenerationOptions `yaml:"planning"`  // Exploration plans
+	Synthesis         GenerationOptions `yaml:"synthesis"` // Final answer
+}
+
+// IsZero reports whether no parameter is set.
+func (o GenerationOptions) IsZero() bool {
+	return o.Temperature == nil && o.TopP == nil && o.NumPredict == nil && o.Seed == nil
+}
+
+// Merge returns o with the parameters set in override replaced.
+func (o GenerationOptions) Merge(override GenerationOptions) GenerationOptions {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.NumPredict != nil {
+		o.NumPredict = override.NumPredict
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	return o
+}
+
+// ForPhase returns the parameters of phase ("planning", "synthesis"); any
+// other phase gets the common parameters.
+func (c OptionsConfig) ForPhase(phase string) GenerationOptions {
+	switch phase {
+	case "planning":
+		return c.GenerationOptions.Merge(c.Planning)
+	case "synthesis":
+		return c.GenerationOptions.Merge(c.Synthesis)
+	default:
+		return c.GenerationOptions
+	}
+}
+
+// LLMConfig selects the LLM provider used by the analysis engine.
+type LLMConfig struct {
+	Provider string `yaml:"provider"` // "ollama" (default) or "openai"
+	BaseURL  string `yaml:"base_url"` // OpenAI-compatible API root, e.g. "https://api.openai.com/v1"
+	APIKey   string `yaml:"api_key"`  // Sent as a bearer token, empty sends none
+	Model    string `yaml:"model"`    // Model used with the openai provider
 }
 
 // AnalysisConfig defines the analysis parameters.
 type AnalysisConfig struct {
-	MaxExplorationIterations int `yaml:"max_exploration_iterations"`
-	MaxDirectoryDepth        int `yaml:"max_directory_depth"`
-	MaxFileReadSize          int `yaml:"max_file_read_size"`
-	MaxPromptLength          int `yaml:"max_prompt_length"`
-	MaxFileRetryAttempts     int `yaml:"max_file_retry_attempts"`
+	MaxExplorationIterations   int                 `yaml:"max_exploration_iterations"`
+	MaxDirectoryDepth          int                 `yaml:"max_directory_depth"`
+	MaxFileReadSize            int                 `yaml:"max_file_read_size"`
+	TruncationHeadRatio        *float64            `yaml:"truncation_head_ratio"`  // Share of a file over max_file_read_size kept from its start, the rest from its end; nil keeps half
+	TruncationHeadRatios       map[string]float64  `yaml:"truncation_head_ratios"` // Overrides of truncation_head_ratio by file extension, without the dot
+	MaxPromptLength            int                 `yaml:"max_prompt_length"`      // In characters, only used when MaxContextTokens is 0
+	MaxContextTokens           int                 `yaml:"max_context_tokens"`     // Prompt budget in estimated tokens
+	MaxFileRetryAttempts       int                 `yaml:"max_file_retry_attempts"`
+	MaxRetainedFiles           int                 `yaml:"max_retained_files"`             // 0 means unlimited
+	MaxRetainedBytes           int                 `yaml:"max_retained_bytes"`             // 0 means unlimited
+	CacheDir                   string              `yaml:"cache_dir"`                      // Knowledge base cache directory, empty disables it
+	TraceDir                   string              `yaml:"trace_dir"`                      // Directory of the plan traces, one JSON Lines file per analysis, empty only logs them
+	StallIterations            int                 `yaml:"stall_iterations"`               // Repeated iterations without progress before stopping, 0 disables it
+	ForceFinishIterations      int                 `yaml:"force_finish_iterations"`        // Iterations in a row without a new file read nor a new note before forcing FINISH, 0 disables it
+	EnableCommands             bool                `yaml:"enable_commands"`                // Opt-in for the RUN_COMMAND action
+	AllowedCommands            []string            `yaml:"allowed_commands"`               // Commands RUN_COMMAND may execute, as written, e.g. "go test ./..."
+	CommandTimeoutSeconds      int                 `yaml:"command_timeout_seconds"`        // Timeout of a single RUN_COMMAND
+	MaxTotalDurationSeconds    int                 `yaml:"max_total_duration_seconds"`     // Time budget of the exploration before the final answer, 0 means unlimited
+	IncrementalIterations      int                 `yaml:"incremental_iterations"`         // Exploration iterations when re-analyzing a session, 0 uses max_exploration_iterations
+	SessionTTLMinutes          int                 `yaml:"session_ttl_minutes"`            // Sessions unused for longer are deleted, 0 keeps them forever
+	DocFiles                   []string            `yaml:"doc_files"`                      // Glob patterns of the documentation files tried in order, the first 3 found are read; empty tries README.md, README.txt and README.rst
+	BootstrapFiles             []string            `yaml:"bootstrap_files"`                // Glob patterns of files read before the exploration, relative to the project root
+	EntryPoints                map[string][]string `yaml:"entry_points"`                   // Glob patterns of the likely entry points by language (lowercase, as in the language breakdown), listed to the planner
+	ReadEntryPoints            bool                `yaml:"read_entry_points"`              // Also read the entry points found before the exploration
+	DetectTestFiles            bool                `yaml:"detect_test_files"`              // List the test files to the planner and point it to them for questions about failures
+	ExtractSymbols             bool                `yaml:"extract_symbols"`                // Map the exported functions and types of the Go and JS/TS files for the planner
+	ProjectOverview            bool                `yaml:"project_overview"`               // Have the model write an overview of the project once, heading every context summary
+	ReadConcurrency            int                 `yaml:"read_concurrency"`               // Files of a plan read at once, 0 or 1 reads them one by one
+	ForbiddenFiles             []string            `yaml:"forbidden_files"`                // Glob patterns of files never read nor shown to the model (secrets)
+	RedactSecrets              bool                `yaml:"redact_secrets"`                 // Mask keys, tokens and passwords in the file contents sent to the model
+	RedactionPatterns          []string            `yaml:"redaction_patterns"`             // Extra regular expressions of secrets, a group named "secret" limits the mask to it
+	MaxAnalysisChars           int                 `yaml:"max_analysis_chars"`             // Length asked for and kept of each ANALYZE result, 0 means unlimited
+	FinalSystemPrompt          string              `yaml:"final_system_prompt"`            // System prompt of the final answer, empty uses the built-in one
+	MaxExcerptChars            int                 `yaml:"max_excerpt_chars"`              // Characters of each file excerpt in the context summary, 0 leaves only the prompt budget
+	FullContentsMaxBytes       int                 `yaml:"full_contents_max_bytes"`        // Read files totalling at most this size are sent whole to the final answer instead of excerpts, 0 always sends excerpts
+	ProjectTypeCacheSize       int                 `yaml:"project_type_cache_size"`        // Project types detected by the model kept in memory, 0 disables the cache
+	ProjectTypeCacheTTLMinutes int                 `yaml:"project_type_cache_ttl_minutes"` // Lifetime of a cached project type, 0 means no expiry
+	SearchIndexMaxEntries      int                 `yaml:"search_index_max_entries"`       // Word/file pairs kept by the SEARCH index, files beyond are scanned at each search; 0 disables the index
+	MaxStepsPerPlan            int                 `yaml:"max_steps_per_plan"`             // Steps of a plan executed per iteration, the others are dropped; 0 means unlimited
+	RelevanceScanBytes         int                 `yaml:"relevance_scan_bytes"`           // Bytes at the start of each file read scanned when ranking the files by relevance, 0 scans them whole
 }
 
 // ExplorerConfig defines the file explorer configuration.
@@ -34,6 +164,17 @@ type ExplorerConfig struct {
 	IgnoreDirs       []string `yaml:"ignore_dirs"`
 	IgnorePrefixes   []string `yaml:"ignore_prefixes"`
 	IgnoreExtensions []string `yaml:"ignore_extensions"`
+	BinaryThreshold  float64  `yaml:"binary_threshold"` // Share of non-printable characters above which a file is binary, 0 uses the default
+	ShowFileSizes    bool     `yaml:"show_file_sizes"`  // Sizes of the files in the structure sent to the model
+	MaxEntries       int      `yaml:"max_entries"`      // Entries collected in the project structure, breadth first; 0 means unlimited
+}
+
+// GitConfig limits the repositories cloned by /analyze-git.
+type GitConfig struct {
+	AllowSSH            bool     `yaml:"allow_ssh"`             // Accept ssh:// and git@host:path URLs besides https
+	CloneTimeoutSeconds int      `yaml:"clone_timeout_seconds"` // Time allowed for the clone, 0 means unlimited
+	MaxCloneBytes       int64    `yaml:"max_clone_bytes"`       // Disk space the clone may take, 0 means unlimited
+	AllowedHosts        []string `yaml:"allowed_hosts"`         // Hosts a repo_url may target even on a private or loopback address
 }
 
 // LoggingConfig defines the logging configuration.
@@ -47,25 +188,91 @@ type LoggingConfig struct {
 type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Ollama   OllamaConfig   `yaml:"ollama"`
+	LLM      LLMConfig      `yaml:"llm"`
 	Analysis AnalysisConfig `yaml:"analysis"`
 	Explorer ExplorerConfig `yaml:"explorer"`
+	Git      GitConfig      `yaml:"git"`
 	Logging  LoggingConfig  `yaml:"logging"`
 }
 
-// AppConfig holds the loaded configuration.
+// AppConfig holds the loaded configuration. Code running while the server
+// serves requests reads it through Get, since Reload may replace it at any
+// time; it is only assigned directly before the server starts and in tests.
 var AppConfig *Config
 
+// mu guards AppConfig, replaced by LoadConfig and Reload.
+var mu sync.RWMutex
+
+// Get returns the configuration currently in effect. Reload replaces it with
+// a new Config instead of modifying it, so the returned snapshot keeps its
+// values for as long as the caller holds it; it must not be modified.
+func Get() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return AppConfig
+}
+
+// contextKey is the key of the configuration carried by a context.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying cfg, so that the code running an
+// analysis keeps reading the configuration it started with across reloads.
+func NewContext(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, contextKey{}, cfg)
+}
+
+// FromContext returns the configuration carried by ctx, or the one currently
+// in effect if ctx carries none.
+func FromContext(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(contextKey{}).(*Config); ok && cfg != nil {
+		return cfg
+	}
+	return Get()
+}
+
 // LoadConfig loads the configuration from file and environment variables.
 func LoadConfig() error {
+	cfg, err := Load()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	AppConfig = cfg
+	mu.Unlock()
+	return nil
+}
+
+// Reload loads the configuration again and, if it is valid, replaces
+// AppConfig with it. On error the current configuration stays in effect. It
+// returns the previous configuration.
+func Reload() (*Config, error) {
+	cfg, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	previous := AppConfig
+	AppConfig = cfg
+	return previous, nil
+}
+
+// Load reads the configuration from config.default.yaml, config.yaml and the
+// DEBUGAGENT_* environment variables without applying it.
+func Load() (*Config, error) {
 	v := viper.New()
+	v.SetConfigType("yaml") // ReadConfig cannot infer the type of a buffer
 
 	// Set default configuration file
 	defaultConfig, err := os.ReadFile("config.default.yaml")
 	if err != nil {
-		return fmt.Errorf("could not read default config file: %w", err)
+		return nil, fmt.Errorf("could not read default config file: %w", err)
 	}
 	if err := v.ReadConfig(bytes.NewBuffer(defaultConfig)); err != nil {
-		return fmt.Errorf("could not parse default config: %w", err)
+		return nil, fmt.Errorf("could not parse default config: %w", err)
 	}
 
 	// Set up viper to look for a config file named "config.yaml"
@@ -76,7 +283,7 @@ func LoadConfig() error {
 	// Attempt to read the user-provided config file and merge it
 	if err := v.MergeInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return fmt.Errorf("could not read user config file: %w", err)
+			return nil, fmt.Errorf("could not read user config file: %w", err)
 		}
 	}
 
@@ -85,23 +292,135 @@ func LoadConfig() error {
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
-	// Unmarshal the configuration into the AppConfig struct
+	// Unmarshal the configuration into a Config struct
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
-		return fmt.Errorf("could not unmarshal config: %w", err)
+		return nil, fmt.Errorf("could not unmarshal config: %w", err)
 	}
 
 	// Workaround: Manual assignment for analysis section due to Viper unmarshal issue
 	if cfg.Analysis.MaxPromptLength == 0 {
 		cfg.Analysis.MaxPromptLength = v.GetInt("analysis.max_prompt_length")
+		cfg.Analysis.MaxContextTokens = v.GetInt("analysis.max_context_tokens")
 		cfg.Analysis.MaxFileReadSize = v.GetInt("analysis.max_file_read_size")
+		if isSet(v, "analysis.truncation_head_ratio") {
+			ratio := v.GetFloat64("analysis.truncation_head_ratio")
+			cfg.Analysis.TruncationHeadRatio = &ratio
+		}
+		cfg.Analysis.TruncationHeadRatios = floatMap(v, "analysis.truncation_head_ratios")
 		cfg.Analysis.MaxExplorationIterations = v.GetInt("analysis.max_exploration_iterations")
 		cfg.Analysis.MaxDirectoryDepth = v.GetInt("analysis.max_directory_depth")
+		cfg.Analysis.MaxFileRetryAttempts = v.GetInt("analysis.max_file_retry_attempts")
+		cfg.Analysis.MaxRetainedFiles = v.GetInt("analysis.max_retained_files")
+		cfg.Analysis.MaxRetainedBytes = v.GetInt("analysis.max_retained_bytes")
+		cfg.Analysis.CacheDir = v.GetString("analysis.cache_dir")
+		cfg.Analysis.TraceDir = v.GetString("analysis.trace_dir")
+		cfg.Analysis.StallIterations = v.GetInt("analysis.stall_iterations")
+		cfg.Analysis.ForceFinishIterations = v.GetInt("analysis.force_finish_iterations")
+		cfg.Analysis.EnableCommands = v.GetBool("analysis.enable_commands")
+		cfg.Analysis.AllowedCommands = v.GetStringSlice("analysis.allowed_commands")
+		cfg.Analysis.CommandTimeoutSeconds = v.GetInt("analysis.command_timeout_seconds")
+		cfg.Analysis.MaxTotalDurationSeconds = v.GetInt("analysis.max_total_duration_seconds")
+		cfg.Analysis.IncrementalIterations = v.GetInt("analysis.incremental_iterations")
+		cfg.Analysis.SessionTTLMinutes = v.GetInt("analysis.session_ttl_minutes")
+		cfg.Analysis.DocFiles = v.GetStringSlice("analysis.doc_files")
+		cfg.Analysis.BootstrapFiles = v.GetStringSlice("analysis.bootstrap_files")
+		cfg.Analysis.EntryPoints = v.GetStringMapStringSlice("analysis.entry_points")
+		cfg.Analysis.ReadEntryPoints = v.GetBool("analysis.read_entry_points")
+		cfg.Analysis.DetectTestFiles = v.GetBool("analysis.detect_test_files")
+		cfg.Analysis.ExtractSymbols = v.GetBool("analysis.extract_symbols")
+		cfg.Analysis.ProjectOverview = v.GetBool("analysis.project_overview")
+		cfg.Analysis.ReadConcurrency = v.GetInt("analysis.read_concurrency")
+		cfg.Analysis.ForbiddenFiles = v.GetStringSlice("analysis.forbidden_files")
+		cfg.Analysis.RedactSecrets = v.GetBool("analysis.redact_secrets")
+		cfg.Analysis.RedactionPatterns = v.GetStringSlice("analysis.redaction_patterns")
+		cfg.Analysis.MaxExcerptChars = v.GetInt("analysis.max_excerpt_chars")
+		cfg.Analysis.FullContentsMaxBytes = v.GetInt("analysis.full_contents_max_bytes")
+		cfg.Analysis.MaxAnalysisChars = v.GetInt("analysis.max_analysis_chars")
+		cfg.Analysis.FinalSystemPrompt = v.GetString("analysis.final_system_prompt")
+		cfg.Analysis.SearchIndexMaxEntries = v.GetInt("analysis.search_index_max_entries")
+		cfg.Analysis.MaxStepsPerPlan = v.GetInt("analysis.max_steps_per_plan")
+		cfg.Analysis.RelevanceScanBytes = v.GetInt("analysis.relevance_scan_bytes")
+		cfg.Analysis.ProjectTypeCacheSize = v.GetInt("analysis.project_type_cache_size")
+		cfg.Analysis.ProjectTypeCacheTTLMinutes = v.GetInt("analysis.project_type_cache_ttl_minutes")
+	}
+
+	// Same workaround for the multi-word keys of the server, ollama, llm, explorer and git sections
+	cfg.Server.AllowedRoots = v.GetStringSlice("server.allowed_roots")
+	cfg.Server.AllowLocalPaths = v.GetBool("server.allow_local_paths")
+	cfg.Server.MaxUploadBytes = v.GetInt64("server.max_upload_bytes")
+	cfg.Server.MaxUploadFiles = v.GetInt("server.max_upload_files")
+	cfg.Server.MaxConcurrentAnalyses = v.GetInt("server.max_concurrent_analyses")
+	cfg.Server.QueueTimeoutSeconds = v.GetInt("server.queue_timeout_seconds")
+	cfg.Server.JobRetentionMinutes = v.GetInt("server.job_retention_minutes")
+	cfg.Server.MaxQueuedJobs = v.GetInt("server.max_queued_jobs")
+	cfg.Server.CallbackAllowedHosts = v.GetStringSlice("server.callback_allowed_hosts")
+	cfg.Server.AdminToken = v.GetString("server.admin_token")
+	cfg.Ollama.RequestTimeoutSeconds = v.GetInt("ollama.request_timeout_seconds")
+	cfg.Ollama.MaxRetries = v.GetInt("ollama.max_retries")
+	cfg.Ollama.KeepAlive = v.GetString("ollama.keep_alive")
+	cfg.LLM.BaseURL = v.GetString("llm.base_url")
+	cfg.LLM.APIKey = v.GetString("llm.api_key")
+	cfg.Explorer.IgnoreDirs = v.GetStringSlice("explorer.ignore_dirs")
+	cfg.Explorer.IgnorePrefixes = v.GetStringSlice("explorer.ignore_prefixes")
+	cfg.Explorer.IgnoreExtensions = v.GetStringSlice("explorer.ignore_extensions")
+	cfg.Explorer.BinaryThreshold = v.GetFloat64("explorer.binary_threshold")
+	cfg.Explorer.ShowFileSizes = v.GetBool("explorer.show_file_sizes")
+	cfg.Explorer.MaxEntries = v.GetInt("explorer.max_entries")
+	cfg.Git.AllowSSH = v.GetBool("git.allow_ssh")
+	cfg.Git.CloneTimeoutSeconds = v.GetInt("git.clone_timeout_seconds")
+	cfg.Git.MaxCloneBytes = v.GetInt64("git.max_clone_bytes")
+	cfg.Git.AllowedHosts = v.GetStringSlice("git.allowed_hosts")
+	cfg.Ollama.Options = OptionsConfig{
+		GenerationOptions: generationOptions(v, "ollama.options"),
+		Planning:          generationOptions(v, "ollama.options.planning"),
+		Synthesis:         generationOptions(v, "ollama.options.synthesis"),
 	}
 
 	// Note: Viper's Unmarshal doesn't work properly with nested structs in some cases,
 	// so we use manual assignment for the analysis section if needed
 
-	AppConfig = &cfg
-	return nil
+	return &cfg, nil
+}
+
+// generationOptions reads the generation parameters under prefix, leaving
+// the missing or empty ones unset.
+func generationOptions(v *viper.Viper, prefix string) GenerationOptions {
+	var options GenerationOptions
+	if isSet(v, prefix+".temperature") {
+		temperature := v.GetFloat64(prefix + ".temperature")
+		options.Temperature = &temperature
+	}
+	if isSet(v, prefix+".top_p") {
+		topP := v.GetFloat64(prefix + ".top_p")
+		options.TopP = &topP
+	}
+	if isSet(v, prefix+".num_predict") {
+		numPredict := v.GetInt(prefix + ".num_predict")
+		options.NumPredict = &numPredict
+	}
+	if isSet(v, prefix+".seed") {
+		seed := v.GetInt(prefix + ".seed")
+		options.Seed = &seed
+	}
+	return options
+}
+
+// floatMap reads the map of numbers under key, such as
+// analysis.truncation_head_ratios. It returns nil when the map is empty.
+func floatMap(v *viper.Viper, key string) map[string]float64 {
+	var values map[string]float64
+	for name := range v.GetStringMap(key) {
+		if values == nil {
+			values = make(map[string]float64)
+		}
+		values[name] = v.GetFloat64(key + "." + name)
+	}
+	return values
+}
+
+// isSet reports whether key has a non-empty value: "temperature:" with no
+// value in the YAML file counts as unset.
+func isSet(v *viper.Viper, key string) bool {
+	return v.IsSet(key) && v.Get(key) != nil && v.GetString(key) != ""
 }
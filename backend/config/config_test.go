@@ -0,0 +1,91 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestReload_ConcurrentReaders reloads the configuration while other
+// goroutines read it; run it with -race to check that Get synchronizes with
+// Reload.
+func TestReload_ConcurrentReaders(t *testing.T) {
+	t.Chdir("..")
+	if err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	initial := Get()
+	iterations := initial.Analysis.MaxExplorationIterations
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if cfg := Get(); cfg == nil || cfg.Server.Port == 0 {
+					t.Error("Get() returned an incomplete configuration during a reload")
+					return
+				}
+			}
+		}()
+	}
+
+	t.Setenv("DEBUGAGENT_ANALYSIS_MAX_EXPLORATION_ITERATIONS", "42")
+	for range 10 {
+		if _, err := Reload(); err != nil {
+			t.Errorf("Reload() error: %v", err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := Get().Analysis.MaxExplorationIterations; got != 42 {
+		t.Errorf("after Reload(), max_exploration_iterations = %d, want 42", got)
+	}
+	if initial.Analysis.MaxExplorationIterations != iterations {
+		t.Error("Reload() modified a snapshot returned by Get()")
+	}
+}
+
+func TestReload_KeepsConfigWhenInvalid(t *testing.T) {
+	t.Chdir("..")
+	if err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	current := Get()
+
+	t.Setenv("DEBUGAGENT_SERVER_PORT", "0")
+	if _, err := Reload(); err == nil {
+		t.Fatal("Reload() = nil, want a validation error")
+	}
+	if Get() != current {
+		t.Error("Reload() replaced the configuration with an invalid one")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Chdir("..")
+	if err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	if got := FromContext(context.Background()); got != Get() {
+		t.Error("FromContext() without a configuration should return Get()")
+	}
+
+	snapshot := Get()
+	ctx := NewContext(context.Background(), snapshot)
+	if _, err := Reload(); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if got := FromContext(ctx); got != snapshot || got == Get() {
+		t.Error("FromContext() should return the configuration carried by ctx across a reload")
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Validate checks the loaded values and returns every problem found, joined
+// with errors.Join, or nil. Settings documented as "0 means unlimited" (or
+// disabled) only have to be non-negative.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	positive := func(key string, value int) {
+		check(value > 0, "%s must be positive, got %d", key, value)
+	}
+	nonNegative := func(key string, value int) {
+		check(value >= 0, "%s must not be negative, got %d", key, value)
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.MaxUploadBytes >= 0, "server.max_upload_bytes must not be negative, got %d", c.Server.MaxUploadBytes)
+	nonNegative("server.max_upload_files", c.Server.MaxUploadFiles)
+	nonNegative("server.max_concurrent_analyses", c.Server.MaxConcurrentAnalyses)
+	nonNegative("server.queue_timeout_seconds", c.Server.QueueTimeoutSeconds)
+	nonNegative("server.job_retention_minutes", c.Server.JobRetentionMinutes)
+	nonNegative("server.max_queued_jobs", c.Server.MaxQueuedJobs)
+
+	provider := strings.ToLower(c.LLM.Provider)
+	check(provider == "" || provider == "ollama" || provider == "openai", "llm.provider must be \"ollama\" or \"openai\", got %q", c.LLM.Provider)
+	if err := validateURL(c.Ollama.Host); err != nil {
+		errs = append(errs, fmt.Errorf("ollama.host: %w", err))
+	}
+	if provider == "openai" {
+		if err := validateURL(c.LLM.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("llm.base_url: %w", err))
+		}
+		check(c.LLM.Model != "", "llm.model must be set with the openai provider")
+	}
+	nonNegative("ollama.request_timeout_seconds", c.Ollama.RequestTimeoutSeconds)
+	nonNegative("ollama.max_retries", c.Ollama.MaxRetries)
+	if _, err := c.Ollama.KeepAliveDuration(); err != nil {
+		errs = append(errs, fmt.Errorf("ollama.keep_alive: %w", err))
+	}
+	errs = append(errs, c.Ollama.Options.validate("ollama.options")...)
+	errs = append(errs, c.Ollama.Options.Planning.validate("ollama.options.planning")...)
+	errs = append(errs, c.Ollama.Options.Synthesis.validate("ollama.options.synthesis")...)
+
+	a := c.Analysis
+	positive("analysis.max_exploration_iterations", a.MaxExplorationIterations)
+	positive("analysis.max_directory_depth", a.MaxDirectoryDepth)
+	positive("analysis.max_file_read_size", a.MaxFileReadSize)
+	positive("analysis.max_file_retry_attempts", a.MaxFileRetryAttempts)
+	nonNegative("analysis.max_prompt_length", a.MaxPromptLength)
+	nonNegative("analysis.max_context_tokens", a.MaxContextTokens)
+	check(a.MaxPromptLength > 0 || a.MaxContextTokens > 0, "analysis.max_context_tokens or analysis.max_prompt_length must be positive")
+	nonNegative("analysis.max_retained_files", a.MaxRetainedFiles)
+	nonNegative("analysis.max_retained_bytes", a.MaxRetainedBytes)
+	nonNegative("analysis.stall_iterations", a.StallIterations)
+	nonNegative("analysis.force_finish_iterations", a.ForceFinishIterations)
+	nonNegative("analysis.command_timeout_seconds", a.CommandTimeoutSeconds)
+	nonNegative("analysis.max_total_duration_seconds", a.MaxTotalDurationSeconds)
+	nonNegative("analysis.incremental_iterations", a.IncrementalIterations)
+	nonNegative("analysis.session_ttl_minutes", a.SessionTTLMinutes)
+	nonNegative("analysis.read_concurrency", a.ReadConcurrency)
+	nonNegative("analysis.max_excerpt_chars", a.MaxExcerptChars)
+	nonNegative("analysis.full_contents_max_bytes", a.FullContentsMaxBytes)
+	nonNegative("analysis.max_analysis_chars", a.MaxAnalysisChars)
+	nonNegative("analysis.search_index_max_entries", a.SearchIndexMaxEntries)
+	nonNegative("analysis.max_steps_per_plan", a.MaxStepsPerPlan)
+	nonNegative("analysis.relevance_scan_bytes", a.RelevanceScanBytes)
+	nonNegative("analysis.project_type_cache_size", a.ProjectTypeCacheSize)
+	nonNegative("analysis.project_type_cache_ttl_minutes", a.ProjectTypeCacheTTLMinutes)
+	for language, patterns := range a.EntryPoints {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("analysis.entry_points.%s: invalid pattern %q: %w", language, pattern, err))
+			}
+		}
+	}
+	if a.TruncationHeadRatio != nil {
+		check(*a.TruncationHeadRatio >= 0 && *a.TruncationHeadRatio <= 1, "analysis.truncation_head_ratio must be between 0 and 1, got %g", *a.TruncationHeadRatio)
+	}
+	for extension, ratio := range a.TruncationHeadRatios {
+		check(ratio >= 0 && ratio <= 1, "analysis.truncation_head_ratios.%s must be between 0 and 1, got %g", extension, ratio)
+	}
+	for _, expr := range a.RedactionPatterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, fmt.Errorf("analysis.redaction_patterns: %w", err))
+		}
+	}
+
+	nonNegative("git.clone_timeout_seconds", c.Git.CloneTimeoutSeconds)
+	check(c.Git.MaxCloneBytes >= 0, "git.max_clone_bytes must not be negative, got %d", c.Git.MaxCloneBytes)
+
+	nonNegative("explorer.max_entries", c.Explorer.MaxEntries)
+	check(c.Explorer.BinaryThreshold >= 0 && c.Explorer.BinaryThreshold <= 1, "explorer.binary_threshold must be between 0 and 1, got %g", c.Explorer.BinaryThreshold)
+
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: %w", err))
+	}
+	format := strings.ToLower(c.Logging.Format)
+	check(format == "" || format == "text" || format == "json", "logging.format must be \"text\" or \"json\", got %q", c.Logging.Format)
+
+	return errors.Join(errs...)
+}
+
+// validate checks the ranges of the generation parameters set under key.
+func (o GenerationOptions) validate(key string) []error {
+	var errs []error
+	if o.Temperature != nil && *o.Temperature < 0 {
+		errs = append(errs, fmt.Errorf("%s.temperature must not be negative, got %g", key, *o.Temperature))
+	}
+	if o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1) {
+		errs = append(errs, fmt.Errorf("%s.top_p must be in (0, 1], got %g", key, *o.TopP))
+	}
+	if o.NumPredict != nil && *o.NumPredict == 0 {
+		errs = append(errs, fmt.Errorf("%s.num_predict must not be 0, leave it empty for the model's default", key))
+	}
+	return errs
+}
+
+// validateURL checks that raw is an absolute http(s) URL.
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validConfig returns a configuration that passes Validate.
+func validConfig() *Config {
+	return &Config{
+		Server: ServerConfig{Port: 8080},
+		Ollama: OllamaConfig{Host: "http://localhost:11434", Model: "llama3.2:1b"},
+		Analysis: AnalysisConfig{
+			MaxExplorationIterations: 5,
+			MaxDirectoryDepth:        4,
+			MaxFileReadSize:          150000,
+			MaxContextTokens:         8000,
+			MaxFileRetryAttempts:     3,
+		},
+		Explorer: ExplorerConfig{BinaryThreshold: 0.3},
+		Logging:  LoggingConfig{Level: "info", Format: "text"},
+	}
+}
+
+func TestValidate_ValidConfig(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidate_DefaultConfigFile(t *testing.T) {
+	t.Chdir("..")
+	if err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	if err := AppConfig.Validate(); err != nil {
+		t.Fatalf("config.default.yaml is invalid: %v", err)
+	}
+}
+
+func TestValidate_InvalidFields(t *testing.T) {
+	negative := -1.0
+	aboveOne := 1.5
+	zero := 0
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"port zero", func(c *Config) { c.Server.Port = 0 }, "server.port"},
+		{"port too large", func(c *Config) { c.Server.Port = 70000 }, "server.port"},
+		{"negative upload bytes", func(c *Config) { c.Server.MaxUploadBytes = -1 }, "server.max_upload_bytes"},
+		{"negative upload files", func(c *Config) { c.Server.MaxUploadFiles = -1 }, "server.max_upload_files"},
+		{"negative concurrent analyses", func(c *Config) { c.Server.MaxConcurrentAnalyses = -1 }, "server.max_concurrent_analyses"},
+		{"negative queue timeout", func(c *Config) { c.Server.QueueTimeoutSeconds = -1 }, "server.queue_timeout_seconds"},
+		{"negative job retention", func(c *Config) { c.Server.JobRetentionMinutes = -1 }, "server.job_retention_minutes"},
+		{"negative queued jobs", func(c *Config) { c.Server.MaxQueuedJobs = -1 }, "server.max_queued_jobs"},
+		{"unknown provider", func(c *Config) { c.LLM.Provider = "anthropic" }, "llm.provider"},
+		{"ollama host without scheme", func(c *Config) { c.Ollama.Host = "localhost:11434" }, "ollama.host"},
+		{"empty ollama host", func(c *Config) { c.Ollama.Host = "" }, "ollama.host"},
+		{"openai without base url", func(c *Config) { c.LLM = LLMConfig{Provider: "openai", Model: "gpt-4o-mini"} }, "llm.base_url"},
+		{"openai without model", func(c *Config) { c.LLM = LLMConfig{Provider: "openai", BaseURL: "https://api.openai.com/v1"} }, "llm.model"},
+		{"negative request timeout", func(c *Config) { c.Ollama.RequestTimeoutSeconds = -1 }, "ollama.request_timeout_seconds"},
+		{"negative retries", func(c *Config) { c.Ollama.MaxRetries = -1 }, "ollama.max_retries"},
+		{"invalid keep alive", func(c *Config) { c.Ollama.KeepAlive = "forever" }, "ollama.keep_alive"},
+		{"negative temperature", func(c *Config) { c.Ollama.Options.Temperature = &negative }, "ollama.options.temperature"},
+		{"negative planning top_p", func(c *Config) { c.Ollama.Options.Planning.TopP = &negative }, "ollama.options.planning.top_p"},
+		{"zero synthesis num_predict", func(c *Config) { c.Ollama.Options.Synthesis.NumPredict = &zero }, "ollama.options.synthesis.num_predict"},
+		{"zero iterations", func(c *Config) { c.Analysis.MaxExplorationIterations = 0 }, "analysis.max_exploration_iterations"},
+		{"zero depth", func(c *Config) { c.Analysis.MaxDirectoryDepth = 0 }, "analysis.max_directory_depth"},
+		{"zero file read size", func(c *Config) { c.Analysis.MaxFileReadSize = 0 }, "analysis.max_file_read_size"},
+		{"zero retry attempts", func(c *Config) { c.Analysis.MaxFileRetryAttempts = 0 }, "analysis.max_file_retry_attempts"},
+		{"no prompt budget", func(c *Config) { c.Analysis.MaxContextTokens = 0 }, "analysis.max_context_tokens or analysis.max_prompt_length"},
+		{"negative prompt length", func(c *Config) { c.Analysis.MaxPromptLength = -1 }, "analysis.max_prompt_length"},
+		{"negative retained files", func(c *Config) { c.Analysis.MaxRetainedFiles = -1 }, "analysis.max_retained_files"},
+		{"negative retained bytes", func(c *Config) { c.Analysis.MaxRetainedBytes = -1 }, "analysis.max_retained_bytes"},
+		{"negative stall iterations", func(c *Config) { c.Analysis.StallIterations = -1 }, "analysis.stall_iterations"},
+		{"negative force finish iterations", func(c *Config) { c.Analysis.ForceFinishIterations = -1 }, "analysis.force_finish_iterations"},
+		{"negative command timeout", func(c *Config) { c.Analysis.CommandTimeoutSeconds = -1 }, "analysis.command_timeout_seconds"},
+		{"negative duration", func(c *Config) { c.Analysis.MaxTotalDurationSeconds = -1 }, "analysis.max_total_duration_seconds"},
+		{"negative incremental iterations", func(c *Config) { c.Analysis.IncrementalIterations = -1 }, "analysis.incremental_iterations"},
+		{"negative session TTL", func(c *Config) { c.Analysis.SessionTTLMinutes = -1 }, "analysis.session_ttl_minutes"},
+		{"negative read concurrency", func(c *Config) { c.Analysis.ReadConcurrency = -1 }, "analysis.read_concurrency"},
+		{"negative excerpt size", func(c *Config) { c.Analysis.MaxExcerptChars = -1 }, "analysis.max_excerpt_chars"},
+		{"negative full contents size", func(c *Config) { c.Analysis.FullContentsMaxBytes = -1 }, "analysis.full_contents_max_bytes"},
+		{"negative analysis size", func(c *Config) { c.Analysis.MaxAnalysisChars = -1 }, "analysis.max_analysis_chars"},
+		{"negative search index size", func(c *Config) { c.Analysis.SearchIndexMaxEntries = -1 }, "analysis.search_index_max_entries"},
+		{"negative plan size", func(c *Config) { c.Analysis.MaxStepsPerPlan = -1 }, "analysis.max_steps_per_plan"},
+		{"negative relevance scan size", func(c *Config) { c.Analysis.RelevanceScanBytes = -1 }, "analysis.relevance_scan_bytes"},
+		{"negative project type cache size", func(c *Config) { c.Analysis.ProjectTypeCacheSize = -1 }, "analysis.project_type_cache_size"},
+		{"negative project type cache ttl", func(c *Config) { c.Analysis.ProjectTypeCacheTTLMinutes = -1 }, "analysis.project_type_cache_ttl_minutes"},
+		{"truncation head ratio above 1", func(c *Config) { c.Analysis.TruncationHeadRatio = &aboveOne }, "analysis.truncation_head_ratio"},
+		{"negative truncation head ratio of an extension", func(c *Config) { c.Analysis.TruncationHeadRatios = map[string]float64{"log": -0.1} }, "analysis.truncation_head_ratios.log"},
+		{"invalid redaction pattern", func(c *Config) { c.Analysis.RedactionPatterns = []string{"sk-[a-z"} }, "analysis.redaction_patterns"},
+		{"invalid entry point pattern", func(c *Config) { c.Analysis.EntryPoints = map[string][]string{"go": {"cmd/[/main.go"}} }, "analysis.entry_points.go"},
+		{"negative clone timeout", func(c *Config) { c.Git.CloneTimeoutSeconds = -1 }, "git.clone_timeout_seconds"},
+		{"negative clone size", func(c *Config) { c.Git.MaxCloneBytes = -1 }, "git.max_clone_bytes"},
+		{"binary threshold above 1", func(c *Config) { c.Explorer.BinaryThreshold = 1.5 }, "explorer.binary_threshold"},
+		{"negative max entries", func(c *Config) { c.Explorer.MaxEntries = -1 }, "explorer.max_entries"},
+		{"unknown log level", func(c *Config) { c.Logging.Level = "verbose" }, "logging.level"},
+		{"unknown log format", func(c *Config) { c.Logging.Format = "xml" }, "logging.format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatalf("Validate() = nil, want an error about %s", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %q, want it to mention %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_ReportsEveryError(t *testing.T) {
+	cfg := validConfig()
+	cfg.Server.Port = 0
+	cfg.Ollama.Host = "not a url"
+	cfg.Logging.Level = "verbose"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want errors")
+	}
+	for _, key := range []string{"server.port", "ollama.host", "logging.level"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Validate() = %q, want it to mention %s", err, key)
+		}
+	}
+}
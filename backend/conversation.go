@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+)
+
+// maxConversationMessages borne le nombre de messages conservés dans
+// l'historique envoyé au modèle.
+const maxConversationMessages = 16
+
+// conversation conserve l'historique des échanges avec le modèle pendant la
+// boucle d'exploration. Le résumé complet du contexte n'est envoyé qu'une fois ;
+// les échanges suivants ne transmettent que ce qui a été appris entre-temps.
+type conversation struct {
+	messages        []ChatMessage
+	fullContextSent bool
+	notesSeen       int
+	historySeen     int
+	filesSeen       map[string]bool
+}
+
+// newConversation crée une conversation vide.
+func newConversation() *conversation {
+	return &conversation{filesSeen: make(map[string]bool)}
+}
+
+// ask construit le prompt du prochain échange à partir du contexte à jour,
+// interroge le modèle et enregistre la question et la réponse dans l'historique.
+// En cas d'erreur, la conversation reste inchangée.
+func (c *conversation) ask(ctx context.Context, client LLMClient, kb *KnowledgeBase, question, systemMessage string, buildPrompt func(contextSummary string) string) (string, error) {
+	saved := *c
+	savedFiles := make(map[string]bool, len(c.filesSeen))
+	for path := range c.filesSeen {
+		savedFiles[path] = true
+	}
+
+	prompt := buildPrompt(c.contextFor(kb, question))
+
+	messages := make([]ChatMessage, 0, len(c.messages)+2)
+	messages = append(messages, ChatMessage{Role: "system", Content: systemMessage})
+	messages = append(messages, c.messages...)
+	messages = append(messages, ChatMessage{Role: "user", Content: prompt})
+
+	response, err := client.ChatRequest(ctx, messages)
+	if err != nil {
+		*c = saved
+		c.filesSeen = savedFiles
+		return "", err
+	}
+
+	c.messages = append(c.messages,
+		ChatMessage{Role: "user", Content: prompt},
+		ChatMessage{Role: "assistant", Content: response},
+	)
+	c.trim(config.FromContext(ctx))
+	return response, nil
+}
+
+// contextFor retourne le contexte à joindre au prochain message : le résumé
+// complet si le modèle ne l'a pas (ou plus) dans son historique, sinon
+// uniquement les nouveautés.
+func (c *conversation) contextFor(kb *KnowledgeBase, question string) string {
+	if !c.fullContextSent {
+		c.fullContextSent = true
+		c.filesSeen = make(map[string]bool)
+		_, c.notesSeen, c.historySeen = kb.getContextUpdate(0, 0, c.filesSeen)
+		return kb.getContextSummary(question, contextTokenBudget(kb.cfg))
+	}
+
+	update, notesSeen, historySeen := kb.getContextUpdate(c.notesSeen, c.historySeen, c.filesSeen)
+	c.notesSeen, c.historySeen = notesSeen, historySeen
+	return update
+}
+
+// trim supprime les échanges les plus anciens au-delà de
+// maxConversationMessages, ou tant que l'historique occupe plus de la moitié
+// du budget de tokens (l'autre moitié revenant au prochain prompt). Le message
+// portant le résumé complet pouvant avoir disparu, il sera renvoyé au
+// prochain échange.
+func (c *conversation) trim(cfg *config.Config) {
+	budget := promptTokenBudget(cfg) / 2
+	if len(c.messages) <= maxConversationMessages && estimateMessagesTokens(c.messages) <= budget {
+		return
+	}
+	for len(c.messages) > maxConversationMessages || (len(c.messages) > 2 && estimateMessagesTokens(c.messages) > budget) {
+		c.messages = c.messages[2:] // Un échange question/réponse à la fois
+	}
+	c.fullContextSent = false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConversation_KeepsHistoryAndSendsOnlyNewFacts(t *testing.T) {
+	var received [][]ChatMessage
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []ChatMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body.Messages)
+		w.Write([]byte(`{"message":{"role":"assistant","content":"1. FINISH"},"done":true}`))
+	})
+
+	client, _ := NewOllamaClient(context.Background())
+	kb := NewKnowledgeBase(t.TempDir())
+	conv := newConversation()
+	build := func(contextSummary string) string { return "CTX:" + contextSummary }
+
+	if _, err := conv.ask(context.Background(), client, kb, "question?", "planner", build); err != nil {
+		t.Fatalf("first ask failed: %v", err)
+	}
+
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "main.go"), "package main")
+	if _, err := conv.ask(context.Background(), client, kb, "question?", "planner", build); err != nil {
+		t.Fatalf("second ask failed: %v", err)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 chat calls, got %d", len(received))
+	}
+	second := received[1]
+	// system + first user turn + assistant reply + new user turn
+	if len(second) != 4 {
+		t.Fatalf("expected the history to be resent, got %d messages", len(second))
+	}
+	if !strings.Contains(second[1].Content, "Problème utilisateur") {
+		t.Error("first turn should carry the full context summary")
+	}
+	last := second[3].Content
+	if strings.Contains(last, "Problème utilisateur") {
+		t.Error("follow-up turn should not re-send the full context summary")
+	}
+	if !strings.Contains(last, "main.go") {
+		t.Error("follow-up turn should mention the newly read file")
+	}
+}
+
+func TestConversationTrim_TokenBudget(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{MaxContextTokens: 200}}
+	c := newConversation()
+	c.fullContextSent = true
+	for i := 0; i < 4; i++ {
+		c.messages = append(c.messages,
+			ChatMessage{Role: "user", Content: strings.Repeat("prompt ", 20)},
+			ChatMessage{Role: "assistant", Content: "answer"},
+		)
+	}
+
+	c.trim(config.AppConfig)
+
+	if tokens := estimateMessagesTokens(c.messages); tokens > 100 {
+		t.Errorf("expected the history to use at most half of the budget, got ~%d tokens", tokens)
+	}
+	if len(c.messages)%2 != 0 || c.messages[0].Role != "user" {
+		t.Errorf("expected whole exchanges to be dropped, got %d messages starting with '%s'", len(c.messages), c.messages[0].Role)
+	}
+	if c.fullContextSent {
+		t.Error("expected the full context to be sent again after trimming")
+	}
+}
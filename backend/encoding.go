@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedEncoding est renvoyée pour un fichier texte qui ne peut pas
+// être converti en UTF-8 sans perte.
+var ErrUnsupportedEncoding = errors.New("unsupported text encoding")
+
+// textEncoding est un encodage de fichier reconnu par detectEncoding.
+type textEncoding string
+
+const (
+	encodingUTF8        textEncoding = "UTF-8"
+	encodingUTF16LE     textEncoding = "UTF-16LE"
+	encodingUTF16BE     textEncoding = "UTF-16BE"
+	encodingWindows1252 textEncoding = "Windows-1252"
+)
+
+// windows1252High associe les octets 0x80 à 0x9F de Windows-1252 à leur
+// caractère ; 0 marque les octets non définis. Les octets 0xA0 à 0xFF sont
+// identiques en Latin-1 et en Unicode.
+var windows1252High = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// detectEncoding renvoie l'encodage probable de content et la taille de son
+// BOM. Le BOM est prioritaire, puis l'UTF-16 sans BOM (voir utf16ByteOrder),
+// puis l'UTF-8 si tout le contenu est valide ; à défaut, le contenu est lu en
+// Windows-1252, sur-ensemble de Latin-1. Un octet NUL hors UTF-16 renvoie un
+// encodage vide : le contenu est binaire.
+func detectEncoding(content []byte) (textEncoding, int) {
+	sample := content[:min(binarySampleSize, len(content))]
+	if bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}) {
+		return encodingUTF8, 3
+	}
+	if order, bomSize := utf16ByteOrder(sample); order == binary.LittleEndian {
+		return encodingUTF16LE, bomSize
+	} else if order == binary.BigEndian {
+		return encodingUTF16BE, bomSize
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return "", 0
+	}
+	if utf8.Valid(content) {
+		return encodingUTF8, 0
+	}
+	return encodingWindows1252, 0
+}
+
+// transcodeToUTF8 convertit content depuis encoding. Les séquences invalides
+// sont remplacées par utf8.RuneError et comptées dans invalid.
+func transcodeToUTF8(content []byte, encoding textEncoding) (text string, invalid int) {
+	switch encoding {
+	case encodingUTF16LE, encodingUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == encodingUTF16BE {
+			order = binary.BigEndian
+		}
+		return decodeUTF16(content, order)
+	case encodingWindows1252:
+		var builder strings.Builder
+		for _, b := range content {
+			r := rune(b)
+			if b >= 0x80 && b <= 0x9F {
+				if r = windows1252High[b-0x80]; r == 0 {
+					r = utf8.RuneError
+					invalid++
+				}
+			}
+			builder.WriteRune(r)
+		}
+		return builder.String(), invalid
+	}
+	return string(content), 0
+}
+
+// decodeUTF16 convertit content, en UTF-16 dans l'ordre order. Un octet final
+// isolé ou une demi-paire de substitution compte comme séquence invalide.
+func decodeUTF16(content []byte, order binary.ByteOrder) (string, int) {
+	invalid := len(content) % 2
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		units[i] = order.Uint16(content[2*i:])
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(units); i++ {
+		r := rune(units[i])
+		if utf16.IsSurrogate(r) {
+			if i+1 < len(units) {
+				if pair := utf16.DecodeRune(r, rune(units[i+1])); pair != utf8.RuneError {
+					builder.WriteRune(pair)
+					i++
+					continue
+				}
+			}
+			r = utf8.RuneError
+			invalid++
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String(), invalid
+}
+
+// utf16ByteOrder détecte un texte UTF-16, par son BOM ou, à défaut, par des
+// octets nuls sur presque toutes les positions paires ou impaires (texte
+// majoritairement ASCII). Elle renvoie l'ordre des octets et la taille du BOM,
+// ou nil si sample n'est pas de l'UTF-16.
+func utf16ByteOrder(sample []byte) (binary.ByteOrder, int) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return binary.LittleEndian, 2
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return binary.BigEndian, 2
+	}
+	if len(sample) < 4 {
+		return nil, 0
+	}
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case oddZeros >= pairs*9/10 && evenZeros == 0:
+		return binary.LittleEndian, 0
+	case evenZeros >= pairs*9/10 && oddZeros == 0:
+		return binary.BigEndian, 0
+	}
+	return nil, 0
+}
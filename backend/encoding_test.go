@@ -0,0 +1,60 @@
+package main
+
+import (
+	"debugagent/config"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReadFileContent_Encodings(t *testing.T) {
+	// "café – “ok”" dans chaque encodage
+	utf16LE := []byte{0xFF, 0xFE}
+	for _, r := range "café – “ok”" {
+		utf16LE = append(utf16LE, byte(r), byte(r>>8))
+	}
+	windows1252 := []byte("caf\xe9 \x96 \x93ok\x94")
+
+	projectPath := setupExplorerTest(t, map[string]string{
+		"utf16le.txt":   string(utf16LE),
+		"cp1252.txt":    string(windows1252),
+		"utf8bom.txt":   "\xEF\xBB\xBFcafé – “ok”",
+		"undefined.txt": "caf\xe9 \x81 \x93ok\x94",
+	})
+	log := logrus.NewEntry(logrus.StandardLogger())
+
+	for _, name := range []string{"utf16le.txt", "cp1252.txt", "utf8bom.txt"} {
+		content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, name))
+		if err != nil || content != "café – “ok”" {
+			t.Errorf("%s: expected the text converted to UTF-8, got %q (%v)", name, content, err)
+		}
+	}
+
+	// 0x81 n'est pas défini en Windows-1252
+	if _, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, "undefined.txt")); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("expected ErrUnsupportedEncoding, got %v", err)
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		content  string
+		encoding textEncoding
+		bomSize  int
+	}{
+		{"package main", encodingUTF8, 0},
+		{"\xEF\xBB\xBFpackage main", encodingUTF8, 3},
+		{"\xFF\xFEp\x00k\x00g\x00", encodingUTF16LE, 2},
+		{"\x00p\x00a\x00c\x00k\x00a\x00g\x00e", encodingUTF16BE, 0},
+		{"r\xe9sum\xe9", encodingWindows1252, 0},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "", 0},
+	}
+	for _, tt := range tests {
+		encoding, bomSize := detectEncoding([]byte(tt.content))
+		if encoding != tt.encoding || bomSize != tt.bomSize {
+			t.Errorf("detectEncoding(%q) = %q, %d; expected %q, %d", tt.content, encoding, bomSize, tt.encoding, tt.bomSize)
+		}
+	}
+}
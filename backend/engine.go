@@ -1,148 +1,806 @@
 package main
 
 import (
+	"context"
 	"debugagent/config"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 // AnalyzeRequest defines the structure for the API request.
 type AnalyzeRequest struct {
-	ProjectPath string
-	Question    string
+	ProjectPath   string
+	Question      string
+	Model         string   // Optional override of ollama.model for this request
+	MaxIterations int      // Overrides analysis.max_exploration_iterations when positive
+	MaxDepth      int      // Overrides analysis.max_directory_depth when positive
+	SystemPrompt  string   // Overrides analysis.final_system_prompt when not empty
+	Language      string   // Code of the language of the final answer, see answerLanguages; empty means English
+	SingleFile    bool     // The upload is a single file: it is read directly, without initial analysis nor exploration
+	Questions     []string // Questions answered separately after a shared exploration, Question is then their combined text; see withQuestions
+	Verbose       bool     // Return the exploration iterations along with the answer, see AnalysisResult.Trace
+	Cached        bool     // The project stays on disk between requests (project_path, sessions): its knowledge base is kept in analysis.cache_dir
 }
 
-// AnalysisEngine orchestrates the project analysis.
-type AnalysisEngine struct {
+// AnalysisResult is the final answer along with the files it is based on.
+type AnalysisResult struct {
+	Answer     string
+	Sources    []Source
+	Stats      AnalysisStats
+	Confidence string           // Self-assessment of the model: high, medium or low, empty when it gave none
+	Unresolved string           // What the model could not determine, empty when nothing
+	Answers    []QuestionAnswer // Answer to each of the request's Questions, the fields above are the first one's
+	Timings    []PhaseTiming    // Time spent in the initial analysis, the exploration and the synthesis
+	Trace      []IterationTrace // Plan and step outcomes of each exploration iteration, only for a Verbose request
+}
+
+// maxSearchResults caps the number of matching lines recorded per SEARCH step.
+const maxSearchResults = 30
+
+// listDirDepth is how many levels a LIST_DIR step descends into.
+const listDirDepth = 2
+
+// analysisState is the state of an analysis, shared by AnalysisEngine and
+// StreamingAnalysisEngine which embed it. The steps both engines run on it
+// report their progress to a progressReporter, which only the streaming
+// engine turns into events.
+type analysisState struct {
+	ctx          context.Context
 	kb           *KnowledgeBase
-	ollamaClient *OllamaClient
+	llmClient    LLMClient
 	request      AnalyzeRequest
 	fileResolver *FileResolver
+	conversation *conversation   // Running chat history shared by planning and analysis
+	log          *logrus.Entry   // Logger carrying the request_id of the analysis
+	prefetched   prefetchedReads // Files of the current plan read ahead, see prefetchReads
+	usage        *usageStats     // Counters reported in the result, llmClient updates the model ones
+	cfg          *config.Config  // Configuration in effect when the analysis was created, kept across config reloads
+	cutShort     bool            // The exploration ended on errors or a budget rather than on FINISH, see selfAssessmentInstruction
+	trace        *planTracer     // Logs each iteration, and writes it to analysis.trace_dir
+}
+
+// AnalysisEngine orchestrates the project analysis.
+type AnalysisEngine struct {
+	analysisState
 }
 
 // StreamingAnalysisEngine orchestrates the project analysis with streaming updates.
 type StreamingAnalysisEngine struct {
-	kb           *KnowledgeBase
-	ollamaClient *OllamaClient
-	request      AnalyzeRequest
-	fileResolver *FileResolver
+	analysisState
 }
 
+// progressReporter receives the progress of an analysis, with the arguments
+// of StreamingAnalysisEngine.sendEvent.
+type progressReporter func(eventType, step, message string, iteration, total int, data string)
+
+// noProgress is the progressReporter of AnalysisEngine, whose caller only
+// gets the result.
+func noProgress(eventType, step, message string, iteration, total int, data string) {}
+
 // NewAnalysisEngine creates a new AnalysisEngine.
-func NewAnalysisEngine(req AnalyzeRequest) (*AnalysisEngine, error) {
-	kb := NewKnowledgeBase(req.ProjectPath)
-	ollamaClient, err := NewOllamaClient()
+// The context bounds the whole analysis: once it is cancelled, no further
+// Ollama calls are issued. The configuration in effect is captured here and
+// read by the whole analysis, so that a reload does not change it midway.
+func NewAnalysisEngine(ctx context.Context, req AnalyzeRequest) (*AnalysisEngine, error) {
+	ctx = config.NewContext(ctx, config.FromContext(ctx))
+	llmClient, err := NewLLMClient(ctx, req.Model)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Ollama client: %w", err)
+		return nil, fmt.Errorf("failed to create LLM client: %w", err)
+	}
+	return NewAnalysisEngineWithClient(ctx, req, llmClient), nil
+}
+
+// NewAnalysisEngineWithClient creates a AnalysisEngine that queries llmClient
+// instead of the configured provider, e.g. a fake in tests.
+func NewAnalysisEngineWithClient(ctx context.Context, req AnalyzeRequest, llmClient LLMClient) *AnalysisEngine {
+	return &AnalysisEngine{newAnalysisState(ctx, req, llmClient)}
+}
+
+// newAnalysisState prepares the analysis of req with the configuration of
+// ctx, restoring the knowledge base cached by a previous analysis.
+func newAnalysisState(ctx context.Context, req AnalyzeRequest, llmClient LLMClient) analysisState {
+	cfg := config.FromContext(ctx)
+	ctx = config.NewContext(ctx, cfg)
+	log := requestLogger(ctx)
+	kb := NewKnowledgeBase(req.ProjectPath)
+	kb.log = log
+	kb.cfg = cfg
+	if req.Cached {
+		warmStartKnowledgeBase(kb, cfg.Analysis.CacheDir)
 	}
 
 	fileResolver := NewFileResolver(req.ProjectPath, kb)
+	fileResolver.maxDepth = directoryDepth(req, cfg)
+	usage := &usageStats{}
 
-	return &AnalysisEngine{
+	return analysisState{
+		ctx:          ctx,
 		kb:           kb,
-		ollamaClient: ollamaClient,
+		llmClient:    &countingClient{LLMClient: llmClient, usage: usage},
 		request:      req,
 		fileResolver: fileResolver,
-	}, nil
+		conversation: newConversation(),
+		log:          log,
+		usage:        usage,
+		cfg:          cfg,
+		trace:        newPlanTracer(ctx, log, cfg.Analysis.TraceDir),
+	}
+}
+
+// cacheDir is where the knowledge base of the analysis is saved, or "" when
+// the request is not Cached: the project of an upload is deleted afterwards,
+// its cache would only keep a copy of its sources.
+func (s *analysisState) cacheDir() string {
+	if !s.request.Cached {
+		return ""
+	}
+	return s.cfg.Analysis.CacheDir
+}
+
+// invalidateFiles drops the restored findings about changed, before an
+// incremental run. It returns the cached file contents that are reused and
+// the ones that will have to be read again.
+func (e *AnalysisEngine) invalidateFiles(changed []string) (reused, recomputed []string) {
+	recomputed = e.kb.InvalidateFiles(changed)
+	for path := range e.kb.FileContents {
+		reused = append(reused, filepath.ToSlash(path))
+	}
+	for i, path := range recomputed {
+		recomputed[i] = filepath.ToSlash(path)
+	}
+	sort.Strings(reused)
+	sort.Strings(recomputed)
+	return reused, recomputed
+}
+
+// warmStartKnowledgeBase restores a previous run's findings for the same
+// project from cacheDir (analysis.cache_dir), if set. Missing or incompatible
+// cache files are ignored.
+func warmStartKnowledgeBase(kb *KnowledgeBase, cacheDir string) {
+	if cacheDir == "" {
+		return
+	}
+	cachePath := knowledgeBaseCachePath(cacheDir, kb.ProjectPath)
+	if _, err := os.Stat(cachePath); err != nil {
+		return
+	}
+	if err := kb.LoadFromFile(cachePath); err != nil {
+		kb.log.Warnf("Ignoring knowledge base cache '%s': %v", cachePath, err)
+		return
+	}
+	kb.AddHistory("Knowledge base restored from a previous run.")
+}
+
+// saveKnowledgeBaseCache persists the knowledge base in cacheDir
+// (analysis.cache_dir), if set.
+func saveKnowledgeBaseCache(kb *KnowledgeBase, cacheDir string) {
+	if cacheDir == "" {
+		return
+	}
+	if err := kb.SaveToFile(knowledgeBaseCachePath(cacheDir, kb.ProjectPath)); err != nil {
+		kb.log.Warnf("Failed to save knowledge base cache: %v", err)
+	}
 }
 
 // RunAnalysis runs the full analysis process.
-func (e *AnalysisEngine) RunAnalysis() (string, error) {
-	logrus.Info("1. Starting initial project analysis...")
-	if err := e.initialAnalysis(); err != nil {
-		// Log the error but continue, as some information may have been gathered.
-		e.kb.AddNote(fmt.Sprintf("Error during initial analysis: %v", err))
+func (e *AnalysisEngine) RunAnalysis() (AnalysisResult, error) {
+	start, outcome := time.Now(), analysisCancelled
+	defer func() { observeAnalysis(outcome, start) }()
+
+	// The initial analysis and the exploration run under the time budget
+	requestCtx := e.ctx
+	var stop context.CancelFunc
+	e.ctx, stop = withTimeBudget(requestCtx, e.cfg.Analysis.MaxTotalDurationSeconds)
+
+	phaseStart := time.Now()
+	if relPath, ok := singleFilePath(e.request); ok {
+		e.log.Infof("1. Single file upload, reading '%s' directly...", relPath)
+		if err := readSingleFile(e.log, e.kb, relPath); err != nil {
+			e.kb.AddNote(fmt.Sprintf("Failed to read the uploaded file '%s': %v", relPath, err))
+		} else {
+			e.usage.addFilesRead(1)
+		}
+		e.usage.addTiming(timingInitial, time.Since(phaseStart))
+	} else {
+		e.log.Info("1. Starting initial project analysis...")
+		if err := e.initialAnalysis(noProgress); err != nil {
+			// Log the error but continue, as some information may have been gathered.
+			e.kb.AddNote(fmt.Sprintf("Error during initial analysis: %v", err))
+		}
+		e.usage.addTiming(timingInitial, time.Since(phaseStart))
+
+		e.log.Info("2. Starting exploration loop...")
+		phaseStart = time.Now()
+		if err := e.explorationLoop(noProgress); err != nil {
+			// Log and continue, as we might still be able to provide a partial answer.
+			e.kb.AddNote(fmt.Sprintf("Error during exploration loop: %v", err))
+		}
+		e.usage.addTiming(timingExploration, time.Since(phaseStart))
+	}
+
+	budgetExceeded := timeBudgetExceeded(e.ctx)
+	stop()
+	e.ctx = requestCtx
+
+	if err := e.ctx.Err(); err != nil {
+		e.log.Info("Analysis cancelled, skipping final answer generation.")
+		return AnalysisResult{}, fmt.Errorf("analysis cancelled: %w", err)
 	}
+	if budgetExceeded {
+		e.log.Warnf("Time budget of %ds exceeded, ending exploration.", e.cfg.Analysis.MaxTotalDurationSeconds)
+		e.kb.AddNote("Exploration was cut short by the time budget: the answer may be incomplete.")
+		e.cutShort = true
+	}
+
+	saveKnowledgeBaseCache(e.kb, e.cacheDir())
 
-	logrus.Info("2. Starting exploration loop...")
-	if err := e.explorationLoop(); err != nil {
-		// Log and continue, as we might still be able to provide a partial answer.
-		e.kb.AddNote(fmt.Sprintf("Error during exploration loop: %v", err))
+	if len(e.request.Questions) == 0 {
+		e.log.Info("3. Generating final answer...")
+		phaseStart = time.Now()
+		result, partial, err := e.answerQuestion(e.request.Question, budgetExceeded)
+		e.usage.addTiming(timingSynthesis, time.Since(phaseStart))
+		if err != nil {
+			return AnalysisResult{}, err
+		}
+		outcome = analysisCompleted
+		if partial {
+			outcome = analysisPartial
+		}
+		result.Stats = e.usage.snapshot()
+		result.Timings = e.usage.timingsSnapshot()
+		result.Trace = e.explorationTrace()
+		return result, nil
 	}
 
-	logrus.Info("3. Generating final answer...")
-	finalAnswer, err := e.generateFinalAnswer()
+	// Each question gets its own answer from the shared exploration
+	var result AnalysisResult
+	outcome = analysisCompleted
+	for i, question := range e.request.Questions {
+		e.log.Infof("3. Generating the answer to question %d/%d...", i+1, len(e.request.Questions))
+		phaseStart = time.Now()
+		answer, partial, err := e.answerQuestion(question, budgetExceeded)
+		e.usage.addTiming(timingSynthesis, time.Since(phaseStart))
+		if err != nil {
+			return AnalysisResult{}, err
+		}
+		if partial {
+			outcome = analysisPartial
+		}
+		if i == 0 {
+			result = answer
+		}
+		result.Answers = append(result.Answers, QuestionAnswer{
+			Question:   question,
+			Answer:     answer.Answer,
+			Sources:    answer.Sources,
+			Confidence: answer.Confidence,
+			Unresolved: answer.Unresolved,
+		})
+	}
+	result.Stats = e.usage.snapshot()
+	result.Timings = e.usage.timingsSnapshot()
+	result.Trace = e.explorationTrace()
+	return result, nil
+}
+
+// explorationTrace returns the iterations of the exploration for a Verbose
+// request, nil otherwise.
+func (e *AnalysisEngine) explorationTrace() []IterationTrace {
+	if !e.request.Verbose {
+		return nil
+	}
+	return e.trace.recorded()
+}
+
+// answerQuestion generates the final answer to question. When the model
+// fails, the answer is a report of the collected findings and partial is
+// true; only a cancellation returns an error.
+func (e *AnalysisEngine) answerQuestion(question string, budgetExceeded bool) (result AnalysisResult, partial bool, err error) {
+	result, err = e.generateFinalAnswer(question)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate final answer: %w", err)
+		if isCancellation(err) {
+			return AnalysisResult{}, false, fmt.Errorf("failed to generate final answer: %w", err)
+		}
+		// Answer with what was collected rather than nothing
+		e.log.Warnf("Final answer generation failed, returning the collected findings: %v", err)
+		result = AnalysisResult{Answer: e.kb.PartialReport(err), Sources: e.kb.Sources("")}
+		partial = true
+	}
+	if budgetExceeded {
+		result.Answer += timeBudgetNotice(e.cfg.Analysis.MaxTotalDurationSeconds)
+	}
+	return result, partial, nil
+}
+
+// DryRun runs the initial analysis and a single planning step, and returns
+// the plan without executing it. The structure and README are still read so
+// that the plan is grounded in the project. The plan of a single-file
+// request is reading that file.
+func (e *AnalysisEngine) DryRun() ([]PlanStep, error) {
+	if relPath, ok := singleFilePath(e.request); ok {
+		e.kb.SetProjectType(singleFileType(relPath))
+		return []PlanStep{{Action: "READ_FILE", Argument: relPath}}, nil
 	}
 
-	return finalAnswer, nil
+	requestCtx := e.ctx
+	var stop context.CancelFunc
+	e.ctx, stop = withTimeBudget(requestCtx, e.cfg.Analysis.MaxTotalDurationSeconds)
+	defer func() {
+		stop()
+		e.ctx = requestCtx
+	}()
+
+	if err := e.initialAnalysis(noProgress); err != nil {
+		e.kb.AddNote(fmt.Sprintf("Error during initial analysis: %v", err))
+	}
+	plan, reasons, err := e.planNextSteps()
+	if err != nil {
+		return nil, err
+	}
+	return planSteps(plan, reasons), nil
 }
 
 // initialAnalysis performs the initial analysis of the project.
-func (e *AnalysisEngine) initialAnalysis() error {
+func (e *analysisState) initialAnalysis(report progressReporter) error {
+	report("step", "structure", "Analyzing directory structure...", 0, 0, "")
+
 	// Analyze directory structure
-	structure, err := getDirectoryStructure(e.kb.ProjectPath, config.AppConfig.Analysis.MaxDirectoryDepth, 0)
+	structure, err := getDirectoryStructure(e.cfg, e.kb.ProjectPath, directoryDepth(e.request, e.cfg), 0)
 	if err != nil {
 		return fmt.Errorf("failed to get directory structure: %w", err)
 	}
-	e.kb.ProjectStructure = structure
+	e.kb.SetProjectStructure(structure)
 	e.kb.AddHistory("Directory structure analysis complete.")
+	if links := symlinksIn(structure, ""); len(links) > 0 {
+		e.kb.AddNote(symlinksNote(links))
+	}
+
+	// Count files per language from their extensions
+	if err := e.kb.DetectLanguages(); err != nil {
+		e.kb.AddNote(fmt.Sprintf("Language detection failed: %v", err))
+	} else if breakdown := e.kb.languageBreakdown(); breakdown != "" {
+		report("step", "languages", fmt.Sprintf("Languages: %s", breakdown), 0, 0, "")
+	}
+
+	report("step", "discovery", "Discovering available project files...", 0, 0, "")
 
 	// Discover available project files
 	e.fileResolver.DiscoverProjectFiles()
 
-	// Read README file
-	readmePath := filepath.Join(e.kb.ProjectPath, "README.md")
-	if _, err := os.Stat(readmePath); err == nil {
-		content, err := readFileContent(readmePath)
-		if err != nil {
-			e.kb.AddNote(fmt.Sprintf("Error reading README: %v", err))
-		} else {
-			e.kb.AddFileContent(readmePath, content)
-			e.kb.ReadmeContent = content[:min(500, len(content))]
-			e.kb.AddHistory("README.md file read.")
+	// Index the words of the text files for SEARCH and the relevance hints
+	e.kb.searchIndex = buildSearchIndex(e.cfg, e.log, e.kb.ProjectPath)
+	report("step", "discovery", fmt.Sprintf("Found %d available files", len(e.kb.AvailableFiles)), 0, 0, "")
+
+	// Rule-based project type, refined by the model below
+	if guess := e.kb.DetectProjectType(); guess.Language != "" {
+		e.kb.AddHistory(fmt.Sprintf("Detected project type: %s (confidence %.0f%%)", guess.Label, guess.Confidence*100))
+		report("step", "type", fmt.Sprintf("Detected: %s (confidence %.0f%%)", guess.Label, guess.Confidence*100), 0, 0, "")
+	}
+
+	report("step", "readme", "Reading documentation files...", 0, 0, "")
+
+	// Read the README and the other documentation files of analysis.doc_files
+	if read := readDocFiles(e.log, e.kb); len(read) > 0 {
+		e.usage.addFilesRead(len(read))
+		report("step", "readme", fmt.Sprintf("Read %d documentation files: %s", len(read), strings.Join(read, ", ")), 0, 0, "")
+	} else {
+		report("step", "readme", "No README or documentation file found", 0, 0, "")
+	}
+
+	// Read the manifests and entry points listed in analysis.bootstrap_files
+	if read := readBootstrapFiles(e.log, e.kb); len(read) > 0 {
+		e.usage.addFilesRead(len(read))
+		report("step", "bootstrap", fmt.Sprintf("Read %d key files: %s", len(read), strings.Join(read, ", ")), 0, 0, "")
+	}
+
+	// List the likely entry points of the detected languages, see analysis.entry_points
+	if found := e.kb.DetectEntryPoints(e.cfg.Analysis.EntryPoints); len(found) > 0 {
+		e.kb.AddHistory(fmt.Sprintf("Likely entry points: %s", strings.Join(found, ", ")))
+		message := fmt.Sprintf("Likely entry points: %s", strings.Join(found, ", "))
+		if e.cfg.Analysis.ReadEntryPoints {
+			if read := readEntryPoints(e.log, e.kb, found); len(read) > 0 {
+				e.usage.addFilesRead(len(read))
+				message += fmt.Sprintf(" (%d read)", len(read))
+			}
+		}
+		report("step", "entry_points", message, 0, 0, "")
+	}
+
+	// Map the exported functions and types of the Go and JS/TS files, see analysis.extract_symbols
+	if e.cfg.Analysis.ExtractSymbols {
+		if files := e.kb.ExtractSymbols(); files > 0 {
+			e.kb.AddHistory(fmt.Sprintf("Exported symbols mapped in %d files", files))
+			report("step", "symbols", fmt.Sprintf("Exported symbols mapped in %d files", files), 0, 0, "")
 		}
 	}
 
+	report("step", "type", "Identifying project type...", 0, 0, "")
+
 	// Identify project type
 	typePrompt := fmt.Sprintf(`
 Initial project context for %s:
 Project Structure (partial): %v
+Languages (files per language): %s
+Rule-based detection from dependency files: %s
+Documentation (beginning): %s
 ---
 Based on the structure, what is the type of this project (e.g., Go Backend, React Frontend)?
-Be brief (1 sentence).`, filepath.Base(e.kb.ProjectPath), e.kb.ProjectStructure)
-	projectType, err := e.ollamaClient.ollamaRequest("You are a software architecture expert.", typePrompt)
+Refine the rule-based detection into a more descriptive label if you can.
+Be brief (1 sentence).`, filepath.Base(e.kb.ProjectPath), e.kb.ProjectStructure, e.kb.languageBreakdown(), e.kb.ProjectType, e.kb.ReadmeContent)
+	projectType, cached, err := requestProjectType(e.ctx, e.llmClient, e.request.Model, typePrompt)
 	if err == nil {
-		e.kb.SetProjectType(strings.TrimSpace(projectType))
+		e.kb.SetProjectType(projectType)
 		e.kb.AddHistory(fmt.Sprintf("Estimated project type: %s", e.kb.ProjectType))
+		message := fmt.Sprintf("Identified as: %s", e.kb.ProjectType)
+		if cached {
+			e.log.Infof("Project type found in cache: %s", projectType)
+			message += " (cached)"
+		}
+		report("step", "type", message, 0, 0, "")
+	} else if !isCancellation(err) {
+		e.kb.AddNote(fmt.Sprintf("Project type detection failed: %v", err))
+		report("error", "type", fmt.Sprintf("Project type detection failed: %v", err), 0, 0, "")
+	}
+
+	// Overview heading the context summaries, see analysis.project_overview
+	if e.cfg.Analysis.ProjectOverview {
+		report("step", "overview", "Writing the project overview...", 0, 0, "")
+		generated, err := refreshProjectOverview(e.ctx, e.llmClient, e.log, e.kb)
+		switch {
+		case err != nil && !isCancellation(err):
+			e.kb.AddNote(fmt.Sprintf("Project overview generation failed: %v", err))
+			report("error", "overview", fmt.Sprintf("Project overview generation failed: %v", err), 0, 0, "")
+		case err == nil && !generated:
+			report("step", "overview", "Project overview unchanged since the previous analysis", 0, 0, "")
+		case err == nil:
+			report("step", "overview", "Project overview written", 0, 0, e.kb.ProjectOverview)
+		}
 	}
 	return nil
 }
 
-// explorationLoop runs the exploration loop.
-func (e *AnalysisEngine) explorationLoop() error {
-	for i := 0; i < config.AppConfig.Analysis.MaxExplorationIterations; i++ {
-		logrus.Infof("--- Iteration %d/%d ---", i+1, config.AppConfig.Analysis.MaxExplorationIterations)
+// maxBootstrapFiles caps the files read up front, so that a broad pattern
+// cannot fill the context before the exploration starts.
+const maxBootstrapFiles = 10
+
+// maxDocFiles caps the documentation files read up front, and docExcerptSize
+// the beginning of each one kept in KnowledgeBase.ReadmeContent.
+const (
+	maxDocFiles    = 3
+	docExcerptSize = 500
+)
+
+// docFilePatterns returns analysis.doc_files, or the README variants of
+// CommonConfigFiles when it is empty.
+func docFilePatterns(cfg *config.Config) []string {
+	if patterns := cfg.Analysis.DocFiles; len(patterns) > 0 {
+		return patterns
+	}
+	var patterns []string
+	for _, file := range CommonConfigFiles {
+		if strings.HasPrefix(file, "README") {
+			patterns = append(patterns, file)
+		}
+	}
+	return patterns
+}
 
-		plan, err := e.planNextSteps()
+// readDocFiles reads into kb the first maxDocFiles documentation files
+// matching docFilePatterns (glob patterns relative to the project root, tried
+// in order), and sets kb.ReadmeContent to the beginning of each one. It
+// returns the relative paths of the files read.
+func readDocFiles(log *logrus.Entry, kb *KnowledgeBase) []string {
+	var read []string
+	var excerpts []string
+	seen := make(map[string]bool)
+	for _, pattern := range docFilePatterns(kb.cfg) {
+		matches, err := filepath.Glob(filepath.Join(kb.ProjectPath, filepath.FromSlash(pattern)))
 		if err != nil {
+			kb.AddNote(fmt.Sprintf("Invalid documentation file pattern '%s': %v", pattern, err))
+			continue
+		}
+		for _, fullPath := range matches {
+			relPath, _ := filepath.Rel(kb.ProjectPath, fullPath)
+			relPath = filepath.ToSlash(relPath)
+			if seen[relPath] || len(read) >= maxDocFiles {
+				continue
+			}
+			seen[relPath] = true
+
+			// A symlink could point to a host file, such as a README.md cloned from a repository
+			if _, err := resolveProjectPath(kb.ProjectPath, relPath); err != nil {
+				log.Warnf("Skipping documentation file: %v", err)
+				continue
+			}
+			info, err := os.Stat(fullPath)
+			if err != nil || info.IsDir() || isForbiddenFile(kb.cfg, relPath) {
+				continue
+			}
+			content, err := readFileContent(kb.cfg, log, fullPath)
+			if err != nil {
+				kb.AddNote(fmt.Sprintf("Error reading documentation file '%s': %v", relPath, err))
+				continue
+			}
+			kb.AddFileContent(fullPath, content)
+			kb.RecordFileStamp(fullPath, info)
+			read = append(read, relPath)
+			excerpt := truncateToTokens(strings.TrimSpace(content), docExcerptSize/charsPerToken)
+			excerpts = append(excerpts, fmt.Sprintf("[%s] %s", relPath, excerpt))
+		}
+	}
+	if len(read) > 0 {
+		kb.ReadmeContent = strings.Join(excerpts, "\n")
+		kb.AddHistory(fmt.Sprintf("Documentation files read: %s", strings.Join(read, ", ")))
+	}
+	return read
+}
+
+// readBootstrapFiles reads the project files matching analysis.bootstrap_files
+// (glob patterns relative to the project root) into kb, so that the first plan
+// already knows the manifests and entry points. Files already read, such as
+// the documentation files of readDocFiles, are skipped. It returns the
+// relative paths of the files read.
+func readBootstrapFiles(log *logrus.Entry, kb *KnowledgeBase) []string {
+	var read []string
+	seen := make(map[string]bool)
+	for _, pattern := range kb.cfg.Analysis.BootstrapFiles {
+		matches, err := filepath.Glob(filepath.Join(kb.ProjectPath, filepath.FromSlash(pattern)))
+		if err != nil {
+			kb.AddNote(fmt.Sprintf("Invalid bootstrap file pattern '%s': %v", pattern, err))
+			continue
+		}
+		for _, fullPath := range matches {
+			relPath, _ := filepath.Rel(kb.ProjectPath, fullPath)
+			relPath = filepath.ToSlash(relPath)
+			if seen[relPath] {
+				continue
+			}
+			seen[relPath] = true
+			if len(read) >= maxBootstrapFiles {
+				log.Warnf("More than %d bootstrap files, skipping '%s'.", maxBootstrapFiles, relPath)
+				continue
+			}
+
+			if _, err := resolveProjectPath(kb.ProjectPath, relPath); err != nil {
+				log.Warnf("Skipping bootstrap file: %v", err)
+				continue
+			}
+			info, err := os.Stat(fullPath)
+			if err != nil || info.IsDir() || isIgnoredEntry(kb.cfg, filepath.Base(fullPath), false) || isForbiddenFile(kb.cfg, relPath) || kb.IsFileUnchanged(fullPath, info) {
+				continue
+			}
+			content, err := readFileContent(kb.cfg, log, fullPath)
+			if err != nil {
+				kb.AddNote(fmt.Sprintf("Could not read bootstrap file '%s': %v", relPath, err))
+				continue
+			}
+			kb.AddFileContent(fullPath, content)
+			kb.RecordFileStamp(fullPath, info)
+			read = append(read, relPath)
+		}
+	}
+	if len(read) > 0 {
+		kb.AddHistory(fmt.Sprintf("Bootstrap files read: %s", strings.Join(read, ", ")))
+	}
+	return read
+}
+
+// readEntryPoints reads into kb the entry points found by
+// KnowledgeBase.DetectEntryPoints (relative paths), when
+// analysis.read_entry_points is set. Files already read and unchanged, such
+// as a main.go among the bootstrap files, are skipped. It returns the
+// relative paths of the files read.
+func readEntryPoints(log *logrus.Entry, kb *KnowledgeBase, entryPoints []string) []string {
+	var read []string
+	for _, relPath := range entryPoints {
+		fullPath, err := resolveProjectPath(kb.ProjectPath, filepath.FromSlash(relPath))
+		if err != nil {
+			log.Warnf("Skipping entry point: %v", err)
+			continue
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil || kb.IsFileUnchanged(fullPath, info) {
+			continue
+		}
+		content, err := readFileContent(kb.cfg, log, fullPath)
+		if err != nil {
+			kb.AddNote(fmt.Sprintf("Could not read entry point '%s': %v", relPath, err))
+			continue
+		}
+		kb.AddFileContent(fullPath, content)
+		kb.RecordFileStamp(fullPath, info)
+		read = append(read, relPath)
+	}
+	if len(read) > 0 {
+		kb.AddHistory(fmt.Sprintf("Entry points read: %s", strings.Join(read, ", ")))
+	}
+	return read
+}
+
+// explorationLoop runs the exploration loop: it plans the next steps and runs
+// them until the planner is done, stalls or the iterations run out.
+func (e *analysisState) explorationLoop(report progressReporter) error {
+	maxIterations := explorationIterations(e.request, e.cfg)
+	stall := newStallDetector(e.cfg.Analysis.StallIterations)
+	stall.finishWhenIdle(e.cfg.Analysis.ForceFinishIterations, e.kb)
+	for i := 0; i < maxIterations; i++ {
+		e.log.Infof("--- Iteration %d/%d ---", i+1, maxIterations)
+		report("step", "iteration", fmt.Sprintf("Planning iteration %d of %d...", i+1, maxIterations), i+1, maxIterations, "")
+		e.usage.addIteration()
+
+		plan, reasons, err := e.planNextSteps()
+		if err != nil {
+			if isCancellation(err) {
+				e.log.Info("Analysis cancelled, stopping exploration.")
+				return nil
+			}
+			if timeBudgetExceeded(e.ctx) {
+				return nil
+			}
 			e.kb.AddNote(fmt.Sprintf("Planning error in iteration %d: %v", i, err))
+			report("error", "planning", fmt.Sprintf("Planning error: %v", err), i+1, maxIterations, "")
+			e.trace.record(IterationTrace{Iteration: i + 1, PlanningError: err.Error()})
+			e.cutShort = true
 			continue
 		}
 
 		if len(plan) == 0 || (len(plan) == 1 && plan[0] == "FINISH") {
-			logrus.Info("Empty or 'FINISH' plan received, ending exploration.")
-			break
+			e.trace.record(IterationTrace{Iteration: i + 1, Plan: []PlanStep{}})
+			e.log.Info("Empty or 'FINISH' plan received, ending exploration.")
+			report("step", "finish", "Analysis complete - no more steps needed", i+1, maxIterations, "")
+			return nil
 		}
 		e.kb.ExplorationPlan = plan
 
-		e.executePlan(plan)
+		steps := e.executePlan(report, plan, reasons, i+1, maxIterations)
+		e.trace.record(IterationTrace{Iteration: i + 1, Plan: planSteps(plan, reasons), Steps: steps})
+
+		if stall.observe(plan, e.kb) {
+			if stall.isStalled() {
+				e.kb.AddNote(stallNote(stall.stalled))
+				e.log.Warnf("Planner stalled for %d iterations, ending exploration.", stall.stalled)
+				report("step", "stall", fmt.Sprintf("No progress in the last %d iterations, ending exploration", stall.stalled), i+1, maxIterations, "")
+			} else {
+				e.kb.AddNote(forceFinishNote(stall.idle))
+				e.log.Warnf("No new file nor note for %d iterations, forcing FINISH.", stall.idle)
+				report("step", "force_finish", fmt.Sprintf("No new file read nor note in the last %d iterations, finishing the exploration", stall.idle), i+1, maxIterations, "")
+			}
+			return nil
+		}
 	}
+	// The iterations ran out before the planner was done
+	e.cutShort = true
 	return nil
 }
 
+// explorationIterations returns the maximum number of exploration iterations for req.
+func explorationIterations(req AnalyzeRequest, cfg *config.Config) int {
+	if req.MaxIterations > 0 {
+		return req.MaxIterations
+	}
+	return cfg.Analysis.MaxExplorationIterations
+}
+
+// directoryDepth returns the depth of the project structure explored for req.
+func directoryDepth(req AnalyzeRequest, cfg *config.Config) int {
+	if req.MaxDepth > 0 {
+		return req.MaxDepth
+	}
+	return cfg.Analysis.MaxDirectoryDepth
+}
+
+// defaultFinalSystemPrompt is the system prompt of the final answer when
+// neither the request nor analysis.final_system_prompt sets one.
+const defaultFinalSystemPrompt = "You are an expert AI assistant who synthesizes technical information."
+
+// finalSystemPrompt returns the system prompt of the final answer for req.
+func finalSystemPrompt(req AnalyzeRequest, cfg *config.Config) string {
+	if req.SystemPrompt != "" {
+		return req.SystemPrompt
+	}
+	if prompt := strings.TrimSpace(cfg.Analysis.FinalSystemPrompt); prompt != "" {
+		return prompt
+	}
+	return defaultFinalSystemPrompt
+}
+
+// answerLanguages maps the codes accepted by the language field to the
+// language names given to the model.
+var answerLanguages = map[string]string{
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"zh": "Chinese",
+}
+
+// defaultAnswerLanguage is the language of the final answer when the request
+// does not set one.
+const defaultAnswerLanguage = "en"
+
+// languageInstruction returns the sentence of the synthesis prompt asking for
+// an answer in the language of req. Code, file names and identifiers stay as
+// they are.
+func languageInstruction(req AnalyzeRequest) string {
+	name, ok := answerLanguages[req.Language]
+	if !ok {
+		name = answerLanguages[defaultAnswerLanguage]
+	}
+	return fmt.Sprintf("Write the answer in %s, whatever the language of the context; keep code, file names and identifiers unchanged.", name)
+}
+
+// stallNote explains why the exploration ended before MaxExplorationIterations.
+func stallNote(iterations int) string {
+	return fmt.Sprintf("Exploration stopped early: the planner repeated previous steps for %d iterations without reading new files or producing new notes.", iterations)
+}
+
+// forceFinishNote explains why the exploration ended without a FINISH from
+// the planner (analysis.force_finish_iterations).
+func forceFinishNote(iterations int) string {
+	return fmt.Sprintf("Exploration finished without FINISH from the planner: the last %d iterations read no new file and added no note, the files read are what the answer can rely on.", iterations)
+}
+
+// errTimeBudgetExceeded is the cause of the exploration context once
+// analysis.max_total_duration_seconds has elapsed.
+var errTimeBudgetExceeded = errors.New("analysis time budget exceeded")
+
+// withTimeBudget bounds the initial analysis and the exploration by seconds
+// (analysis.max_total_duration_seconds). A model call still running when the
+// budget runs out is cancelled; the final answer is generated with the
+// request context afterwards.
+func withTimeBudget(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, time.Duration(seconds)*time.Second, errTimeBudgetExceeded)
+}
+
+// timeBudgetExceeded reports whether ctx ended because of the time budget.
+func timeBudgetExceeded(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTimeBudgetExceeded)
+}
+
+// timeBudgetNotice is appended to the final answer when the exploration was
+// cut short.
+func timeBudgetNotice(seconds int) string {
+	return fmt.Sprintf("\n\n_Note: the exploration was cut short by the %ds time budget (analysis.max_total_duration_seconds); this answer may be incomplete._", seconds)
+}
+
 // planNextSteps plans the next steps in the exploration.
-func (e *AnalysisEngine) planNextSteps() ([]string, error) {
-	contextSummary := e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength)
-	planPrompt := fmt.Sprintf(`
+func (e *analysisState) planNextSteps() ([]string, stepReasons, error) {
+	buildPlanPrompt := func(contextSummary string) string {
+		return plannerPrompt(e.cfg, e.request.Question, contextSummary)
+	}
+
+	rawPlan, err := e.conversation.ask(withGenerationPhase(e.ctx, phasePlanning), e.llmClient, e.kb, e.request.Question, plannerSystemPrompt, buildPlanPrompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, reasons := parsePlannerResponse(rawPlan)
+	return plan, reasons, nil
+}
+
+// plannerSystemPrompt is the system prompt of the planning requests.
+const plannerSystemPrompt = "You are a code exploration planner. Respond ONLY with the JSON array of actions."
+
+// plannerPrompt builds the planning request shared by both engines.
+func plannerPrompt(cfg *config.Config, question, contextSummary string) string {
+	return fmt.Sprintf(`
 Objective: Answer "%s"
 Current Context:
 %s
@@ -151,28 +809,73 @@ IMPORTANT GUIDELINES:
 - DO NOT request files that are listed as "Non Disponibles" - they don't exist
 - Use available dependency files when looking for project information
 - If you need dependency info, use the files listed in "Fichiers de Dépendances Disponibles"
+- To find where execution starts, begin with the files listed in "Points d'Entrée Probables"
 - Avoid repeating failed operations from previous iterations
-
-Propose the next 3-5 logical steps. Use actions: READ_FILE <path>, ANALYZE <subject>, FINISH.
-MANDATORY output format: Simple numbered list.
+- Use SEARCH <pattern> to locate where a symbol is defined instead of reading files blindly
+- Use LIST_DIR <path> to see inside a directory truncated by the depth limit
+%s
+Propose the next 3-5 logical steps. Use actions: READ_FILE <path>, SEARCH <pattern>, LIST_DIR <path>, ANALYZE <subject>, FINISH.
+SEARCH takes a regular expression or plain text and lists the matching files and lines.
+READ_FILE <path>:<start>-<end> reads only those lines, e.g. "server.go:120-180" around a line found by SEARCH. Use it for large files, which are otherwise truncated in the middle.
+LIST_DIR lists the contents of a project subdirectory (two levels deep).
+%sMANDATORY output format: a JSON array of objects with "action", "argument" and "reason" keys, nothing else.
+"reason" is a short sentence explaining why the step helps answer the objective.
 Example:
-1. READ_FILE main.go
-2. ANALYZE the application entry point
-`, e.request.Question, contextSummary)
+[
+  {"action": "SEARCH", "argument": "func main", "reason": "locate the entry point"},
+  {"action": "READ_FILE", "argument": "main.go", "reason": "see which commands are registered at startup"},
+  {"action": "ANALYZE", "argument": "the application entry point", "reason": "summarize the startup sequence"}
+]
+`, question, contextSummary, testFilesGuideline(cfg, question), commandsPromptSection(cfg))
+}
 
-	planSystemPrompt := "You are a code exploration planner. Respond ONLY with the numbered list of actions."
-	rawPlan, err := e.ollamaClient.ollamaRequest(planSystemPrompt, planPrompt)
-	if err != nil {
-		return nil, err
+// failureWords are the beginnings of the words, in English and French, of a
+// question about a bug or a failure.
+var failureWords = []string{"fail", "error", "bug", "crash", "panic", "broke", "break", "regress", "flak", "exception", "wrong", "échec", "échou", "erreur", "plant", "cassé"}
+
+// isFailureQuestion reports whether question is about a bug or a failure.
+func isFailureQuestion(question string) bool {
+	for _, keyword := range questionKeywords(question) {
+		for _, word := range failureWords {
+			if strings.HasPrefix(keyword, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// testFilesGuideline points the planner to the test files for a question
+// about a failure, when analysis.detect_test_files lists them.
+func testFilesGuideline(cfg *config.Config, question string) string {
+	if !cfg.Analysis.DetectTestFiles || !isFailureQuestion(question) {
+		return ""
+	}
+	return "- The question is about a failure: read the tests listed in \"Fichiers de Test\" that cover the code involved, they show the expected behavior and how to reproduce it\n"
+}
+
+// commandsPromptSection documents RUN_COMMAND in the planner prompt, only when
+// commands are enabled.
+func commandsPromptSection(cfg *config.Config) string {
+	if !commandsEnabled(cfg) {
+		return ""
 	}
-	return parsePlan(rawPlan), nil
+	return fmt.Sprintf("RUN_COMMAND <command> runs a build or test command in the project and records its output. Allowed commands, to use exactly as written: %s.\n",
+		strings.Join(cfg.Analysis.AllowedCommands, ", "))
 }
 
+// isCancellation reports whether err stems from a cancelled analysis context.
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
 
-func parsePlan(planStr string) []string {
+// parsePlan parses a numbered list of "ACTION argument" steps, each one
+// optionally followed by "— reason" (see splitStepReason).
+func parsePlan(planStr string) ([]string, stepReasons) {
 	lines := strings.Split(planStr, "\n")
 	plan := make([]string, 0)
-	actionRegex := regexp.MustCompile(`^\s*\d+\.\s*(READ_FILE|ANALYZE|FINISH)\s*(.*)$`)
+	reasons := make(stepReasons)
+	actionRegex := regexp.MustCompile(`^\s*\d+\.\s*(READ_FILE|SEARCH|LIST_DIR|RUN_COMMAND|ANALYZE|FINISH)\s*(.*)$`)
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -188,20 +891,213 @@ func parsePlan(planStr string) []string {
 			}
 
 			if len(matches) > 2 {
-				args := strings.TrimSpace(matches[2])
+				args, reason := splitStepReason(matches[2])
+				if action == "READ_FILE" {
+					args = normalizeReadFileArgument(args)
+				}
 				if args != "" {
-					plan = append(plan, fmt.Sprintf("%s %s", action, args))
+					plan = append(plan, reasons.add(fmt.Sprintf("%s %s", action, args), reason))
+				}
+			}
+		}
+	}
+	return plan, reasons
+}
+
+// planActions are the actions the planner may use.
+var planActions = map[string]bool{
+	"READ_FILE":   true,
+	"SEARCH":      true,
+	"LIST_DIR":    true,
+	"RUN_COMMAND": true,
+	"ANALYZE":     true,
+	"FINISH":      true,
+}
+
+// normalizeReadFileArgument rewrites the line ranges of a READ_FILE argument
+// in the "path:start-end" form executeReadFile expects, whatever the notation
+// the model used (see parseLineRange).
+func normalizeReadFileArgument(argument string) string {
+	if path, lines, ok := parseLineRange(argument); ok {
+		return path + ":" + lines.String()
+	}
+	return argument
+}
+
+// stepReasons maps the steps of a plan to the reason the planner gave for
+// them. Steps without a reason are absent.
+type stepReasons map[string]string
+
+// add records the reason of step, unless the step already has one, and
+// returns step.
+func (r stepReasons) add(step, reason string) string {
+	if _, found := r[step]; !found && reason != "" {
+		r[step] = reason
+	}
+	return step
+}
+
+// stepReasonSeparator separates a step from its reason in the
+// "ACTION argument — reason" form. An en dash or a double hyphen needs spaces
+// around it, so that line ranges such as "120–180" are left alone.
+var stepReasonSeparator = regexp.MustCompile(`\s*—\s*|\s+(?:–|--)\s+`)
+
+// splitStepReason splits "argument — reason" into the argument and the reason.
+func splitStepReason(text string) (argument, reason string) {
+	if loc := stepReasonSeparator.FindStringIndex(text); loc != nil {
+		return strings.TrimSpace(text[:loc[0]]), strings.TrimSpace(text[loc[1]:])
+	}
+	return strings.TrimSpace(text), ""
+}
+
+// parsePlannerResponse parses the planner output, preferring the JSON format
+// requested by plannerPrompt and falling back to the numbered list.
+func parsePlannerResponse(raw string) ([]string, stepReasons) {
+	if plan, reasons, ok := parseJSONPlan(raw); ok {
+		return plan, reasons
+	}
+	logrus.Debug("Plan is not valid JSON, falling back to the numbered list parser.")
+	return parsePlan(raw)
+}
+
+// parseJSONPlan parses a JSON array of {"action", "argument", "reason"}
+// objects, as produced by chat-tuned models: code fences, surrounding prose,
+// an object wrapping the array, alternative key names or plain
+// "ACTION argument — reason" strings are tolerated. ok is false when no JSON
+// array could be found.
+func parseJSONPlan(raw string) (plan []string, reasons stepReasons, ok bool) {
+	steps, ok := extractJSONSteps(raw)
+	if !ok {
+		return nil, nil, false
+	}
+
+	plan = make([]string, 0, len(steps))
+	reasons = make(stepReasons)
+	recognized := false
+	for _, step := range steps {
+		action, argument, reason := jsonPlanStep(step)
+		action = strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(action)))
+		argument = strings.TrimSpace(argument)
+		if !planActions[action] {
+			continue
+		}
+		recognized = true
+		if action == "FINISH" {
+			plan = append(plan, "FINISH")
+			break
+		}
+		if action == "READ_FILE" {
+			argument = normalizeReadFileArgument(argument)
+		}
+		if argument != "" {
+			plan = append(plan, reasons.add(fmt.Sprintf("%s %s", action, argument), reason))
+		}
+	}
+	if len(steps) > 0 && !recognized {
+		// Brackets in a numbered list, e.g. "ANALYZE item [1]", are not a JSON plan
+		return nil, nil, false
+	}
+	return plan, reasons, true
+}
+
+// extractJSONSteps locates the array of steps in a model response.
+func extractJSONSteps(raw string) ([]interface{}, bool) {
+	if start, end := strings.Index(raw, "["), strings.LastIndex(raw, "]"); start >= 0 && end > start {
+		var steps []interface{}
+		if err := json.Unmarshal([]byte(raw[start:end+1]), &steps); err == nil {
+			return steps, true
+		}
+	}
+
+	// An object wrapping the array, e.g. {"plan": [...]}
+	if start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}"); start >= 0 && end > start {
+		var wrapper map[string]interface{}
+		if err := json.Unmarshal([]byte(raw[start:end+1]), &wrapper); err == nil {
+			for _, value := range wrapper {
+				if steps, isArray := value.([]interface{}); isArray {
+					return steps, true
 				}
 			}
 		}
 	}
-	return plan
+	return nil, false
+}
+
+// jsonPlanStep extracts the action, argument and reason of a single JSON step.
+func jsonPlanStep(step interface{}) (action, argument, reason string) {
+	switch v := step.(type) {
+	case string:
+		action, argument, _ = strings.Cut(strings.TrimSpace(v), " ")
+		argument, reason = splitStepReason(argument)
+		return action, argument, reason
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			fields[strings.ToLower(key)] = value
+		}
+		for _, key := range []string{"action", "type", "step"} {
+			if value, found := fields[key]; found {
+				action = fmt.Sprint(value)
+				break
+			}
+		}
+		for _, key := range []string{"argument", "arguments", "args", "arg", "path", "pattern", "subject", "target"} {
+			if value, found := fields[key]; found && value != nil {
+				argument = fmt.Sprint(value)
+				break
+			}
+		}
+		for _, key := range []string{"reason", "rationale", "why", "justification"} {
+			if value, found := fields[key]; found && value != nil {
+				reason = strings.TrimSpace(fmt.Sprint(value))
+				break
+			}
+		}
+	}
+	return action, argument, reason
 }
 
-// executePlan executes the given exploration plan.
-func (e *AnalysisEngine) executePlan(plan []string) {
+// PlanStep is a parsed plan step, as returned by a dry run.
+type PlanStep struct {
+	Action   string `json:"action"`
+	Argument string `json:"argument,omitempty"`
+	Reason   string `json:"reason,omitempty"` // Why the planner chose the step
+}
+
+// planSteps splits the "ACTION argument" steps of plan, with their reasons.
+func planSteps(plan []string, reasons stepReasons) []PlanStep {
+	steps := make([]PlanStep, 0, len(plan))
 	for _, step := range plan {
-		logrus.Infof("Executing step: %s", step)
+		action, argument, _ := strings.Cut(step, " ")
+		steps = append(steps, PlanStep{Action: action, Argument: argument, Reason: reasons[step]})
+	}
+	return steps
+}
+
+// executePlan executes the given exploration plan, the iteration-th of total,
+// and returns the outcome of the steps executed.
+func (e *analysisState) executePlan(report progressReporter, plan []string, reasons stepReasons, iteration, total int) []StepTrace {
+	if kept, dropped := capPlanSteps(plan, e.cfg.Analysis.MaxStepsPerPlan); dropped > 0 {
+		e.log.Warnf("Plan of %d steps truncated to %d (analysis.max_steps_per_plan).", len(plan), len(kept))
+		e.kb.AddNote(planTruncatedNote(len(kept), len(plan)))
+		report("step", "truncated", fmt.Sprintf("Plan truncated to %d of its %d steps", len(kept), len(plan)), iteration, total, "")
+		plan = kept
+	}
+
+	e.prefetched = prefetchReads(e.ctx, e.log, e.kb, plan)
+	defer func() { e.prefetched = nil }()
+
+	steps := make([]StepTrace, 0, len(plan))
+	for _, step := range plan {
+		if e.ctx.Err() != nil {
+			return steps
+		}
+		if reason := reasons[step]; reason != "" {
+			e.log.Infof("Executing step: %s (%s)", step, reason)
+		} else {
+			e.log.Infof("Executing step: %s", step)
+		}
+		report("step", "execute", executeMessage(step, reasons[step]), iteration, total, "")
 		parts := strings.SplitN(step, " ", 2)
 		action := parts[0]
 		args := ""
@@ -209,334 +1105,583 @@ func (e *AnalysisEngine) executePlan(plan []string) {
 			args = parts[1]
 		}
 
+		notes := e.kb.NoteCount()
+		var err error
 		switch action {
 		case "READ_FILE":
-			e.executeReadFile(args)
+			err = e.executeReadFile(report, args, iteration, total)
+		case "SEARCH":
+			err = e.executeSearch(report, args, iteration, total)
+		case "LIST_DIR":
+			err = e.executeListDir(report, args, iteration, total)
+		case "RUN_COMMAND":
+			err = e.executeRunCommand(report, args, iteration, total)
 		case "ANALYZE":
-			e.executeAnalyze(args)
+			err = e.executeAnalyze(report, args, iteration, total)
 		}
+		steps = append(steps, newStepTrace(step, err, e.kb.NotesSince(notes)))
 	}
+	return steps
+}
+
+// capPlanSteps keeps the first maxSteps steps of plan (analysis.max_steps_per_plan,
+// 0 means unlimited) and returns them with the number of steps dropped.
+func capPlanSteps(plan []string, maxSteps int) ([]string, int) {
+	if maxSteps <= 0 || len(plan) <= maxSteps {
+		return plan, 0
+	}
+	return plan[:maxSteps], len(plan) - maxSteps
+}
+
+// planTruncatedNote records in the knowledge base that only the first kept of
+// the total steps of a plan were executed.
+func planTruncatedNote(kept, total int) string {
+	return fmt.Sprintf("Plan truncated to %d of its %d steps (analysis.max_steps_per_plan), the others were not executed.", kept, total)
 }
 
 // executeReadFile reads a file and adds its content to the knowledge base.
-func (e *AnalysisEngine) executeReadFile(filePath string) {
+// It returns the reason of a failed read, also recorded as a note.
+func (e *analysisState) executeReadFile(report progressReporter, filePath string, iteration, total int) error {
+	// READ_FILE <path>:<start>-<end> only reads those lines
+	filePath, lines, hasRange := parseLineRange(filePath)
+	report("step", "read", fmt.Sprintf("Resolving file: %s", filePath), iteration, total, "")
+
+	// Refuse paths escaping the project before looking anything up
+	if _, err := resolveProjectPath(e.kb.ProjectPath, filePath); err != nil {
+		e.kb.AddNote(fmt.Sprintf("Refused step 'READ_FILE %s': %v", filePath, err))
+		report("error", "read", fmt.Sprintf("Refused to read %s: %v", filePath, err), iteration, total, "")
+		return err
+	}
+
 	// Use FileResolver to find the best available file
 	resolvedFile, err := e.fileResolver.ResolveFile(filePath)
 	if err != nil {
 		e.kb.AddNote(fmt.Sprintf("Failed to resolve file '%s': %v", filePath, err))
+		report("error", "read", fmt.Sprintf("Failed to resolve %s: %v", filePath, err), iteration, total, "")
 
 		// Suggest alternatives if available
-		if strings.Contains(strings.ToLower(filePath), "composer") {
-			alternatives := e.fileResolver.GetAvailableAlternatives("composer")
-			if len(alternatives) > 0 {
-				e.kb.AddNote(fmt.Sprintf("Available composer alternatives: %v", alternatives))
-			}
-		} else if strings.Contains(strings.ToLower(filePath), "package") {
-			alternatives := e.fileResolver.GetAvailableAlternatives("npm")
-			if len(alternatives) > 0 {
-				e.kb.AddNote(fmt.Sprintf("Available npm alternatives: %v", alternatives))
-			}
+		if hint := alternativesHint(e.fileResolver, filePath); hint != "" {
+			e.kb.AddNote(hint)
+			report("step", "read", hint, iteration, total, "")
 		}
-		return
+		return err
 	}
 
-	// Read the resolved file
-	fullPath := filepath.Join(e.kb.ProjectPath, resolvedFile)
-	content, err := readFileContent(fullPath)
+	if resolvedFile != filePath {
+		report("step", "read", fmt.Sprintf("Using alternative file: %s", resolvedFile), iteration, total, "")
+	}
+
+	// The resolver may have substituted another file, which is checked in turn
+	fullPath, err := resolveProjectPath(e.kb.ProjectPath, resolvedFile)
 	if err != nil {
+		e.kb.AddNote(fmt.Sprintf("Refused step 'READ_FILE %s': %v", filePath, err))
+		report("error", "read", fmt.Sprintf("Refused to read %s: %v", resolvedFile, err), iteration, total, "")
+		return err
+	}
+
+	if isForbiddenFile(e.cfg, resolvedFile) {
+		e.kb.AddNote(forbiddenFileNote(resolvedFile))
+		report("step", "read", fmt.Sprintf("Refused to read forbidden file: %s", resolvedFile), iteration, total, "")
+		return errForbiddenFile
+	}
+
+	if hasRange {
+		if _, err := readFileRange(e.log, e.kb, resolvedFile, lines); err != nil {
+			e.kb.AddNote(fmt.Sprintf("Failed to read lines %s of '%s': %v", lines, resolvedFile, err))
+			report("error", "read", fmt.Sprintf("Failed to read lines %s of %s: %v", lines, resolvedFile, err), iteration, total, "")
+			return err
+		}
+		e.usage.addFilesRead(1)
+		report("step", "read", fmt.Sprintf("Successfully read lines %s of %s", lines, resolvedFile), iteration, total, "")
+		return nil
+	}
+
+	// Skip files already read that have not changed on disk since
+	info, statErr := os.Stat(fullPath)
+	if statErr == nil && e.kb.IsFileUnchanged(fullPath, info) {
+		e.kb.AddNote(fmt.Sprintf("File '%s' was already read and is unchanged", resolvedFile))
+		report("step", "read", fmt.Sprintf("Already read: %s (unchanged)", resolvedFile), iteration, total, "")
+		return nil
+	}
+
+	// Read the resolved file
+	content, err := e.prefetched.read(e.cfg, e.log, fullPath)
+	if errors.Is(err, ErrBinaryFile) {
+		e.kb.AddNote(fmt.Sprintf("Skipped binary file '%s'", resolvedFile))
+		e.kb.AddFailedFileAttempt(resolvedFile)
+		report("step", "read", fmt.Sprintf("Skipped binary file: %s", resolvedFile), iteration, total, "")
+	} else if errors.Is(err, ErrUnsupportedEncoding) {
+		e.kb.AddNote(fmt.Sprintf("Unreadable file '%s': %v", resolvedFile, err))
+		e.kb.AddFailedFileAttempt(resolvedFile)
+		report("error", "read", fmt.Sprintf("Unreadable file %s: %v", resolvedFile, err), iteration, total, "")
+	} else if err != nil {
 		e.kb.AddNote(fmt.Sprintf("Failed to read resolved file '%s': %v", resolvedFile, err))
 		e.kb.AddFailedFileAttempt(resolvedFile)
+		report("error", "read", fmt.Sprintf("Failed to read %s: %v", resolvedFile, err), iteration, total, "")
 	} else {
 		e.kb.AddFileContent(fullPath, content)
+		e.usage.addFilesRead(1)
+		if statErr == nil {
+			e.kb.RecordFileStamp(fullPath, info)
+		}
+		successMsg := fmt.Sprintf("Successfully read: %s (%d bytes)", resolvedFile, len(content))
 		if resolvedFile != filePath {
+			// Keep track of the substitution so the final answer cites the right file
 			e.kb.AddNote(fmt.Sprintf("Successfully read '%s' (alternative for '%s')", resolvedFile, filePath))
+			successMsg += fmt.Sprintf(" (alternative for %s)", filePath)
 		}
+		report("step", "read", successMsg, iteration, total, "")
 	}
+	return err
 }
 
-// executeAnalyze analyzes a subject and adds the result to the knowledge base.
-func (e *AnalysisEngine) executeAnalyze(subject string) {
-	analysisPrompt := fmt.Sprintf(`
-Context: %s
----
-Analyze the following question: "%s"`, e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength), subject)
-	analysisResult, err := e.ollamaClient.ollamaRequest("You are a code analysis assistant.", analysisPrompt)
+// errForbiddenFile is the outcome of a READ_FILE step refused by
+// analysis.forbidden_files.
+var errForbiddenFile = errors.New("the file matches analysis.forbidden_files")
+
+// forbiddenFileNote is the note recorded in place of the content of a file
+// matching analysis.forbidden_files.
+func forbiddenFileNote(relPath string) string {
+	return fmt.Sprintf("Refused to read '%s': it matches analysis.forbidden_files and may contain secrets (content redacted)", relPath)
+}
+
+// readFileRange reads lines of the project file relPath into kb, under the
+// "path:start-end" key so that the excerpt neither replaces nor passes for
+// the whole file. It returns that key.
+func readFileRange(log *logrus.Entry, kb *KnowledgeBase, relPath string, lines lineRange) (string, error) {
+	fullPath := filepath.Join(kb.ProjectPath, relPath)
+	info, statErr := os.Stat(fullPath) // Before reading, so that a later change is detected
+	content, err := readFileLines(kb.cfg, log, fullPath, lines)
 	if err != nil {
-		e.kb.AddNote(fmt.Sprintf("Failed to analyze '%s': %v", subject, err))
-	} else {
-		e.kb.AddNote(fmt.Sprintf("Analysis of '%s': %s", subject, analysisResult))
+		return "", err
 	}
+	key := relPath + ":" + lines.String()
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, key), content)
+	if statErr == nil {
+		kb.RecordFileStamp(filepath.Join(kb.ProjectPath, key), info)
+	}
+	return key, nil
 }
 
-// generateFinalAnswer generates the final answer based on the collected knowledge.
-func (e *AnalysisEngine) generateFinalAnswer() (string, error) {
-	finalContext := e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength)
-	finalPrompt := fmt.Sprintf(`
-Final collected context:
-%s
----
-Synthesize all this information to provide a complete and structured answer to the user's initial question: "%s"`, finalContext, e.request.Question)
+// alternativesHint lists the dependency files available in place of a file
+// that could not be resolved, or returns an empty string if there are none.
+func alternativesHint(fileResolver *FileResolver, filePath string) string {
+	var fileType string
+	switch lower := strings.ToLower(filePath); {
+	case strings.Contains(lower, "composer"):
+		fileType = "composer"
+	case strings.Contains(lower, "package"):
+		fileType = "npm"
+	default:
+		return ""
+	}
 
-	return e.ollamaClient.ollamaRequest("You are an expert AI assistant who synthesizes technical information.", finalPrompt)
+	alternatives := fileResolver.GetAvailableAlternatives(fileType)
+	if len(alternatives) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Available %s alternatives: %v", fileType, alternatives)
 }
 
-// NewStreamingAnalysisEngine creates a new StreamingAnalysisEngine.
-func NewStreamingAnalysisEngine(req AnalyzeRequest) (*StreamingAnalysisEngine, error) {
-	kb := NewKnowledgeBase(req.ProjectPath)
-	ollamaClient, err := NewOllamaClient()
+// executeSearch searches the project files and records the matches as a note.
+func (e *analysisState) executeSearch(report progressReporter, pattern string, iteration, total int) error {
+	report("step", "search", fmt.Sprintf("Searching: %s", pattern), iteration, total, "")
+	note, err := searchNote(e.kb, pattern)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Ollama client: %w", err)
+		e.kb.AddNote(fmt.Sprintf("Search for '%s' failed: %v", pattern, err))
+		report("error", "search", fmt.Sprintf("Search failed for %s: %v", pattern, err), iteration, total, "")
+		return err
 	}
-
-	fileResolver := NewFileResolver(req.ProjectPath, kb)
-
-	return &StreamingAnalysisEngine{
-		kb:           kb,
-		ollamaClient: ollamaClient,
-		request:      req,
-		fileResolver: fileResolver,
-	}, nil
+	e.kb.AddNote(note)
+	report("step", "search", strings.SplitN(note, "\n", 2)[0], iteration, total, "")
+	return nil
 }
 
-// sendEvent sends a streaming event to the client
-func (e *StreamingAnalysisEngine) sendEvent(w http.ResponseWriter, eventType, step, message string, iteration, total int, data string) {
-	event := map[string]interface{}{
-		"type":      eventType,
-		"step":      step,
-		"message":   message,
-		"iteration": iteration,
-		"total":     total,
-		"data":      data,
+// searchNote runs a project search and formats the matches as a knowledge base note.
+func searchNote(kb *KnowledgeBase, pattern string) (string, error) {
+	pattern = strings.Trim(pattern, "\"'`")
+	matches, err := searchProject(kb.cfg, kb.ProjectPath, pattern, maxSearchResults, kb.searchIndex)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return fmt.Sprintf("Search for '%s': no matches.", pattern), nil
 	}
-	eventData, _ := json.Marshal(event)
-	fmt.Fprintf(w, "data: %s\n\n", eventData)
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
+
+	var note strings.Builder
+	note.WriteString(fmt.Sprintf("Search for '%s' (%d matches):", pattern, len(matches)))
+	for _, m := range matches {
+		note.WriteString(fmt.Sprintf("\n- %s:%d: %s", m.Path, m.Line, m.Snippet))
 	}
+	return note.String(), nil
 }
 
-// RunStreamingAnalysis runs the full analysis process with streaming updates.
-func (e *StreamingAnalysisEngine) RunStreamingAnalysis(w http.ResponseWriter) {
-	e.sendEvent(w, "progress", "initial", "Starting initial project analysis...", 0, 0, "")
+// executeListDir lists a project subdirectory and records it as a note.
+func (e *analysisState) executeListDir(report progressReporter, dirPath string, iteration, total int) error {
+	report("step", "list", fmt.Sprintf("Listing directory: %s", dirPath), iteration, total, "")
+	note, err := listDirNote(e.cfg, e.kb.ProjectPath, dirPath)
+	if err != nil {
+		e.kb.AddNote(fmt.Sprintf("Failed to list directory '%s': %v", dirPath, err))
+		report("error", "list", fmt.Sprintf("Failed to list %s: %v", dirPath, err), iteration, total, "")
+		return err
+	}
+	e.kb.AddNote(note)
+	report("step", "list", fmt.Sprintf("Listed directory: %s", dirPath), iteration, total, "")
+	return nil
+}
 
-	if err := e.initialStreamingAnalysis(w); err != nil {
-		e.kb.AddNote(fmt.Sprintf("Error during initial analysis: %v", err))
-		e.sendEvent(w, "error", "initial", fmt.Sprintf("Error during initial analysis: %v", err), 0, 0, "")
+// listDirNote lists a project subdirectory and formats it as a knowledge base note.
+func listDirNote(cfg *config.Config, projectPath, dirPath string) (string, error) {
+	dirPath = strings.Trim(dirPath, "\"'`")
+	if _, err := resolveProjectPath(projectPath, dirPath); err != nil {
+		return "", err
 	}
 
-	e.sendEvent(w, "progress", "exploration", "Starting exploration loop...", 0, 0, "")
+	structure, err := getSubdirectoryStructure(cfg, projectPath, dirPath, listDirDepth)
+	if err != nil {
+		return "", err
+	}
+	structureBytes, err := json.MarshalIndent(structure, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render directory listing: %w", err)
+	}
+	return fmt.Sprintf("Contents of '%s':\n%s", dirPath, structureBytes), nil
+}
 
-	if err := e.explorationStreamingLoop(w); err != nil {
-		e.kb.AddNote(fmt.Sprintf("Error during exploration loop: %v", err))
-		e.sendEvent(w, "error", "exploration", fmt.Sprintf("Error during exploration: %v", err), 0, 0, "")
+// resolveProjectPath joins a path requested by the planner to the project
+// root and rejects any path that would escape it, through ".." segments or
+// through a symlink pointing outside the project.
+func resolveProjectPath(projectPath, requested string) (string, error) {
+	fullPath := filepath.Join(projectPath, requested)
+	rel, err := filepath.Rel(projectPath, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path '%s' is outside the project directory", requested)
 	}
+	if resolved, err := filepath.EvalSymlinks(fullPath); err == nil {
+		root, err := filepath.EvalSymlinks(projectPath)
+		if err != nil || !isWithinDir(root, resolved) {
+			return "", fmt.Errorf("path '%s' is outside the project directory", requested)
+		}
+	}
+	return fullPath, nil
+}
 
-	e.sendEvent(w, "progress", "final", "Generating final answer...", 0, 0, "")
+// executeRunCommand runs an allowed command in the project directory and
+// records its output as a note, see commandNote.
+func (e *analysisState) executeRunCommand(report progressReporter, command string, iteration, total int) error {
+	report("step", "command", fmt.Sprintf("Running: %s", command), iteration, total, "")
+	note, err := commandNote(e.ctx, e.kb.ProjectPath, command)
+	if err != nil {
+		e.kb.AddNote(fmt.Sprintf("Command '%s' refused or failed: %v", command, err))
+		report("error", "command", fmt.Sprintf("Command refused or failed: %v", err), iteration, total, "")
+		return err
+	}
+	e.kb.AddNote(note)
+	report("step", "command", strings.SplitN(note, "\n", 2)[0], iteration, total, "")
+	return nil
+}
 
-	finalAnswer, err := e.generateStreamingFinalAnswer(w)
+// executeAnalyze analyzes a subject and adds the result to the knowledge base.
+func (e *analysisState) executeAnalyze(report progressReporter, subject string, iteration, total int) error {
+	report("step", "analyze", fmt.Sprintf("Analyzing: %s", subject), iteration, total, "")
+	buildAnalysisPrompt := func(contextSummary string) string {
+		return fmt.Sprintf(`
+Context: %s
+---
+Analyze the following question: "%s"
+%s`, contextSummary, subject, analysisLengthInstruction(e.cfg))
+	}
+	analysisResult, err := e.conversation.ask(e.ctx, e.llmClient, e.kb, e.request.Question, "You are a code analysis assistant.", buildAnalysisPrompt)
 	if err != nil {
-		e.sendEvent(w, "error", "final", fmt.Sprintf("Error generating final answer: %v", err), 0, 0, "")
-		return
+		if isCancellation(err) {
+			return err
+		}
+		e.kb.AddNote(fmt.Sprintf("Failed to analyze '%s': %v", subject, err))
+		report("error", "analyze", fmt.Sprintf("Analysis failed for %s: %v", subject, err), iteration, total, "")
+	} else if strings.TrimSpace(analysisResult) != "" {
+		e.kb.AddAnalysis(subject, analysisResult)
+		report("finding", "analyze", fmt.Sprintf("Analysis complete: %s", subject), iteration, total, findingExcerpt(analysisResult))
 	}
+	return err
+}
 
-	e.sendEvent(w, "result", "complete", "Analysis completed successfully!", 0, 0, finalAnswer)
+// analysisLengthInstruction asks for an ANALYZE answer that fits in
+// analysis.max_analysis_chars, conclusion first so that the summary kept in
+// the notes is meaningful.
+func analysisLengthInstruction(cfg *config.Config) string {
+	maxChars := cfg.Analysis.MaxAnalysisChars
+	if maxChars <= 0 {
+		return "Start with a one-sentence conclusion."
+	}
+	return fmt.Sprintf("Start with a one-sentence conclusion, then give the supporting details in at most %d words.", max(maxChars/6, 20))
 }
 
-// initialStreamingAnalysis performs the initial analysis with streaming updates.
-func (e *StreamingAnalysisEngine) initialStreamingAnalysis(w http.ResponseWriter) error {
-	e.sendEvent(w, "step", "structure", "Analyzing directory structure...", 0, 0, "")
+// finalPrompt returns the prompt of the final answer to question, with the
+// collected knowledge.
+func (e *analysisState) finalPrompt(question string) string {
+	finalContext := e.kb.getFinalContext(question, contextTokenBudget(e.cfg))
+	return fmt.Sprintf(`
+Final collected context:
+%s
+---
+Synthesize all this information to provide a complete and structured answer to the user's initial question: "%s"
+%s
+%s`, finalContext, question, languageInstruction(e.request), selfAssessmentInstruction(e.cutShort))
+}
 
-	// Analyze directory structure
-	structure, err := getDirectoryStructure(e.kb.ProjectPath, config.AppConfig.Analysis.MaxDirectoryDepth, 0)
+// generateFinalAnswer generates the final answer to question based on the
+// collected knowledge.
+func (e *AnalysisEngine) generateFinalAnswer(question string) (AnalysisResult, error) {
+	answer, err := e.llmClient.Request(withGenerationPhase(e.ctx, phaseSynthesis), finalSystemPrompt(e.request, e.cfg), e.finalPrompt(question))
 	if err != nil {
-		return fmt.Errorf("failed to get directory structure: %w", err)
+		return AnalysisResult{}, err
 	}
-	e.kb.ProjectStructure = structure
-	e.kb.AddHistory("Directory structure analysis complete.")
+	answer, confidence, unresolved := parseSelfAssessment(answer)
+	return AnalysisResult{
+		Answer:     answer,
+		Sources:    e.kb.Sources(answer),
+		Confidence: capConfidence(confidence, e.cutShort),
+		Unresolved: unresolved,
+	}, nil
+}
 
-	e.sendEvent(w, "step", "discovery", "Discovering available project files...", 0, 0, "")
+// NewStreamingAnalysisEngine creates a new StreamingAnalysisEngine.
+// The context is typically derived from the HTTP request so that a
+// disconnected client stops the exploration loop. As with NewAnalysisEngine,
+// the analysis keeps the configuration in effect when it is created.
+func NewStreamingAnalysisEngine(ctx context.Context, req AnalyzeRequest) (*StreamingAnalysisEngine, error) {
+	ctx = config.NewContext(ctx, config.FromContext(ctx))
+	llmClient, err := NewLLMClient(ctx, req.Model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LLM client: %w", err)
+	}
+	return NewStreamingAnalysisEngineWithClient(ctx, req, llmClient), nil
+}
 
-	// Discover available project files
-	e.fileResolver.DiscoverProjectFiles()
-	e.sendEvent(w, "step", "discovery", fmt.Sprintf("Found %d available files", len(e.kb.AvailableFiles)), 0, 0, "")
+// NewStreamingAnalysisEngineWithClient creates a StreamingAnalysisEngine that queries llmClient
+// instead of the configured provider, e.g. a fake in tests.
+func NewStreamingAnalysisEngineWithClient(ctx context.Context, req AnalyzeRequest, llmClient LLMClient) *StreamingAnalysisEngine {
+	return &StreamingAnalysisEngine{newAnalysisState(ctx, req, llmClient)}
+}
+
+// sendEvent sends a streaming event to the client
+func (e *StreamingAnalysisEngine) sendEvent(w progressSink, eventType, step, message string, iteration, total int, data string) {
+	w.send(ProgressEvent{
+		Type:      eventType,
+		Step:      step,
+		Message:   message,
+		Iteration: iteration,
+		Total:     total,
+		Data:      data,
+	})
+}
 
-	e.sendEvent(w, "step", "readme", "Reading README file...", 0, 0, "")
+// progress returns the progressReporter sending the progress of the analysis
+// to w.
+func (e *StreamingAnalysisEngine) progress(w progressSink) progressReporter {
+	return func(eventType, step, message string, iteration, total int, data string) {
+		e.sendEvent(w, eventType, step, message, iteration, total, data)
+	}
+}
 
-	// Read README file
-	readmePath := filepath.Join(e.kb.ProjectPath, "README.md")
-	if _, err := os.Stat(readmePath); err == nil {
-		content, err := readFileContent(readmePath)
-		if err != nil {
-			e.kb.AddNote(fmt.Sprintf("Error reading README: %v", err))
+// RunStreamingAnalysis runs the full analysis process with streaming updates.
+func (e *StreamingAnalysisEngine) RunStreamingAnalysis(w progressSink) {
+	start, outcome := time.Now(), analysisCancelled
+	defer func() { observeAnalysis(outcome, start) }()
+
+	// The initial analysis and the exploration run under the time budget
+	requestCtx := e.ctx
+	var stop context.CancelFunc
+	e.ctx, stop = withTimeBudget(requestCtx, e.cfg.Analysis.MaxTotalDurationSeconds)
+
+	if relPath, ok := singleFilePath(e.request); ok {
+		e.sendEvent(w, "progress", "initial", "Single file upload, skipping the project exploration...", 0, 0, "")
+		e.sendEvent(w, "step", "read", fmt.Sprintf("Reading file: %s", relPath), 0, 0, "")
+		if err := readSingleFile(e.log, e.kb, relPath); err != nil {
+			e.kb.AddNote(fmt.Sprintf("Failed to read the uploaded file '%s': %v", relPath, err))
+			e.sendEvent(w, "error", "read", fmt.Sprintf("Failed to read %s: %v", relPath, err), 0, 0, "")
 		} else {
-			e.kb.AddFileContent(readmePath, content)
-			e.kb.ReadmeContent = content[:min(500, len(content))]
-			e.kb.AddHistory("README.md file read.")
-			e.sendEvent(w, "step", "readme", "README file processed successfully", 0, 0, "")
+			e.usage.addFilesRead(1)
+			e.sendEvent(w, "step", "read", fmt.Sprintf("Successfully read: %s (%s)", relPath, e.kb.ProjectType), 0, 0, "")
 		}
 	} else {
-		e.sendEvent(w, "step", "readme", "No README file found", 0, 0, "")
+		e.sendEvent(w, "progress", "initial", "Starting initial project analysis...", 0, 0, "")
+
+		if err := e.initialAnalysis(e.progress(w)); err != nil {
+			e.kb.AddNote(fmt.Sprintf("Error during initial analysis: %v", err))
+			e.sendEvent(w, "error", "initial", fmt.Sprintf("Error during initial analysis: %v", err), 0, 0, "")
+		}
+
+		e.sendEvent(w, "progress", "exploration", "Starting exploration loop...", 0, 0, "")
+
+		if err := e.explorationLoop(e.progress(w)); err != nil {
+			e.kb.AddNote(fmt.Sprintf("Error during exploration loop: %v", err))
+			e.sendEvent(w, "error", "exploration", fmt.Sprintf("Error during exploration: %v", err), 0, 0, "")
+		}
 	}
 
-	e.sendEvent(w, "step", "type", "Identifying project type...", 0, 0, "")
+	budgetExceeded := timeBudgetExceeded(e.ctx)
+	stop()
+	e.ctx = requestCtx
 
-	// Identify project type
-	typePrompt := fmt.Sprintf(`
-Initial project context for %s:
-Project Structure (partial): %v
----
-Based on the structure, what is the type of this project (e.g., Go Backend, React Frontend)?
-Be brief (1 sentence).`, filepath.Base(e.kb.ProjectPath), e.kb.ProjectStructure)
-	projectType, err := e.ollamaClient.ollamaRequest("You are a software architecture expert.", typePrompt)
-	if err == nil {
-		e.kb.SetProjectType(strings.TrimSpace(projectType))
-		e.kb.AddHistory(fmt.Sprintf("Estimated project type: %s", e.kb.ProjectType))
-		e.sendEvent(w, "step", "type", fmt.Sprintf("Identified as: %s", e.kb.ProjectType), 0, 0, "")
+	// The client went away, or asked to stop through POST /cancel/{id}
+	if e.ctx.Err() != nil {
+		e.log.Info("Streaming analysis cancelled by client, stopping.")
+		e.sendCancelled(w)
+		return
+	}
+	if budgetExceeded {
+		seconds := e.cfg.Analysis.MaxTotalDurationSeconds
+		e.log.Warnf("Time budget of %ds exceeded, ending exploration.", seconds)
+		e.kb.AddNote("Exploration was cut short by the time budget: the answer may be incomplete.")
+		e.cutShort = true
+		e.sendEvent(w, "step", "budget", fmt.Sprintf("Time budget of %ds exceeded, ending exploration", seconds), 0, 0, "")
 	}
-	return nil
-}
 
-// explorationStreamingLoop runs the exploration loop with streaming updates.
-func (e *StreamingAnalysisEngine) explorationStreamingLoop(w http.ResponseWriter) error {
-	maxIterations := config.AppConfig.Analysis.MaxExplorationIterations
-	for i := 0; i < maxIterations; i++ {
-		e.sendEvent(w, "step", "iteration", fmt.Sprintf("Planning iteration %d of %d...", i+1, maxIterations), i+1, maxIterations, "")
+	saveKnowledgeBaseCache(e.kb, e.cacheDir())
+
+	e.sendEvent(w, "progress", "final", "Generating final answer...", 0, 0, "")
 
-		plan, err := e.planNextSteps()
+	if len(e.request.Questions) == 0 {
+		result, partial, err := e.streamAnswer(w, e.request.Question, budgetExceeded)
 		if err != nil {
-			e.kb.AddNote(fmt.Sprintf("Planning error in iteration %d: %v", i, err))
-			e.sendEvent(w, "error", "planning", fmt.Sprintf("Planning error: %v", err), i+1, maxIterations, "")
-			continue
+			e.sendCancelled(w)
+			return
 		}
-
-		if len(plan) == 0 || (len(plan) == 1 && plan[0] == "FINISH") {
-			e.sendEvent(w, "step", "finish", "Analysis complete - no more steps needed", i+1, maxIterations, "")
-			break
+		outcome = analysisCompleted
+		if partial {
+			outcome = analysisPartial
 		}
-		e.kb.ExplorationPlan = plan
-
-		e.executeStreamingPlan(w, plan, i+1, maxIterations)
+		result.Stats = e.usage.snapshot()
+		e.sendResult(w, result)
+		return
 	}
-	return nil
-}
 
-// executeStreamingPlan executes the given exploration plan with streaming updates.
-func (e *StreamingAnalysisEngine) executeStreamingPlan(w http.ResponseWriter, plan []string, iteration, total int) {
-	for stepIndex, step := range plan {
-		e.sendEvent(w, "step", "execute", fmt.Sprintf("Executing: %s", step), iteration, total, "")
-		parts := strings.SplitN(step, " ", 2)
-		action := parts[0]
-		args := ""
-		if len(parts) > 1 {
-			args = parts[1]
+	// Each question gets its own answer from the shared exploration, its
+	// tokens preceded by a "question" step
+	var result AnalysisResult
+	anyPartial := false
+	for i, question := range e.request.Questions {
+		e.sendEvent(w, "step", "question", fmt.Sprintf("Answering question %d of %d: %s", i+1, len(e.request.Questions), question), i+1, len(e.request.Questions), question)
+		answer, partial, err := e.streamAnswer(w, question, budgetExceeded)
+		if err != nil {
+			e.sendCancelled(w)
+			return
 		}
-
-		switch action {
-		case "READ_FILE":
-			e.executeStreamingReadFile(w, args, iteration, total, stepIndex+1, len(plan))
-		case "ANALYZE":
-			e.executeStreamingAnalyze(w, args, iteration, total, stepIndex+1, len(plan))
+		anyPartial = anyPartial || partial
+		if i == 0 {
+			result = answer
 		}
+		result.Answers = append(result.Answers, QuestionAnswer{
+			Question:   question,
+			Answer:     answer.Answer,
+			Sources:    answer.Sources,
+			Confidence: answer.Confidence,
+			Unresolved: answer.Unresolved,
+		})
+	}
+	outcome = analysisCompleted
+	if anyPartial {
+		outcome = analysisPartial
 	}
+	result.Stats = e.usage.snapshot()
+	e.sendResult(w, result)
 }
 
-// executeStreamingReadFile reads a file with streaming updates.
-func (e *StreamingAnalysisEngine) executeStreamingReadFile(w http.ResponseWriter, filePath string, iteration, total, stepNum, totalSteps int) {
-	e.sendEvent(w, "step", "read", fmt.Sprintf("Resolving file: %s", filePath), iteration, total, "")
-
-	// Use FileResolver to find the best available file
-	resolvedFile, err := e.fileResolver.ResolveFile(filePath)
+// streamAnswer generates the final answer to question with streaming
+// updates. When the model fails, the answer is a report of the collected
+// findings and partial is true; only a cancellation returns an error.
+func (e *StreamingAnalysisEngine) streamAnswer(w progressSink, question string, budgetExceeded bool) (result AnalysisResult, partial bool, err error) {
+	finalAnswer, err := e.generateStreamingFinalAnswer(w, question)
 	if err != nil {
-		e.kb.AddNote(fmt.Sprintf("Failed to resolve file '%s': %v", filePath, err))
-		e.sendEvent(w, "error", "read", fmt.Sprintf("Failed to resolve %s: %v", filePath, err), iteration, total, "")
-
-		// Suggest alternatives if available
-		if strings.Contains(strings.ToLower(filePath), "composer") {
-			alternatives := e.fileResolver.GetAvailableAlternatives("composer")
-			if len(alternatives) > 0 {
-				e.sendEvent(w, "step", "read", fmt.Sprintf("Available composer alternatives: %v", alternatives), iteration, total, "")
-			}
+		if isCancellation(err) {
+			e.log.Info("Streaming analysis cancelled during final answer generation.")
+			return AnalysisResult{}, false, err
 		}
-		return
+		// Answer with what was collected rather than nothing
+		e.log.Warnf("Final answer generation failed, returning the collected findings: %v", err)
+		e.sendEvent(w, "error", "final", fmt.Sprintf("Error generating final answer: %v", err), 0, 0, "")
+		return AnalysisResult{Answer: e.kb.PartialReport(err), Sources: e.kb.Sources("")}, true, nil
 	}
-
-	if resolvedFile != filePath {
-		e.sendEvent(w, "step", "read", fmt.Sprintf("Using alternative file: %s", resolvedFile), iteration, total, "")
+	finalAnswer, confidence, unresolved := parseSelfAssessment(finalAnswer)
+	if budgetExceeded {
+		notice := timeBudgetNotice(e.cfg.Analysis.MaxTotalDurationSeconds)
+		e.sendEvent(w, "token", "generating", "", 0, 0, notice)
+		finalAnswer += notice
 	}
+	return AnalysisResult{
+		Answer:     finalAnswer,
+		Sources:    e.kb.Sources(finalAnswer),
+		Confidence: capConfidence(confidence, e.cutShort),
+		Unresolved: unresolved,
+	}, false, nil
+}
 
-	// Read the resolved file
-	fullPath := filepath.Join(e.kb.ProjectPath, resolvedFile)
-	content, err := readFileContent(fullPath)
-	if err != nil {
-		e.kb.AddNote(fmt.Sprintf("Failed to read resolved file '%s': %v", resolvedFile, err))
-		e.kb.AddFailedFileAttempt(resolvedFile)
-		e.sendEvent(w, "error", "read", fmt.Sprintf("Failed to read %s: %v", resolvedFile, err), iteration, total, "")
-	} else {
-		e.kb.AddFileContent(fullPath, content)
-		successMsg := fmt.Sprintf("Successfully read: %s (%d bytes)", resolvedFile, len(content))
-		if resolvedFile != filePath {
-			successMsg += fmt.Sprintf(" (alternative for %s)", filePath)
-		}
-		e.sendEvent(w, "step", "read", successMsg, iteration, total, "")
+// sendCancelled sends the final "cancelled" event when the analysis was
+// stopped through POST /cancel/{id}; a client that went away gets nothing.
+func (e *StreamingAnalysisEngine) sendCancelled(w progressSink) {
+	if cancelledByClient(e.ctx) {
+		e.sendEvent(w, "cancelled", "cancelled", "Analysis cancelled", 0, 0, "")
 	}
 }
 
-// executeStreamingAnalyze analyzes a subject with streaming updates.
-func (e *StreamingAnalysisEngine) executeStreamingAnalyze(w http.ResponseWriter, subject string, iteration, total, stepNum, totalSteps int) {
-	e.sendEvent(w, "step", "analyze", fmt.Sprintf("Analyzing: %s", subject), iteration, total, "")
-	analysisPrompt := fmt.Sprintf(`
-Context: %s
----
-Analyze the following question: "%s"`, e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength), subject)
-	analysisResult, err := e.ollamaClient.ollamaRequest("You are a code analysis assistant.", analysisPrompt)
-	if err != nil {
-		e.kb.AddNote(fmt.Sprintf("Failed to analyze '%s': %v", subject, err))
-		e.sendEvent(w, "error", "analyze", fmt.Sprintf("Analysis failed for %s: %v", subject, err), iteration, total, "")
-	} else {
-		e.kb.AddNote(fmt.Sprintf("Analysis of '%s': %s", subject, analysisResult))
-		e.sendEvent(w, "step", "analyze", fmt.Sprintf("Analysis complete: %s", subject), iteration, total, "")
+// sendResult sends the final "result" event, with the answer in data, the
+// files it is based on in sources, the cost of the analysis in stats, the
+// self-assessment of the model and, for several questions, their answers.
+func (e *StreamingAnalysisEngine) sendResult(w progressSink, result AnalysisResult) {
+	w.send(ProgressEvent{
+		Type:       "result",
+		Step:       "complete",
+		Message:    "Analysis completed successfully!",
+		Data:       result.Answer,
+		Sources:    result.Sources,
+		Stats:      &result.Stats,
+		Confidence: result.Confidence,
+		Unresolved: result.Unresolved,
+		Answers:    answersByQuestion(result.Answers),
+	})
+}
+
+// executeMessage is the message of the event announcing step, with the
+// reason the planner gave for it.
+func executeMessage(step, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("Executing: %s", step)
 	}
+	return fmt.Sprintf("Executing: %s — %s", step, reason)
 }
 
-// generateStreamingFinalAnswer generates the final answer with streaming updates.
-func (e *StreamingAnalysisEngine) generateStreamingFinalAnswer(w http.ResponseWriter) (string, error) {
-	e.sendEvent(w, "step", "synthesis", "Synthesizing collected information...", 0, 0, "")
-	finalContext := e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength)
-	finalPrompt := fmt.Sprintf(`
-Final collected context:
-%s
----
-Synthesize all this information to provide a complete and structured answer to the user's initial question: "%s"`, finalContext, e.request.Question)
+// maxFindingChars bounds the analysis text carried by a "finding" event; the
+// knowledge base keeps the whole analysis.
+const maxFindingChars = 1500
 
-	e.sendEvent(w, "step", "generating", "Generating final answer with AI...", 0, 0, "")
-	return e.ollamaClient.ollamaRequest("You are an expert AI assistant who synthesizes technical information.", finalPrompt)
+// findingExcerpt returns the start of analysis, up to maxFindingChars, for a
+// "finding" event.
+func findingExcerpt(analysis string) string {
+	analysis = strings.TrimSpace(analysis)
+	if truncated := truncateToTokens(analysis, maxFindingChars/charsPerToken); truncated != analysis {
+		return truncated + "..."
+	}
+	return analysis
 }
 
-// planNextSteps plans the next steps in the exploration for streaming engine.
-func (e *StreamingAnalysisEngine) planNextSteps() ([]string, error) {
-	contextSummary := e.kb.getContextSummary(e.request.Question, config.AppConfig.Analysis.MaxPromptLength)
-	planPrompt := fmt.Sprintf(`
-Objective: Answer "%s"
-Current Context:
-%s
----
-IMPORTANT GUIDELINES:
-- DO NOT request files that are listed as "Non Disponibles" - they don't exist
-- Use available dependency files when looking for project information
-- If you need dependency info, use the files listed in "Fichiers de Dépendances Disponibles"
-- Avoid repeating failed operations from previous iterations
+// generateStreamingFinalAnswer generates the final answer to question with
+// streaming updates.
+func (e *StreamingAnalysisEngine) generateStreamingFinalAnswer(w progressSink, question string) (string, error) {
+	e.sendEvent(w, "step", "synthesis", "Synthesizing collected information...", 0, 0, "")
+	finalPrompt := e.finalPrompt(question)
 
-Propose the next 3-5 logical steps. Use actions: READ_FILE <path>, ANALYZE <subject>, FINISH.
-MANDATORY output format: Simple numbered list.
-Example:
-1. READ_FILE main.go
-2. ANALYZE the application entry point
-`, e.request.Question, contextSummary)
+	e.sendEvent(w, "step", "generating", "Generating final answer with AI...", 0, 0, "")
 
-	planSystemPrompt := "You are a code exploration planner. Respond ONLY with the numbered list of actions."
-	rawPlan, err := e.ollamaClient.ollamaRequest(planSystemPrompt, planPrompt)
+	// Forward each chunk as it arrives, except the self-assessment; the caller
+	// still sends the assembled answer in the final "result" event.
+	answer := &selfAssessmentStream{emit: func(token string) {
+		e.sendEvent(w, "token", "generating", "", 0, 0, token)
+	}}
+	err := e.llmClient.StreamRequest(withGenerationPhase(e.ctx, phaseSynthesis), finalSystemPrompt(e.request, e.cfg), finalPrompt, answer.write)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return parsePlan(rawPlan), nil
+	answer.finish()
+	return cleanResponse(answer.String()), nil
 }
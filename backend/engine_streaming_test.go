@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeLLMClient replays scripted answers in place of a model.
+type fakeLLMClient struct {
+	projectType string        // Answer to Request outside of the synthesis phase
+	requestErr  error         // Error returned by Request
+	answer      string        // Answer to Request in the synthesis phase
+	plans       []string      // Successive answers to the planning calls, then "[]"
+	planErr     error         // Error returned by every planning call
+	blockPlans  bool          // Planning calls wait for the end of their context
+	chunks      []string      // Chunks of the final answer
+	streamErr   error         // Error returned by StreamRequest after the chunks
+	planCalls   int           // Number of planning calls received
+	analyses    []ChatMessage // Last message of each non-planning chat call
+	phases      []string      // Generation phase of every call
+	synthesis   string        // System message of the last synthesis call
+	finalPrompt string        // User prompt of the last synthesis call
+	overview    string        // Answer to the overview request
+}
+
+func (c *fakeLLMClient) Request(ctx context.Context, systemMessage, userPrompt string) (string, error) {
+	c.phases = append(c.phases, generationPhase(ctx))
+	if c.requestErr != nil {
+		return "", c.requestErr
+	}
+	if generationPhase(ctx) == phaseSynthesis {
+		c.synthesis, c.finalPrompt = systemMessage, userPrompt
+		return c.answer, nil
+	}
+	if systemMessage == overviewSystemPrompt {
+		return c.overview, nil
+	}
+	return c.projectType, nil
+}
+
+func (c *fakeLLMClient) ChatRequest(ctx context.Context, messages []ChatMessage) (string, error) {
+	c.phases = append(c.phases, generationPhase(ctx))
+	if messages[0].Content != plannerSystemPrompt {
+		c.analyses = append(c.analyses, messages[len(messages)-1])
+		return "The handler parses the request.", nil
+	}
+	c.planCalls++
+	if c.blockPlans {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if c.planErr != nil {
+		return "", c.planErr
+	}
+	if c.planCalls > len(c.plans) {
+		return "[]", nil
+	}
+	return c.plans[c.planCalls-1], nil
+}
+
+func (c *fakeLLMClient) StreamRequest(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error {
+	c.phases = append(c.phases, generationPhase(ctx))
+	c.synthesis, c.finalPrompt = systemMessage, userPrompt
+	for _, chunk := range c.chunks {
+		callback(chunk)
+	}
+	return c.streamErr
+}
+
+// setupStreamingEngineTest writes a small Go project and configures a short
+// exploration over it.
+func setupStreamingEngineTest(t *testing.T, withReadme bool) string {
+	t.Helper()
+	config.AppConfig = &config.Config{
+		Analysis: config.AnalysisConfig{
+			MaxExplorationIterations: 2,
+			MaxDirectoryDepth:        3,
+			MaxFileReadSize:          10000,
+			MaxPromptLength:          8000,
+			MaxFileRetryAttempts:     3,
+		},
+	}
+
+	projectDir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/demo\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	if withReadme {
+		files["README.md"] = "# Demo\n\nA demo service.\n"
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(projectDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+	return projectDir
+}
+
+// runStreamingEngine runs a streaming analysis with client and returns the
+// emitted events as "type/step".
+func runStreamingEngine(t *testing.T, projectDir string, client LLMClient) ([]string, []ProgressEvent) {
+	t.Helper()
+	engine := NewStreamingAnalysisEngineWithClient(context.Background(), AnalyzeRequest{
+		ProjectPath: projectDir,
+		Question:    "How does the handler work?",
+	}, client)
+	rr := httptest.NewRecorder()
+	engine.RunStreamingAnalysis(sseSink{rr})
+
+	var sequence []string
+	var events []ProgressEvent
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event ProgressEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		events = append(events, event)
+		sequence = append(sequence, event.Type+"/"+event.Step)
+	}
+	return sequence, events
+}
+
+// initialEvents is the sequence emitted on the fixture project until the
+// exploration loop starts.
+var initialEvents = []string{
+	"progress/initial",
+	"step/structure",
+	"step/languages",
+	"step/discovery",
+	"step/discovery",
+	"step/type",
+	"step/readme",
+	"step/readme",
+	"step/type",
+	"step/type",
+	"progress/exploration",
+}
+
+func TestRunStreamingAnalysis_Completion(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		plans: []string{
+			`[{"action": "READ_FILE", "argument": "main.go"}, {"action": "ANALYZE", "argument": "the handler"}]`,
+		},
+		chunks: []string{"See ", "main.go"},
+	}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	expected := append(append([]string{}, initialEvents...),
+		"step/iteration",
+		"step/execute", "step/read", "step/read",
+		"step/execute", "step/analyze", "finding/analyze",
+		"step/iteration",
+		"step/finish",
+		"progress/final",
+		"step/synthesis",
+		"step/generating",
+		"token/generating", "token/generating",
+		"result/complete",
+	)
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", sequence, expected)
+	}
+
+	if finding := events[slices.Index(sequence, "finding/analyze")]; finding.Data != "The handler parses the request." {
+		t.Errorf("expected the analysis text in the finding event, got '%s'", finding.Data)
+	}
+	result := events[len(events)-1]
+	if result.Data != "See main.go" {
+		t.Errorf("expected the assembled answer in the result, got '%s'", result.Data)
+	}
+	expectedSources := []Source{{Path: "README.md"}, {Path: "main.go", Cited: true}}
+	if !reflect.DeepEqual(result.Sources, expectedSources) {
+		t.Errorf("expected sources %+v, got %+v", expectedSources, result.Sources)
+	}
+	if client.planCalls != 2 || len(client.analyses) != 1 {
+		t.Errorf("expected 2 planning calls and 1 analysis, got %d and %d", client.planCalls, len(client.analyses))
+	}
+	// Type detection, 2 plans, 1 analysis and the streamed answer
+	if stats := result.Stats; stats == nil || stats.LLMCalls != 5 || stats.Iterations != 2 || stats.FilesRead != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFindingExcerpt(t *testing.T) {
+	if got := findingExcerpt("  Short finding.\n"); got != "Short finding." {
+		t.Errorf("expected a short finding to be kept whole, got '%s'", got)
+	}
+
+	long := strings.Repeat("word ", maxFindingChars)
+	got := findingExcerpt(long)
+	if len(got) > maxFindingChars+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("expected a long finding to be cut at %d characters, got %d", maxFindingChars, len(got))
+	}
+}
+
+func TestRunStreamingAnalysis_NoReadme(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	client := &fakeLLMClient{projectType: "Go CLI", chunks: []string{"Done"}}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	// Same steps as with a README: only the message of the second readme step differs
+	expected := append(append([]string{}, initialEvents...),
+		"step/iteration",
+		"step/finish",
+		"progress/final",
+		"step/synthesis",
+		"step/generating",
+		"token/generating",
+		"result/complete",
+	)
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", sequence, expected)
+	}
+	if events[7].Message != "No README or documentation file found" {
+		t.Errorf("expected the missing README to be reported, got '%s'", events[7].Message)
+	}
+}
+
+func TestRunStreamingAnalysis_RefusesPathsOutsideProject(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		plans:       []string{`[{"action": "READ_FILE", "argument": "../../etc/passwd"}]`},
+		chunks:      []string{"Done"},
+	}
+
+	_, events := runStreamingEngine(t, projectDir, client)
+
+	refused := false
+	for _, event := range events {
+		if event.Type == "error" && event.Step == "read" && strings.Contains(event.Message, "outside the project directory") {
+			refused = true
+		}
+		if strings.HasPrefix(event.Message, "Successfully read: ../../etc/passwd") {
+			t.Errorf("expected ../../etc/passwd not to be read, got %q", event.Message)
+		}
+	}
+	if !refused {
+		t.Errorf("expected an event refusing ../../etc/passwd, got %+v", events)
+	}
+}
+
+func TestRunStreamingAnalysis_PlanningError(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		planErr:     errors.New("model 'test-model' not found"),
+		chunks:      []string{"Partial answer"},
+	}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	expected := append(append([]string{}, initialEvents...),
+		"step/iteration", "error/planning",
+		"step/iteration", "error/planning",
+		"progress/final",
+		"step/synthesis",
+		"step/generating",
+		"token/generating",
+		"result/complete",
+	)
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", sequence, expected)
+	}
+	for _, event := range events {
+		if event.Type == "error" && !strings.Contains(event.Message, "model 'test-model' not found") {
+			t.Errorf("expected the planning error in the event, got '%s'", event.Message)
+		}
+	}
+}
+
+func TestRunStreamingAnalysis_TimeBudget(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	config.AppConfig.Analysis.MaxTotalDurationSeconds = 1
+	client := &fakeLLMClient{projectType: "Go CLI", blockPlans: true, chunks: []string{"Partial answer"}}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	expected := append(append([]string{}, initialEvents...),
+		"step/iteration",
+		"step/budget",
+		"progress/final",
+		"step/synthesis",
+		"step/generating",
+		"token/generating", "token/generating",
+		"result/complete",
+	)
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", sequence, expected)
+	}
+	if result := events[len(events)-1]; result.Data != "Partial answer"+timeBudgetNotice(config.AppConfig.Analysis.MaxTotalDurationSeconds) {
+		t.Errorf("expected the time budget notice after the answer, got '%s'", result.Data)
+	}
+}
+
+func TestRunStreamingAnalysis_SynthesisErrorSendsPartialReport(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	client := &fakeLLMClient{projectType: "Go CLI", streamErr: errors.New("connection reset")}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	expected := append(append([]string{}, initialEvents...),
+		"step/iteration",
+		"step/finish",
+		"progress/final",
+		"step/synthesis",
+		"step/generating",
+		"error/final",
+		"result/complete",
+	)
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("unexpected event sequence:\n got %v\nwant %v", sequence, expected)
+	}
+	result := events[len(events)-1]
+	for _, expected := range []string{"could not be generated (connection reset)", "**Project type:** Go CLI", "- README.md"} {
+		if !strings.Contains(result.Data, expected) {
+			t.Errorf("expected %q in the partial report, got '%s'", expected, result.Data)
+		}
+	}
+}
+
+func TestRunStreamingAnalysis_CapsPlanSteps(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	config.AppConfig.Analysis.MaxStepsPerPlan = 2
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		plans:       []string{`[{"action": "LIST_DIR", "argument": "."}, {"action": "READ_FILE", "argument": "main.go"}, {"action": "SEARCH", "argument": "func main"}]`},
+		chunks:      []string{"Done"},
+	}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	truncated := slices.Index(sequence, "step/truncated")
+	if truncated < 0 {
+		t.Fatalf("expected a step/truncated event, got %v", sequence)
+	}
+	if message := events[truncated].Message; message != "Plan truncated to 2 of its 3 steps" {
+		t.Errorf("unexpected truncation message %q", message)
+	}
+	for _, event := range events {
+		if event.Message == "Executing: SEARCH func main" {
+			t.Error("expected the SEARCH step beyond the limit not to be executed")
+		}
+	}
+}
+
+func TestRunStreamingAnalysis_StepReasons(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		plans:       []string{`[{"action": "READ_FILE", "argument": "main.go", "reason": "the entry point"}, {"action": "SEARCH", "argument": "func main"}]`},
+		chunks:      []string{"Done"},
+	}
+
+	_, events := runStreamingEngine(t, projectDir, client)
+
+	var messages []string
+	for _, event := range events {
+		if event.Step == "execute" {
+			messages = append(messages, event.Message)
+		}
+	}
+	expected := []string{"Executing: READ_FILE main.go — the entry point", "Executing: SEARCH func main"}
+	if !slices.Equal(messages, expected) {
+		t.Errorf("expected the execute events %q, got %q", expected, messages)
+	}
+}
@@ -2,134 +2,580 @@ package main
 
 import (
 	"debugagent/config"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	ignoreDirs       map[string]bool
-	ignoreExtensions map[string]bool
-	ignorePrefixes   []string
-)
+// getDirectoryStructure récupère la structure récursivement, en filtrant et limitant la profondeur.
+// En plus des listes de la configuration, les chemins exclus par les .gitignore
+// du projet (racine et imbriqués) sont ignorés, puis le .debugagentignore de
+// rootDir a le dernier mot.
+func getDirectoryStructure(cfg *config.Config, rootDir string, maxDepth int, currentDepth int) (map[string]interface{}, error) {
+	return walkDirectoryStructure(cfg, rootDir, "", maxDepth, currentDepth, &gitignoreMatcher{}, loadAgentIgnore(rootDir))
+}
 
-func initializeExplorerConfig() {
-	cfg := config.AppConfig.Explorer
-	ignoreDirs = make(map[string]bool)
-	for _, dir := range cfg.IgnoreDirs {
-		ignoreDirs[dir] = true
+// getSubdirectoryStructure est getDirectoryStructure pour le sous-dossier
+// relDir de projectPath : le .debugagentignore de la racine du projet s'y
+// applique aussi.
+func getSubdirectoryStructure(cfg *config.Config, projectPath, relDir string, maxDepth int) (map[string]interface{}, error) {
+	relDir = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(relDir)), "/")
+	if relDir == "." {
+		relDir = ""
 	}
-	ignoreExtensions = make(map[string]bool)
-	for _, ext := range cfg.IgnoreExtensions {
-		ignoreExtensions[ext] = true
-	}
-	ignorePrefixes = cfg.IgnorePrefixes
+	return walkDirectoryStructure(cfg, filepath.Join(projectPath, relDir), relDir, maxDepth, 0, &gitignoreMatcher{}, loadAgentIgnore(projectPath))
 }
 
-// getDirectoryStructure récupère la structure récursivement, en filtrant et limitant la profondeur.
-func getDirectoryStructure(rootDir string, maxDepth int, currentDepth int) (map[string]interface{}, error) {
-	if ignoreDirs == nil {
-		initializeExplorerConfig()
+// structureDir est un dossier en attente dans le parcours de
+// walkDirectoryStructure.
+type structureDir struct {
+	dir, relDir string
+	depth       int
+	gitignore   *gitignoreMatcher      // Règles .gitignore des dossiers parents
+	entries     map[string]interface{} // Entrées du dossier, déjà rattachées à son parent
+	parent      map[string]interface{} // nil pour le dossier de départ
+	key         string                 // Clé du dossier dans parent
+}
+
+// walkDirectoryStructure parcourt dir, dont relDir est le chemin relatif à la
+// racine du projet, en accumulant les règles .gitignore rencontrées. Le
+// parcours se fait en largeur : au-delà de explorer.max_entries entrées, les
+// dossiers restants sont marqués sans être lus, et le premier niveau du
+// projet est toujours complet avant les sous-dossiers.
+func walkDirectoryStructure(cfg *config.Config, dir, relDir string, maxDepth int, currentDepth int, gitignore, agentIgnore *gitignoreMatcher) (map[string]interface{}, error) {
+	root := dir
+	if relDir != "" {
+		for range strings.Split(relDir, "/") {
+			root = filepath.Dir(root)
+		}
 	}
+	maxEntries := cfg.Explorer.MaxEntries
+
 	structure := make(map[string]interface{})
-	if currentDepth >= maxDepth {
-		structure["..."] = fmt.Sprintf("(limite de profondeur %d atteinte)", maxDepth)
-		return structure, nil
+	queue := []structureDir{{dir: dir, relDir: relDir, depth: currentDepth, gitignore: gitignore, entries: structure}}
+	count := 0
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current.depth >= maxDepth {
+			current.entries["..."] = fmt.Sprintf("(limite de profondeur %d atteinte)", maxDepth)
+			continue
+		}
+		if maxEntries > 0 && count >= maxEntries {
+			current.entries["..."] = omittedEntriesMarker(maxEntries)
+			continue
+		}
+
+		files, err := ioutil.ReadDir(current.dir)
+		if err != nil {
+			err = fmt.Errorf("impossible de lister le dossier '%s': %w", current.dir, err)
+			if current.parent == nil {
+				return nil, err
+			}
+			current.parent[current.key] = fmt.Sprintf("Erreur d'accès: %v", err)
+			continue
+		}
+		dirGitignore := current.gitignore.withGitignore(current.dir, current.relDir)
+
+		for _, file := range files {
+			fileName := file.Name()
+			relPath := path.Join(current.relDir, fileName)
+
+			excluded := isIgnoredEntry(cfg, fileName, file.IsDir()) || dirGitignore.isIgnored(relPath, file.IsDir())
+			if agentIgnore.overrides(excluded, relPath, file.IsDir()) {
+				continue
+			}
+			if maxEntries > 0 && count >= maxEntries {
+				current.entries["..."] = omittedEntriesMarker(maxEntries)
+				break
+			}
+			count++
+
+			if file.Mode()&os.ModeSymlink != 0 {
+				// Jamais suivi : un lien vers un parent bouclerait, un lien vers
+				// l'extérieur sortirait du projet
+				value, isDir := describeSymlink(filepath.Join(current.dir, fileName), root)
+				if isDir {
+					fileName += "/"
+				}
+				current.entries[fileName] = value
+			} else if file.IsDir() {
+				subStructure := make(map[string]interface{})
+				current.entries[fileName+"/"] = subStructure
+				queue = append(queue, structureDir{
+					dir:       filepath.Join(current.dir, fileName),
+					relDir:    relPath,
+					depth:     current.depth + 1,
+					gitignore: dirGitignore,
+					entries:   subStructure,
+					parent:    current.entries,
+					key:       fileName + "/",
+				})
+			} else if cfg.Explorer.ShowFileSizes {
+				current.entries[fileName] = formatFileSize(file.Size())
+			} else {
+				current.entries[fileName] = ""
+			}
+		}
 	}
+	return structure, nil
+}
+
+// omittedEntriesMarker est la valeur marquant un dossier dont les entrées ont
+// été omises, la limite explorer.max_entries étant atteinte.
+func omittedEntriesMarker(maxEntries int) string {
+	return fmt.Sprintf("(autres entrées omises, limite de %d entrées atteinte)", maxEntries)
+}
 
-	files, err := ioutil.ReadDir(rootDir)
+// symlinkPrefix commence la valeur d'un lien symbolique dans la structure :
+// les liens ne sont pas suivis, seule leur cible est indiquée.
+const symlinkPrefix = "lien symbolique -> "
+
+// describeSymlink retourne la valeur du lien symbolique linkPath dans la
+// structure du projet root, et si sa cible est un dossier. La cible est
+// marquée si elle est introuvable, hors du projet, ou un dossier contenant le
+// lien (une boucle).
+func describeSymlink(linkPath, root string) (value string, isDir bool) {
+	target, err := os.Readlink(linkPath)
 	if err != nil {
-		return nil, fmt.Errorf("impossible de lister le dossier '%s': %w", rootDir, err)
+		return fmt.Sprintf("%s? (illisible: %v)", symlinkPrefix, err), false
 	}
+	value = symlinkPrefix + filepath.ToSlash(target)
 
-	for _, file := range files {
-		fileName := file.Name()
+	resolved, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		return value + " (cible introuvable)", false // Cible absente ou chaîne de liens circulaire
+	}
+	info, err := os.Stat(resolved)
+	isDir = err == nil && info.IsDir()
+	realRoot, rootErr := filepath.EvalSymlinks(root)
+	realParent, parentErr := filepath.EvalSymlinks(filepath.Dir(linkPath))
+	switch {
+	case rootErr != nil || !isWithinDir(realRoot, resolved):
+		value += " (hors du projet)"
+	case isDir && parentErr == nil && isWithinDir(resolved, realParent):
+		value += " (boucle)"
+	}
+	return value, isDir
+}
 
-		// Ignorer les répertoires et préfixes
-		if ignoreDirs[fileName] {
-			continue
-		}
-		isIgnoredPrefix := false
-		for _, prefix := range ignorePrefixes {
-			if strings.HasPrefix(fileName, prefix) {
-				isIgnoredPrefix = true
-				break
+// symlinksIn liste les liens symboliques de structure, dont prefix est le
+// chemin relatif, sous la forme "chemin -> cible", triés par chemin.
+func symlinksIn(structure map[string]interface{}, prefix string) []string {
+	var links []string
+	for name, value := range structure {
+		switch v := value.(type) {
+		case string:
+			if strings.HasPrefix(v, symlinkPrefix) {
+				links = append(links, fmt.Sprintf("%s%s -> %s", prefix, name, strings.TrimPrefix(v, symlinkPrefix)))
 			}
+		case map[string]interface{}:
+			links = append(links, symlinksIn(v, prefix+name)...)
 		}
-		if isIgnoredPrefix {
-			continue
+	}
+	sort.Strings(links)
+	return links
+}
+
+// maxSymlinksNoted limite les liens cités par symlinksNote.
+const maxSymlinksNoted = 10
+
+// symlinksNote est la note signalant au modèle les liens symboliques non suivis.
+func symlinksNote(links []string) string {
+	note := "Symbolic links listed but not followed: " + strings.Join(links[:min(len(links), maxSymlinksNoted)], ", ")
+	if len(links) > maxSymlinksNoted {
+		note += fmt.Sprintf(" (and %d more)", len(links)-maxSymlinksNoted)
+	}
+	return note
+}
+
+// formatFileSize formate size en octets, Ko ou Mo selon sa grandeur, pour
+// limiter la taille de la structure envoyée au modèle.
+func formatFileSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// isIgnoredEntry indique si une entrée doit être ignorée selon les listes
+// de répertoires, préfixes et extensions de la configuration.
+func isIgnoredEntry(cfg *config.Config, name string, isDir bool) bool {
+	// Ignorer les répertoires et préfixes
+	if slices.Contains(cfg.Explorer.IgnoreDirs, name) {
+		return true
+	}
+	for _, prefix := range cfg.Explorer.IgnorePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
 		}
+	}
 
-		if file.IsDir() {
-			subStructure, err := getDirectoryStructure(filepath.Join(rootDir, fileName), maxDepth, currentDepth+1)
-			if err != nil {
-				structure[fileName+"/"] = fmt.Sprintf("Erreur d'accès: %v", err)
-			} else {
-				structure[fileName+"/"] = subStructure
-			}
-		} else {
-			// Ignorer les extensions
-			ext := strings.ToLower(filepath.Ext(fileName))
-			if ignoreExtensions[ext] {
+	// Ignorer les extensions
+	return !isDir && hasIgnoredExtension(cfg, name)
+}
+
+// isForbiddenFile indique si relPath (relatif à la racine du projet) correspond
+// à un motif de analysis.forbidden_files : ces fichiers peuvent contenir des
+// secrets et ne doivent jamais être lus ni montrés au modèle. Un motif sans
+// "/" porte sur le nom du fichier, quel que soit son dossier.
+func isForbiddenFile(cfg *config.Config, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range cfg.Analysis.ForbiddenFiles {
+		target := path.Base(relPath)
+		if strings.Contains(pattern, "/") {
+			target = relPath
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIgnoredExtension indique si l'extension de name fait partie de la liste
+// explorer.ignore_extensions.
+func hasIgnoredExtension(cfg *config.Config, name string) bool {
+	return slices.Contains(cfg.Explorer.IgnoreExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// searchMatch représente une ligne correspondant à une recherche.
+type searchMatch struct {
+	Path    string
+	Line    int
+	Snippet string
+}
+
+// searchProject recherche pattern dans les fichiers texte du projet en
+// respectant les listes d'exclusion. pattern est interprété comme une
+// expression régulière, ou comme une simple sous-chaîne s'il n'est pas valide.
+// La recherche s'arrête après maxResults correspondances. Avec un index (voir
+// buildSearchIndex), seuls les fichiers pouvant contenir le motif sont lus.
+func searchProject(cfg *config.Config, rootDir, pattern string, maxResults int, index *searchIndex) ([]searchMatch, error) {
+	matcher := func(line string) bool { return strings.Contains(line, pattern) }
+	if re, err := regexp.Compile(pattern); err == nil {
+		matcher = re.MatchString
+	}
+
+	var matches []searchMatch
+	searchFile := func(path, relPath string) bool {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return true // Fichier illisible
+		}
+		text, _, err := decodeText(cfg, content)
+		if err != nil {
+			return true // Fichier binaire ou encodage non reconnu
+		}
+
+		for i, line := range strings.Split(text, "\n") {
+			if !matcher(line) {
 				continue
 			}
-			structure[fileName] = fmt.Sprintf("%d bytes", file.Size())
+			snippet, _ := redactSecrets(cfg, strings.TrimSpace(line))
+			if len(snippet) > 120 {
+				snippet = snippet[:120] + "..."
+			}
+			matches = append(matches, searchMatch{Path: relPath, Line: i + 1, Snippet: snippet})
+			if len(matches) >= maxResults {
+				return false
+			}
 		}
+		return true
 	}
-	return structure, nil
+
+	if index != nil {
+		for _, relPath := range index.candidateFiles(pattern) {
+			if !searchFile(filepath.Join(rootDir, relPath), relPath) {
+				break
+			}
+		}
+		return matches, nil
+	}
+
+	errLimitReached := errors.New("limite de résultats atteinte")
+	err := walkSearchableFiles(cfg, rootDir, func(path, relPath string) error {
+		if !searchFile(path, relPath) {
+			return errLimitReached
+		}
+		return nil
+	})
+	if err != nil && err != errLimitReached {
+		return nil, fmt.Errorf("erreur lors de la recherche dans '%s': %w", rootDir, err)
+	}
+	return matches, nil
 }
 
+// walkSearchableFiles appelle fn, dans l'ordre lexical, pour chaque fichier
+// du projet que SEARCH peut lire : ni ignoré, ni interdit, ni plus gros que
+// analysis.max_file_read_size. Le parcours s'arrête à la première erreur de fn.
+func walkSearchableFiles(cfg *config.Config, rootDir string, fn func(path, relPath string) error) error {
+	maxSize := int64(cfg.Analysis.MaxFileReadSize)
+	agentIgnore := loadAgentIgnore(rootDir)
+	return filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Ignorer les entrées illisibles
+		}
+		if path == rootDir {
+			return nil
+		}
+		relPath, _ := filepath.Rel(rootDir, path)
+		if agentIgnore.overrides(isIgnoredEntry(cfg, d.Name(), d.IsDir()), filepath.ToSlash(relPath), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if isForbiddenFile(cfg, relPath) || d.Type()&fs.ModeSymlink != 0 {
+			return nil // Un lien pourrait sortir du projet, sa cible interne est lue à son propre chemin
+		}
+
+		info, err := d.Info()
+		if err != nil || (maxSize > 0 && info.Size() > maxSize) {
+			return nil
+		}
+		return fn(path, relPath)
+	})
+}
+
+// ErrBinaryFile est renvoyée par readFileContent pour un fichier binaire,
+// qui n'est pas envoyé au modèle.
+var ErrBinaryFile = errors.New("skipped binary file")
+
+// binarySampleSize est le nombre d'octets examinés en début de fichier pour
+// décider s'il est binaire.
+const binarySampleSize = 1024
+
+// defaultBinaryThreshold est la proportion de caractères non imprimables au-delà
+// de laquelle un fichier est considéré binaire si explorer.binary_threshold
+// n'est pas défini.
+const defaultBinaryThreshold = 0.3
+
 // readFileContent lit le contenu d'un fichier avec gestion d'erreurs et de taille.
-func readFileContent(absFilepath string) (string, error) {
+// Les messages sont journalisés avec log, le logger de l'analyse en cours.
+// Les fichiers binaires (extension ignorée ou contenu non textuel) renvoient
+// ErrBinaryFile, ceux dont l'encodage n'est pas reconnu ErrUnsupportedEncoding ;
+// les autres sont convertis en UTF-8 (voir detectEncoding).
+func readFileContent(cfg *config.Config, log *logrus.Entry, absFilepath string) (string, error) {
+	text, fileInfo, err := readTextFile(cfg, log, absFilepath)
+	if err != nil {
+		return "", err
+	}
+	name := fileInfo.Name()
+
+	// Tronquer les fichiers trop volumineux en gardant le début et la fin
+	if truncated, ok := truncateMiddle(text, cfg.Analysis.MaxFileReadSize, truncationHeadRatio(cfg, name)); ok {
+		log.Warnf("File '%s' (%d bytes) is too large. Reading partially.", name, fileInfo.Size())
+		return truncated, nil
+	}
+
+	log.Infof("Reading complete file '%s' (%d bytes).", name, fileInfo.Size())
+	return text, nil
+}
+
+// readTextFile lit le fichier absFilepath et le convertit en UTF-8, avec les
+// mêmes erreurs que readFileContent, mais sans limite de taille.
+func readTextFile(cfg *config.Config, log *logrus.Entry, absFilepath string) (string, os.FileInfo, error) {
 	fileInfo, err := os.Stat(absFilepath)
 	if err != nil {
-		return "", fmt.Errorf("fichier non trouvé ou erreur de stat: %w", err)
+		return "", nil, fmt.Errorf("fichier non trouvé ou erreur de stat: %w", err)
 	}
 
 	if fileInfo.IsDir() {
-		return "", fmt.Errorf("le chemin '%s' est un dossier, pas un fichier", absFilepath)
+		return "", nil, fmt.Errorf("le chemin '%s' est un dossier, pas un fichier", absFilepath)
 	}
 
-	// Vérifier si le fichier est binaire
-	buffer := make([]byte, 1024)
-	file, err := os.Open(absFilepath)
-	if err != nil {
-		return "", fmt.Errorf("impossible d'ouvrir le fichier pour vérification binaire: %w", err)
+	name := filepath.Base(absFilepath)
+	if hasIgnoredExtension(cfg, name) {
+		return "", nil, fmt.Errorf("%w '%s' (ignored extension)", ErrBinaryFile, name)
 	}
-	n, _ := file.Read(buffer)
-	file.Close()
 
-	for i := 0; i < n; i++ {
-		if buffer[i] == 0 {
-			return "", fmt.Errorf("le fichier '%s' semble être binaire", filepath.Base(absFilepath))
-		}
+	content, err := os.ReadFile(absFilepath)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading file: %w", err)
+	}
+	text, encoding, err := decodeText(cfg, content)
+	if errors.Is(err, ErrBinaryFile) {
+		return "", nil, fmt.Errorf("%w '%s'", ErrBinaryFile, name)
+	}
+	if err != nil {
+		return "", nil, err
 	}
+	if encoding != encodingUTF8 {
+		log.Infof("File '%s' converted from %s to UTF-8.", name, encoding)
+	}
+	return text, fileInfo, nil
+}
 
-	// Lire le contenu du fichier
-	size := fileInfo.Size()
-	maxSize := int64(config.AppConfig.Analysis.MaxFileReadSize)
-	if size > maxSize {
-		logrus.Warnf("File '%s' (%d bytes) is too large. Reading partially.", filepath.Base(absFilepath), size)
-		content, err := os.ReadFile(absFilepath)
-		if err != nil {
-			return "", fmt.Errorf("error reading partial file: %w", err)
-		}
+// lineRange est une plage de lignes d'un fichier, numérotées à partir de 1 et
+// bornes incluses, demandée par READ_FILE <chemin>:<début>-<fin>.
+type lineRange struct {
+	Start, End int
+}
 
-		startContent := string(content[:int(maxSize)/2])
-		endContent := string(content[len(content)-(int(maxSize)/2):])
+func (r lineRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// lineRangeSuffix reconnaît une plage de lignes en fin d'argument de READ_FILE :
+// "main.go:10-50", "main.go:10 - 50" ou "main.go#L10-L50".
+var lineRangeSuffix = regexp.MustCompile(`^(.+?)(?::|#L)\s*(\d+)\s*-\s*L?(\d+)$`)
 
-		return fmt.Sprintf("%s\n\n[... content truncated (file too large) ...]\n\n%s", startContent, endContent), nil
+// parseLineRange sépare le chemin et la plage de lignes d'un argument de
+// READ_FILE. ok est faux si l'argument n'a pas de plage valide (début à
+// partir de 1, fin non inférieure au début).
+func parseLineRange(argument string) (path string, lines lineRange, ok bool) {
+	matches := lineRangeSuffix.FindStringSubmatch(strings.TrimSpace(argument))
+	if matches == nil {
+		return argument, lineRange{}, false
+	}
+	start, errStart := strconv.Atoi(matches[2])
+	end, errEnd := strconv.Atoi(matches[3])
+	if errStart != nil || errEnd != nil || start < 1 || end < start {
+		return argument, lineRange{}, false
 	}
+	return strings.TrimSpace(matches[1]), lineRange{Start: start, End: end}, true
+}
 
-	logrus.Infof("Reading complete file '%s' (%d bytes).", filepath.Base(absFilepath), size)
-	content, err := os.ReadFile(absFilepath)
+// readFileLines lit les lignes lines du fichier absFilepath, préfixées de leur
+// numéro pour que le modèle puisse les citer, et précédées de la plage
+// effectivement lue. Une fin au-delà de la dernière ligne est ramenée à
+// celle-ci ; la taille reste limitée par analysis.max_file_read_size.
+func readFileLines(cfg *config.Config, log *logrus.Entry, absFilepath string, lines lineRange) (string, error) {
+	text, _, err := readTextFile(cfg, log, absFilepath)
 	if err != nil {
-		return "", fmt.Errorf("error reading complete file: %w", err)
+		return "", err
 	}
+	fileLines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if lines.Start > len(fileLines) {
+		return "", fmt.Errorf("line %d is past the end of the file (%d lines)", lines.Start, len(fileLines))
+	}
+	end := min(lines.End, len(fileLines))
+
+	var excerpt strings.Builder
+	fmt.Fprintf(&excerpt, "[lines %d-%d of %d]\n", lines.Start, end, len(fileLines))
+	for i := lines.Start; i <= end; i++ {
+		fmt.Fprintf(&excerpt, "%d: %s\n", i, fileLines[i-1])
+	}
+	if truncated, ok := truncateMiddle(excerpt.String(), cfg.Analysis.MaxFileReadSize, truncationHeadRatio(cfg, absFilepath)); ok {
+		log.Warnf("Lines %d-%d of '%s' are too large. Reading partially.", lines.Start, end, filepath.Base(absFilepath))
+		return truncated, nil
+	}
+	log.Infof("Reading lines %d-%d of '%s'.", lines.Start, end, filepath.Base(absFilepath))
+	return excerpt.String(), nil
+}
 
-	return string(content), nil
+// truncateMiddle garde au plus maxSize octets de text, répartis entre le début
+// (la part headRatio, entre 0 et 1) et la fin autour d'un marqueur de
+// troncature, sans couper de caractère UTF-8. Les deux parties ne se
+// chevauchent jamais. Elle renvoie false si text tient dans maxSize (ou si
+// maxSize <= 0, sans limite) : le marqueur n'apparaît que si du contenu est
+// réellement omis.
+func truncateMiddle(text string, maxSize int, headRatio float64) (string, bool) {
+	if maxSize <= 0 || len(text) <= maxSize {
+		return text, false
+	}
+	tail := int(float64(maxSize) * (1 - min(max(headRatio, 0), 1)))
+	start := maxSize - tail
+	end := len(text) - tail
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	return fmt.Sprintf("%s\n\n[... content truncated (file too large) ...]\n\n%s", text[:start], text[end:]), true
+}
+
+// defaultTruncationHeadRatio est la part du début gardée d'un fichier tronqué
+// si analysis.truncation_head_ratio n'est pas défini.
+const defaultTruncationHeadRatio = 0.5
+
+// truncationHeadRatio renvoie la part du début à garder quand le fichier
+// filePath est tronqué : celle de son extension dans
+// analysis.truncation_head_ratios, sinon analysis.truncation_head_ratio.
+func truncationHeadRatio(cfg *config.Config, filePath string) float64 {
+	analysis := cfg.Analysis
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	if ratio, ok := analysis.TruncationHeadRatios[extension]; ok && extension != "" {
+		return ratio
+	}
+	if analysis.TruncationHeadRatio != nil {
+		return *analysis.TruncationHeadRatio
+	}
+	return defaultTruncationHeadRatio
+}
+
+// decodeText renvoie content converti en UTF-8 et son encodage d'origine.
+// Elle renvoie ErrBinaryFile si le contenu ressemble à un fichier binaire
+// (octet NUL hors UTF-16 ou trop de caractères non imprimables), et
+// ErrUnsupportedEncoding s'il contient des octets invalides dans l'encodage
+// détecté.
+func decodeText(cfg *config.Config, content []byte) (string, textEncoding, error) {
+	encoding, bomSize := detectEncoding(content)
+	if encoding == "" {
+		return "", "", ErrBinaryFile
+	}
+	text, invalid := transcodeToUTF8(content[bomSize:], encoding)
+	if nonPrintableRatio([]byte(text[:min(binarySampleSize, len(text))])) > binaryThreshold(cfg) {
+		return "", encoding, ErrBinaryFile
+	}
+	if invalid > 0 {
+		return "", encoding, fmt.Errorf("%w: %d invalid %s sequences", ErrUnsupportedEncoding, invalid, encoding)
+	}
+	return text, encoding, nil
+}
+
+// nonPrintableRatio renvoie la proportion de caractères de sample qui ne sont
+// ni imprimables ni des espaces usuels, caractères de remplacement et
+// séquences UTF-8 invalides compris.
+// Un caractère coupé en fin d'échantillon n'est pas compté.
+func nonPrintableRatio(sample []byte) float64 {
+	var total, nonPrintable int
+	for len(sample) > 0 {
+		if !utf8.FullRune(sample) {
+			break
+		}
+		r, size := utf8.DecodeRune(sample)
+		sample = sample[size:]
+		total++
+		switch {
+		case r == utf8.RuneError:
+			nonPrintable++
+		case r == '\t', r == '\n', r == '\r', r == '\f', r == '\v', r == '\b', r == 0x1B: // 0x1B : séquences ANSI des logs
+		case !unicode.IsPrint(r) && !unicode.IsSpace(r):
+			nonPrintable++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(nonPrintable) / float64(total)
+}
+
+// binaryThreshold renvoie le seuil de caractères non imprimables configuré.
+func binaryThreshold(cfg *config.Config) float64 {
+	if threshold := cfg.Explorer.BinaryThreshold; threshold > 0 {
+		return threshold
+	}
+	return defaultBinaryThreshold
 }
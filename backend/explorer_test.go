@@ -0,0 +1,398 @@
+package main
+
+import (
+	"debugagent/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setupExplorerTest creates a small project and installs its configuration.
+func setupExplorerTest(t *testing.T, files map[string]string) string {
+	projectPath := t.TempDir()
+	for name, content := range files {
+		fullPath := filepath.Join(projectPath, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	config.AppConfig = &config.Config{
+		Analysis: config.AnalysisConfig{
+			MaxFileReadSize: 150000,
+		},
+		Explorer: config.ExplorerConfig{
+			IgnoreDirs:       []string{"node_modules"},
+			IgnorePrefixes:   []string{"."},
+			IgnoreExtensions: []string{".log"},
+		},
+	}
+
+	return projectPath
+}
+
+func TestSearchProject(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go":                 "package main\n\nfunc main() {\n\tstartServer()\n}\n",
+		"server/server.go":        "package server\n\nfunc startServer() {}\n",
+		"node_modules/lib/x.js":   "function startServer() {}\n",
+		"debug.log":               "startServer called\n",
+		".hidden/startServer.txt": "startServer\n",
+	})
+
+	matches, err := searchProject(config.AppConfig, projectPath, `func startServer`, 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d: %v", len(matches), matches)
+	}
+	if matches[0].Path != filepath.Join("server", "server.go") || matches[0].Line != 3 {
+		t.Errorf("unexpected match: %+v", matches[0])
+	}
+}
+
+func TestSearchProject_InvalidRegexFallsBackToSubstring(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go": "x := compute(a[\n",
+	})
+
+	matches, err := searchProject(config.AppConfig, projectPath, "compute(a[", 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("expected a substring match, got %v", matches)
+	}
+}
+
+func TestSearchProject_MaxResults(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"a.txt": "todo\ntodo\ntodo\ntodo\n",
+	})
+
+	matches, err := searchProject(config.AppConfig, projectPath, "todo", 2, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Errorf("expected results to be capped at 2, got %d", len(matches))
+	}
+}
+
+func TestSearchProject_SkipsForbiddenFiles(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"config.go":         "token := os.Getenv(\"TOKEN\")\n",
+		"certs/server.key":  "TOKEN=secret\n",
+		"secrets/token.txt": "TOKEN=secret\n",
+	})
+	config.AppConfig.Analysis.ForbiddenFiles = []string{"*.key", "secrets/*"}
+
+	matches, err := searchProject(config.AppConfig, projectPath, "TOKEN", 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Path != "config.go" {
+		t.Errorf("expected only the match in config.go, got %v", matches)
+	}
+}
+
+func TestListDirNote(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"pkg/a/b/c/deep.go":  "package c\n",
+		"pkg/a/shallow.go":   "package a\n",
+		"pkg/node_modules/x": "ignored\n",
+		"pkg/a/debug.log":    "ignored\n",
+	})
+
+	note, err := listDirNote(config.AppConfig, projectPath, "pkg/a")
+	if err != nil {
+		t.Fatalf("listDirNote(config.AppConfig, ) returned error: %v", err)
+	}
+	if !strings.Contains(note, "shallow.go") || !strings.Contains(note, "b/") {
+		t.Errorf("listing is missing expected entries: %s", note)
+	}
+	if strings.Contains(note, "deep.go") || strings.Contains(note, "debug.log") {
+		t.Errorf("listing should respect depth and ignore lists: %s", note)
+	}
+
+	if _, err := listDirNote(config.AppConfig, projectPath, "../.."); err == nil {
+		t.Error("expected an error for a directory outside the project")
+	}
+}
+
+func TestReadFileContent_BinaryDetection(t *testing.T) {
+	utf16Text := []byte{0xFF, 0xFE}
+	for _, r := range "package main // é" {
+		utf16Text = append(utf16Text, byte(r), byte(r>>8))
+	}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x10\x00\x00\x00\x10\x08\x06\x00\x00\x00")
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go":    "package main\n\nfunc main() {\n\tprintln(\"héllo\")\n}\n",
+		"utf16.go":   string(utf16Text),
+		"image.png":  string(png),
+		"noise.dat":  "\x01\x02\x03\x04\x05\x06abc\x7f\x1c\x1d",
+		"server.log": "started\n",
+	})
+	log := logrus.NewEntry(logrus.StandardLogger())
+
+	tests := []struct {
+		name    string
+		content string
+		binary  bool
+	}{
+		{name: "main.go", content: "package main\n\nfunc main() {\n\tprintln(\"héllo\")\n}\n"},
+		{name: "utf16.go", content: "package main // é"},
+		{name: "image.png", binary: true},
+		{name: "noise.dat", binary: true},
+		{name: "server.log", binary: true}, // Extension ignorée
+	}
+	for _, tt := range tests {
+		content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, tt.name))
+		if tt.binary {
+			if !errors.Is(err, ErrBinaryFile) {
+				t.Errorf("%s: expected ErrBinaryFile, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || content != tt.content {
+			t.Errorf("%s: expected %q, got %q (%v)", tt.name, tt.content, content, err)
+		}
+	}
+
+	config.AppConfig.Explorer.BinaryThreshold = 0.9
+	if _, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, "noise.dat")); err != nil {
+		t.Errorf("expected a higher binary_threshold to accept noise.dat, got %v", err)
+	}
+}
+
+func TestReadFileContent_TruncationAtLimit(t *testing.T) {
+	const marker = "[... content truncated (file too large) ...]"
+	projectPath := setupExplorerTest(t, map[string]string{
+		"under.txt":  "abcdefghi",
+		"at.txt":     "abcdefghij",
+		"over.txt":   "abcdefghijk",
+		"accent.txt": "aébécédéeé",
+	})
+	config.AppConfig.Analysis.MaxFileReadSize = 10
+	log := logrus.NewEntry(logrus.StandardLogger())
+
+	for _, name := range []string{"under.txt", "at.txt"} {
+		content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, name))
+		if err != nil || strings.Contains(content, marker) {
+			t.Errorf("%s: expected the complete file, got %q (%v)", name, content, err)
+		}
+	}
+
+	content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, "over.txt"))
+	if err != nil {
+		t.Fatalf("over.txt: readFileContent() returned error: %v", err)
+	}
+	if want := "abcde\n\n" + marker + "\n\nghijk"; content != want {
+		t.Errorf("over.txt: expected %q, got %q", want, content)
+	}
+
+	// Les coupures ne tombent pas au milieu d'un caractère multi-octets
+	content, err = readFileContent(config.AppConfig, log, filepath.Join(projectPath, "accent.txt"))
+	if err != nil || !utf8.ValidString(content) || !strings.Contains(content, marker) {
+		t.Errorf("accent.txt: expected valid truncated UTF-8, got %q (%v)", content, err)
+	}
+
+	for _, maxSize := range []int{1, 2, 3} {
+		config.AppConfig.Analysis.MaxFileReadSize = maxSize
+		content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, "over.txt"))
+		if err != nil || !strings.Contains(content, marker) || len(content)-len(marker)-4 > maxSize {
+			t.Errorf("max_file_read_size %d: unexpected content %q (%v)", maxSize, content, err)
+		}
+	}
+}
+
+func TestTruncateMiddle_HeadRatio(t *testing.T) {
+	const text = "abcdefghijklmnopqrst"
+	for _, tt := range []struct {
+		ratio      float64
+		head, tail string
+	}{
+		{0, "", "mnopqrst"},
+		{0.25, "ab", "opqrst"},
+		{0.5, "abcd", "qrst"},
+		{0.8, "abcdefg", "t"},
+		{1, "abcdefgh", ""},
+	} {
+		truncated, ok := truncateMiddle(text, 8, tt.ratio)
+		head, tail, found := strings.Cut(truncated, "\n\n[... content truncated (file too large) ...]\n\n")
+		if !ok || !found || head != tt.head || tail != tt.tail {
+			t.Errorf("ratio %g: expected %q and %q around the marker, got %q", tt.ratio, tt.head, tt.tail, truncated)
+		}
+		// The two slices never overlap
+		if len(head)+len(tail) > 8 || !strings.HasPrefix(text, head) || !strings.HasSuffix(text, tail) {
+			t.Errorf("ratio %g: overlapping or out of place slices %q and %q", tt.ratio, head, tail)
+		}
+	}
+}
+
+func TestReadFileContent_TruncationHeadRatio(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"build.out":  "0123456789abcdefghij",
+		"config.yml": "0123456789abcdefghij",
+		"main.go":    "0123456789abcdefghij",
+	})
+	ratio := 0.25
+	config.AppConfig.Analysis.MaxFileReadSize = 8
+	config.AppConfig.Analysis.TruncationHeadRatio = &ratio
+	config.AppConfig.Analysis.TruncationHeadRatios = map[string]float64{"out": 0, "yml": 1}
+	log := logrus.NewEntry(logrus.StandardLogger())
+
+	for name, want := range map[string][2]string{
+		"build.out":  {"", "cdefghij"},
+		"config.yml": {"01234567", ""},
+		"main.go":    {"01", "efghij"},
+	} {
+		content, err := readFileContent(config.AppConfig, log, filepath.Join(projectPath, name))
+		if err != nil {
+			t.Fatalf("%s: readFileContent() returned error: %v", name, err)
+		}
+		if expected := want[0] + "\n\n[... content truncated (file too large) ...]\n\n" + want[1]; content != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, content)
+		}
+	}
+}
+
+func TestGetDirectoryStructure_FileSizes(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go":     "package main",
+		"data/big.go": strings.Repeat("x", 3*1024+512),
+	})
+
+	config.AppConfig.Explorer.ShowFileSizes = true
+	structure, err := getDirectoryStructure(config.AppConfig, projectPath, 3, 0)
+	if err != nil {
+		t.Fatalf("getDirectoryStructure(config.AppConfig, ) returned error: %v", err)
+	}
+	if structure["main.go"] != "12 B" || structure["data/"].(map[string]interface{})["big.go"] != "3.5 KB" {
+		t.Errorf("expected human-readable sizes, got %v", structure)
+	}
+
+	config.AppConfig.Explorer.ShowFileSizes = false
+	structure, _ = getDirectoryStructure(config.AppConfig, projectPath, 3, 0)
+	if structure["main.go"] != "" {
+		t.Errorf("expected sizes to be omitted, got %v", structure)
+	}
+}
+
+func TestFormatFileSize(t *testing.T) {
+	for size, expected := range map[int64]string{0: "0 B", 1023: "1023 B", 1024: "1.0 KB", 5 << 20: "5.0 MB"} {
+		if got := formatFileSize(size); got != expected {
+			t.Errorf("formatFileSize(%d) = %q, expected %q", size, got, expected)
+		}
+	}
+}
+
+func TestGetDirectoryStructure_Symlinks(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go":     "package main",
+		"pkg/util.go": "package pkg",
+	})
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("TOKEN=secret\n"), 0644)
+	links := map[string]string{
+		"pkg/loop":    "..", // Points to the root, which holds pkg
+		"pkg/self":    ".",
+		"alias.go":    "main.go",
+		"external":    outside,
+		"leak.txt":    filepath.Join(outside, "secret.txt"),
+		"missing.txt": "nowhere.txt",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(projectPath, link)); err != nil {
+			t.Skipf("symlinks are not supported here: %v", err)
+		}
+	}
+
+	structure, err := getDirectoryStructure(config.AppConfig, projectPath, 10, 0)
+	if err != nil {
+		t.Fatalf("getDirectoryStructure(config.AppConfig, ) returned error: %v", err)
+	}
+	pkg := structure["pkg/"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"loop/":       symlinkPrefix + ".. (boucle)",
+		"self/":       symlinkPrefix + ". (boucle)",
+		"util.go":     "",
+		"alias.go":    symlinkPrefix + "main.go",
+		"external/":   symlinkPrefix + filepath.ToSlash(outside) + " (hors du projet)",
+		"leak.txt":    symlinkPrefix + filepath.ToSlash(filepath.Join(outside, "secret.txt")) + " (hors du projet)",
+		"missing.txt": symlinkPrefix + "nowhere.txt (cible introuvable)",
+	}
+	for name, value := range expected {
+		got, ok := structure[name]
+		if !ok {
+			got = pkg[name]
+		}
+		if got != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, got)
+		}
+	}
+
+	found := symlinksIn(structure, "")
+	if len(found) != len(links) || found[len(found)-1] != "pkg/self/ -> . (boucle)" {
+		t.Errorf("expected every symlink listed by path, got %v", found)
+	}
+
+	// SEARCH does not follow the links, the secret outside the project stays hidden
+	matches, err := searchProject(config.AppConfig, projectPath, "TOKEN|package", 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	for _, match := range matches {
+		if match.Path == "leak.txt" || match.Path == "alias.go" {
+			t.Errorf("expected the symlinks to be skipped by SEARCH, got %v", matches)
+		}
+	}
+}
+
+func TestGetDirectoryStructure_MaxEntries(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"a.go":          "package main",
+		"b.go":          "package main",
+		"x/one.go":      "package x",
+		"x/two.go":      "package x",
+		"x/deep/in.go":  "package deep",
+		"y/three.go":    "package y",
+		"z/nested/z.go": "package nested",
+	})
+	config.AppConfig.Explorer.MaxEntries = 6
+
+	structure, err := getDirectoryStructure(config.AppConfig, projectPath, 10, 0)
+	if err != nil {
+		t.Fatalf("getDirectoryStructure(config.AppConfig, ) returned error: %v", err)
+	}
+	// The top level is complete, then the subdirectories in order until the cap
+	for _, name := range []string{"a.go", "b.go", "x/", "y/", "z/"} {
+		if _, ok := structure[name]; !ok {
+			t.Errorf("expected the top-level entry %s, got %v", name, structure)
+		}
+	}
+	x := structure["x/"].(map[string]interface{})
+	if _, ok := x["deep/"]; !ok || len(x) != 2 || x["..."] != omittedEntriesMarker(6) {
+		t.Errorf("expected the first entry of x and the omission marker, got %v", x)
+	}
+	for _, name := range []string{"y/", "z/"} {
+		if dir := structure[name].(map[string]interface{}); len(dir) != 1 || dir["..."] != omittedEntriesMarker(6) {
+			t.Errorf("expected %s to be left unread, got %v", name, dir)
+		}
+	}
+
+	config.AppConfig.Explorer.MaxEntries = 0
+	structure, _ = getDirectoryStructure(config.AppConfig, projectPath, 10, 0)
+	if _, ok := structure["z/"].(map[string]interface{})["nested/"]; !ok {
+		t.Errorf("expected the whole structure without a cap, got %v", structure)
+	}
+}
@@ -1,20 +1,20 @@
 package main
 
 import (
-	"debugagent/config"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-
-	"github.com/sirupsen/logrus"
 )
 
 // FileResolver handles intelligent file resolution and fallback strategies.
 type FileResolver struct {
-	projectPath     string
-	kb              *KnowledgeBase
+	projectPath      string
+	kb               *KnowledgeBase
 	maxRetryAttempts int
+	maxDepth         int // Depth of the sub-project discovery, analysis.max_directory_depth by default
 }
 
 // DependencyFileMapping defines fallback strategies for different file types.
@@ -42,8 +42,8 @@ var CommonConfigFiles = []string{
 // NewFileResolver creates a new FileResolver instance.
 func NewFileResolver(projectPath string, kb *KnowledgeBase) *FileResolver {
 	maxRetryAttempts := 3 // Default value
-	if config.AppConfig != nil && config.AppConfig.Analysis.MaxFileRetryAttempts > 0 {
-		maxRetryAttempts = config.AppConfig.Analysis.MaxFileRetryAttempts
+	if cfg := kb.cfg; cfg != nil && cfg.Analysis.MaxFileRetryAttempts > 0 {
+		maxRetryAttempts = cfg.Analysis.MaxFileRetryAttempts
 	}
 
 	return &FileResolver{
@@ -69,10 +69,10 @@ func (fr *FileResolver) ResolveFile(requestedFile string) (string, error) {
 
 	// If exact file doesn't exist, try to find alternatives
 	alternatives := fr.findAlternatives(requestedFile)
-	for _, alt := range alternatives {
-		altPath := filepath.Join(fr.projectPath, alt)
-		if fr.fileExists(altPath) {
-			logrus.Infof("Found alternative for '%s': '%s'", requestedFile, alt)
+	for _, pattern := range alternatives {
+		if matches := fr.matchingFiles(pattern); len(matches) > 0 {
+			alt := matches[0]
+			fr.kb.log.Infof("Found alternative for '%s': '%s'", requestedFile, alt)
 			fr.kb.AddAvailableFile(alt)
 			return alt, nil
 		}
@@ -126,13 +126,12 @@ func (fr *FileResolver) findAlternatives(requestedFile string) []string {
 
 // DiscoverProjectFiles scans the project for available dependency and config files.
 func (fr *FileResolver) DiscoverProjectFiles() {
-	logrus.Info("Discovering available project files...")
+	fr.kb.log.Info("Discovering available project files...")
 
-	// Check for dependency files
-	for depType, files := range DependencyFileMapping {
-		for _, file := range files {
-			fullPath := filepath.Join(fr.projectPath, file)
-			if fr.fileExists(fullPath) {
+	// Check for dependency files, patterns such as "*.csproj" are expanded
+	for depType, patterns := range DependencyFileMapping {
+		for _, pattern := range patterns {
+			for _, file := range fr.matchingFiles(pattern) {
 				fr.kb.AddDependencyFile(depType, file)
 				fr.kb.AddAvailableFile(file)
 			}
@@ -141,13 +140,82 @@ func (fr *FileResolver) DiscoverProjectFiles() {
 
 	// Check for common config files
 	for _, file := range CommonConfigFiles {
-		fullPath := filepath.Join(fr.projectPath, file)
-		if fr.fileExists(fullPath) {
-			fr.kb.AddAvailableFile(file)
+		for _, match := range fr.matchingFiles(file) {
+			fr.kb.AddAvailableFile(match)
+		}
+	}
+
+	// Manifests of the sub-projects of a monorepo
+	fr.discoverSubProjects()
+
+	fr.kb.log.Infof("File discovery complete. Found %d available files", len(fr.kb.AvailableFiles))
+}
+
+// discoverSubProjects walks the project subdirectories, down to maxDepth
+// (analysis.max_directory_depth by default) and skipping the ignored entries, and records
+// every dependency manifest found there by relative path.
+func (fr *FileResolver) discoverSubProjects() {
+	maxDepth := fr.maxDepth
+	if maxDepth <= 0 {
+		maxDepth = fr.kb.cfg.Analysis.MaxDirectoryDepth
+	}
+	filepath.WalkDir(fr.projectPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == fr.projectPath {
+			return nil
+		}
+		relPath, _ := filepath.Rel(fr.projectPath, path)
+		relPath = filepath.ToSlash(relPath)
+
+		if entry.IsDir() {
+			if isIgnoredEntry(fr.kb.cfg, entry.Name(), true) || strings.Count(relPath, "/")+1 >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.Contains(relPath, "/") || isIgnoredEntry(fr.kb.cfg, entry.Name(), false) {
+			return nil // Root manifests are recorded by DiscoverProjectFiles
+		}
+		if depType := manifestType(entry.Name()); depType != "" {
+			fr.kb.AddSubProjectManifest(relPath, depType)
+		}
+		return nil
+	})
+}
+
+// manifestType returns the dependency type of a file named name according to
+// DependencyFileMapping, or "" if it is not a manifest.
+func manifestType(name string) string {
+	depTypes := make([]string, 0, len(DependencyFileMapping))
+	for depType := range DependencyFileMapping {
+		depTypes = append(depTypes, depType)
+	}
+	sort.Strings(depTypes)
+
+	for _, depType := range depTypes {
+		for _, pattern := range DependencyFileMapping[depType] {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return depType
+			}
 		}
 	}
+	return ""
+}
 
-	logrus.Infof("File discovery complete. Found %d available files", len(fr.kb.AvailableFiles))
+// matchingFiles returns the files of the project matching pattern, a path
+// relative to the project root that may contain filepath.Match wildcards.
+func (fr *FileResolver) matchingFiles(pattern string) []string {
+	matches, err := filepath.Glob(filepath.Join(fr.projectPath, pattern))
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, match := range matches {
+		if fr.fileExists(match) {
+			relPath, _ := filepath.Rel(fr.projectPath, match)
+			files = append(files, filepath.ToSlash(relPath))
+		}
+	}
+	return files
 }
 
 // fileExists checks if a file exists and is readable.
@@ -177,12 +245,9 @@ func (fr *FileResolver) removeDuplicates(slice []string) []string {
 func (fr *FileResolver) GetAvailableAlternatives(fileType string) []string {
 	var alternatives []string
 
-	if files, exists := DependencyFileMapping[fileType]; exists {
-		for _, file := range files {
-			fullPath := filepath.Join(fr.projectPath, file)
-			if fr.fileExists(fullPath) {
-				alternatives = append(alternatives, file)
-			}
+	if patterns, exists := DependencyFileMapping[fileType]; exists {
+		for _, pattern := range patterns {
+			alternatives = append(alternatives, fr.matchingFiles(pattern)...)
 		}
 	}
 
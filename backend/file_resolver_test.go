@@ -4,6 +4,8 @@ import (
 	"debugagent/config"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -148,3 +150,60 @@ func TestGetAvailableAlternatives(t *testing.T) {
 		t.Error("Expected composer.json to be in alternatives")
 	}
 }
+
+func TestDiscoverProjectFiles_GlobPatterns(t *testing.T) {
+	resolver, tempDir := setupFileResolverTest(t)
+	for _, file := range []string{"Foo.csproj", "bar.gemspec"} {
+		if err := os.WriteFile(filepath.Join(tempDir, file), []byte("test content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", file, err)
+		}
+	}
+
+	resolver.DiscoverProjectFiles()
+
+	if resolver.kb.DependencyFiles["dotnet"] != "Foo.csproj" {
+		t.Errorf("Expected Foo.csproj to be discovered, got %v", resolver.kb.DependencyFiles)
+	}
+	if resolver.kb.DependencyFiles["ruby"] != "bar.gemspec" {
+		t.Errorf("Expected bar.gemspec to be discovered, got %v", resolver.kb.DependencyFiles)
+	}
+	if alternatives := resolver.GetAvailableAlternatives("dotnet"); len(alternatives) != 1 || alternatives[0] != "Foo.csproj" {
+		t.Errorf("Expected Foo.csproj as dotnet alternative, got %v", alternatives)
+	}
+}
+
+func TestDiscoverProjectFiles_SubProjects(t *testing.T) {
+	resolver, tempDir := setupFileResolverTest(t)
+	config.AppConfig.Analysis.MaxDirectoryDepth = 3
+	config.AppConfig.Explorer.IgnoreDirs = []string{"node_modules"}
+
+	for _, file := range []string{
+		"services/api/go.mod",
+		"services/api/go.sum",
+		"web/package.json",
+		"web/node_modules/left-pad/package.json", // Ignored directory
+		"deep/a/b/go.mod",                        // Beyond max_directory_depth
+	} {
+		path := filepath.Join(tempDir, filepath.FromSlash(file))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", file, err)
+		}
+	}
+
+	resolver.DiscoverProjectFiles()
+
+	expected := map[string]string{
+		"services/api/go.mod": "go",
+		"services/api/go.sum": "go",
+		"web/package.json":    "npm",
+	}
+	if !reflect.DeepEqual(resolver.kb.SubProjects, expected) {
+		t.Errorf("Expected sub-projects %v, got %v", expected, resolver.kb.SubProjects)
+	}
+
+	summary := resolver.kb.getContextSummary("question", 4000)
+	if !strings.Contains(summary, "- services/api/ (go: go.mod, go: go.sum)\n- web/ (npm: package.json)") {
+		t.Errorf("Expected the sub-projects in the context summary, got:\n%s", summary)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxFollowupRequestBytes bounds the JSON body of a follow-up question.
+const maxFollowupRequestBytes = 64 << 10
+
+// FollowupRequest is the JSON body of /analyze/{id}/followup.
+type FollowupRequest struct {
+	Question     string `json:"question"`
+	Model        string `json:"model"`         // Optional model override, as the "model" form field
+	SystemPrompt string `json:"system_prompt"` // Optional, as the "system_prompt" form field
+	Language     string `json:"language"`      // Optional, as the "language" form field
+}
+
+// FollowupResponse is the answer of /analyze/{id}/followup. CacheID is the
+// session the question was asked on, for the next follow-up.
+type FollowupResponse struct {
+	Answer  string        `json:"answer"`
+	Sources []Source      `json:"sources"`
+	Stats   AnalysisStats `json:"stats"`
+	CacheID string        `json:"cache_id"`
+
+	Confidence string `json:"confidence,omitempty"`
+	Unresolved string `json:"unresolved,omitempty"`
+}
+
+// analyzeFollowupHandler answers a follow-up question about the project of
+// an incremental analysis session, without uploading it again. The session's
+// knowledge base, with the files already read and the previous questions and
+// answers, is restored from the cache, and the exploration is limited to
+// analysis.incremental_iterations.
+func analyzeFollowupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var followup FollowupRequest
+	if !decodeJSONRequest(w, r, maxFollowupRequestBytes, &followup) || !requireQuestion(w, &followup.Question) {
+		return
+	}
+
+	systemPrompt, err := validateSystemPrompt(followup.SystemPrompt)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	language, err := validateLanguage(followup.Language)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	session, err := openSession(r.PathValue("id"))
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	defer session.close()
+
+	release, err := analyses.acquire(r.Context(), queueTimeout(), nil)
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			writeServerBusy(w)
+		}
+		return // Otherwise the client went away
+	}
+	defer release()
+
+	engine, err := NewAnalysisEngine(r.Context(), AnalyzeRequest{
+		ProjectPath:   session.projectDir(),
+		Question:      followup.Question,
+		Model:         followup.Model, // Empty falls back to the configured model
+		SystemPrompt:  systemPrompt,
+		Language:      language,
+		MaxIterations: config.Get().Analysis.IncrementalIterations,
+		Cached:        true,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error initializing analysis engine: %v", err))
+		return
+	}
+
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		writeAnalysisError(w, "Error during analysis", err)
+		return
+	}
+	engine.kb.AddPreviousQuestion(followup.Question, result.Answer)
+	saveKnowledgeBaseCache(engine.kb, engine.cacheDir())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FollowupResponse{
+		Answer:     result.Answer,
+		Sources:    result.Sources,
+		Stats:      result.Stats,
+		CacheID:    session.ID,
+		Confidence: result.Confidence,
+		Unresolved: result.Unresolved,
+	})
+}
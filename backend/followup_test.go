@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"debugagent/config"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newFollowupRequest builds a follow-up request with a JSON body on session id.
+func newFollowupRequest(id, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/analyze/"+id+"/followup", strings.NewReader(body))
+	req.SetPathValue("id", id)
+	return req
+}
+
+func TestAnalyzeFollowupHandler(t *testing.T) {
+	var mu sync.Mutex
+	var prompts []string
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		prompts = append(prompts, string(body))
+		mu.Unlock()
+		if r.URL.Path == "/api/chat" {
+			w.Write([]byte(`{"message":{"role":"assistant","content":"[{\"action\":\"READ_FILE\",\"argument\":\"main.go\"}]"},"done":true}`))
+			return
+		}
+		w.Write([]byte(`{"response":"See main.go","done":true}`))
+	})
+	config.AppConfig.Analysis.CacheDir = t.TempDir()
+	config.AppConfig.Analysis.MaxExplorationIterations = 1
+	config.AppConfig.Analysis.IncrementalIterations = 1
+	config.AppConfig.Analysis.MaxFileReadSize = 10000
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	part, _ := writer.CreateFormFile("files", "main.go")
+	part.Write([]byte("package main"))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/analyze-incremental", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	analyzeIncrementalHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	var first IncrementalAnalyzeResponse
+	json.Unmarshal(rr.Body.Bytes(), &first)
+
+	prompts = nil
+	rr = httptest.NewRecorder()
+	analyzeFollowupHandler(rr, newFollowupRequest(first.CacheID, `{"question": "Where is the entry point?"}`))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	var resp FollowupResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.CacheID != first.CacheID || resp.Answer == "" {
+		t.Errorf("expected an answer on session '%s', got %+v", first.CacheID, resp)
+	}
+	if !strings.Contains(strings.Join(prompts, "\n"), "Q: What does this do?") {
+		t.Error("expected the previous question to be sent to the model")
+	}
+}
+
+func TestAnalyzeFollowupHandler_Errors(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{CacheDir: t.TempDir()}}
+	unknownID := strings.Repeat("0", 32)
+
+	testCases := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid JSON", unknownID, `{`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"missing question", unknownID, `{}`, http.StatusBadRequest, ErrCodeMissingQuestion},
+		{"blank question", unknownID, `{"question": "   "}`, http.StatusBadRequest, ErrCodeMissingQuestion},
+		{"oversized body", unknownID, `{"question": "` + strings.Repeat("a", maxFollowupRequestBytes) + `"}`, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge},
+		{"unknown session", unknownID, `{"question": "Why?"}`, http.StatusNotFound, ErrCodeNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			analyzeFollowupHandler(rr, newFollowupRequest(tc.id, tc.body))
+
+			if rr.Code != tc.status || !strings.Contains(rr.Body.String(), tc.code) {
+				t.Errorf("expected %d %s, got %d (%s)", tc.status, tc.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+
+	"github.com/JexSrs/go-ollama"
+)
+
+// Phases de l'analyse pouvant recevoir leurs propres paramètres de génération
+// (ollama.options.planning, ollama.options.synthesis).
+const (
+	phasePlanning  = "planning"
+	phaseSynthesis = "synthesis"
+)
+
+type generationPhaseKey struct{}
+
+// withGenerationPhase retourne une copie de ctx dont les requêtes au modèle
+// utilisent les paramètres de génération de phase.
+func withGenerationPhase(ctx context.Context, phase string) context.Context {
+	return context.WithValue(ctx, generationPhaseKey{}, phase)
+}
+
+// generationPhase retourne la phase portée par ctx, ou "" hors phase.
+func generationPhase(ctx context.Context) string {
+	phase, _ := ctx.Value(generationPhaseKey{}).(string)
+	return phase
+}
+
+// ollamaOptions convertit les paramètres de génération de la phase de ctx
+// en options go-ollama. ok est faux si aucun paramètre n'est défini : les
+// valeurs par défaut du modèle s'appliquent alors.
+func ollamaOptions(ctx context.Context) (options ollama.Options, ok bool) {
+	params := config.FromContext(ctx).Ollama.Options.ForPhase(generationPhase(ctx))
+	if params.IsZero() {
+		return ollama.Options{}, false
+	}
+	return ollama.Options{
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		NumPredict:  params.NumPredict,
+		Seed:        params.Seed,
+	}, true
+}
+
+// ollamaKeepAlive retourne la durée ollama.keep_alive à envoyer avec chaque
+// requête, pour que le modèle reste chargé entre les étapes d'une analyse.
+// ok est faux si elle n'est pas définie (ou invalide, ce que Config.Validate
+// refuse au démarrage) : la valeur par défaut du serveur s'applique alors.
+func ollamaKeepAlive(ctx context.Context) (keepAlive string, ok bool) {
+	keepAlive, err := config.FromContext(ctx).Ollama.KeepAliveDuration()
+	return keepAlive, err == nil && keepAlive != ""
+}
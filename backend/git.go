@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxGitRequestBytes caps the JSON body of /analyze-git.
+const maxGitRequestBytes = 1 << 20
+
+// cloneSizeCheckInterval is how often the size of a running clone is checked
+// against git.max_clone_bytes.
+const cloneSizeCheckInterval = 500 * time.Millisecond
+
+var (
+	// ErrInvalidRepository is returned for repository URLs or refs refused
+	// before cloning: unsupported scheme, option-like values...
+	ErrInvalidRepository = errors.New("invalid repository")
+	// ErrCloneTooLarge is returned when a clone exceeds git.max_clone_bytes.
+	ErrCloneTooLarge = errors.New("repository too large")
+	// ErrCloneTimeout is returned when a clone exceeds git.clone_timeout_seconds.
+	ErrCloneTimeout = errors.New("clone timed out")
+
+	// scpLikeURL matches the git@host:path form of ssh URLs.
+	scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/\\-][^\\]*$`)
+	// validRef matches the branch, tag and commit names accepted as ref.
+	validRef = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// GitAnalyzeRequest is the JSON body of /analyze-git.
+type GitAnalyzeRequest struct {
+	RepoURL      string   `json:"repo_url"`
+	Question     string   `json:"question"`
+	Questions    []string `json:"questions"`     // Optional, questions sharing the exploration, as the "questions" form fields
+	Ref          string   `json:"ref"`           // Branch, tag or commit, empty for the default branch
+	Model        string   `json:"model"`         // Optional model override, as the "model" form field
+	MaxDepth     int      `json:"max_depth"`     // Optional, as the "max_depth" form field
+	SystemPrompt string   `json:"system_prompt"` // Optional, as the "system_prompt" form field
+	Language     string   `json:"language"`      // Optional, as the "language" form field
+}
+
+// validateRepoURL checks that repoURL is an https URL, or an ssh one when
+// git.allow_ssh is set. Local paths and the other git transports (file://,
+// ext::...) are always refused.
+func validateRepoURL(repoURL string) error {
+	if strings.HasPrefix(repoURL, "-") {
+		return fmt.Errorf("%w: '%s'", ErrInvalidRepository, repoURL)
+	}
+	if config.Get().Git.AllowSSH && scpLikeURL.MatchString(repoURL) {
+		return nil
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: '%s' is not a repository URL", ErrInvalidRepository, repoURL)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "ssh":
+		if config.Get().Git.AllowSSH {
+			return nil
+		}
+		return fmt.Errorf("%w: ssh URLs are disabled (see git.allow_ssh)", ErrInvalidRepository)
+	default:
+		return fmt.Errorf("%w: only https URLs are accepted, got '%s'", ErrInvalidRepository, u.Scheme)
+	}
+}
+
+// repoHost returns the host of repoURL, which validateRepoURL accepted.
+func repoHost(repoURL string) string {
+	if scpLikeURL.MatchString(repoURL) {
+		_, rest, _ := strings.Cut(repoURL, "@")
+		host, _, _ := strings.Cut(rest, ":")
+		return host
+	}
+	if u, err := url.Parse(repoURL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// repoAddresses are the addresses checkRepoHost resolved the host of a
+// repository URL to. git connects to them rather than resolving the name
+// again, which a rebinding DNS server could answer with an internal address
+// the second time. The zero value, for IP addresses and the hosts of
+// git.allowed_hosts, leaves the resolution to git.
+type repoAddresses struct {
+	host  string
+	addrs []net.IP
+}
+
+// checkRepoHost refuses repoURL when its host is, or resolves to, a loopback,
+// private or link-local address, as for callback URLs, unless it is listed in
+// git.allowed_hosts. It returns the addresses checked, which the clone is
+// pinned to, see gitOptions; git is also run without following redirects,
+// see runGit.
+func checkRepoHost(ctx context.Context, repoURL string) (repoAddresses, error) {
+	host := repoHost(repoURL)
+	if hostListed(config.Get().Git.AllowedHosts, host) {
+		return repoAddresses{}, nil
+	}
+	refused := fmt.Errorf("%w: loopback, private and link-local addresses are refused (see git.allowed_hosts)", ErrInvalidRepository)
+	if lower := strings.ToLower(host); lower == "localhost" || strings.HasSuffix(lower, ".localhost") {
+		return repoAddresses{}, refused
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return repoAddresses{}, refused
+		}
+		return repoAddresses{}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return repoAddresses{}, fmt.Errorf("%w: could not resolve '%s'", ErrInvalidRepository, host)
+	}
+	checked := repoAddresses{host: host}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return repoAddresses{}, refused
+		}
+		checked.addrs = append(checked.addrs, addr.IP)
+	}
+	return checked, nil
+}
+
+// gitOptions returns the -c options of the git commands fetching repoURL,
+// which connect to the addresses in a. An https URL goes through curl's
+// resolve list, an ssh one through the HostName of ssh, the host name still
+// being the one looked up in known_hosts.
+func (a repoAddresses) gitOptions(repoURL string) []string {
+	sshCommand := "ssh -o BatchMode=yes" // Fail instead of asking for credentials
+	if len(a.addrs) == 0 {
+		return []string{"-c", "core.sshCommand=" + sshCommand}
+	}
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme == "https" {
+		port := u.Port()
+		if port == "" {
+			port = "443"
+		}
+		addrs := make([]string, len(a.addrs))
+		for i, ip := range a.addrs {
+			addrs[i] = ip.String()
+			if ip.To4() == nil {
+				addrs[i] = "[" + addrs[i] + "]"
+			}
+		}
+		return []string{
+			"-c", "core.sshCommand=" + sshCommand,
+			"-c", fmt.Sprintf("http.curloptResolve=%s:%s:%s", a.host, port, strings.Join(addrs, ",")),
+		}
+	}
+	return []string{"-c", fmt.Sprintf("core.sshCommand=%s -o HostName=%s -o HostKeyAlias=%s", sshCommand, a.addrs[0], a.host)}
+}
+
+// validateRef checks that ref is a plain branch, tag or commit name.
+func validateRef(ref string) error {
+	if ref == "" {
+		return nil
+	}
+	if strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") || !validRef.MatchString(ref) {
+		return fmt.Errorf("%w: invalid ref '%s'", ErrInvalidRepository, ref)
+	}
+	return nil
+}
+
+// cloneRepository fetches the single commit ref (the default branch when
+// empty) of repoURL into destDir, which must exist and be empty, without
+// history. Fetching rather than cloning with --branch also accepts commit
+// hashes. git connects to the addresses of pinned, as checked by
+// checkRepoHost. The clone is bounded by git.clone_timeout_seconds and
+// git.max_clone_bytes, and never prompts for credentials.
+func cloneRepository(ctx context.Context, repoURL, ref, destDir string, pinned repoAddresses) error {
+	if timeout := config.Get().Git.CloneTimeoutSeconds; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(timeout)*time.Second, ErrCloneTimeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if maxBytes := config.Get().Git.MaxCloneBytes; maxBytes > 0 {
+		go watchCloneSize(ctx, cancel, destDir, maxBytes)
+	}
+
+	// HOME of git, which reads and writes nothing in the server's home
+	home, err := os.MkdirTemp("", "git-home-")
+	if err != nil {
+		return fmt.Errorf("could not create the git home directory: %w", err)
+	}
+	defer os.RemoveAll(home)
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	options := pinned.gitOptions(repoURL)
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", "--no-tags", "--", repoURL, ref},
+		{"checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if err := runGit(ctx, destDir, home, options, args...); err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return cause
+			}
+			return err
+		}
+	}
+	// The checkout may have finished between two checks of watchCloneSize
+	if maxBytes := config.Get().Git.MaxCloneBytes; maxBytes > 0 && dirSize(destDir) > maxBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrCloneTooLarge, maxBytes)
+	}
+	return nil
+}
+
+// redactedRepoURL returns repoURL with the password of its user info, such as
+// an access token, masked for the logs.
+func redactedRepoURL(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil {
+		return u.Redacted()
+	}
+	return repoURL
+}
+
+// runGit runs git with options, as returned by gitOptions, and args in dir,
+// and returns the end of its error output if it fails. That output is only
+// logged, see cloneErrorMessage. Redirects are not followed, so that a public
+// URL cannot send the clone to an internal host, and neither the system nor
+// the user git configuration is read.
+func runGit(ctx context.Context, dir, home string, options []string, args ...string) error {
+	gitArgs := append([]string{"-c", "http.followRedirects=false"}, options...)
+	cmd := exec.CommandContext(ctx, "git", append(gitArgs, args...)...)
+	cmd.Dir = dir
+	cmd.Env = gitEnv(home)
+	output := &tailBuffer{limit: 1000}
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(string(output.data)))
+	}
+	return nil
+}
+
+// gitEnv returns the environment of git, like commandEnv: only PATH is kept
+// from the server, whose environment holds the LLM API key and the admin
+// token, and whose GIT_*, proxy or askpass variables would change how the
+// repository is fetched.
+func gitEnv(home string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + home,
+		"GIT_TERMINAL_PROMPT=0", // Fail instead of asking for credentials, see gitOptions for ssh
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+	}
+}
+
+// watchCloneSize cancels the clone with ErrCloneTooLarge as soon as dir
+// takes more than maxBytes, until ctx is done.
+func watchCloneSize(ctx context.Context, cancel context.CancelCauseFunc, dir string, maxBytes int64) {
+	ticker := time.NewTicker(cloneSizeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if dirSize(dir) > maxBytes {
+				cancel(fmt.Errorf("%w: more than %d bytes", ErrCloneTooLarge, maxBytes))
+				return
+			}
+		}
+	}
+}
+
+// dirSize returns the total size of the regular files under dir, ignoring
+// the entries that disappear or cannot be read while it walks.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// cloneErrorStatus maps a cloneRepository error to an HTTP status.
+func cloneErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRepository):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrCloneTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrCloneTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway // The repository or ref could not be fetched
+	}
+}
+
+// analyzeGitHandler analyzes a git repository instead of uploaded files: the
+// JSON body gives the repo_url, the question and optionally the ref. The
+// repository is cloned without history into a temporary directory, removed
+// once answered, and the response has the same shape as /analyze.
+func analyzeGitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var gitReq GitAnalyzeRequest
+	if !decodeJSONRequest(w, r, maxGitRequestBytes, &gitReq) {
+		return
+	}
+	questions, ok := requireQuestions(w, gitReq.Question, gitReq.Questions)
+	if !ok {
+		return
+	}
+	if gitReq.RepoURL = strings.TrimSpace(gitReq.RepoURL); gitReq.RepoURL == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRepository, "Missing 'repo_url' field")
+		return
+	}
+	if err := validateRepoURL(gitReq.RepoURL); err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRepository, err.Error())
+		return
+	}
+	if err := validateRef(gitReq.Ref); err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRepository, err.Error())
+		return
+	}
+	maxDepth, err := validateMaxDepth(gitReq.MaxDepth)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	systemPrompt, err := validateSystemPrompt(gitReq.SystemPrompt)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	language, err := validateLanguage(gitReq.Language)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	pinned, err := checkRepoHost(r.Context(), gitReq.RepoURL)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRepository, err.Error())
+		return
+	}
+
+	// Wait for an analysis slot before cloning, so that concurrent requests
+	// neither overload Ollama nor fill the disk with clones waiting their turn
+	release, err := analyses.acquire(r.Context(), queueTimeout(), nil)
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			writeServerBusy(w)
+		}
+		return // Otherwise the client went away
+	}
+	defer release()
+
+	tempDir, err := os.MkdirTemp("", "git-project-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "Error creating temporary directory")
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	log := requestLogger(r.Context())
+	log.Infof("Cloning %s (ref %q)", redactedRepoURL(gitReq.RepoURL), gitReq.Ref)
+	if err := cloneRepository(r.Context(), gitReq.RepoURL, gitReq.Ref, tempDir, pinned); err != nil {
+		if r.Context().Err() != nil {
+			return // The client went away
+		}
+		log.Warnf("Clone of %s failed: %v", redactedRepoURL(gitReq.RepoURL), err)
+		writeJSONError(w, cloneErrorStatus(err), cloneErrorCode(err), cloneErrorMessage(err))
+		return
+	}
+
+	engine, err := NewAnalysisEngine(r.Context(), AnalyzeRequest{
+		ProjectPath:  tempDir,
+		Model:        gitReq.Model,
+		MaxDepth:     maxDepth,
+		SystemPrompt: systemPrompt,
+		Language:     language,
+	}.withQuestions(questions))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error initializing analysis engine: %v", err))
+		return
+	}
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		writeAnalysisError(w, "Error during analysis", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalyzeResponse{
+		Answer:     result.Answer,
+		Sources:    result.Sources,
+		Stats:      result.Stats,
+		Confidence: result.Confidence,
+		Unresolved: result.Unresolved,
+		Answers:    answersByQuestion(result.Answers),
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupGitRepository creates a repository with a commit on main holding
+// main.go, tagged v1, and a second commit changing it.
+func setupGitRepository(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	config.AppConfig = &config.Config{Git: config.GitConfig{CloneTimeoutSeconds: 30}}
+
+	repoDir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(repoDir, "main.go"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet", "--initial-branch=main")
+	write("package main // v1\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	write("package main // v2\n")
+	git("commit", "--quiet", "-am", "v2")
+	return repoDir
+}
+
+func TestValidateRepoURL(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	tests := []struct {
+		url      string
+		allowSSH bool
+		valid    bool
+	}{
+		{url: "https://github.com/nohe-sohbi/DebugAgent.git", valid: true},
+		{url: "http://github.com/nohe-sohbi/DebugAgent.git"},
+		{url: "file:///etc"},
+		{url: "/tmp/repo"},
+		{url: "ext::sh -c touch% /tmp/pwned"},
+		{url: "--upload-pack=touch /tmp/pwned"},
+		{url: "git@github.com:nohe-sohbi/DebugAgent.git"},
+		{url: "ssh://git@github.com/nohe-sohbi/DebugAgent.git"},
+		{url: "git@github.com:nohe-sohbi/DebugAgent.git", allowSSH: true, valid: true},
+		{url: "ssh://git@github.com/nohe-sohbi/DebugAgent.git", allowSSH: true, valid: true},
+		{url: "git@github.com:-oProxyCommand=x", allowSSH: true},
+	}
+	for _, tt := range tests {
+		config.AppConfig.Git.AllowSSH = tt.allowSSH
+		err := validateRepoURL(tt.url)
+		if tt.valid && err != nil {
+			t.Errorf("validateRepoURL(%q, ssh=%v) = %v, want nil", tt.url, tt.allowSSH, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidRepository) {
+			t.Errorf("validateRepoURL(%q, ssh=%v) = %v, want ErrInvalidRepository", tt.url, tt.allowSSH, err)
+		}
+	}
+}
+
+func TestCheckRepoHost(t *testing.T) {
+	config.AppConfig = &config.Config{Git: config.GitConfig{AllowSSH: true, AllowedHosts: []string{"10.0.0.5", "Git.Internal.localhost"}}}
+	refused := []string{
+		"https://127.0.0.1/repo.git",
+		"https://169.254.169.254/latest/meta-data",
+		"https://192.168.1.10/repo.git",
+		"https://[::1]/repo.git",
+		"https://localhost/repo.git",
+		"git@10.0.0.6:team/repo.git",
+	}
+	for _, repoURL := range refused {
+		if _, err := checkRepoHost(context.Background(), repoURL); !errors.Is(err, ErrInvalidRepository) {
+			t.Errorf("checkRepoHost(%q) = %v, want ErrInvalidRepository", repoURL, err)
+		}
+	}
+	allowed := []string{
+		"https://10.0.0.5/repo.git",
+		"https://git.internal.localhost/repo.git",
+		"git@10.0.0.5:team/repo.git",
+		"https://8.8.8.8/repo.git",
+	}
+	for _, repoURL := range allowed {
+		if _, err := checkRepoHost(context.Background(), repoURL); err != nil {
+			t.Errorf("checkRepoHost(%q) = %v, want nil", repoURL, err)
+		}
+	}
+}
+
+func TestRepoAddresses_GitOptions(t *testing.T) {
+	pinned := repoAddresses{host: "git.example.com", addrs: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::7")}}
+	tests := []struct {
+		addrs   repoAddresses
+		repoURL string
+		want    []string
+	}{
+		{repoAddresses{}, "https://10.0.0.5/repo.git", []string{"-c", "core.sshCommand=ssh -o BatchMode=yes"}},
+		{pinned, "https://git.example.com/repo.git", []string{
+			"-c", "core.sshCommand=ssh -o BatchMode=yes",
+			"-c", "http.curloptResolve=git.example.com:443:203.0.113.7,[2001:db8::7]",
+		}},
+		{pinned, "https://git.example.com:8443/repo.git", []string{
+			"-c", "core.sshCommand=ssh -o BatchMode=yes",
+			"-c", "http.curloptResolve=git.example.com:8443:203.0.113.7,[2001:db8::7]",
+		}},
+		{pinned, "git@git.example.com:team/repo.git", []string{
+			"-c", "core.sshCommand=ssh -o BatchMode=yes -o HostName=203.0.113.7 -o HostKeyAlias=git.example.com",
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.addrs.gitOptions(tt.repoURL); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("gitOptions(%q) = %q, want %q", tt.repoURL, got, tt.want)
+		}
+	}
+}
+
+func TestCloneErrorMessage(t *testing.T) {
+	failed := errors.New("git fetch failed: exit status 128: fatal: unable to access 'https://internal/': Connection refused")
+	if msg := cloneErrorMessage(failed); msg != "Error cloning repository: clone failed" {
+		t.Errorf("expected the git output to be left out, got %q", msg)
+	}
+	if msg := cloneErrorMessage(ErrCloneTimeout); !strings.Contains(msg, ErrCloneTimeout.Error()) {
+		t.Errorf("expected the timeout to be reported, got %q", msg)
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	for _, ref := range []string{"", "main", "v1.2.3", "feature/login", "3f2a9c1d0b7e4a68"} {
+		if err := validateRef(ref); err != nil {
+			t.Errorf("validateRef(%q) = %v, want nil", ref, err)
+		}
+	}
+	for _, ref := range []string{"--upload-pack=x", "main..dev", "main dev", "refs/heads/main:x"} {
+		if err := validateRef(ref); !errors.Is(err, ErrInvalidRepository) {
+			t.Errorf("validateRef(%q) = %v, want ErrInvalidRepository", ref, err)
+		}
+	}
+}
+
+func TestCloneRepository(t *testing.T) {
+	repoDir := setupGitRepository(t)
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "", want: "package main // v2\n"},
+		{ref: "main", want: "package main // v2\n"},
+		{ref: "v1", want: "package main // v1\n"},
+	}
+	for _, tt := range tests {
+		destDir := t.TempDir()
+		if err := cloneRepository(context.Background(), repoDir, tt.ref, destDir, repoAddresses{}); err != nil {
+			t.Fatalf("cloneRepository(ref %q) returned error: %v", tt.ref, err)
+		}
+		content, err := os.ReadFile(filepath.Join(destDir, "main.go"))
+		if err != nil || string(content) != tt.want {
+			t.Errorf("ref %q: expected main.go to be %q, got %q (%v)", tt.ref, tt.want, content, err)
+		}
+	}
+
+	err := cloneRepository(context.Background(), repoDir, "missing-branch", t.TempDir(), repoAddresses{})
+	if err == nil || cloneErrorStatus(err) != http.StatusBadGateway {
+		t.Errorf("expected a fetch error for a missing ref, got %v", err)
+	}
+}
+
+func TestCloneRepository_IgnoresServerEnvironment(t *testing.T) {
+	repoDir := setupGitRepository(t)
+	t.Setenv("GIT_DIR", t.TempDir()) // Would send every step to another repository
+	t.Setenv("DEBUGAGENT_LLM_API_KEY", "sk-server-secret")
+
+	destDir := t.TempDir()
+	if err := cloneRepository(context.Background(), repoDir, "", destDir, repoAddresses{}); err != nil {
+		t.Fatalf("cloneRepository() returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "main.go")); err != nil {
+		t.Errorf("expected main.go to be checked out: %v", err)
+	}
+	for _, variable := range gitEnv(t.TempDir()) {
+		if strings.HasPrefix(variable, "GIT_DIR=") || strings.Contains(variable, "sk-server-secret") {
+			t.Errorf("git saw the server environment: %s", variable)
+		}
+	}
+}
+
+func TestCloneRepository_SizeLimit(t *testing.T) {
+	repoDir := setupGitRepository(t)
+	config.AppConfig.Git.MaxCloneBytes = 100
+
+	err := cloneRepository(context.Background(), repoDir, "", t.TempDir(), repoAddresses{})
+	if !errors.Is(err, ErrCloneTooLarge) || cloneErrorStatus(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected ErrCloneTooLarge, got %v", err)
+	}
+}
+
+func TestAnalyzeGitHandler_RejectsInvalidRequests(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid JSON", `{"repo_url": `},
+		{"missing question", `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git"}`},
+		{"blank question", `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git", "question": "  \n"}`},
+		{"blank questions", `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git", "questions": ["", " "]}`},
+		{"too many questions", `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git", "questions": ["1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"]}`},
+		{"missing repo_url", `{"question": "?"}`},
+		{"http URL", `{"repo_url": "http://github.com/nohe-sohbi/DebugAgent.git", "question": "?"}`},
+		{"local path", `{"repo_url": "/etc", "question": "?"}`},
+		{"option-like ref", `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git", "question": "?", "ref": "--upload-pack=x"}`},
+		{"loopback address", `{"repo_url": "https://127.0.0.1:8080/repo.git", "question": "?"}`},
+		{"metadata address", `{"repo_url": "https://169.254.169.254/latest/meta-data", "question": "?"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/analyze-git", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			analyzeGitHandler(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnalyzeGitHandler_RejectsOversizedBody(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	body := `{"repo_url": "https://github.com/nohe-sohbi/DebugAgent.git", "question": "` + strings.Repeat("a", maxGitRequestBytes) + `"}`
+	rr := httptest.NewRecorder()
+	analyzeGitHandler(rr, httptest.NewRequest(http.MethodPost, "/analyze-git", strings.NewReader(body)))
+
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), ErrCodeRequestTooLarge) {
+		t.Errorf("expected 413 %s, got %d: %s", ErrCodeRequestTooLarge, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAnalyzeGitHandler_WaitsForSlotBeforeCloning(t *testing.T) {
+	config.AppConfig = &config.Config{Server: config.ServerConfig{QueueTimeoutSeconds: 1}}
+	analyses = newAnalysisQueue(1)
+	t.Cleanup(func() { analyses = nil })
+	release, _ := analyses.acquire(t.Context(), 0, nil)
+	defer release()
+
+	// The slot is taken: the request gives up without cloning anything
+	body := `{"repo_url": "https://203.0.113.7/repo.git", "question": "?"}`
+	rr := httptest.NewRecorder()
+	analyzeGitHandler(rr, httptest.NewRequest(http.MethodPost, "/analyze-git", strings.NewReader(body)))
+
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), ErrCodeServerBusy) {
+		t.Errorf("expected 503 %s before the clone, got %d: %s", ErrCodeServerBusy, rr.Code, rr.Body.String())
+	}
+}
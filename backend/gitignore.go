@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gitignoreRule est un motif d'un fichier .gitignore, compilé en expression régulière.
+type gitignoreRule struct {
+	base     string // Répertoire du .gitignore, relatif au projet ("" pour la racine)
+	regex    *regexp.Regexp
+	negate   bool // Motif commençant par "!"
+	dirOnly  bool // Motif terminé par "/"
+	anchored bool // Motif contenant un "/" : relatif à base plutôt qu'au nom seul
+}
+
+// gitignoreMatcher regroupe les règles des .gitignore rencontrés pendant le
+// parcours. Comme pour git, la dernière règle correspondante l'emporte, et les
+// règles d'un .gitignore imbriqué passent après celles de ses parents.
+type gitignoreMatcher struct {
+	rules []gitignoreRule
+}
+
+// agentIgnoreFile est le fichier d'exclusions propre à l'agent, lu à la racine
+// du projet avec la syntaxe des .gitignore. Ses règles passent après celles de
+// la configuration et des .gitignore (voir overrides).
+const agentIgnoreFile = ".debugagentignore"
+
+// loadAgentIgnore retourne les règles du .debugagentignore de projectPath, ou
+// un matcher vide s'il n'existe pas.
+func loadAgentIgnore(projectPath string) *gitignoreMatcher {
+	return (&gitignoreMatcher{}).withIgnoreFile(projectPath, "", agentIgnoreFile)
+}
+
+// withGitignore retourne un matcher complété par le .gitignore de dir (s'il
+// existe). relDir est le chemin de dir relatif au projet, avec des "/".
+// Le matcher d'origine n'est pas modifié, pour ne pas affecter les répertoires voisins.
+func (m *gitignoreMatcher) withGitignore(dir, relDir string) *gitignoreMatcher {
+	return m.withIgnoreFile(dir, relDir, ".gitignore")
+}
+
+// withIgnoreFile est withGitignore pour un fichier d'exclusions nommé name.
+func (m *gitignoreMatcher) withIgnoreFile(dir, relDir, name string) *gitignoreMatcher {
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return m
+	}
+	defer file.Close()
+
+	rules := append([]gitignoreRule{}, m.rules...)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseGitignoreLine(scanner.Text(), relDir); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Warnf("Could not read %s in '%s': %v", name, dir, err)
+	}
+	return &gitignoreMatcher{rules: rules}
+}
+
+// isIgnored indique si relPath (relatif au projet, avec des "/") est exclu.
+func (m *gitignoreMatcher) isIgnored(relPath string, isDir bool) bool {
+	ignored, _ := m.match(relPath, isDir)
+	return ignored
+}
+
+// match retourne le verdict de la dernière règle correspondant à relPath, et
+// false pour matched si aucune ne correspond.
+func (m *gitignoreMatcher) match(relPath string, isDir bool) (ignored, matched bool) {
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(relPath) {
+			ignored, matched = !rule.negate, true
+		}
+	}
+	return ignored, matched
+}
+
+// overrides applique les règles de m par-dessus excluded, le verdict des
+// exclusions de priorité inférieure : si une règle correspond à relPath, elle
+// l'emporte, y compris un "!motif" qui réintègre une entrée exclue.
+func (m *gitignoreMatcher) overrides(excluded bool, relPath string, isDir bool) bool {
+	if ignored, matched := m.match(relPath, isDir); matched {
+		return ignored
+	}
+	return excluded
+}
+
+func (r gitignoreRule) matches(relPath string) bool {
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, r.base+"/")
+	}
+	if !r.anchored {
+		relPath = path.Base(relPath)
+	}
+	return r.regex.MatchString(relPath)
+}
+
+// parseGitignoreLine convertit une ligne de .gitignore en règle. Les lignes
+// vides et les commentaires retournent false.
+func parseGitignoreLine(line, base string) (gitignoreRule, bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return gitignoreRule{}, false
+	}
+
+	rule := gitignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return gitignoreRule{}, false
+	}
+
+	regex, err := regexp.Compile("^" + gitignorePatternToRegex(line) + "$")
+	if err != nil {
+		logrus.Warnf("Ignoring invalid .gitignore pattern '%s': %v", line, err)
+		return gitignoreRule{}, false
+	}
+	rule.regex = regex
+	return rule, true
+}
+
+// gitignorePatternToRegex traduit un motif glob de .gitignore : "*" et "?"
+// ne traversent pas les "/", "**" correspond à un nombre quelconque de répertoires.
+func gitignorePatternToRegex(pattern string) string {
+	var regex strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			regex.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			regex.WriteString(".*")
+			i++
+		case c == '*':
+			regex.WriteString("[^/]*")
+		case c == '?':
+			regex.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			regex.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				regex.WriteString("\\[")
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			regex.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return regex.String()
+}
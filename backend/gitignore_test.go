@@ -0,0 +1,152 @@
+package main
+
+import (
+	"debugagent/config"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitignoreMatcher(t *testing.T) {
+	var rules []gitignoreRule
+	for _, line := range []string{
+		"# build output",
+		"",
+		"*.tmp",
+		"dist/",
+		"/config.local",
+		"docs/**/*.pdf",
+		"**/generated",
+		"secrets/**",
+		"*.env",
+		"!example.env",
+	} {
+		if rule, ok := parseGitignoreLine(line, ""); ok {
+			rules = append(rules, rule)
+		}
+	}
+	matcher := &gitignoreMatcher{rules: rules}
+
+	testCases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"cache.tmp", false, true},
+		{"src/cache.tmp", false, true},
+		{"dist", true, true},
+		{"src/dist", true, true},
+		{"dist", false, false}, // Trailing slash only matches directories
+		{"config.local", false, true},
+		{"src/config.local", false, false}, // Leading slash anchors to the root
+		{"docs/manual.pdf", false, true},
+		{"docs/a/b/manual.pdf", false, true},
+		{"manual.pdf", false, false},
+		{"generated", true, true},
+		{"api/v1/generated", true, true},
+		{"secrets/key.pem", false, true},
+		{"secrets", true, false},
+		{"prod.env", false, true},
+		{"example.env", false, false}, // Negated by a later rule
+		{"main.go", false, false},
+	}
+
+	for _, tc := range testCases {
+		if got := matcher.isIgnored(tc.path, tc.isDir); got != tc.ignored {
+			t.Errorf("isIgnored(%q, dir=%v): expected %v, got %v", tc.path, tc.isDir, tc.ignored, got)
+		}
+	}
+}
+
+func TestGetDirectoryStructure_RespectsGitignore(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		".gitignore":          "build/\n*.out\n",
+		"main.go":             "package main",
+		"app.out":             "binary",
+		"build/artifact.bin":  "binary",
+		"web/.gitignore":      "*.map\n!keep.map\n",
+		"web/app.js":          "console.log()",
+		"web/app.js.map":      "{}",
+		"web/keep.map":        "{}",
+		"other/bundle.js.map": "{}",
+	})
+
+	structure, err := getDirectoryStructure(config.AppConfig, projectPath, 3, 0)
+	if err != nil {
+		t.Fatalf("getDirectoryStructure(config.AppConfig, ) returned error: %v", err)
+	}
+
+	for _, name := range []string{"build/", "app.out"} {
+		if _, ok := structure[name]; ok {
+			t.Errorf("expected '%s' to be excluded by the root .gitignore", name)
+		}
+	}
+	if _, ok := structure["main.go"]; !ok {
+		t.Error("expected 'main.go' to be kept")
+	}
+
+	web := structure["web/"].(map[string]interface{})
+	if _, ok := web["app.js.map"]; ok {
+		t.Error("expected 'web/app.js.map' to be excluded by the nested .gitignore")
+	}
+	for _, name := range []string{"app.js", "keep.map"} {
+		if _, ok := web[name]; !ok {
+			t.Errorf("expected 'web/%s' to be kept", name)
+		}
+	}
+
+	// Nested rules only apply below their own directory
+	other := structure["other/"].(map[string]interface{})
+	if _, ok := other["bundle.js.map"]; !ok {
+		t.Error("expected 'other/bundle.js.map' to be kept")
+	}
+}
+
+func TestGetDirectoryStructure_RespectsDebugagentignore(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		".gitignore":          "*.out\n",
+		".debugagentignore":   "generated/\n*.csv\nsrc/fixtures/\n!app.out\n!debug.log\n",
+		"main.go":             "package main",
+		"app.out":             "kept despite .gitignore",
+		"debug.log":           "kept despite the ignored extensions",
+		"data.csv":            "a,b",
+		"generated/api.go":    "package generated",
+		"src/handler.go":      "package src",
+		"src/fixtures/big.go": "package fixtures",
+	})
+
+	structure, err := getDirectoryStructure(config.AppConfig, projectPath, 3, 0)
+	if err != nil {
+		t.Fatalf("getDirectoryStructure(config.AppConfig, ) returned error: %v", err)
+	}
+	for _, name := range []string{"generated/", "data.csv"} {
+		if _, ok := structure[name]; ok {
+			t.Errorf("expected '%s' to be excluded by .debugagentignore", name)
+		}
+	}
+	// .debugagentignore takes precedence over .gitignore and the configuration
+	for _, name := range []string{"main.go", "app.out", "debug.log"} {
+		if _, ok := structure[name]; !ok {
+			t.Errorf("expected '%s' to be kept", name)
+		}
+	}
+
+	// LIST_DIR applies the rules of the project root to subdirectories
+	note, err := listDirNote(config.AppConfig, projectPath, "src")
+	if err != nil {
+		t.Fatalf("listDirNote(config.AppConfig, ) returned error: %v", err)
+	}
+	if !strings.Contains(note, "handler.go") || strings.Contains(note, "fixtures") {
+		t.Errorf("expected src/fixtures to be excluded from the listing, got %s", note)
+	}
+
+	matches, err := searchProject(config.AppConfig, projectPath, "package", 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	for _, match := range matches {
+		if strings.HasPrefix(match.Path, "generated") || strings.HasPrefix(match.Path, filepath.Join("src", "fixtures")) {
+			t.Errorf("expected SEARCH to skip %s", match.Path)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// JobStatus is the state of an analysis started with /analyze-async.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"  // Waiting for an analysis slot
+	JobRunning JobStatus = "running" // Analysis in progress
+	JobDone    JobStatus = "done"    // Result available
+	JobFailed  JobStatus = "failed"  // Error set
+)
+
+// Delivery states of the result to the callback_url of a job.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// jobPollInterval is the Retry-After suggested to clients polling a job that
+// is not finished.
+const jobPollInterval = 5 * time.Second
+
+// webhookMaxAttempts is the number of deliveries of a job result to its
+// callback_url before giving up. The delay between two attempts starts at
+// webhookRetryDelay and doubles each time.
+const webhookMaxAttempts = 4
+
+var (
+	webhookRetryDelay = 2 * time.Second
+	webhookClient     = newWebhookClient()
+)
+
+// ErrCallbackNotAllowed is returned when a callback_url targets a loopback,
+// private or link-local address outside server.callback_allowed_hosts.
+var ErrCallbackNotAllowed = errors.New("callback address not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), private in
+// practice although net.IP.IsPrivate leaves it out.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is a public unicast address, one that a
+// callback may reach: not loopback, private, link-local (cloud metadata
+// endpoints), multicast nor unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// callbackHostAllowed reports whether host is listed in
+// server.callback_allowed_hosts, which may then be any address.
+func callbackHostAllowed(host string) bool {
+	return hostListed(config.Get().Server.CallbackAllowedHosts, host)
+}
+
+// hostListed reports whether host is one of hosts, ignoring case.
+func hostListed(hosts []string, host string) bool {
+	return slices.ContainsFunc(hosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
+}
+
+// newWebhookClient returns the client delivering the job results. The address
+// is checked when connecting, after the name resolution and on every
+// redirect, so that a host name resolving to a private address is refused
+// too. Proxies are not used, they would hide the address.
+func newWebhookClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		if host, _, err := net.SplitHostPort(addr); err != nil || !callbackHostAllowed(host) {
+			dialer.Control = func(network, address string, _ syscall.RawConn) error {
+				host, _, _ := net.SplitHostPort(address)
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("%w: %s", ErrCallbackNotAllowed, host)
+				}
+				return nil
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// AnalysisJob is the state of an asynchronous analysis, as returned by
+// GET /jobs/{id}.
+type AnalysisJob struct {
+	ID          string           `json:"id"`
+	Status      JobStatus        `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	ErrorCode   string           `json:"error_code,omitempty"` // Same codes as the API errors
+	Result      *AnalyzeResponse `json:"result,omitempty"`
+	CallbackURL string           `json:"callback_url,omitempty"`
+	Delivery    string           `json:"delivery,omitempty"` // State of the callback delivery: pending, delivered or failed
+	CreatedAt   time.Time        `json:"created_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+}
+
+// jobStore holds the asynchronous analyses by ID. Finished jobs are dropped
+// server.job_retention_minutes after they end.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*AnalysisJob
+}
+
+var jobs = &jobStore{jobs: make(map[string]*AnalysisJob)}
+
+// jobRetention returns how long a finished job stays queryable, 0 for as long
+// as the server runs.
+func jobRetention() time.Duration {
+	return time.Duration(config.Get().Server.JobRetentionMinutes) * time.Minute
+}
+
+// pruneLocked drops the jobs finished for longer than jobRetention. The caller
+// holds s.mu.
+func (s *jobStore) pruneLocked() {
+	retention := jobRetention()
+	if retention <= 0 {
+		return
+	}
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// create registers a queued job and drops the expired ones. It returns false,
+// without registering it, when server.max_queued_jobs jobs are already
+// waiting for a slot.
+func (s *jobStore) create(id, callbackURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	if limit := config.Get().Server.MaxQueuedJobs; limit > 0 {
+		queued := 0
+		for _, job := range s.jobs {
+			if job.Status == JobQueued {
+				queued++
+			}
+		}
+		if queued >= limit {
+			return false
+		}
+	}
+	job := &AnalysisJob{ID: id, Status: JobQueued, CallbackURL: callbackURL, CreatedAt: time.Now()}
+	if callbackURL != "" {
+		job.Delivery = deliveryPending
+	}
+	s.jobs[id] = job
+	return true
+}
+
+// update applies change to the job id under the store lock.
+func (s *jobStore) update(id string, change func(job *AnalysisJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// finish records the outcome of the job id: its result, or err.
+func (s *jobStore) finish(id string, result *AnalyzeResponse, err error) {
+	s.update(id, func(job *AnalysisJob) {
+		now := time.Now()
+		job.FinishedAt = &now
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+			_, job.ErrorCode = analysisError(err)
+			return
+		}
+		job.Status = JobDone
+		job.Result = result
+	})
+}
+
+// get returns a copy of the job id, unless it expired.
+func (s *jobStore) get(id string) (AnalysisJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	job, ok := s.jobs[id]
+	if !ok {
+		return AnalysisJob{}, false
+	}
+	return *job, true
+}
+
+// validateCallbackURL checks that callbackURL is an absolute http or https
+// URL, and that it does not name a loopback, private or link-local address
+// outside server.callback_allowed_hosts. The addresses a host name resolves
+// to are checked when the result is delivered, see newWebhookClient.
+func validateCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid 'callback_url' field, expected an http or https URL")
+	}
+	host := u.Hostname()
+	if callbackHostAllowed(host) {
+		return nil
+	}
+	lower := strings.ToLower(host)
+	if ip := net.ParseIP(host); (ip != nil && !isPublicIP(ip)) || lower == "localhost" || strings.HasSuffix(lower, ".localhost") {
+		return fmt.Errorf("invalid 'callback_url' field, %w: loopback, private and link-local addresses are refused (see server.callback_allowed_hosts)", ErrCallbackNotAllowed)
+	}
+	return nil
+}
+
+// analyzeAsyncHandler starts an analysis of the uploaded project (same form
+// fields as /analyze) in the background and answers 202 with the job ID right
+// away. The job is followed with GET /jobs/{id}, its result fetched with GET
+// /jobs/{id}/result, it is cancelled with POST /cancel/{id}, and its result
+// is POSTed to the optional callback_url.
+func analyzeAsyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if status, err := parseUploadForm(w, r); err != nil {
+		writeJSONError(w, status, uploadErrorCode(status), err.Error())
+		return
+	}
+
+	questions, ok := requireQuestions(w, r.FormValue("question"), r.MultipartForm.Value["questions"])
+	if !ok {
+		return
+	}
+	maxDepth, err := parseMaxDepth(r.FormValue("max_depth"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	systemPrompt, err := validateSystemPrompt(r.FormValue("system_prompt"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	language, err := validateLanguage(r.FormValue("language"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	verbose, err := parseBoolField("verbose", r.FormValue("verbose"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	callbackURL := r.FormValue("callback_url")
+	if callbackURL != "" {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	// Save the uploaded files or archive, or read the project_path in place
+	upload, uerr := receiveUpload(r)
+	if uerr != nil {
+		writeJSONError(w, uerr.status, uerr.code, uerr.Error())
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			upload.remove() // Otherwise removed by the job
+		}
+	}()
+
+	// The job outlives the request but keeps its ID for the logs
+	id := requestIDFromContext(r.Context())
+	if !jobs.create(id, callbackURL) {
+		writeServerBusy(w)
+		return
+	}
+	started = true
+	go runAnalysisJob(context.WithoutCancel(r.Context()), id, AnalyzeRequest{
+		ProjectPath:  upload.Dir,
+		Model:        r.FormValue("model"),
+		MaxDepth:     maxDepth,
+		SystemPrompt: systemPrompt,
+		Language:     language,
+		SingleFile:   upload.SingleFile,
+		Verbose:      verbose,
+		Cached:       !upload.temp,
+	}.withQuestions(questions), callbackURL, upload)
+
+	job, _ := jobs.get(id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// runAnalysisJob runs the analysis of the job id once a slot is free, records
+// its outcome, delivers it to callbackURL if set and removes the upload. The
+// number of uploaded files left out is reported in the result.
+func runAnalysisJob(ctx context.Context, id string, req AnalyzeRequest, callbackURL string, upload *projectUpload) {
+	defer upload.remove()
+	log := requestLogger(ctx)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer registerAnalysis(id, cancel)()
+
+	result, err := func() (*AnalyzeResponse, error) {
+		// Jobs wait in line as long as needed, nobody holds a connection open
+		release, err := analyses.acquire(ctx, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		jobs.update(id, func(job *AnalysisJob) { job.Status = JobRunning })
+
+		engine, err := NewAnalysisEngine(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing analysis engine: %w", err)
+		}
+		analysis, err := engine.RunAnalysis()
+		if err != nil {
+			return nil, fmt.Errorf("error during analysis: %w", err)
+		}
+		if cancelledByClient(ctx) {
+			return nil, errCancelledByClient
+		}
+		return &AnalyzeResponse{
+			Answer:       analysis.Answer,
+			Sources:      analysis.Sources,
+			Stats:        analysis.Stats,
+			Confidence:   analysis.Confidence,
+			Unresolved:   analysis.Unresolved,
+			Answers:      answersByQuestion(analysis.Answers),
+			SkippedFiles: upload.Skipped,
+
+			ExplorationTrace: analysis.Trace,
+		}, nil
+	}()
+	if cancelledByClient(ctx) {
+		err = errCancelledByClient
+	}
+	jobs.finish(id, result, err)
+	if err != nil {
+		log.Warnf("Analysis job %s failed: %v", id, err)
+	} else {
+		log.Infof("Analysis job %s done", id)
+	}
+
+	if callbackURL == "" {
+		return
+	}
+	delivery := deliveryDelivered
+	if err := deliverJobResult(context.WithoutCancel(ctx), callbackURL, id, result, err); err != nil {
+		log.Warnf("Could not deliver the result of job %s to %s: %v", id, redactedRepoURL(callbackURL), err)
+		delivery = deliveryFailed
+	}
+	jobs.update(id, func(job *AnalysisJob) { job.Delivery = delivery })
+}
+
+// deliverJobResult POSTs the AnalyzeResponse of the job id to callbackURL, or
+// the API error envelope if jobErr is set, with the X-Job-ID and X-Job-Status headers.
+// Network errors, 429 and 5xx answers are retried up to webhookMaxAttempts
+// times with an exponential delay; other answers end the delivery.
+func deliverJobResult(ctx context.Context, callbackURL, id string, result *AnalyzeResponse, jobErr error) error {
+	status := JobDone
+	var body []byte
+	if jobErr != nil {
+		status = JobFailed
+		_, code := analysisError(jobErr)
+		body, _ = json.Marshal(apiErrorResponse{Error: APIError{Code: code, Message: jobErr.Error()}})
+	} else {
+		body, _ = json.Marshal(result)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(webhookRetryDelay * time.Duration(1<<(attempt-2))):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Job-ID", id)
+		req.Header.Set("X-Job-Status", string(status))
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("callback answered %s", resp.Status)
+		default:
+			return fmt.Errorf("callback answered %s", resp.Status)
+		}
+	}
+	return fmt.Errorf("%w after %d attempts", lastErr, webhookMaxAttempts)
+}
+
+// jobHandler returns the state of the job whose ID /analyze-async answered,
+// with its result once done.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "No job with this ID")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// jobResultHandler returns the AnalyzeResponse of a finished job, for clients
+// that poll instead of receiving the callback. While the job is queued or
+// running it answers 202 with the job state and a Retry-After header; a
+// failed job gets a 500 with its error and error code.
+func jobResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "No job with this ID")
+		return
+	}
+
+	switch job.Status {
+	case JobDone:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.Result)
+	case JobFailed:
+		writeJSONError(w, http.StatusInternalServerError, job.ErrorCode, fmt.Sprintf("Analysis failed: %s", job.Error))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", fmt.Sprint(int(jobPollInterval.Seconds())))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	}
+}
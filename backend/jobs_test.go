@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newJobRequest builds an /analyze-async request for a main.go file with the
+// given callback_url.
+func newJobRequest(callbackURL string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	writer.WriteField("callback_url", callbackURL)
+	part, _ := writer.CreateFormFile("files", "main.go")
+	part.Write([]byte("package main"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze-async", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// waitForJob polls the job id until it is finished and its result delivered.
+func waitForJob(t *testing.T, id string) AnalysisJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := jobs.get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.FinishedAt != nil && job.Delivery != deliveryPending {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return AnalysisJob{}
+}
+
+func TestAnalyzeAsyncHandler_DeliversResult(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat" {
+			w.Write([]byte(`{"message":{"role":"assistant","content":"[]"},"done":true}`))
+			return
+		}
+		w.Write([]byte(`{"response":"See main.go","done":true}`))
+	})
+	config.AppConfig.Analysis.MaxExplorationIterations = 1
+	config.AppConfig.Analysis.MaxFileReadSize = 10000
+	config.AppConfig.Server.CallbackAllowedHosts = []string{"127.0.0.1"}
+
+	callbacks := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		callbacks <- r
+		bodies <- string(body)
+	}))
+	t.Cleanup(callback.Close)
+
+	req := newJobRequest(callback.URL + "/hook")
+	rr := httptest.NewRecorder()
+	requestIDMiddleware(analyzeAsyncHandler)(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	var accepted AnalysisJob
+	json.Unmarshal(rr.Body.Bytes(), &accepted)
+	if accepted.ID == "" || rr.Header().Get("Location") != "/jobs/"+accepted.ID {
+		t.Fatalf("expected the job ID and its location, got %+v and %q", accepted, rr.Header().Get("Location"))
+	}
+
+	job := waitForJob(t, accepted.ID)
+	if job.Status != JobDone || job.Result == nil || job.Result.Answer != "See main.go" || job.Delivery != deliveryDelivered {
+		t.Fatalf("expected a delivered result, got %+v", job)
+	}
+	delivered := <-callbacks
+	if delivered.URL.Path != "/hook" || delivered.Header.Get("X-Job-ID") != accepted.ID || delivered.Header.Get("X-Job-Status") != "done" {
+		t.Errorf("unexpected callback request %s %v", delivered.URL.Path, delivered.Header)
+	}
+	var resp AnalyzeResponse
+	if err := json.Unmarshal([]byte(<-bodies), &resp); err != nil || resp.Answer != "See main.go" {
+		t.Errorf("expected the AnalyzeResponse in the callback, got %+v (%v)", resp, err)
+	}
+
+	// GET /jobs/{id} returns the same state
+	rr = httptest.NewRecorder()
+	getReq := httptest.NewRequest(http.MethodGet, "/jobs/"+accepted.ID, nil)
+	getReq.SetPathValue("id", accepted.ID)
+	jobHandler(rr, getReq)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"done"`) {
+		t.Errorf("expected the finished job, got %d (%s)", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAnalyzeAsyncHandler_RejectsInvalidCallbackURL(t *testing.T) {
+	setupUploadTest(0, 0)
+	for _, callbackURL := range []string{
+		"file:///etc/passwd", "not a url", "/relative",
+		"http://169.254.169.254/latest/meta-data", "http://127.0.0.1:8080/hook", "http://localhost/hook",
+		"https://10.0.0.5/hook", "http://[::1]/hook", "http://100.64.0.1/hook",
+	} {
+		req := newJobRequest(callbackURL)
+		rr := httptest.NewRecorder()
+		analyzeAsyncHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for callback_url %q, got %d", callbackURL, rr.Code)
+		}
+	}
+}
+
+func TestJobHandler_UnknownJob(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil)
+	req.SetPathValue("id", "unknown")
+	rr := httptest.NewRecorder()
+	jobHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestValidateCallbackURL_AllowedHosts(t *testing.T) {
+	config.AppConfig = &config.Config{Server: config.ServerConfig{CallbackAllowedHosts: []string{"127.0.0.1", "Hooks.Internal"}}}
+	for _, callbackURL := range []string{"http://127.0.0.1:8080/hook", "http://hooks.internal/ci", "https://ci.example.com/hook"} {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", callbackURL, err)
+		}
+	}
+	if err := validateCallbackURL("http://192.168.1.10/hook"); !errors.Is(err, ErrCallbackNotAllowed) {
+		t.Errorf("expected a private address outside the allowed hosts to be refused, got %v", err)
+	}
+}
+
+func TestDeliverJobResult_RefusesPrivateAddresses(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	t.Cleanup(server.Close)
+
+	// The address is checked again when connecting, whatever validateCallbackURL saw
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = 2 * time.Second })
+	err := deliverJobResult(t.Context(), server.URL, "job", &AnalyzeResponse{}, nil)
+	if !errors.Is(err, ErrCallbackNotAllowed) || calls != 0 {
+		t.Errorf("expected the loopback callback to be refused when connecting, got %v after %d calls", err, calls)
+	}
+}
+
+func TestAnalyzeAsyncHandler_LimitsQueuedJobs(t *testing.T) {
+	setupUploadTest(0, 0)
+	config.AppConfig.Server.MaxQueuedJobs = 1
+	jobs.create("waiting-job", "")
+	t.Cleanup(func() { jobs.finish("waiting-job", &AnalyzeResponse{}, nil) })
+
+	rr := httptest.NewRecorder()
+	analyzeAsyncHandler(rr, newJobRequest(""))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), ErrCodeServerBusy) {
+		t.Errorf("expected 503 once server.max_queued_jobs jobs wait, got %d (%s)", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeliverJobResult_RetriesTransientFailures(t *testing.T) {
+	config.AppConfig = &config.Config{Server: config.ServerConfig{CallbackAllowedHosts: []string{"127.0.0.1"}}}
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = 2 * time.Second })
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	err := deliverJobResult(t.Context(), server.URL, "job", &AnalyzeResponse{Answer: "ok"}, nil)
+	if err != nil || calls != 3 {
+		t.Errorf("expected a delivery on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	// Client errors are not retried
+	calls = 0
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(rejecting.Close)
+	if err := deliverJobResult(t.Context(), rejecting.URL, "job", &AnalyzeResponse{}, nil); err == nil || calls != 1 {
+		t.Errorf("expected a single failed attempt, got %v after %d calls", err, calls)
+	}
+}
+
+func TestJobResultHandler(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	getResult := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/"+id+"/result", nil)
+		req.SetPathValue("id", id)
+		rr := httptest.NewRecorder()
+		jobResultHandler(rr, req)
+		return rr
+	}
+
+	jobs.create("result-running", "")
+	if rr := getResult("result-running"); rr.Code != http.StatusAccepted || rr.Header().Get("Retry-After") == "" || !strings.Contains(rr.Body.String(), `"status":"queued"`) {
+		t.Errorf("expected 202 with a Retry-After while queued, got %d %v (%s)", rr.Code, rr.Header(), rr.Body.String())
+	}
+
+	jobs.finish("result-running", &AnalyzeResponse{Answer: "See main.go"}, nil)
+	rr := getResult("result-running")
+	var resp AnalyzeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusOK || err != nil || resp.Answer != "See main.go" {
+		t.Errorf("expected the AnalyzeResponse once done, got %d (%s)", rr.Code, rr.Body.String())
+	}
+
+	jobs.create("result-failed", "")
+	jobs.finish("result-failed", nil, errors.New("model not found"))
+	if rr := getResult("result-failed"); rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "model not found") {
+		t.Errorf("expected 500 with the job error, got %d (%s)", rr.Code, rr.Body.String())
+	}
+
+	if rr := getResult("unknown"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown job, got %d", rr.Code)
+	}
+}
+
+func TestJobStore_DropsExpiredJobs(t *testing.T) {
+	config.AppConfig = &config.Config{Server: config.ServerConfig{JobRetentionMinutes: 60}}
+	jobs.create("expired", "")
+	jobs.create("recent", "")
+	jobs.finish("recent", &AnalyzeResponse{}, nil)
+	jobs.update("expired", func(job *AnalysisJob) {
+		finished := time.Now().Add(-2 * time.Hour)
+		job.Status, job.FinishedAt = JobDone, &finished
+	})
+
+	if _, ok := jobs.get("expired"); ok {
+		t.Error("expected the job finished 2 hours ago to be dropped")
+	}
+	if _, ok := jobs.get("recent"); !ok {
+		t.Error("expected the recently finished job to be kept")
+	}
+}
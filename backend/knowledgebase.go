@@ -1,29 +1,88 @@
 package main
 
 import (
+	"crypto/sha256"
+	"debugagent/config"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 // KnowledgeBase structure pour stocker les informations collectées pendant l'analyse.
 type KnowledgeBase struct {
-	ProjectPath        string
-	ProjectStructure   map[string]interface{}
-	ProjectType        string
-	ReadmeContent      string
-	FileContents       map[string]string
-	AnalysisNotes      []string
-	ExplorationPlan    []string
-	ExplorationHistory []string
-	FailedFileAttempts map[string]int    // Track failed file read attempts with retry count
-	AvailableFiles     []string          // Track files that exist and can be read
-	DependencyFiles    map[string]string // Map dependency types to found files
-	mu                 sync.Mutex        // Pour gérer l'accès concurrentiel
+	ProjectPath           string
+	ProjectStructure      map[string]interface{}
+	ProjectType           string
+	PrimaryLanguage       string  // Langage principal détecté par DetectProjectType
+	ProjectTypeConfidence float64 // Confiance de la détection par règles, entre 0 et 1
+	ReadmeContent         string
+	FileContents          map[string]string
+	AnalysisNotes         []string
+	AnalysisDetails       map[string]string // Texte complet des étapes ANALYZE par sujet, les notes n'en gardent qu'un résumé
+	ExplorationPlan       []string
+	ExplorationHistory    []string
+	FailedFileAttempts    map[string]int       // Track failed file read attempts with retry count
+	AvailableFiles        []string             // Track files that exist and can be read
+	DependencyFiles       map[string]string    // Map dependency types to found files
+	SubProjects           map[string]string    // Manifestes trouvés dans les sous-dossiers (chemin relatif -> type de dépendance)
+	Languages             map[string]int       // Nombre de fichiers par langage, voir DetectLanguages
+	PreviousQuestions     []questionAnswer     // Questions déjà traitées sur ce projet (sessions), les plus anciennes d'abord
+	DuplicateFiles        map[string]string    // Fichiers identiques à un fichier lu (chemin -> chemin du représentant), leur contenu n'est pas stocké
+	SingleFile            string               // Fichier analysé seul (chemin relatif), vide pour un projet, voir readSingleFile
+	EntryPoints           []string             // Points d'entrée probables (chemins relatifs), voir DetectEntryPoints
+	TestFiles             []string             // Fichiers de test du projet (chemins relatifs), voir DetectLanguages
+	Symbols               map[string][]Symbol  // Symboles exportés des fichiers Go et JS/TS (chemin relatif -> symboles), voir ExtractSymbols
+	ProjectOverview       string               // Vue d'ensemble du projet écrite par le modèle, en tête de chaque résumé
+	OverviewFacts         string               // Empreinte des faits dont ProjectOverview a été tirée, voir overviewFacts
+	mu                    sync.Mutex           // Pour gérer l'accès concurrentiel
+	fileAccess            map[string]uint64    // Dernier accès de chaque fichier, pour l'éviction LRU
+	accessClock           uint64               // Horloge logique des accès aux fichiers
+	retainedBytes         int                  // Taille totale des contenus conservés
+	fileStamps            map[string]fileStamp // Date de modification et taille des fichiers lus sur disque
+	staleFiles            map[string]bool      // Fichiers de la sauvegarde modifiés depuis leur lecture, non restaurés
+	contentHashes         map[string]string    // SHA-256 de chaque contenu de FileContents -> chemin du fichier
+	contextFiles          map[string]bool      // Fichiers inclus dans au moins un contexte envoyé au modèle
+	searchIndex           *searchIndex         // Index des mots du projet, nil tant qu'il n'est pas construit
+	log                   *logrus.Entry        // Logger de l'analyse, porte le request_id
+	cfg                   *config.Config       // Configuration de l'analyse, conservée si elle est rechargée entre-temps
+}
+
+// questionAnswer est une question posée sur le projet et la réponse donnée,
+// conservées pour les questions de suivi d'une session.
+type questionAnswer struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// maxPreviousQuestions limite les questions précédentes conservées, et
+// previousAnswerTokens la part de chaque réponse montrée au modèle.
+const (
+	maxPreviousQuestions = 5
+	previousAnswerTokens = 100
+)
+
+// Source est un fichier ayant servi à construire la réponse finale.
+type Source struct {
+	Path  string `json:"path"`
+	Cited bool   `json:"cited"` // Le chemin apparaît dans la réponse
+}
+
+// fileStamp identifie la version d'un fichier lu, pour détecter s'il a changé depuis.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
 }
 
 // NewKnowledgeBase crée une nouvelle instance de KnowledgeBase.
@@ -33,6 +92,10 @@ func NewKnowledgeBase(projectPath string) *KnowledgeBase {
 		logrus.Warnf("Could not resolve absolute path for %s: %v", projectPath, err)
 		absPath = projectPath
 	}
+	// Un fichier donné comme projet : le projet est son répertoire
+	if info, err := os.Stat(absPath); err == nil && !info.IsDir() {
+		absPath = filepath.Dir(absPath)
+	}
 
 	return &KnowledgeBase{
 		ProjectPath:        absPath,
@@ -40,11 +103,21 @@ func NewKnowledgeBase(projectPath string) *KnowledgeBase {
 		ProjectType:        "Inconnu",
 		FileContents:       make(map[string]string),
 		AnalysisNotes:      []string{},
+		AnalysisDetails:    make(map[string]string),
 		ExplorationPlan:    []string{},
 		ExplorationHistory: []string{},
 		FailedFileAttempts: make(map[string]int),
 		AvailableFiles:     []string{},
 		DependencyFiles:    make(map[string]string),
+		SubProjects:        make(map[string]string),
+		Languages:          make(map[string]int),
+		DuplicateFiles:     make(map[string]string),
+		fileAccess:         make(map[string]uint64),
+		fileStamps:         make(map[string]fileStamp),
+		contentHashes:      make(map[string]string),
+		contextFiles:       make(map[string]bool),
+		log:                logrus.NewEntry(logrus.StandardLogger()),
+		cfg:                config.Get(),
 	}
 }
 
@@ -53,32 +126,340 @@ func (kb *KnowledgeBase) getRelativePath(absFilepath string) (string, error) {
 	return filepath.Rel(kb.ProjectPath, absFilepath)
 }
 
-
-// AddFileContent ajoute le contenu d'un fichier à la base de connaissances.
+// AddFileContent ajoute le contenu d'un fichier à la base de connaissances,
+// après avoir masqué les secrets qu'il contient (voir redactSecrets). Un
+// fichier identique à un fichier déjà conservé (fichiers générés par exemple)
+// n'est pas stocké une seconde fois : il est enregistré dans DuplicateFiles et
+// une note renvoie vers le représentant. Les petits fichiers, moins coûteux
+// que la note, sont toujours stockés.
 func (kb *KnowledgeBase) AddFileContent(absFilepath string, content string) {
 	kb.mu.Lock()
 	defer kb.mu.Unlock()
 
 	relPath, err := kb.getRelativePath(absFilepath)
 	if err != nil {
-		logrus.Warnf("Could not get relative path for %s: %v. Using absolute path.", absFilepath, err)
+		kb.log.Warnf("Could not get relative path for %s: %v. Using absolute path.", absFilepath, err)
 		relPath = absFilepath
 	}
+	content, redacted := redactSecrets(kb.cfg, content)
+	if redacted > 0 {
+		kb.log.Infof("Redacted %d secret(s) in '%s'", redacted, relPath)
+	}
 
+	hash := contentHash(content)
+	if original, ok := kb.contentHashes[hash]; ok && original != relPath && len(content) >= minDuplicateBytes {
+		kb.removeFileContent(relPath)
+		kb.DuplicateFiles[relPath] = original
+		kb.accessClock++
+		kb.fileAccess[original] = kb.accessClock
+		if note := duplicateFileNote(relPath, original); !slices.Contains(kb.AnalysisNotes, note) {
+			kb.AnalysisNotes = append(kb.AnalysisNotes, note)
+		}
+		kb.log.Infof("'%s' is identical to '%s', content not stored again", relPath, original)
+		return
+	}
+
+	if previous, ok := kb.FileContents[relPath]; ok && kb.contentHashes[contentHash(previous)] == relPath {
+		delete(kb.contentHashes, contentHash(previous))
+	}
+	delete(kb.DuplicateFiles, relPath)
+	kb.contentHashes[hash] = relPath
+	kb.retainedBytes += len(content) - len(kb.FileContents[relPath])
 	kb.FileContents[relPath] = content
-	logrus.Infof("Content added/updated for '%s'", relPath)
+	kb.accessClock++
+	kb.fileAccess[relPath] = kb.accessClock
+	delete(kb.fileStamps, relPath) // Contenu d'origine inconnue, voir RecordFileStamp
+	kb.log.Infof("Content added/updated for '%s'", relPath)
+
+	kb.enforceRetentionLimits(relPath)
+}
+
+// minDuplicateBytes est la taille à partir de laquelle un fichier identique à
+// un autre n'est pas stocké une seconde fois.
+const minDuplicateBytes = 256
+
+// contentHash retourne le SHA-256 de content, en hexadécimal.
+func contentHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// duplicateFileNote est la note enregistrée à la place du contenu d'un
+// fichier identique à original.
+func duplicateFileNote(relPath, original string) string {
+	return fmt.Sprintf("'%s' is identical to '%s' (same content), see that file.", relPath, original)
+}
+
+// removeFileContent retire le contenu de relPath de la base. Les doublons
+// qui le prenaient pour représentant sont oubliés, ils devront être relus.
+// Doit être appelée avec kb.mu verrouillé.
+func (kb *KnowledgeBase) removeFileContent(relPath string) bool {
+	delete(kb.DuplicateFiles, relPath)
+	delete(kb.fileStamps, relPath)
+	content, ok := kb.FileContents[relPath]
+	if !ok {
+		return false
+	}
+	kb.retainedBytes -= len(content)
+	delete(kb.FileContents, relPath)
+	delete(kb.fileAccess, relPath)
+	delete(kb.fileStamps, relPath)
+	if hash := contentHash(content); kb.contentHashes[hash] == relPath {
+		delete(kb.contentHashes, hash)
+	}
+	for duplicate, original := range kb.DuplicateFiles {
+		if original == relPath {
+			delete(kb.DuplicateFiles, duplicate)
+			delete(kb.fileStamps, duplicate)
+		}
+	}
+	return true
+}
+
+// duplicatesOf retourne, triés, les fichiers identiques à relPath. Doit être
+// appelée avec kb.mu verrouillé.
+func (kb *KnowledgeBase) duplicatesOf(relPath string) []string {
+	var duplicates []string
+	for duplicate, original := range kb.DuplicateFiles {
+		if original == relPath {
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+	sort.Strings(duplicates)
+	return duplicates
+}
+
+// maxListedDuplicates borne les doublons nommés après un fichier dans le
+// résumé du contexte.
+const maxListedDuplicates = 3
+
+// duplicatesLabel présente les doublons d'un fichier après son chemin, par
+// exemple " (+2 fichiers identiques: b.go, c.go)", ou "" s'il n'en a pas.
+func duplicatesLabel(duplicates []string) string {
+	if len(duplicates) == 0 {
+		return ""
+	}
+	names := strings.Join(duplicates[:min(len(duplicates), maxListedDuplicates)], ", ")
+	if len(duplicates) > maxListedDuplicates {
+		names += ", ..."
+	}
+	if len(duplicates) == 1 {
+		return fmt.Sprintf(" (+1 fichier identique: %s)", names)
+	}
+	return fmt.Sprintf(" (+%d fichiers identiques: %s)", len(duplicates), names)
+}
+
+// RecordFileStamp mémorise la date de modification et la taille du fichier
+// dont le contenu vient d'être ajouté avec AddFileContent, qu'il ait été
+// stocké ou enregistré comme doublon.
+func (kb *KnowledgeBase) RecordFileStamp(absFilepath string, info fs.FileInfo) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	relPath, err := kb.getRelativePath(absFilepath)
+	if err != nil {
+		relPath = absFilepath
+	}
+	_, stored := kb.FileContents[relPath]
+	if _, duplicate := kb.DuplicateFiles[relPath]; stored || duplicate {
+		kb.fileStamps[relPath] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+}
+
+// IsFileUnchanged indique si le fichier est déjà dans la base et n'a pas changé
+// sur disque depuis sa lecture. Dans ce cas il compte comme un nouvel accès
+// pour l'éviction LRU.
+func (kb *KnowledgeBase) IsFileUnchanged(absFilepath string, info fs.FileInfo) bool {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	relPath, err := kb.getRelativePath(absFilepath)
+	if err != nil {
+		relPath = absFilepath
+	}
+	stamp, ok := kb.fileStamps[relPath]
+	if !ok || !stamp.modTime.Equal(info.ModTime()) || stamp.size != info.Size() {
+		return false
+	}
+	if original, ok := kb.DuplicateFiles[relPath]; ok {
+		relPath = original // Un doublon n'a pas de contenu, c'est son représentant qui sert
+	}
+	kb.accessClock++
+	kb.fileAccess[relPath] = kb.accessClock
+	return true
+}
+
+// InvalidateFiles retire de la base les fichiers de relPaths, modifiés ou
+// supprimés depuis leur lecture, leurs extraits "chemin:début-fin" (voir
+// readFileRange) ainsi que les notes qui les mentionnent. Retourne les
+// fichiers dont un contenu a effectivement été retiré, y compris à la
+// restauration (voir LoadFromFile).
+func (kb *KnowledgeBase) InvalidateFiles(relPaths []string) []string {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	var invalidated []string
+	for _, relPath := range relPaths {
+		relPath = filepath.Clean(filepath.FromSlash(relPath))
+		removed := kb.removeFileContent(relPath)
+		for key := range kb.FileContents {
+			if strings.HasPrefix(key, relPath+":") {
+				removed = kb.removeFileContent(key) || removed
+			}
+		}
+		if removed || kb.staleFiles[relPath] {
+			invalidated = append(invalidated, relPath)
+		}
+		kb.forgetFindings(relPath)
+	}
+	return invalidated
 }
 
-// AddNote ajoute une note d'analyse.
+// forgetFindings retire les notes et les analyses qui mentionnent relPath.
+// Doit être appelée avec kb.mu verrouillé.
+func (kb *KnowledgeBase) forgetFindings(relPath string) {
+	notes := kb.AnalysisNotes[:0]
+	for _, note := range kb.AnalysisNotes {
+		if !strings.Contains(note, relPath) {
+			notes = append(notes, note)
+		}
+	}
+	kb.AnalysisNotes = notes
+	for subject, details := range kb.AnalysisDetails {
+		if strings.Contains(subject, relPath) || strings.Contains(details, relPath) {
+			delete(kb.AnalysisDetails, subject)
+		}
+	}
+}
+
+// enforceRetentionLimits évince les fichiers les moins récemment utilisés tant
+// que analysis.max_retained_files ou analysis.max_retained_bytes est dépassé.
+// Le fichier qui vient d'être ajouté et ceux référencés par le dernier plan ne
+// sont jamais évincés. Doit être appelée avec kb.mu verrouillé.
+func (kb *KnowledgeBase) enforceRetentionLimits(justAdded string) {
+	cfg := kb.cfg
+	if cfg == nil {
+		return
+	}
+	maxFiles := cfg.Analysis.MaxRetainedFiles
+	maxBytes := cfg.Analysis.MaxRetainedBytes
+
+	exceeded := func() bool {
+		return (maxFiles > 0 && len(kb.FileContents) > maxFiles) || (maxBytes > 0 && kb.retainedBytes > maxBytes)
+	}
+	if !exceeded() {
+		return
+	}
+
+	protected := map[string]bool{justAdded: true}
+	for _, step := range kb.ExplorationPlan {
+		if path, found := strings.CutPrefix(step, "READ_FILE "); found {
+			protected[filepath.Clean(strings.Trim(path, "\"'`"))] = true
+		}
+	}
+
+	for exceeded() {
+		victim := ""
+		for path := range kb.FileContents {
+			if protected[path] {
+				continue
+			}
+			if victim == "" || kb.fileAccess[path] < kb.fileAccess[victim] {
+				victim = path
+			}
+		}
+		if victim == "" {
+			kb.log.Warnf("Retention limits exceeded (%d files, %d bytes) but all remaining files are in use.", len(kb.FileContents), kb.retainedBytes)
+			return
+		}
+
+		kb.removeFileContent(victim)
+		kb.log.Infof("Evicted '%s' from knowledge base (limits: %d files, %d bytes)", victim, maxFiles, maxBytes)
+	}
+}
+
+// AddNote ajoute une note d'analyse, sans les espaces qui l'entourent. Les
+// notes vides (réponse vide du modèle par exemple) sont ignorées pour ne pas
+// ajouter de puces vides au contexte.
 func (kb *KnowledgeBase) AddNote(note string) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return
+	}
 	kb.mu.Lock()
 	defer kb.mu.Unlock()
 
 	// Éviter les notes dupliquées consécutives
 	if len(kb.AnalysisNotes) == 0 || kb.AnalysisNotes[len(kb.AnalysisNotes)-1] != note {
 		kb.AnalysisNotes = append(kb.AnalysisNotes, note)
-		logrus.Debugf("Note added: %s...", note[:min(100, len(note))])
+		kb.log.Debugf("Note added: %s...", note[:min(100, len(note))])
+	}
+}
+
+// NoteCount retourne le nombre de notes, à passer ensuite à NotesSince.
+func (kb *KnowledgeBase) NoteCount() int {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	return len(kb.AnalysisNotes)
+}
+
+// NotesSince retourne les notes ajoutées depuis que kb en comptait count,
+// par exemple par une étape du plan.
+func (kb *KnowledgeBase) NotesSince(count int) []string {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if count >= len(kb.AnalysisNotes) {
+		return nil
+	}
+	return append([]string(nil), kb.AnalysisNotes[count:]...)
+}
+
+// analysisNoteChars borne le résumé d'une analyse gardé dans les notes.
+const analysisNoteChars = 300
+
+// AddAnalysis enregistre le résultat d'une étape ANALYZE sur subject : le
+// texte complet, borné par analysis.max_analysis_chars, dans AnalysisDetails
+// pour la synthèse finale, et son résumé dans les notes qui alimentent le
+// contexte des itérations. Les résultats vides sont ignorés.
+func (kb *KnowledgeBase) AddAnalysis(subject, result string) {
+	result = strings.TrimSpace(result)
+	if result == "" {
+		return
+	}
+	if maxChars := kb.cfg.Analysis.MaxAnalysisChars; maxChars > 0 {
+		if truncated := truncateToTokens(result, maxChars/charsPerToken); truncated != result {
+			result = truncated + "..."
+		}
+	}
+
+	kb.mu.Lock()
+	kb.AnalysisDetails[subject] = result
+	kb.mu.Unlock()
+
+	if summary := summarizeAnalysis(result, analysisNoteChars); summary != result {
+		kb.AddNote(fmt.Sprintf("Analysis of '%s' (summary, the full analysis is kept for the final answer): %s", subject, summary))
+	} else {
+		kb.AddNote(fmt.Sprintf("Analysis of '%s': %s", subject, result))
+	}
+}
+
+// summarizeAnalysis réduit text à une ligne d'au plus maxChars caractères,
+// coupée après la dernière phrase complète quand c'est possible. Les analyses
+// commençant par leur conclusion, c'est elle qui est gardée.
+func summarizeAnalysis(text string, maxChars int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	cut := string(runes[:maxChars])
+	if end := strings.LastIndexAny(cut, ".!?"); end >= len(cut)/3 {
+		return cut[:end+1]
+	}
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
 	}
+	return cut + "..."
 }
 
 // AddHistory ajoute une action à l'historique.
@@ -89,8 +470,36 @@ func (kb *KnowledgeBase) AddHistory(actionDescription string) {
 	// Éviter les entrées d'historique dupliquées consécutives
 	if len(kb.ExplorationHistory) == 0 || kb.ExplorationHistory[len(kb.ExplorationHistory)-1] != actionDescription {
 		kb.ExplorationHistory = append(kb.ExplorationHistory, actionDescription)
-		logrus.Debugf("History added: %s", actionDescription)
+		kb.log.Debugf("History added: %s", actionDescription)
+	}
+}
+
+// SetProjectStructure remplace la structure du projet. Elle ne garde que des
+// chaînes et des sous-structures, toujours sérialisables en JSON : une autre
+// valeur est remplacée par son rendu texte.
+func (kb *KnowledgeBase) SetProjectStructure(structure map[string]interface{}) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	kb.ProjectStructure = marshalableStructure(structure)
+}
+
+// marshalableStructure copie structure en n'y laissant que des chaînes et des
+// map[string]interface{}, voir SetProjectStructure.
+func marshalableStructure(structure map[string]interface{}) map[string]interface{} {
+	clean := make(map[string]interface{}, len(structure))
+	for name, value := range structure {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			clean[name] = marshalableStructure(v)
+		case string:
+			clean[name] = v
+		case nil:
+			clean[name] = ""
+		default:
+			clean[name] = fmt.Sprint(v)
+		}
 	}
+	return clean
 }
 
 // SetProjectType met à jour le type de projet.
@@ -100,7 +509,30 @@ func (kb *KnowledgeBase) SetProjectType(pType string) {
 
 	if pType != "" && kb.ProjectType != pType {
 		kb.ProjectType = pType
-		logrus.Infof("Project type updated: %s", pType)
+		kb.log.Infof("Project type updated: %s", pType)
+	}
+}
+
+// SetProjectOverview enregistre la vue d'ensemble du projet et l'empreinte
+// des faits dont elle a été tirée.
+func (kb *KnowledgeBase) SetProjectOverview(overview, facts string) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	kb.ProjectOverview = overview
+	kb.OverviewFacts = facts
+}
+
+// AddPreviousQuestion enregistre une question traitée et sa réponse, pour le
+// contexte des questions de suivi. Seules les maxPreviousQuestions dernières
+// sont gardées.
+func (kb *KnowledgeBase) AddPreviousQuestion(question, answer string) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	kb.PreviousQuestions = append(kb.PreviousQuestions, questionAnswer{Question: question, Answer: answer})
+	if extra := len(kb.PreviousQuestions) - maxPreviousQuestions; extra > 0 {
+		kb.PreviousQuestions = kb.PreviousQuestions[extra:]
 	}
 }
 
@@ -110,7 +542,7 @@ func (kb *KnowledgeBase) AddFailedFileAttempt(filePath string) {
 	defer kb.mu.Unlock()
 
 	kb.FailedFileAttempts[filePath]++
-	logrus.Debugf("Failed file attempt recorded for '%s' (attempt #%d)", filePath, kb.FailedFileAttempts[filePath])
+	kb.log.Debugf("Failed file attempt recorded for '%s' (attempt #%d)", filePath, kb.FailedFileAttempts[filePath])
 }
 
 // IsFileAttemptExceeded checks if a file has been attempted too many times.
@@ -133,7 +565,7 @@ func (kb *KnowledgeBase) AddAvailableFile(filePath string) {
 		}
 	}
 	kb.AvailableFiles = append(kb.AvailableFiles, filePath)
-	logrus.Debugf("Available file recorded: '%s'", filePath)
+	kb.log.Debugf("Available file recorded: '%s'", filePath)
 }
 
 // AddDependencyFile maps a dependency type to a found file.
@@ -142,43 +574,410 @@ func (kb *KnowledgeBase) AddDependencyFile(depType, filePath string) {
 	defer kb.mu.Unlock()
 
 	kb.DependencyFiles[depType] = filePath
-	logrus.Infof("Dependency file found: %s -> %s", depType, filePath)
+	kb.log.Infof("Dependency file found: %s -> %s", depType, filePath)
+}
+
+// AddSubProjectManifest enregistre le manifeste d'un sous-projet, relPath
+// étant relatif à la racine du projet.
+func (kb *KnowledgeBase) AddSubProjectManifest(relPath, depType string) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	kb.SubProjects[relPath] = depType
+	kb.log.Infof("Sub-project manifest found: %s -> %s", depType, relPath)
+}
+
+// subProjectsSummary regroupe les manifestes des sous-projets par dossier,
+// une ligne par dossier trié, au plus maxSubProjects lignes. Doit être appelée
+// avec kb.mu verrouillé.
+func (kb *KnowledgeBase) subProjectsSummary() []string {
+	manifests := make(map[string][]string)
+	for relPath, depType := range kb.SubProjects {
+		dir := path.Dir(relPath)
+		manifests[dir] = append(manifests[dir], fmt.Sprintf("%s: %s", depType, path.Base(relPath)))
+	}
+	dirs := make([]string, 0, len(manifests))
+	for dir := range manifests {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	lines := make([]string, 0, len(dirs))
+	for i, dir := range dirs {
+		if i == maxSubProjects {
+			lines = append(lines, fmt.Sprintf("... et %d autres sous-projets", len(dirs)-i))
+			break
+		}
+		sort.Strings(manifests[dir])
+		lines = append(lines, fmt.Sprintf("%s/ (%s)", dir, strings.Join(manifests[dir], ", ")))
+	}
+	return lines
+}
+
+// maxSubProjects limite le nombre de sous-projets listés dans le contexte.
+const maxSubProjects = 20
+
+// DetectLanguages parcourt le projet et compte les fichiers de chaque langage
+// d'après leur extension (ou leur nom pour Dockerfile, Makefile...), sans
+// passer par le modèle. Les entrées ignorées par l'explorateur sont exclues.
+// Avec analysis.detect_test_files, les fichiers de test sont relevés au
+// passage dans TestFiles.
+func (kb *KnowledgeBase) DetectLanguages() error {
+	counts := make(map[string]int)
+	detectTests := kb.cfg.Analysis.DetectTestFiles
+	var testFiles []string
+	err := filepath.WalkDir(kb.ProjectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == kb.ProjectPath {
+				return err
+			}
+			return nil // Entrée illisible, on continue
+		}
+		if path == kb.ProjectPath {
+			return nil
+		}
+		if isIgnoredEntry(kb.cfg, d.Name(), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && d.Type()&fs.ModeSymlink == 0 {
+			if lang := languageForFile(d.Name()); lang != "" {
+				counts[lang]++
+			}
+			if detectTests && isTestFile(d.Name()) {
+				if relPath, err := kb.getRelativePath(path); err == nil && !isForbiddenFile(kb.cfg, filepath.ToSlash(relPath)) {
+					testFiles = append(testFiles, filepath.ToSlash(relPath))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("impossible de détecter les langages: %w", err)
+	}
+
+	kb.mu.Lock()
+	kb.Languages = counts
+	kb.TestFiles = testFiles
+	kb.mu.Unlock()
+	kb.log.Debugf("Languages detected: %v", counts)
+	return nil
+}
+
+// languagesByFrequency retourne les langages détectés, du plus fréquent au
+// moins fréquent.
+func (kb *KnowledgeBase) languagesByFrequency() []string {
+	langs := make([]string, 0, len(kb.Languages))
+	for lang := range kb.Languages {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if kb.Languages[langs[i]] != kb.Languages[langs[j]] {
+			return kb.Languages[langs[i]] > kb.Languages[langs[j]]
+		}
+		return langs[i] < langs[j]
+	})
+	return langs
+}
+
+// languageBreakdown formate la répartition des langages, du plus fréquent au moins fréquent.
+func (kb *KnowledgeBase) languageBreakdown() string {
+	langs := kb.languagesByFrequency()
+	parts := make([]string, len(langs))
+	for i, lang := range langs {
+		parts[i] = fmt.Sprintf("%s: %d fichiers", lang, kb.Languages[lang])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// maxListedTestFiles limite les fichiers de test listés dans le résumé.
+const maxListedTestFiles = 10
+
+// testFilesByRelevance retourne les fichiers de test, ceux dont le chemin
+// contient un mot-clé de la question en premier, puis par ordre alphabétique.
+func (kb *KnowledgeBase) testFilesByRelevance(userProblem string) []string {
+	keywords := questionKeywords(userProblem)
+	scores := make(map[string]int, len(kb.TestFiles))
+	files := append([]string(nil), kb.TestFiles...)
+	for _, path := range files {
+		scores[path] = relevanceScore(path, "", keywords)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if scores[files[i]] != scores[files[j]] {
+			return scores[files[i]] > scores[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+// maxEntryPoints limite les points d'entrée retenus par DetectEntryPoints.
+const maxEntryPoints = 5
+
+// DetectEntryPoints cherche les points d'entrée probables du projet :
+// patterns associe à chaque langage (en minuscules, voir
+// analysis.entry_points) des motifs glob relatifs à la racine. Les langages
+// détectés par DetectLanguages sont parcourus du plus fréquent au moins
+// fréquent, et au plus maxEntryPoints fichiers sont retenus dans EntryPoints.
+func (kb *KnowledgeBase) DetectEntryPoints(patterns map[string][]string) []string {
+	var found []string
+	seen := make(map[string]bool)
+	for _, lang := range kb.languagesByFrequency() {
+		for _, pattern := range patterns[strings.ToLower(lang)] {
+			matches, err := filepath.Glob(filepath.Join(kb.ProjectPath, filepath.FromSlash(pattern)))
+			if err != nil {
+				kb.AddNote(fmt.Sprintf("Invalid entry point pattern '%s': %v", pattern, err))
+				continue
+			}
+			for _, fullPath := range matches {
+				relPath, err := kb.getRelativePath(fullPath)
+				if err != nil {
+					continue
+				}
+				relPath = filepath.ToSlash(relPath)
+				if seen[relPath] || len(found) >= maxEntryPoints {
+					continue
+				}
+				seen[relPath] = true
+				info, err := os.Stat(fullPath)
+				if err != nil || info.IsDir() || isIgnoredEntry(kb.cfg, info.Name(), false) || isForbiddenFile(kb.cfg, relPath) {
+					continue
+				}
+				found = append(found, relPath)
+			}
+		}
+	}
+
+	kb.mu.Lock()
+	kb.EntryPoints = found
+	kb.mu.Unlock()
+	return found
+}
+
+// withoutForbiddenFiles renvoie une copie de structure (dont relDir est le
+// chemin relatif à la racine) sans les fichiers de analysis.forbidden_files.
+func withoutForbiddenFiles(cfg *config.Config, structure map[string]interface{}, relDir string) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(structure))
+	for name, value := range structure {
+		if sub, ok := value.(map[string]interface{}); ok {
+			filtered[name] = withoutForbiddenFiles(cfg, sub, path.Join(relDir, strings.TrimSuffix(name, "/")))
+		} else if strings.HasSuffix(name, "/") || !isForbiddenFile(cfg, path.Join(relDir, name)) {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
+
+// unmarshalableKey retourne le chemin de la première clé de structure (par
+// ordre alphabétique) dont la valeur n'est pas sérialisable en JSON, ou "".
+func unmarshalableKey(structure map[string]interface{}, prefix string) string {
+	names := make([]string, 0, len(structure))
+	for name := range structure {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sub, ok := structure[name].(map[string]interface{}); ok {
+			if key := unmarshalableKey(sub, prefix+name); key != "" {
+				return key
+			}
+		} else if _, err := json.Marshal(structure[name]); err != nil {
+			return prefix + name
+		}
+	}
+	return ""
+}
+
+// topLevelEntries rend en texte les entrées de premier niveau de structure,
+// une par ligne et triées, avec leur taille quand elle est connue.
+func topLevelEntries(structure map[string]interface{}) string {
+	names := make([]string, 0, len(structure))
+	for name := range structure {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			entries.WriteString("\n")
+		}
+		entries.WriteString("- " + name)
+		if size, ok := structure[name].(string); ok && size != "" {
+			entries.WriteString(" (" + size + ")")
+		}
+	}
+	return entries.String()
+}
+
+// Parts du budget de tokens du résumé accordées à ses sections de taille variable.
+const (
+	structureBudgetShare = 30 // En pourcentage
+	excerptsBudgetShare  = 30
+	notesBudgetShare     = 20
+)
+
+// contextUpdateExcerptChars borne l'extrait de chaque fichier dans getContextUpdate.
+const contextUpdateExcerptChars = 300
+
+// detailsBudgetShare est la part du budget du contexte final réservée aux
+// analyses détaillées, quand il y en a.
+const detailsBudgetShare = 30
+
+// fullContentsBudgetShare est la part du budget du résumé que le contenu
+// complet des fichiers lus peut occuper dans le contexte final, voir
+// fitsFullContents.
+const fullContentsBudgetShare = 60
+
+// fitsFullContents indique si le contenu complet des fichiers lus peut
+// remplacer leurs extraits dans un résumé de maxTokens : leur taille totale
+// ne doit dépasser ni analysis.full_contents_max_bytes (0 désactive ce mode)
+// ni fullContentsBudgetShare du budget.
+func (kb *KnowledgeBase) fitsFullContents(maxTokens int) bool {
+	maxBytes := kb.cfg.Analysis.FullContentsMaxBytes
+	if maxBytes <= 0 {
+		return false
+	}
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	total, tokens := 0, 0
+	for path, content := range kb.FileContents {
+		if !isForbiddenFile(kb.cfg, path) {
+			total += len(content)
+			tokens += estimateTokens(content)
+		}
+	}
+	return total > 0 && total <= maxBytes && tokens <= maxTokens*fullContentsBudgetShare/100
+}
+
+// getFinalContext construit le contexte de la synthèse finale : le résumé de
+// getContextSummary suivi du texte complet des analyses (AnalysisDetails),
+// que le contexte des itérations ne montre que résumées. L'ensemble tient
+// dans maxTokens (estimés).
+func (kb *KnowledgeBase) getFinalContext(userProblem string, maxTokens int) string {
+	kb.mu.Lock()
+	subjects := make([]string, 0, len(kb.AnalysisDetails))
+	details := make(map[string]string, len(kb.AnalysisDetails))
+	for subject, text := range kb.AnalysisDetails {
+		subjects = append(subjects, subject)
+		details[subject] = text
+	}
+	kb.mu.Unlock()
+	if len(subjects) == 0 {
+		return kb.finalSummary(userProblem, maxTokens)
+	}
+	sort.Strings(subjects)
+
+	detailsTokens := maxTokens * detailsBudgetShare / 100
+	var context strings.Builder
+	context.WriteString(kb.finalSummary(userProblem, maxTokens-detailsTokens))
+	context.WriteString("\nAnalyses Détaillées:\n")
+	for _, subject := range subjects {
+		text := details[subject]
+		if truncated := truncateToTokens(text, detailsTokens/len(subjects)); truncated != text {
+			text = truncated + "..."
+		}
+		context.WriteString(fmt.Sprintf("### %s\n%s\n", subject, text))
+	}
+	return context.String()
+}
+
+// finalSummary est le résumé du contexte final : les fichiers lus y figurent
+// en entier plutôt qu'en extraits quand ils tiennent (voir fitsFullContents).
+func (kb *KnowledgeBase) finalSummary(userProblem string, maxTokens int) string {
+	return kb.contextSummary(userProblem, maxTokens, kb.fitsFullContents(maxTokens))
 }
 
+// getContextSummary résume la base de connaissances pour le modèle en tenant
+// dans maxTokens (estimés) : la structure, les extraits de fichiers et les
+// notes sont tronqués selon leur part du budget.
+func (kb *KnowledgeBase) getContextSummary(userProblem string, maxTokens int) string {
+	return kb.contextSummary(userProblem, maxTokens, false)
+}
 
-func (kb *KnowledgeBase) getContextSummary(userProblem string, maxPromptLength int) string {
+// contextSummary construit le résumé de getContextSummary, avec le contenu
+// complet des fichiers lus au lieu de leurs extraits si fullContents.
+func (kb *KnowledgeBase) contextSummary(userProblem string, maxTokens int, fullContents bool) string {
 	var summary strings.Builder
 
+	// La vue d'ensemble en premier, identique d'un résumé à l'autre
+	if kb.ProjectOverview != "" {
+		summary.WriteString(fmt.Sprintf("Vue d'Ensemble du Projet:\n%s\n\n", kb.ProjectOverview))
+	}
 	summary.WriteString(fmt.Sprintf("Problème utilisateur: \"%s\"\n", userProblem))
-	summary.WriteString(fmt.Sprintf("Projet: %s (Type: %s)\n", filepath.Base(kb.ProjectPath), kb.ProjectType))
+	if kb.SingleFile != "" {
+		summary.WriteString(fmt.Sprintf("Fichier analysé seul: %s (Type: %s)\n", kb.SingleFile, kb.ProjectType))
+	} else {
+		summary.WriteString(fmt.Sprintf("Projet: %s (Type: %s)\n", filepath.Base(kb.ProjectPath), kb.ProjectType))
+	}
+	if kb.PrimaryLanguage != "" {
+		summary.WriteString(fmt.Sprintf("Langage principal: %s (confiance %.0f%%)\n", kb.PrimaryLanguage, kb.ProjectTypeConfidence*100))
+	}
+	if breakdown := kb.languageBreakdown(); breakdown != "" {
+		summary.WriteString(fmt.Sprintf("Langages: %s\n", breakdown))
+	}
 
-	if kb.ProjectStructure != nil {
-		structureBytes, err := json.MarshalIndent(kb.ProjectStructure, "", "  ")
-		if err == nil {
-			structureStr := string(structureBytes)
-			maxStructureLen := 1800
-			if len(structureStr) > maxStructureLen {
-				structureStr = structureStr[:maxStructureLen] + "\n...(structure tronquée)"
-			}
-			summary.WriteString(fmt.Sprintf("\nStructure Projet (partielle):\n```json\n%s\n```\n", structureStr))
+	if len(kb.ProjectStructure) > 0 {
+		structure := withoutForbiddenFiles(kb.cfg, kb.ProjectStructure, "")
+		structureStr, format := "", "json"
+		if structureBytes, err := json.MarshalIndent(structure, "", "  "); err == nil {
+			structureStr = string(structureBytes)
+		} else {
+			// Le modèle garde au moins les entrées de premier niveau
+			kb.log.Warnf("Could not render the project structure as JSON (key '%s'): %v, listing its top-level entries instead", unmarshalableKey(structure, ""), err)
+			structureStr, format = topLevelEntries(structure), "text"
+		}
+		if truncated := truncateToTokens(structureStr, maxTokens*structureBudgetShare/100); truncated != structureStr {
+			structureStr = truncated + "\n...(structure tronquée)"
 		}
+		summary.WriteString(fmt.Sprintf("\nStructure Projet (partielle):\n```%s\n%s\n```\n", format, structureStr))
 	}
 
-	summary.WriteString("\nFichiers Lus (Extraits):\n")
-	if len(kb.FileContents) == 0 {
-		summary.WriteString("(Aucun)\n")
+	if fullContents {
+		summary.WriteString("\nFichiers Lus (Contenu Complet):\n")
+		for _, path := range kb.filesByRelevance(userProblem) {
+			if isForbiddenFile(kb.cfg, path) {
+				continue
+			}
+			summary.WriteString(fmt.Sprintf("- `%s`%s\n```\n%s\n```\n", path, duplicatesLabel(kb.duplicatesOf(path)), strings.TrimRight(kb.FileContents[path], "\n")))
+			kb.contextFiles[path] = true
+		}
 	} else {
-		count := 0
-		for path, content := range kb.FileContents {
-			excerpt := strings.ReplaceAll(strings.ReplaceAll(content, "`", ""), "\n", " ")
-			if len(excerpt) > 80 {
-				excerpt = excerpt[:80]
+		summary.WriteString("\nFichiers Lus (Extraits):\n")
+		if len(kb.FileContents) == 0 {
+			summary.WriteString("(Aucun)\n")
+		} else {
+			count := 0
+			excerptChars := maxTokens * excerptsBudgetShare / 100 / min(len(kb.FileContents), 5) * charsPerToken
+			if maxExcerpt := kb.cfg.Analysis.MaxExcerptChars; maxExcerpt > 0 {
+				excerptChars = min(excerptChars, maxExcerpt)
 			}
-			summary.WriteString(fmt.Sprintf("- `%s`: %s...\n", path, excerpt))
-			count++
-			if count >= 5 {
-				summary.WriteString(fmt.Sprintf("... et %d autres fichiers lus.\n", len(kb.FileContents)-count))
-				break
+			keywords := questionKeywords(userProblem)
+			// Les fichiers les plus proches de la question en premier
+			for _, path := range kb.filesByRelevance(userProblem) {
+				if isForbiddenFile(kb.cfg, path) {
+					continue
+				}
+				excerpt, first, last := fileExcerpt(kb.FileContents[path], keywords, excerptChars)
+				summary.WriteString(fmt.Sprintf("- `%s`%s %s\n", path, duplicatesLabel(kb.duplicatesOf(path)), formatExcerpt(excerpt, first, last)))
+				kb.contextFiles[path] = true
+				count++
+				if count >= 5 {
+					summary.WriteString(fmt.Sprintf("... et %d autres fichiers lus.\n", len(kb.FileContents)-count))
+					break
+				}
+			}
+		}
+	}
+
+	// Fichiers pas encore lus dont le contenu mentionne la question
+	if kb.searchIndex != nil {
+		if files := kb.searchIndex.filesMentioning(questionKeywords(userProblem), kb.FileContents, 5); len(files) > 0 {
+			summary.WriteString("\nFichiers Non Lus Mentionnant la Question:\n")
+			for _, path := range files {
+				summary.WriteString(fmt.Sprintf("- %s\n", path))
 			}
 		}
 	}
@@ -188,8 +987,14 @@ func (kb *KnowledgeBase) getContextSummary(userProblem string, maxPromptLength i
 	if len(kb.FailedFileAttempts) == 0 {
 		summary.WriteString("(Aucun)\n")
 	} else {
-		for filePath, attempts := range kb.FailedFileAttempts {
-			summary.WriteString(fmt.Sprintf("- %s (tenté %d fois)\n", filePath, attempts))
+		// Ordre stable d'un appel à l'autre, les maps n'en ont pas
+		failed := make([]string, 0, len(kb.FailedFileAttempts))
+		for filePath := range kb.FailedFileAttempts {
+			failed = append(failed, filePath)
+		}
+		sort.Strings(failed)
+		for _, filePath := range failed {
+			summary.WriteString(fmt.Sprintf("- %s (tenté %d fois)\n", filePath, kb.FailedFileAttempts[filePath]))
 		}
 	}
 
@@ -198,8 +1003,69 @@ func (kb *KnowledgeBase) getContextSummary(userProblem string, maxPromptLength i
 	if len(kb.DependencyFiles) == 0 {
 		summary.WriteString("(Aucun détecté)\n")
 	} else {
-		for depType, filePath := range kb.DependencyFiles {
-			summary.WriteString(fmt.Sprintf("- %s: %s\n", depType, filePath))
+		depTypes := make([]string, 0, len(kb.DependencyFiles))
+		for depType := range kb.DependencyFiles {
+			depTypes = append(depTypes, depType)
+		}
+		sort.Strings(depTypes)
+		for _, depType := range depTypes {
+			summary.WriteString(fmt.Sprintf("- %s: %s\n", depType, kb.DependencyFiles[depType]))
+		}
+	}
+
+	// Points d'entrée probables, pour ne pas les chercher pendant l'exploration
+	if len(kb.EntryPoints) > 0 {
+		summary.WriteString("\nPoints d'Entrée Probables:\n")
+		for _, entryPoint := range kb.EntryPoints {
+			summary.WriteString(fmt.Sprintf("- %s\n", entryPoint))
+		}
+	}
+
+	// Symboles exportés, pour trouver une fonction sans lire tous les fichiers
+	if len(kb.Symbols) > 0 {
+		summary.WriteString("\nSymboles Exportés (fichier: nom (genre, ligne)):\n")
+		symbolFiles := kb.symbolFilesByRelevance(userProblem)
+		for _, path := range symbolFiles[:min(len(symbolFiles), maxListedSymbolFiles)] {
+			summary.WriteString(fmt.Sprintf("- %s: %s\n", path, formatSymbols(kb.Symbols[path])))
+		}
+		if len(symbolFiles) > maxListedSymbolFiles {
+			summary.WriteString(fmt.Sprintf("... et %d autres fichiers.\n", len(symbolFiles)-maxListedSymbolFiles))
+		}
+	}
+
+	// Fichiers de test, les plus utiles pour une question sur un échec
+	if len(kb.TestFiles) > 0 {
+		summary.WriteString("\nFichiers de Test:\n")
+		testFiles := kb.testFilesByRelevance(userProblem)
+		for _, path := range testFiles[:min(len(testFiles), maxListedTestFiles)] {
+			read := ""
+			if _, ok := kb.FileContents[path]; ok {
+				read = " (lu)"
+			}
+			summary.WriteString(fmt.Sprintf("- %s%s\n", path, read))
+		}
+		if len(testFiles) > maxListedTestFiles {
+			summary.WriteString(fmt.Sprintf("... et %d autres fichiers de test.\n", len(testFiles)-maxListedTestFiles))
+		}
+	}
+
+	// Sous-projets d'un monorepo
+	if len(kb.SubProjects) > 0 {
+		summary.WriteString("\nSous-projets (monorepo):\n")
+		for _, line := range kb.subProjectsSummary() {
+			summary.WriteString(fmt.Sprintf("- %s\n", line))
+		}
+	}
+
+	// Questions précédentes de la session, pour les questions de suivi
+	if len(kb.PreviousQuestions) > 0 {
+		summary.WriteString("\nQuestions Précédentes (même session):\n")
+		for _, previous := range kb.PreviousQuestions {
+			answer := strings.TrimSpace(previous.Answer)
+			if truncated := truncateToTokens(answer, previousAnswerTokens); truncated != answer {
+				answer = truncated + "..."
+			}
+			summary.WriteString(fmt.Sprintf("- Q: %s\n  R: %s\n", previous.Question, strings.ReplaceAll(answer, "\n", " ")))
 		}
 	}
 
@@ -213,19 +1079,314 @@ func (kb *KnowledgeBase) getContextSummary(userProblem string, maxPromptLength i
 		if len(combinedInfo) > maxHistory {
 			start = len(combinedInfo) - maxHistory
 		}
+		noteTokens := maxTokens * notesBudgetShare / 100 / len(combinedInfo[start:])
 		for _, info := range combinedInfo[start:] {
-			if len(info) > 80 { // Reduced length to save space
-				info = info[:80] + "..."
+			if truncated := truncateToTokens(info, noteTokens); truncated != info {
+				info = truncated + "..."
 			}
 			summary.WriteString(fmt.Sprintf("- %s\n", info))
 		}
 	}
 
-	// Truncate if too long
 	finalSummary := summary.String()
-	if len(finalSummary) > maxPromptLength-500 {
-		logrus.Warnf("Context summary is potentially too long (%d chars).", len(finalSummary))
+	if tokens := estimateTokens(finalSummary); tokens > maxTokens {
+		kb.log.Warnf("Context summary is potentially too long (~%d tokens, budget %d).", tokens, maxTokens)
 	}
 
 	return finalSummary
 }
+
+// PartialReport résume ce que l'analyse a collecté (type de projet, fichiers
+// lus, notes), en remplacement de la réponse finale quand sa génération a
+// échoué avec err.
+func (kb *KnowledgeBase) PartialReport(err error) string {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "_Warning: the final answer could not be generated (%v). Here is what the analysis collected so far._\n", err)
+	if kb.ProjectType != "" {
+		fmt.Fprintf(&report, "\n**Project type:** %s\n", kb.ProjectType)
+	}
+
+	files := make([]string, 0, len(kb.FileContents))
+	for path := range kb.FileContents {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	if len(files) > 0 {
+		report.WriteString("\n**Files read:**\n")
+		for _, path := range files {
+			fmt.Fprintf(&report, "- %s\n", path)
+			for _, duplicate := range kb.duplicatesOf(path) {
+				fmt.Fprintf(&report, "- %s (same as %s)\n", duplicate, path)
+			}
+		}
+	}
+
+	if len(kb.AnalysisNotes) > 0 {
+		report.WriteString("\n**Notes:**\n")
+		for _, note := range kb.AnalysisNotes {
+			fmt.Fprintf(&report, "- %s\n", note)
+		}
+	}
+	return report.String()
+}
+
+// Sources liste, triés par chemin, les fichiers effectivement inclus dans les
+// contextes envoyés au modèle, y compris ceux évincés depuis. Cited indique
+// ceux que la réponse mentionne explicitement.
+func (kb *KnowledgeBase) Sources(answer string) []Source {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	sources := make([]Source, 0, len(kb.contextFiles))
+	for path := range kb.contextFiles {
+		sources = append(sources, Source{
+			Path:  path,
+			Cited: strings.Contains(answer, path),
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Path < sources[j].Path })
+	return sources
+}
+
+// getContextUpdate résume ce que la base de connaissances a appris depuis le
+// dernier échange avec le modèle : nouveaux fichiers lus, nouvelles notes et
+// nouvelles entrées d'historique. seenFiles est complété avec les fichiers
+// inclus ; les nouveaux compteurs de notes et d'historique sont retournés.
+func (kb *KnowledgeBase) getContextUpdate(notesSeen, historySeen int, seenFiles map[string]bool) (string, int, int) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	var update strings.Builder
+	update.WriteString("Nouveautés depuis le dernier échange:\n")
+	empty := true
+
+	for path, content := range kb.FileContents {
+		if seenFiles[path] || isForbiddenFile(kb.cfg, path) {
+			continue
+		}
+		seenFiles[path] = true
+		excerpt, first, last := fileExcerpt(content, nil, contextUpdateExcerptChars)
+		update.WriteString(fmt.Sprintf("- Fichier lu `%s` %s\n", path, formatExcerpt(excerpt, first, last)))
+		kb.contextFiles[path] = true
+		empty = false
+	}
+
+	for _, note := range kb.AnalysisNotes[min(notesSeen, len(kb.AnalysisNotes)):] {
+		update.WriteString(fmt.Sprintf("- Note: %s\n", note))
+		empty = false
+	}
+	for _, entry := range kb.ExplorationHistory[min(historySeen, len(kb.ExplorationHistory)):] {
+		update.WriteString(fmt.Sprintf("- %s\n", entry))
+		empty = false
+	}
+
+	if empty {
+		update.WriteString("(Aucune)\n")
+	}
+	return update.String(), len(kb.AnalysisNotes), len(kb.ExplorationHistory)
+}
+
+// knowledgeBaseCacheVersion est incrémentée à chaque changement incompatible
+// du format de sauvegarde ; les fichiers d'une autre version sont ignorés.
+const knowledgeBaseCacheVersion = 1
+
+// ErrIncompatibleCache signale un fichier de sauvegarde d'une autre version.
+var ErrIncompatibleCache = errors.New("incompatible knowledge base cache version")
+
+// knowledgeBaseSnapshot est la forme sérialisée d'une KnowledgeBase.
+type knowledgeBaseSnapshot struct {
+	Version          int                    `json:"version"`
+	ProjectPath      string                 `json:"project_path"`
+	ProjectType      string                 `json:"project_type"`
+	ProjectStructure map[string]interface{} `json:"project_structure"`
+	FileContents     map[string]string      `json:"file_contents"`
+	AnalysisNotes    []string               `json:"analysis_notes"`
+	AnalysisDetails  map[string]string      `json:"analysis_details,omitempty"`
+	DependencyFiles  map[string]string      `json:"dependency_files"`
+
+	PreviousQuestions []questionAnswer          `json:"previous_questions,omitempty"`
+	DuplicateFiles    map[string]string         `json:"duplicate_files,omitempty"`
+	ProjectOverview   string                    `json:"project_overview,omitempty"`
+	OverviewFacts     string                    `json:"overview_facts,omitempty"`
+	FileStamps        map[string]savedFileStamp `json:"file_stamps,omitempty"`
+}
+
+// savedFileStamp est la forme sérialisée d'un fileStamp.
+type savedFileStamp struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// SaveToFile sérialise la base de connaissances en JSON dans path.
+// L'écriture passe par un fichier temporaire pour ne jamais laisser de
+// sauvegarde partielle.
+func (kb *KnowledgeBase) SaveToFile(path string) error {
+	kb.mu.Lock()
+	stamps := make(map[string]savedFileStamp, len(kb.fileStamps))
+	for file, stamp := range kb.fileStamps {
+		stamps[file] = savedFileStamp{ModTime: stamp.modTime, Size: stamp.size}
+	}
+	data, err := json.Marshal(knowledgeBaseSnapshot{
+		Version:          knowledgeBaseCacheVersion,
+		ProjectPath:      kb.ProjectPath,
+		ProjectType:      kb.ProjectType,
+		ProjectStructure: kb.ProjectStructure,
+		FileContents:     kb.FileContents,
+		AnalysisNotes:    kb.AnalysisNotes,
+		AnalysisDetails:  kb.AnalysisDetails,
+		DependencyFiles:  kb.DependencyFiles,
+
+		PreviousQuestions: kb.PreviousQuestions,
+		DuplicateFiles:    kb.DuplicateFiles,
+		ProjectOverview:   kb.ProjectOverview,
+		OverviewFacts:     kb.OverviewFacts,
+		FileStamps:        stamps,
+	})
+	kb.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not serialize knowledge base: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create cache directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("could not write knowledge base cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("could not write knowledge base cache: %w", err)
+	}
+	return nil
+}
+
+// LoadFromFile restaure la base de connaissances depuis une sauvegarde JSON.
+// Les notes et les questions précédentes remplacent celles de la base, pour
+// qu'elles ne s'accumulent pas d'une analyse à l'autre. Seuls les contenus
+// dont le fichier n'a pas changé sur disque depuis sa lecture sont restaurés.
+// Une sauvegarde d'une autre version retourne ErrIncompatibleCache et laisse
+// la base inchangée.
+func (kb *KnowledgeBase) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read knowledge base cache: %w", err)
+	}
+
+	var snapshot knowledgeBaseSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("could not parse knowledge base cache: %w", err)
+	}
+	if snapshot.Version != knowledgeBaseCacheVersion {
+		return fmt.Errorf("%w: got %d, want %d", ErrIncompatibleCache, snapshot.Version, knowledgeBaseCacheVersion)
+	}
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	if snapshot.ProjectType != "" {
+		kb.ProjectType = snapshot.ProjectType
+	}
+	if snapshot.ProjectStructure != nil {
+		kb.ProjectStructure = marshalableStructure(snapshot.ProjectStructure)
+	}
+	kb.AnalysisNotes = restoredNotes(snapshot.AnalysisNotes)
+	for subject, details := range snapshot.AnalysisDetails {
+		kb.AnalysisDetails[subject] = details
+	}
+	for depType, file := range snapshot.DependencyFiles {
+		kb.DependencyFiles[depType] = file
+	}
+	kb.PreviousQuestions = snapshot.PreviousQuestions[max(0, len(snapshot.PreviousQuestions)-maxPreviousQuestions):]
+	if snapshot.ProjectOverview != "" {
+		kb.ProjectOverview, kb.OverviewFacts = snapshot.ProjectOverview, snapshot.OverviewFacts
+	}
+	stale := make(map[string]bool)
+	for path, content := range snapshot.FileContents {
+		file := kb.savedFile(path)
+		stamp, ok := snapshot.FileStamps[path]
+		if !ok || !kb.matchesStamp(file, stamp) {
+			stale[file] = true
+			continue
+		}
+		kb.retainedBytes += len(content) - len(kb.FileContents[path])
+		kb.FileContents[path] = content
+		kb.contentHashes[contentHash(content)] = path
+		kb.accessClock++
+		kb.fileAccess[path] = kb.accessClock
+		kb.fileStamps[path] = fileStamp{modTime: stamp.ModTime, size: stamp.Size}
+	}
+	for duplicate, original := range snapshot.DuplicateFiles {
+		if _, ok := kb.FileContents[original]; !ok {
+			continue
+		}
+		stamp, ok := snapshot.FileStamps[duplicate]
+		if !ok || !kb.matchesStamp(duplicate, stamp) {
+			stale[duplicate] = true
+			continue
+		}
+		kb.DuplicateFiles[duplicate] = original
+		kb.fileStamps[duplicate] = fileStamp{modTime: stamp.ModTime, size: stamp.Size}
+	}
+	for file := range stale {
+		kb.forgetFindings(file)
+	}
+	kb.staleFiles = stale
+	if len(stale) > 0 {
+		kb.log.Infof("%d cached file(s) changed on disk since they were read, not restored", len(stale))
+	}
+
+	kb.log.Infof("Knowledge base restored from '%s' (%d files, %d notes)", path, len(snapshot.FileContents), len(snapshot.AnalysisNotes))
+	return nil
+}
+
+// matchesStamp indique si le fichier relPath du projet a toujours la date de
+// modification et la taille de stamp.
+func (kb *KnowledgeBase) matchesStamp(relPath string, stamp savedFileStamp) bool {
+	info, err := os.Stat(filepath.Join(kb.ProjectPath, relPath))
+	return err == nil && info.ModTime().Equal(stamp.ModTime) && info.Size() == stamp.Size
+}
+
+// savedFile retourne le fichier du projet dont relPath est le contenu
+// sauvegardé : relPath lui-même, ou le fichier d'un extrait "chemin:début-fin"
+// (voir readFileRange).
+func (kb *KnowledgeBase) savedFile(relPath string) string {
+	if _, err := os.Stat(filepath.Join(kb.ProjectPath, relPath)); err != nil {
+		if match := lineRangeSuffix.FindStringSubmatch(relPath); match != nil {
+			return match[1]
+		}
+	}
+	return relPath
+}
+
+// maxRestoredNotes limite les notes restaurées d'une sauvegarde : les plus
+// récentes sont gardées.
+const maxRestoredNotes = 100
+
+// restoredNotes retourne les notes d'une sauvegarde sans doublons, limitées
+// à maxRestoredNotes.
+func restoredNotes(notes []string) []string {
+	seen := make(map[string]bool, len(notes))
+	restored := []string{}
+	for _, note := range notes {
+		if !seen[note] {
+			seen[note] = true
+			restored = append(restored, note)
+		}
+	}
+	return restored[max(0, len(restored)-maxRestoredNotes):]
+}
+
+// knowledgeBaseCachePath retourne le fichier de cache associé à un projet,
+// indexé par un hash de son chemin absolu.
+func knowledgeBaseCachePath(cacheDir, projectPath string) string {
+	absPath, err := filepath.Abs(projectPath)
+	if err != nil {
+		absPath = projectPath
+	}
+	hash := sha256.Sum256([]byte(absPath))
+	return filepath.Join(cacheDir, hex.EncodeToString(hash[:8])+".json")
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"debugagent/config"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 )
 
 func setupKnowledgeBase(t *testing.T) *KnowledgeBase {
@@ -54,7 +60,8 @@ func TestGetContextSummary(t *testing.T) {
 	if !strings.Contains(summary, "Type: Go Backend") {
 		t.Error("getContextSummary() did not include the project type")
 	}
-	if !strings.Contains(summary, "- `main.go`: package main  func main() {}...") {
+	// The excerpt skips the package clause to start at the first declaration
+	if !strings.Contains(summary, "- `main.go` (lignes 3-3):\n```\nfunc main() {}\n```") {
 		t.Error("getContextSummary() did not include the file content")
 	}
 	if !strings.Contains(summary, "- This is a test note.") {
@@ -64,3 +71,579 @@ func TestGetContextSummary(t *testing.T) {
 		t.Error("getContextSummary() did not include the history")
 	}
 }
+
+func TestGetContextSummary_DeterministicOrder(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	for _, name := range []string{"zeta.go", "alpha.go", "mid/beta.go", "gamma.go"} {
+		kb.AddFileContent(filepath.Join(kb.ProjectPath, name), "package main")
+	}
+	for _, name := range []string{"missing/z.go", "missing/a.go", "missing/m.go"} {
+		kb.AddFailedFileAttempt(name)
+	}
+	kb.AddDependencyFile("npm", "package.json")
+	kb.AddDependencyFile("go", "go.mod")
+	kb.AddDependencyFile("cargo", "Cargo.toml")
+
+	summary := kb.getContextSummary("question", 1000)
+	for i := 0; i < 10; i++ {
+		if again := kb.getContextSummary("question", 1000); again != summary {
+			t.Fatalf("getContextSummary() changed between calls:\n%s\n---\n%s", summary, again)
+		}
+	}
+
+	// Equal relevance scores, failed attempts and dependency files are sorted by path or type
+	for _, ordered := range [][]string{
+		{"`alpha.go`", "`gamma.go`", "`mid/beta.go`", "`zeta.go`"},
+		{"- missing/a.go", "- missing/m.go", "- missing/z.go"},
+		{"- cargo: Cargo.toml", "- go: go.mod", "- npm: package.json"},
+	} {
+		previous := -1
+		for _, entry := range ordered {
+			index := strings.Index(summary, entry)
+			if index <= previous {
+				t.Errorf("expected %q in order in the summary:\n%s", ordered, summary)
+				break
+			}
+			previous = index
+		}
+	}
+}
+
+func TestAddNote_SkipsEmptyNotes(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	kb.AddNote("")
+	kb.AddNote("  \n\t")
+	kb.AddNote("x")
+	kb.AddNote(" x ")
+
+	if len(kb.AnalysisNotes) != 1 || kb.AnalysisNotes[0] != "x" {
+		t.Fatalf("expected the single note \"x\", got %q", kb.AnalysisNotes)
+	}
+	summary := kb.getContextSummary("question", 1000)
+	if strings.Contains(summary, "- \n") {
+		t.Errorf("getContextSummary() contains an empty bullet point:\n%s", summary)
+	}
+	if !strings.Contains(summary, "- x\n") {
+		t.Errorf("getContextSummary() did not include the one-character note:\n%s", summary)
+	}
+}
+
+func TestGetContextSummary_TokenBudget(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	structure := make(map[string]interface{})
+	for i := 0; i < 500; i++ {
+		structure[fmt.Sprintf("file_%03d.go", i)] = nil
+	}
+	kb.ProjectStructure = structure
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "main.go"), strings.Repeat("word ", 1000))
+	kb.AddNote(strings.Repeat("note ", 1000))
+
+	for _, budget := range []int{500, 4000} {
+		summary := kb.getContextSummary("question", budget)
+		if tokens := estimateTokens(summary); tokens > budget {
+			t.Errorf("expected the summary to fit in %d tokens, got ~%d", budget, tokens)
+		}
+		if !strings.Contains(summary, "...(structure tronquée)") {
+			t.Errorf("expected the structure to be truncated with a %d token budget", budget)
+		}
+	}
+
+	// A larger budget gives longer excerpts
+	small, large := kb.getContextSummary("question", 500), kb.getContextSummary("question", 4000)
+	if len(large) <= len(small) {
+		t.Errorf("expected a larger budget to produce a longer summary, got %d and %d characters", len(small), len(large))
+	}
+}
+
+func TestGetContextSummary_UnmarshalableStructure(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	logger, hook := test.NewNullLogger()
+	kb.log = logrus.NewEntry(logger)
+	kb.ProjectStructure = map[string]interface{}{
+		"main.go": "1.2 KB",
+		"go.mod":  "",
+		"src/":    map[string]interface{}{"handler.go": make(chan int)},
+	}
+
+	summary := kb.getContextSummary("question", 4000)
+	if !strings.Contains(summary, "Structure Projet (partielle):\n```text\n- go.mod\n- main.go (1.2 KB)\n- src/\n```") {
+		t.Errorf("expected the top-level entries as text, got:\n%s", summary)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel || !strings.Contains(entry.Message, "key 'src/handler.go'") {
+		t.Errorf("expected a warning naming the offending key, got %+v", entry)
+	}
+
+	// Values set through SetProjectStructure are always rendered as JSON
+	kb.SetProjectStructure(map[string]interface{}{"main.go": nil, "src/": map[string]interface{}{"handler.go": 42}})
+	summary = kb.getContextSummary("question", 4000)
+	if !strings.Contains(summary, "```json") || !strings.Contains(summary, `"handler.go": "42"`) {
+		t.Errorf("expected the structure as JSON, got:\n%s", summary)
+	}
+}
+
+func TestAddFileContent_EvictsLeastRecentlyUsed(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.MaxRetainedFiles = 2
+
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "a.go"), "a")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "b.go"), "b")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "a.go"), "a") // refresh a.go
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "c.go"), "c")
+
+	if _, ok := kb.FileContents["b.go"]; ok {
+		t.Error("expected the least recently used file to be evicted")
+	}
+	if _, ok := kb.FileContents["a.go"]; !ok {
+		t.Error("expected the recently refreshed file to be kept")
+	}
+	if len(kb.FileContents) != 2 {
+		t.Errorf("expected 2 retained files, got %d", len(kb.FileContents))
+	}
+}
+
+func TestAddFileContent_KeepsFilesFromCurrentPlan(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.MaxRetainedBytes = 10
+
+	kb.ExplorationPlan = []string{"READ_FILE old.go", "READ_FILE new.go"}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "old.go"), "123456")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "other.go"), "12")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "new.go"), "123456")
+
+	if _, ok := kb.FileContents["old.go"]; !ok {
+		t.Error("files referenced by the last plan must not be evicted")
+	}
+	if _, ok := kb.FileContents["other.go"]; ok {
+		t.Error("expected the unreferenced file to be evicted")
+	}
+}
+
+func TestAddFileContent_Duplicates(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	generated := "// Code generated by protoc. DO NOT EDIT.\n" + strings.Repeat("var field int\n", 30)
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "api", "v1.pb.go"), generated)
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "api", "v2.pb.go"), generated)
+
+	duplicate := filepath.Join("api", "v2.pb.go")
+	if _, ok := kb.FileContents[duplicate]; ok || len(kb.FileContents) != 1 {
+		t.Fatalf("expected a single copy of the content, got %d files", len(kb.FileContents))
+	}
+	if kb.DuplicateFiles[duplicate] != filepath.Join("api", "v1.pb.go") {
+		t.Errorf("expected v2.pb.go to reference v1.pb.go, got %v", kb.DuplicateFiles)
+	}
+	if kb.retainedBytes != len(generated) {
+		t.Errorf("expected %d retained bytes, got %d", len(generated), kb.retainedBytes)
+	}
+
+	summary := kb.getContextSummary("What does the API define?", 4000)
+	if !strings.Contains(summary, "v1.pb.go` (+1 fichier identique: "+duplicate+")") {
+		t.Errorf("expected the duplicate count next to the representative, got:\n%s", summary)
+	}
+	if !strings.Contains(summary, duplicateFileNote(duplicate, filepath.Join("api", "v1.pb.go"))) {
+		t.Errorf("expected a note referencing the representative, got:\n%s", summary)
+	}
+
+	// Once the representative is dropped, the duplicate has to be read again
+	kb.InvalidateFiles([]string{"api/v1.pb.go"})
+	if len(kb.DuplicateFiles) != 0 {
+		t.Errorf("expected the duplicate to be forgotten with its representative, got %v", kb.DuplicateFiles)
+	}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "api", "v2.pb.go"), generated)
+	if kb.FileContents[duplicate] != generated {
+		t.Error("expected the duplicate to be stored once it is the only copy")
+	}
+}
+
+func TestAddFileContent_DuplicateReadAgain(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	generated := "// Code generated by protoc. DO NOT EDIT.\n" + strings.Repeat("var field int\n", 30)
+	os.MkdirAll(filepath.Join(kb.ProjectPath, "api"), 0755)
+	for _, name := range []string{"v1.pb.go", "v2.pb.go"} {
+		path := filepath.Join(kb.ProjectPath, "api", name)
+		os.WriteFile(path, []byte(generated), 0644)
+		info, _ := os.Stat(path)
+		kb.AddFileContent(path, generated)
+		kb.RecordFileStamp(path, info)
+	}
+
+	duplicate := filepath.Join(kb.ProjectPath, "api", "v2.pb.go")
+	info, _ := os.Stat(duplicate)
+	if !kb.IsFileUnchanged(duplicate, info) {
+		t.Error("expected the unchanged duplicate not to be read again")
+	}
+	kb.AddFileContent(duplicate, generated)
+	if len(kb.AnalysisNotes) != 1 {
+		t.Errorf("expected a single note about the duplicate, got %v", kb.AnalysisNotes)
+	}
+}
+
+func TestSaveAndLoadKnowledgeBase(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	kb.SetProjectType("Go Backend")
+	kb.ProjectStructure = map[string]interface{}{"main.go": "12 bytes"}
+	mainPath := filepath.Join(kb.ProjectPath, "main.go")
+	os.WriteFile(mainPath, []byte("package main"), 0644)
+	info, _ := os.Stat(mainPath)
+	kb.AddFileContent(mainPath, "package main")
+	kb.RecordFileStamp(mainPath, info)
+	kb.AddNote("Entry point is main.go")
+	kb.AnalysisDetails["entry point"] = "main.go starts the server."
+	kb.AddDependencyFile("go", "go.mod")
+
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+	if err := kb.SaveToFile(cachePath); err != nil {
+		t.Fatalf("SaveToFile() failed: %v", err)
+	}
+
+	restored := NewKnowledgeBase(kb.ProjectPath)
+	if err := restored.LoadFromFile(cachePath); err != nil {
+		t.Fatalf("LoadFromFile() failed: %v", err)
+	}
+	if restored.FileContents["main.go"] != "package main" || !restored.IsFileUnchanged(mainPath, info) {
+		t.Error("file contents were not restored")
+	}
+	if len(restored.AnalysisNotes) != 1 || restored.AnalysisNotes[0] != "Entry point is main.go" {
+		t.Errorf("notes were not restored: %v", restored.AnalysisNotes)
+	}
+	if restored.DependencyFiles["go"] != "go.mod" || restored.ProjectType != "Go Backend" {
+		t.Error("dependency files or project type were not restored")
+	}
+	if restored.ProjectStructure["main.go"] != "12 bytes" {
+		t.Error("project structure was not restored")
+	}
+	if restored.AnalysisDetails["entry point"] != "main.go starts the server." {
+		t.Errorf("analysis details were not restored: %v", restored.AnalysisDetails)
+	}
+}
+
+func TestPreviousQuestions(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	for i := 0; i <= maxPreviousQuestions; i++ {
+		kb.AddPreviousQuestion(fmt.Sprintf("Question %d?", i), "Answer\non two lines")
+	}
+	if len(kb.PreviousQuestions) != maxPreviousQuestions || kb.PreviousQuestions[0].Question != "Question 1?" {
+		t.Fatalf("expected the %d last questions to be kept, got %+v", maxPreviousQuestions, kb.PreviousQuestions)
+	}
+
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+	if err := kb.SaveToFile(cachePath); err != nil {
+		t.Fatalf("SaveToFile() failed: %v", err)
+	}
+	restored := NewKnowledgeBase(kb.ProjectPath)
+	if err := restored.LoadFromFile(cachePath); err != nil {
+		t.Fatalf("LoadFromFile() failed: %v", err)
+	}
+
+	summary := restored.getContextSummary("Follow-up?", 4000)
+	if !strings.Contains(summary, "Questions Précédentes") || !strings.Contains(summary, "- Q: Question 5?\n  R: Answer on two lines") {
+		t.Errorf("expected the restored questions in the context summary, got:\n%s", summary)
+	}
+}
+
+func TestLoadKnowledgeBase_DropsChangedFiles(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	for _, name := range []string{"main.go", "config.go", "old.go"} {
+		path := filepath.Join(kb.ProjectPath, name)
+		os.WriteFile(path, []byte("package "+name), 0644)
+		info, _ := os.Stat(path)
+		kb.AddFileContent(path, "package "+name)
+		kb.RecordFileStamp(path, info)
+	}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "unstamped.go"), "package unstamped")
+	kb.AddNote("config.go reads config.yaml")
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+	if err := kb.SaveToFile(cachePath); err != nil {
+		t.Fatalf("SaveToFile() failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(kb.ProjectPath, "config.go"), []byte("package config // edited"), 0644)
+	os.Remove(filepath.Join(kb.ProjectPath, "old.go"))
+	restored := NewKnowledgeBase(kb.ProjectPath)
+	if err := restored.LoadFromFile(cachePath); err != nil {
+		t.Fatalf("LoadFromFile() failed: %v", err)
+	}
+
+	if _, ok := restored.FileContents["main.go"]; !ok || len(restored.FileContents) != 1 {
+		t.Errorf("expected only the unchanged main.go to be restored, got %v", restored.FileContents)
+	}
+	if len(restored.AnalysisNotes) != 0 {
+		t.Errorf("expected the notes about config.go to be dropped, got %v", restored.AnalysisNotes)
+	}
+	if invalidated := restored.InvalidateFiles([]string{"config.go"}); len(invalidated) != 1 {
+		t.Errorf("expected config.go to be reported as invalidated, got %v", invalidated)
+	}
+}
+
+func TestLoadKnowledgeBase_NotesDoNotAccumulate(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+
+	// Each run restores the previous one's findings, adds its own and saves them
+	for run := 0; run < 3; run++ {
+		restored := NewKnowledgeBase(kb.ProjectPath)
+		if run > 0 {
+			if err := restored.LoadFromFile(cachePath); err != nil {
+				t.Fatalf("LoadFromFile() failed: %v", err)
+			}
+		}
+		restored.AddNote("Entry point is main.go")
+		restored.AddNote("Configuration is read from config.yaml")
+		restored.AddPreviousQuestion(fmt.Sprintf("Question %d?", run), "Answer")
+		if err := restored.SaveToFile(cachePath); err != nil {
+			t.Fatalf("SaveToFile() failed: %v", err)
+		}
+	}
+
+	restored := NewKnowledgeBase(kb.ProjectPath)
+	if err := restored.LoadFromFile(cachePath); err != nil {
+		t.Fatalf("LoadFromFile() failed: %v", err)
+	}
+	if len(restored.AnalysisNotes) != 2 {
+		t.Errorf("expected each note once, got %v", restored.AnalysisNotes)
+	}
+	if len(restored.PreviousQuestions) != 3 {
+		t.Errorf("expected one question per run, got %+v", restored.PreviousQuestions)
+	}
+}
+
+func TestLoadKnowledgeBase_IgnoresIncompatibleVersion(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+	os.WriteFile(cachePath, []byte(`{"version": 999, "analysis_notes": ["stale"]}`), 0644)
+
+	err := kb.LoadFromFile(cachePath)
+	if !errors.Is(err, ErrIncompatibleCache) {
+		t.Fatalf("expected ErrIncompatibleCache, got: %v", err)
+	}
+	if len(kb.AnalysisNotes) != 0 {
+		t.Error("an incompatible cache must not modify the knowledge base")
+	}
+}
+
+func TestDetectLanguages(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"main.go":              "package main",
+		"engine.go":            "package main",
+		"web/app.ts":           "export {}",
+		"web/component.tsx":    "export {}",
+		"Dockerfile":           "FROM golang",
+		"Makefile":             "all:",
+		"notes.unknown":        "?",
+		"node_modules/x/x.js":  "ignored",
+		".github/workflow.yml": "ignored",
+	})
+	kb := NewKnowledgeBase(projectPath)
+
+	if err := kb.DetectLanguages(); err != nil {
+		t.Fatalf("DetectLanguages() returned error: %v", err)
+	}
+
+	expected := map[string]int{"Go": 2, "TypeScript": 2, "Dockerfile": 1, "Makefile": 1}
+	if len(kb.Languages) != len(expected) {
+		t.Errorf("expected languages %v, got %v", expected, kb.Languages)
+	}
+	for lang, count := range expected {
+		if kb.Languages[lang] != count {
+			t.Errorf("expected %d %s files, got %d", count, lang, kb.Languages[lang])
+		}
+	}
+
+	summary := kb.getContextSummary("question", 8000)
+	if !strings.Contains(summary, "Langages: Go: 2 fichiers, TypeScript: 2 fichiers, Dockerfile: 1 fichiers") {
+		t.Errorf("expected the language breakdown in the summary, got:\n%s", summary)
+	}
+}
+
+func TestDetectEntryPoints(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"cmd/api/main.go":    "package main",
+		"cmd/worker/main.go": "package main",
+		"internal/db.go":     "package internal",
+		"web/index.js":       "start()",
+		"web/.env":           "TOKEN=x",
+	})
+	config.AppConfig.Analysis.ForbiddenFiles = []string{".env"}
+	kb := NewKnowledgeBase(projectPath)
+	kb.DetectLanguages()
+
+	found := kb.DetectEntryPoints(map[string][]string{
+		"go":         {"main.go", "cmd/*/main.go"},
+		"javascript": {"web/index.js", "web/.env"},
+		"python":     {"app.py"},
+	})
+
+	// Go has more files, so its entry points come first
+	expected := []string{"cmd/api/main.go", "cmd/worker/main.go", "web/index.js"}
+	if !reflect.DeepEqual(found, expected) || !reflect.DeepEqual(kb.EntryPoints, expected) {
+		t.Errorf("expected entry points %v, got %v", expected, found)
+	}
+	summary := kb.getContextSummary("question", 8000)
+	if !strings.Contains(summary, "Points d'Entrée Probables:\n- cmd/api/main.go\n") {
+		t.Errorf("expected the entry points in the summary, got:\n%s", summary)
+	}
+}
+
+func TestIsTestFile(t *testing.T) {
+	for _, name := range []string{"engine_test.go", "app.test.js", "Button.spec.tsx", "test_models.py", "models_test.py", "UserServiceTest.java", "user_spec.rb"} {
+		if !isTestFile(name) {
+			t.Errorf("expected %s to be a test file", name)
+		}
+	}
+	for _, name := range []string{"engine.go", "_test.go", "test_data.json", "latest.js", "contest.py", "spec.md", "testing.go"} {
+		if isTestFile(name) {
+			t.Errorf("expected %s not to be a test file", name)
+		}
+	}
+}
+
+func TestDetectLanguages_TestFiles(t *testing.T) {
+	projectPath := setupExplorerTest(t, map[string]string{
+		"auth/token.go":          "package auth",
+		"auth/token_test.go":     "package auth",
+		"api/server_test.go":     "package api",
+		"web/app.test.js":        "test()",
+		"node_modules/x.test.js": "ignored",
+	})
+	config.AppConfig.Analysis.DetectTestFiles = true
+	kb := NewKnowledgeBase(projectPath)
+
+	if err := kb.DetectLanguages(); err != nil {
+		t.Fatalf("DetectLanguages() returned error: %v", err)
+	}
+	expected := []string{"api/server_test.go", "auth/token_test.go", "web/app.test.js"}
+	if !reflect.DeepEqual(kb.TestFiles, expected) {
+		t.Errorf("expected test files %v, got %v", expected, kb.TestFiles)
+	}
+	kb.FileContents["web/app.test.js"] = "test()"
+
+	// The tests whose path matches the question come first
+	summary := kb.getContextSummary("Why does the token expire?", 8000)
+	if !strings.Contains(summary, "Fichiers de Test:\n- auth/token_test.go\n- api/server_test.go\n- web/app.test.js (lu)\n") {
+		t.Errorf("expected the test files in the summary, got:\n%s", summary)
+	}
+
+	config.AppConfig.Analysis.DetectTestFiles = false
+	kb.DetectLanguages()
+	if len(kb.TestFiles) != 0 || strings.Contains(kb.getContextSummary("question", 8000), "Fichiers de Test") {
+		t.Errorf("expected no test files when detect_test_files is off, got %v", kb.TestFiles)
+	}
+}
+
+func TestIsFileUnchanged(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	absFilePath := filepath.Join(kb.ProjectPath, "main.go")
+	os.WriteFile(absFilePath, []byte("package main"), 0644)
+	info, _ := os.Stat(absFilePath)
+
+	if kb.IsFileUnchanged(absFilePath, info) {
+		t.Fatal("expected a file that was never read to be reported as changed")
+	}
+
+	kb.AddFileContent(absFilePath, "package main")
+	kb.RecordFileStamp(absFilePath, info)
+	if !kb.IsFileUnchanged(absFilePath, info) {
+		t.Error("expected the file to be unchanged right after being read")
+	}
+
+	os.WriteFile(absFilePath, []byte("package main\n\nfunc main() {}"), 0644)
+	info, _ = os.Stat(absFilePath)
+	if kb.IsFileUnchanged(absFilePath, info) {
+		t.Error("expected a modified file to be reported as changed")
+	}
+
+	// Content added without a stamp (e.g. restored from a cache) must be re-read
+	kb.RecordFileStamp(absFilePath, info)
+	kb.AddFileContent(absFilePath, "package main")
+	if kb.IsFileUnchanged(absFilePath, info) {
+		t.Error("expected AddFileContent to invalidate the previous stamp")
+	}
+}
+
+func TestSources(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "main.go"), "package main")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "util.go"), "package main")
+
+	if sources := kb.Sources("anything"); len(sources) != 0 {
+		t.Fatalf("expected no sources before any context was built, got %v", sources)
+	}
+
+	// Files become sources once sent to the model, in an update or a full summary
+	kb.getContextUpdate(0, 0, map[string]bool{"util.go": true})
+	if sources := kb.Sources(""); len(sources) != 1 || sources[0].Path != "main.go" {
+		t.Fatalf("expected only main.go after the update, got %v", sources)
+	}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "server.go"), "package main")
+	kb.getContextSummary("question", 8000)
+
+	sources := kb.Sources("The entry point is in main.go.")
+	expected := []Source{{Path: "main.go", Cited: true}, {Path: "server.go", Cited: false}, {Path: "util.go", Cited: false}}
+	if len(sources) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, sources)
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], sources[i])
+		}
+	}
+}
+
+func TestAddAnalysis_KeepsSummaryInNotesAndFullTextForFinalContext(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.MaxAnalysisChars = 2000
+	conclusion := "The handler rejects expired tokens before checking the signature."
+	details := strings.Repeat("The middleware reads the Authorization header and validates it. ", 40)
+	kb.AddAnalysis("token validation", conclusion+"\n\n"+details)
+
+	if len(kb.AnalysisNotes) != 1 {
+		t.Fatalf("expected a single note, got %v", kb.AnalysisNotes)
+	}
+	note := kb.AnalysisNotes[0]
+	if !strings.Contains(note, conclusion) || len(note) > analysisNoteChars+200 {
+		t.Errorf("expected a short note starting with the conclusion, got %d characters: %s", len(note), note)
+	}
+	if full := kb.AnalysisDetails["token validation"]; !strings.HasPrefix(full, conclusion) || len(full) > 2000+3 {
+		t.Errorf("expected the full analysis capped at 2000 characters, got %d", len(full))
+	}
+
+	final := kb.getFinalContext("Why are tokens rejected?", 8000)
+	if !strings.Contains(final, "### token validation\n"+conclusion+"\n\nThe middleware") {
+		t.Errorf("expected the full analysis in the final context, got:\n%s", final)
+	}
+	if tokens := estimateTokens(final); tokens > 8000 {
+		t.Errorf("expected the final context to fit in 8000 tokens, got ~%d", tokens)
+	}
+
+	// Short analyses are kept as is
+	kb.AddAnalysis("entry point", "main.go starts the server.")
+	if note := kb.AnalysisNotes[1]; note != "Analysis of 'entry point': main.go starts the server." {
+		t.Errorf("unexpected note for a short analysis: %s", note)
+	}
+}
+
+func TestGetFinalContext_FullContents(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.MaxExcerptChars = 100
+	config.AppConfig.Analysis.FullContentsMaxBytes = 2000
+	handler := "package main\n\n" + strings.Repeat("// The handler validates the token.\n", 20) + "func handle() {}\n"
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "handler.go"), handler)
+
+	final := kb.getFinalContext("How is the token validated?", 8000)
+	if !strings.Contains(final, "Fichiers Lus (Contenu Complet):\n- `handler.go`\n```\n"+strings.TrimRight(handler, "\n")+"\n```\n") {
+		t.Errorf("expected the whole file in the final context, got:\n%s", final)
+	}
+	// The planner keeps the excerpts
+	if summary := kb.getContextSummary("How is the token validated?", 8000); strings.Contains(summary, "func handle()") {
+		t.Errorf("expected only an excerpt in the planning context, got:\n%s", summary)
+	}
+	// Over the prompt budget
+	if final := kb.getFinalContext("How is the token validated?", 300); strings.Contains(final, "Contenu Complet") {
+		t.Errorf("expected excerpts when the files don't fit in the budget, got:\n%s", final)
+	}
+
+	// Over analysis.full_contents_max_bytes
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "store.go"), "package main\n\n"+strings.Repeat("// The store keeps the sessions.\n", 50))
+	if final := kb.getFinalContext("How is the token validated?", 8000); !strings.Contains(final, "Fichiers Lus (Extraits):") {
+		t.Errorf("expected excerpts above the size threshold, got:\n%s", final)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// languageByExtension associe les extensions de fichiers courantes à leur langage.
+var languageByExtension = map[string]string{
+	".go":    "Go",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".cjs":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".py":    "Python",
+	".rb":    "Ruby",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".scala": "Scala",
+	".rs":    "Rust",
+	".c":     "C",
+	".h":     "C",
+	".cpp":   "C++",
+	".cc":    "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".php":   "PHP",
+	".swift": "Swift",
+	".dart":  "Dart",
+	".lua":   "Lua",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".sql":   "SQL",
+	".html":  "HTML",
+	".css":   "CSS",
+	".scss":  "SCSS",
+	".vue":   "Vue",
+	".proto": "Protocol Buffers",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".json":  "JSON",
+	".toml":  "TOML",
+	".md":    "Markdown",
+}
+
+// languageByFileName couvre les fichiers reconnus à leur nom, souvent sans extension.
+var languageByFileName = map[string]string{
+	"Dockerfile":     "Dockerfile",
+	"Makefile":       "Makefile",
+	"makefile":       "Makefile",
+	"GNUmakefile":    "Makefile",
+	"CMakeLists.txt": "CMake",
+	"Gemfile":        "Ruby",
+	"Rakefile":       "Ruby",
+	"Jenkinsfile":    "Groovy",
+}
+
+// languageForFile retourne le langage d'un fichier d'après son nom ou son
+// extension, ou une chaîne vide s'il n'est pas reconnu.
+func languageForFile(name string) string {
+	if lang, ok := languageByFileName[name]; ok {
+		return lang
+	}
+	// Variantes courantes comme Dockerfile.dev
+	if strings.HasPrefix(name, "Dockerfile.") {
+		return "Dockerfile"
+	}
+	return languageByExtension[strings.ToLower(filepath.Ext(name))]
+}
+
+// Conventions de nommage des fichiers de test : suffixes du nom (*_test.go,
+// FooTest.java...), suffixes du nom sans son extension (app.test.js,
+// app.spec.ts) et préfixes réservés à Python (test_app.py).
+var (
+	testFileSuffixes     = []string{"_test.go", "_test.py", "_test.rb", "_spec.rb", "_test.exs", "Test.java", "Tests.java", "Test.kt", "Tests.cs", "Test.php"}
+	testFileStemSuffixes = []string{".test", ".spec"}
+)
+
+// isTestFile indique si name est un fichier de test d'après son nom.
+func isTestFile(name string) bool {
+	for _, suffix := range testFileSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+	if languageForFile(name) == "" {
+		return false
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, suffix := range testFileStemSuffixes {
+		if strings.HasSuffix(stem, suffix) && len(stem) > len(suffix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "test_") && filepath.Ext(name) == ".py"
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"fmt"
+	"strings"
+)
+
+// LLMClient est l'interface commune aux fournisseurs de modèles utilisés par
+// les moteurs d'analyse. Toutes les implémentations tronquent les prompts,
+// respectent l'annulation de ctx et retentent les erreurs transitoires de la
+// même façon, pour que le moteur n'ait pas à savoir à qui il s'adresse.
+type LLMClient interface {
+	// Request envoie un message système et un prompt, et retourne la réponse.
+	Request(ctx context.Context, systemMessage, userPrompt string) (string, error)
+
+	// ChatRequest envoie une conversation complète et retourne la réponse de l'assistant.
+	ChatRequest(ctx context.Context, messages []ChatMessage) (string, error)
+
+	// StreamRequest appelle callback pour chaque fragment de la réponse.
+	StreamRequest(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error
+}
+
+// NewLLMClient crée le client du fournisseur choisi par llm.provider. model
+// remplace le modèle configuré pour ce fournisseur ; vide, il est ignoré.
+func NewLLMClient(ctx context.Context, model string) (LLMClient, error) {
+	switch provider := strings.ToLower(strings.TrimSpace(config.FromContext(ctx).LLM.Provider)); provider {
+	case "", "ollama":
+		client, err := NewOllamaClientForModel(ctx, model)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "openai":
+		client, err := NewOpenAIClient(ctx, model)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("fournisseur LLM inconnu '%s' (llm.provider doit valoir \"ollama\" ou \"openai\")", provider)
+	}
+}
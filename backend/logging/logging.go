@@ -11,7 +11,7 @@ import (
 
 // InitLogger initializes the logger based on the configuration.
 func InitLogger() {
-	cfg := config.AppConfig.Logging
+	cfg := config.Get().Logging
 
 	// Set log level
 	level, err := logrus.ParseLevel(cfg.Level)
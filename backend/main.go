@@ -1,31 +1,96 @@
 package main
 
 import (
+	"context"
 	"debugagent/config"
 	"debugagent/logging"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
 
 // AnalyzeResponse defines the structure for the API response.
 type AnalyzeResponse struct {
-	Answer string `json:"answer"`
+	Answer  string        `json:"answer"`
+	Sources []Source      `json:"sources"` // Files the answer is based on
+	Stats   AnalysisStats `json:"stats"`   // Model calls, prompt size, iterations and files read
+
+	Confidence string `json:"confidence,omitempty"` // Self-assessment of the answer: high, medium or low
+	Unresolved string `json:"unresolved,omitempty"` // What the model could not determine from the files it saw
+
+	// Answer to each question of a request with "questions", the fields above are the first one's
+	Answers map[string]QuestionAnswer `json:"answers,omitempty"`
+
+	SkippedFiles int `json:"skipped_files,omitempty"` // Uploaded files dropped by explorer.ignore_dirs and ignore_extensions
+
+	// Plan and step outcomes of each exploration iteration, with verbose=true only
+	ExplorationTrace []IterationTrace `json:"exploration_trace,omitempty"`
+}
+
+// IncrementalAnalyzeResponse is the answer of /analyze-incremental. CacheID
+// identifies the session to send the next changes to.
+type IncrementalAnalyzeResponse struct {
+	Answer     string        `json:"answer"`
+	Sources    []Source      `json:"sources"`
+	Stats      AnalysisStats `json:"stats"`
+	CacheID    string        `json:"cache_id"`
+	Confidence string        `json:"confidence,omitempty"`
+	Unresolved string        `json:"unresolved,omitempty"`
+	Changed    []string      `json:"changed"`    // Uploaded files that are new or differ from the session
+	Unchanged  []string      `json:"unchanged"`  // Uploaded files identical to the session's, ignored
+	Deleted    []string      `json:"deleted"`    // Files removed from the session
+	Reused     []string      `json:"reused"`     // Cached file contents kept from the previous runs
+	Recomputed []string      `json:"recomputed"` // Cached file contents dropped because the file changed
+
+	// Answer to each question of a request with "questions", the fields above are the first one's
+	Answers map[string]QuestionAnswer `json:"answers,omitempty"`
+
+	SkippedFiles int `json:"skipped_files,omitempty"` // Uploaded files dropped by explorer.ignore_dirs and ignore_extensions
+}
+
+// DryRunResponse is the answer of /analyze with dry_run=true: the steps the
+// planner would take first, without executing them.
+type DryRunResponse struct {
+	ProjectType string     `json:"project_type"`
+	Plan        []PlanStep `json:"plan"`
+}
+
+// ModelsResponse defines the structure for the /models response.
+type ModelsResponse struct {
+	Models  []ModelInfo `json:"models"`
+	Current string      `json:"current"` // Model configured in ollama.model
 }
 
 // ProgressEvent defines the structure for streaming progress events
 type ProgressEvent struct {
-	Type      string `json:"type"`      // "progress", "step", "result", "error"
-	Step      string `json:"step"`      // Current step description
-	Message   string `json:"message"`   // Progress message
-	Iteration int    `json:"iteration"` // Current iteration number
-	Total     int    `json:"total"`     // Total iterations
-	Data      string `json:"data"`      // Additional data (final answer, etc.)
+	Type       string                    `json:"type"`                 // "started", "progress", "queued", "step", "finding", "token", "result", "error", "cancelled"
+	Step       string                    `json:"step"`                 // Current step description
+	Message    string                    `json:"message"`              // Progress message
+	Iteration  int                       `json:"iteration"`            // Current iteration number
+	Total      int                       `json:"total"`                // Total iterations
+	Data       string                    `json:"data"`                 // Additional data (final answer, text of a finding, etc.)
+	Sources    []Source                  `json:"sources,omitempty"`    // Files the final answer is based on ("result" only)
+	Stats      *AnalysisStats            `json:"stats,omitempty"`      // Cost of the analysis ("result" only)
+	Confidence string                    `json:"confidence,omitempty"` // Self-assessment of the answer: high, medium or low ("result" only)
+	Unresolved string                    `json:"unresolved,omitempty"` // What the model could not determine ("result" only)
+	Answers    map[string]QuestionAnswer `json:"answers,omitempty"`    // Answer to each question of a request with "questions" ("result" only)
+	RequestID  string                    `json:"request_id,omitempty"` // ID of the analysis, as in the X-Request-ID header
 }
 
 // CORS middleware to handle cross-origin requests
@@ -35,6 +100,8 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Cache-Control")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		w.Header().Set("Timing-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		// Handle preflight OPTIONS request
@@ -48,102 +115,313 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// recoverMiddleware turns a panic in a handler (typically in the analysis
+// engine) into an error response. The handler's deferred calls, such as the
+// removal of the upload directory, have already run when the panic reaches it.
+func recoverMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				requestLogger(r.Context()).Errorf("Panic while handling %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				if w.Header().Get("Content-Type") == "text/event-stream" {
+					sendSSEError(w, "Internal error during analysis")
+					return
+				}
+				writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "Internal error during analysis")
+			}
+		}()
+		next(w, r)
+	}
+}
+
 func analyzeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, http.MethodPost)
 		return
 	}
+	uploadStart := time.Now()
 
 	// Parse the multipart form data
-	err := r.ParseMultipartForm(32 << 20) // 32MB max memory
-	if err != nil {
-		http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
+	if status, err := parseUploadForm(w, r); err != nil {
+		writeJSONError(w, status, uploadErrorCode(status), err.Error())
 		return
 	}
 
-	// Get the question from the form data
-	question := r.FormValue("question")
-	if question == "" {
-		http.Error(w, "Missing 'question' field", http.StatusBadRequest)
+	// Get the question, or the questions sharing the exploration, from the form data
+	questions, ok := requireQuestions(w, r.FormValue("question"), r.MultipartForm.Value["questions"])
+	if !ok {
 		return
 	}
 
-	// Create a temporary directory to store the uploaded files
-	tempDir, err := os.MkdirTemp("", "uploaded-project-")
+	// dry_run=true only returns the first plan, to iterate on the planner prompt
+	dryRun, err := parseBoolField("dry_run", r.FormValue("dry_run"))
 	if err != nil {
-		http.Error(w, "Error creating temporary directory", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	// verbose=true, in the form or the query, adds the exploration trace to the response
+	verbose, err := parseBoolField("verbose", r.FormValue("verbose"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	maxDepth, err := parseMaxDepth(r.FormValue("max_depth"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	systemPrompt, err := validateSystemPrompt(r.FormValue("system_prompt"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	language, err := validateLanguage(r.FormValue("language"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
-	defer os.RemoveAll(tempDir)
 
-	// Get the files from the form data
-	files := r.MultipartForm.File["files"]
-	if len(files) == 0 {
-		http.Error(w, "No files uploaded", http.StatusBadRequest)
+	// Save the uploaded files or archive, or read the project_path in place
+	upload, uerr := receiveUpload(r)
+	if uerr != nil {
+		writeJSONError(w, uerr.status, uerr.code, uerr.Error())
 		return
 	}
+	defer upload.remove()
+	timings := []PhaseTiming{{Name: timingUpload, Duration: time.Since(uploadStart)}}
 
-	for _, fileHeader := range files {
-		// Open the uploaded file
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, "Error opening uploaded file", http.StatusInternalServerError)
-			return
+	// Wait for an analysis slot so that concurrent requests don't overload Ollama
+	queueStart := time.Now()
+	release, err := analyses.acquire(r.Context(), queueTimeout(), nil)
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			writeServerBusy(w)
 		}
-		defer file.Close()
+		return // Otherwise the client went away
+	}
+	defer release()
+	timings = append(timings, PhaseTiming{Name: timingQueue, Duration: time.Since(queueStart)})
 
-		// Create the file in the temporary directory
-		// The client side sends relative paths, so we need to create the directory structure
-		destPath := filepath.Join(tempDir, fileHeader.Filename)
-		if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-			http.Error(w, "Error creating directory structure", http.StatusInternalServerError)
-			return
-		}
+	// --- Create and Run Analysis Engine ---
+	// The AnalyzeRequest struct is defined in engine.go, so we use it here
+	req := AnalyzeRequest{
+		ProjectPath:  upload.Dir,
+		Model:        r.FormValue("model"), // Empty falls back to the configured model
+		MaxDepth:     maxDepth,
+		SystemPrompt: systemPrompt,
+		Language:     language,
+		SingleFile:   upload.SingleFile,
+		Verbose:      verbose,
+		Cached:       !upload.temp,
+	}.withQuestions(questions)
 
-		destFile, err := os.Create(destPath)
+	engine, err := NewAnalysisEngine(r.Context(), req)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error initializing analysis engine: %v", err))
+		return
+	}
+
+	if dryRun {
+		plan, err := engine.DryRun()
 		if err != nil {
-			http.Error(w, "Error creating file in temporary directory", http.StatusInternalServerError)
+			writeAnalysisError(w, "Error during planning", err)
 			return
 		}
-		defer destFile.Close()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DryRunResponse{ProjectType: engine.kb.ProjectType, Plan: plan})
+		return
+	}
+
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		writeAnalysisError(w, "Error during analysis", err)
+		return
+	}
+
+	// --- Send Response ---
+	resp := AnalyzeResponse{
+		Answer:       result.Answer,
+		Sources:      result.Sources,
+		Stats:        result.Stats,
+		Confidence:   result.Confidence,
+		Unresolved:   result.Unresolved,
+		Answers:      answersByQuestion(result.Answers),
+		SkippedFiles: upload.Skipped,
+
+		ExplorationTrace: result.Trace,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Server-Timing", serverTiming(append(timings, result.Timings...)))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// analyzeIncrementalHandler re-analyzes a project kept in a session, for
+// iterative debugging. The first request uploads the whole project (files or
+// archive) without cache_id; the following ones send the cache_id returned
+// along with the changed files and the names of the deleted ones ("deleted").
+// Only the cached findings about changed files are dropped, and the
+// exploration is limited to analysis.incremental_iterations.
+func analyzeIncrementalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if status, err := parseUploadForm(w, r); err != nil {
+		writeJSONError(w, status, uploadErrorCode(status), err.Error())
+		return
+	}
+
+	// Get the question, or the questions sharing the exploration, from the form data
+	questions, ok := requireQuestions(w, r.FormValue("question"), r.MultipartForm.Value["questions"])
+	if !ok {
+		return
+	}
+
+	maxDepth, err := parseMaxDepth(r.FormValue("max_depth"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	systemPrompt, err := validateSystemPrompt(r.FormValue("system_prompt"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	language, err := validateLanguage(r.FormValue("language"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	files := r.MultipartForm.File["files"]
+	archives := r.MultipartForm.File["archive"]
+	deleted := r.MultipartForm.Value["deleted"]
+	cacheID := r.FormValue("cache_id")
+	if len(files) == 0 && len(archives) == 0 && (cacheID == "" || len(deleted) == 0) {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeNoFiles, "No files uploaded")
+		return
+	}
+
+	// The upload is staged first, so that only the files whose content
+	// changed are written to the session, and checked before creating one
+	stagingDir, err := os.MkdirTemp("", "uploaded-changes-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "Error creating temporary directory")
+		return
+	}
+	defer os.RemoveAll(stagingDir)
 
-		// Copy the file content
-		if _, err := io.Copy(destFile, file); err != nil {
-			http.Error(w, "Error copying file content", http.StatusInternalServerError)
+	var skipped int
+	if len(archives) > 0 {
+		if skipped, err = extractArchive(archives[0], stagingDir); err != nil {
+			writeJSONError(w, archiveErrorStatus(err), archiveErrorCode(err), fmt.Sprintf("Error extracting archive: %v", err))
 			return
 		}
+		files = nil
 	}
+	if err := validateUploadPaths(stagingDir, files); err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidFileName, err.Error())
+		return
+	}
+	savedSkipped, err := saveUploadedFiles(stagingDir, files)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	skipped += savedSkipped
+
+	var session *analysisSession
+	if cacheID == "" {
+		session, err = newSession()
+	} else {
+		session, err = openSession(cacheID)
+	}
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	defer session.close()
+
+	changes, err := session.applyUpload(stagingDir, deleted)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Error updating analysis session: %v", err))
+		return
+	}
+	release, err := analyses.acquire(r.Context(), queueTimeout(), nil)
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			writeServerBusy(w)
+		}
+		return // Otherwise the client went away
+	}
+	defer release()
 
-	// --- Create and Run Analysis Engine ---
-	// The AnalyzeRequest struct is defined in engine.go, so we use it here
 	req := AnalyzeRequest{
-		ProjectPath: tempDir,
-		Question:    question,
+		ProjectPath:  session.projectDir(),
+		Model:        r.FormValue("model"), // Empty falls back to the configured model
+		MaxDepth:     maxDepth,
+		SystemPrompt: systemPrompt,
+		Language:     language,
+		Cached:       true,
+	}.withQuestions(questions)
+	if cacheID != "" {
+		req.MaxIterations = config.Get().Analysis.IncrementalIterations
 	}
 
-	engine, err := NewAnalysisEngine(req)
+	engine, err := NewAnalysisEngine(r.Context(), req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error initializing analysis engine: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error initializing analysis engine: %v", err))
 		return
 	}
+	reused, recomputed := engine.invalidateFiles(append(changes.Changed, changes.Deleted...))
 
-	finalAnswer, err := engine.RunAnalysis()
+	result, err := engine.RunAnalysis()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error during analysis: %v", err), http.StatusInternalServerError)
+		writeAnalysisError(w, "Error during analysis", err)
 		return
 	}
+	// Recorded for the follow-up questions, see analyzeFollowupHandler
+	if len(result.Answers) == 0 {
+		engine.kb.AddPreviousQuestion(req.Question, result.Answer)
+	}
+	for _, answer := range result.Answers {
+		engine.kb.AddPreviousQuestion(answer.Question, answer.Answer)
+	}
+	saveKnowledgeBaseCache(engine.kb, engine.cacheDir())
 
-	// --- Send Response ---
-	resp := AnalyzeResponse{
-		Answer: finalAnswer,
+	// Saved last: after a failed run, the changed files are still seen as
+	// changed next time, so that stale findings about them are dropped
+	if err := session.save(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error saving analysis session: %v", err))
+		return
 	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(IncrementalAnalyzeResponse{
+		Answer:     result.Answer,
+		Sources:    result.Sources,
+		Stats:      result.Stats,
+		CacheID:    session.ID,
+		Confidence: result.Confidence,
+		Unresolved: result.Unresolved,
+		Answers:    answersByQuestion(result.Answers),
+		Changed:    changes.Changed,
+		Unchanged:  changes.Unchanged,
+		Deleted:    changes.Deleted,
+		Reused:     reused,
+		Recomputed: recomputed,
+
+		SkippedFiles: skipped,
+	})
 }
 
 func analyzeStreamHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, http.MethodPost)
 		return
 	}
 
@@ -154,97 +432,530 @@ func analyzeStreamHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
 
 	// Parse the multipart form data
-	err := r.ParseMultipartForm(32 << 20) // 32MB max memory
-	if err != nil {
-		sendSSEError(w, "Error parsing multipart form")
+	if status, err := parseUploadForm(w, r); err != nil {
+		w.WriteHeader(status)
+		sendSSEError(w, err.Error())
 		return
 	}
 
-	// Get the question from the form data
-	question := r.FormValue("question")
-	if question == "" {
-		sendSSEError(w, "Missing 'question' field")
+	// Get the question, or the questions sharing the exploration, from the form data
+	questions, err := requiredQuestions(r.FormValue("question"), r.MultipartForm.Value["questions"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendSSEError(w, err.Error())
 		return
 	}
-
-	// Create a temporary directory to store the uploaded files
-	tempDir, err := os.MkdirTemp("", "uploaded-project-")
+	maxDepth, err := parseMaxDepth(r.FormValue("max_depth"))
 	if err != nil {
-		sendSSEError(w, "Error creating temporary directory")
+		w.WriteHeader(http.StatusBadRequest)
+		sendSSEError(w, err.Error())
+		return
+	}
+	systemPrompt, err := validateSystemPrompt(r.FormValue("system_prompt"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendSSEError(w, err.Error())
+		return
+	}
+	language, err := validateLanguage(r.FormValue("language"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendSSEError(w, err.Error())
 		return
 	}
-	defer os.RemoveAll(tempDir)
 
-	// Get the files from the form data
-	files := r.MultipartForm.File["files"]
-	if len(files) == 0 {
-		sendSSEError(w, "No files uploaded")
+	// Save the uploaded files or archive, or read the project_path in place
+	upload, uerr := receiveUpload(r)
+	if uerr != nil {
+		w.WriteHeader(uerr.status)
+		sendSSEError(w, uerr.Error())
 		return
 	}
+	defer upload.remove()
+
+	// The analysis can be stopped with POST /cancel/{id}, the ID sent in the first event
+	analysisID := requestIDFromContext(r.Context())
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	defer registerAnalysis(analysisID, cancel)()
+	sendSSEEvent(w, ProgressEvent{
+		Type:    "started",
+		Step:    "init",
+		Message: "Analysis started",
+		Data:    analysisID,
+	})
 
 	// Send initial progress
 	sendSSEEvent(w, ProgressEvent{
 		Type:    "progress",
 		Step:    "upload",
-		Message: fmt.Sprintf("Processing %d uploaded files...", len(files)),
+		Message: upload.Message,
 	})
+	if upload.Skipped > 0 {
+		sendSSEEvent(w, skippedFilesEvent(upload.Skipped))
+	}
 
-	// Process uploaded files
-	for _, fileHeader := range files {
-		// Open the uploaded file
-		file, err := fileHeader.Open()
-		if err != nil {
-			sendSSEError(w, "Error opening uploaded file")
-			return
+	// Wait for an analysis slot, telling the client where it stands in the queue
+	release, err := analyses.acquire(ctx, queueTimeout(), func(position int) {
+		sendSSEEvent(w, ProgressEvent{
+			Type:    "queued",
+			Step:    "queue",
+			Message: fmt.Sprintf("Queued, position %d", position),
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			// The queued events already sent the status, so the 503 can only be reported in the stream
+			sendSSEError(w, "Server busy: too many analyses in progress, try again later")
+		} else if cancelledByClient(ctx) {
+			sendSSECancelled(w)
 		}
-		defer file.Close()
+		return // Otherwise the client went away
+	}
+	defer release()
 
-		// Create the file in the temporary directory
-		destPath := filepath.Join(tempDir, fileHeader.Filename)
-		if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-			sendSSEError(w, "Error creating directory structure")
-			return
+	// Send progress update
+	sendSSEEvent(w, ProgressEvent{
+		Type:    "progress",
+		Step:    "init",
+		Message: "Initializing analysis engine...",
+	})
+
+	// Create and run streaming analysis
+	req := AnalyzeRequest{
+		ProjectPath:  upload.Dir,
+		Model:        r.FormValue("model"), // Empty falls back to the configured model
+		MaxDepth:     maxDepth,
+		SystemPrompt: systemPrompt,
+		Language:     language,
+		SingleFile:   upload.SingleFile,
+		Cached:       !upload.temp,
+	}.withQuestions(questions)
+
+	// The analysis context derives from the request so that a disconnected
+	// browser stops the exploration loop instead of burning GPU time.
+	engine, err := NewStreamingAnalysisEngine(ctx, req)
+	if err != nil {
+		sendSSEError(w, fmt.Sprintf("Error initializing analysis engine: %v", err))
+		return
+	}
+
+	// Run the streaming analysis
+	engine.RunStreamingAnalysis(sseSink{w})
+}
+
+// WSAnalyzeRequest is the first message of a /analyze-ws connection, the
+// counterpart of the multipart form of the other analysis endpoints.
+type WSAnalyzeRequest struct {
+	Question     string   `json:"question"`
+	Questions    []string `json:"questions"`     // Optional, questions sharing the exploration, as the "questions" form fields
+	Model        string   `json:"model"`         // Empty falls back to the configured model
+	MaxDepth     int      `json:"max_depth"`     // Optional, as the "max_depth" form field
+	SystemPrompt string   `json:"system_prompt"` // Optional, as the "system_prompt" form field
+	Language     string   `json:"language"`      // Optional, as the "language" form field
+	Files        []WSFile `json:"files"`
+}
+
+// WSFile is a project file sent over the WebSocket. Content is plain text
+// unless Encoding is "base64".
+type WSFile struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// analyzeWSHandler streams the same progress events as analyzeStreamHandler
+// over a WebSocket, for networks whose proxies buffer or cut SSE responses.
+// The client sends a WSAnalyzeRequest as its first message; closing the
+// socket afterwards cancels the analysis.
+func analyzeWSHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		requestLogger(r.Context()).Warnf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.close(wsCloseNormal, "")
+	conn.readLimit = config.Get().Server.MaxUploadBytes
+	sink := wsSink{conn: conn, requestID: requestIDFromContext(r.Context())}
+
+	message, err := conn.readMessage()
+	if err != nil {
+		if errors.Is(err, errWebSocketTooLarge) {
+			sendWSError(sink, uploadTooLargeError(conn.maxMessageBytes(), 0).Error())
+			conn.close(wsCloseTooLarge, "message too large")
 		}
+		return
+	}
 
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			sendSSEError(w, "Error creating file in temporary directory")
-			return
+	var request WSAnalyzeRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		sendWSError(sink, fmt.Sprintf("Invalid analysis request: %v", err))
+		return
+	}
+	questions, err := requiredQuestions(request.Question, request.Questions)
+	if err != nil {
+		sendWSError(sink, err.Error())
+		return
+	}
+	if request.MaxDepth, err = validateMaxDepth(request.MaxDepth); err != nil {
+		sendWSError(sink, err.Error())
+		return
+	}
+	if request.SystemPrompt, err = validateSystemPrompt(request.SystemPrompt); err != nil {
+		sendWSError(sink, err.Error())
+		return
+	}
+	if request.Language, err = validateLanguage(request.Language); err != nil {
+		sendWSError(sink, err.Error())
+		return
+	}
+	if len(request.Files) == 0 {
+		sendWSError(sink, "No files uploaded")
+		return
+	}
+	if maxFiles := config.Get().Server.MaxUploadFiles; maxFiles > 0 && len(request.Files) > maxFiles {
+		sendWSError(sink, fmt.Sprintf("Too many files: %d uploaded, the limit is %d", len(request.Files), maxFiles))
+		return
+	}
+
+	// Create a temporary directory to store the uploaded files
+	tempDir, err := os.MkdirTemp("", "uploaded-project-")
+	if err != nil {
+		sendWSError(sink, "Error creating temporary directory")
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	sink.send(ProgressEvent{
+		Type:    "progress",
+		Step:    "upload",
+		Message: fmt.Sprintf("Processing %d uploaded files...", len(request.Files)),
+	})
+	skipped, err := saveWSFiles(tempDir, request.Files)
+	if err != nil {
+		sendWSError(sink, err.Error())
+		return
+	}
+	if skipped > 0 {
+		sink.send(skippedFilesEvent(skipped))
+	}
+
+	// Any further message is ignored, but a closed socket stops the analysis
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, err := conn.readMessage(); err != nil {
+				return
+			}
 		}
-		defer destFile.Close()
+	}()
 
-		// Copy the file content
-		if _, err := io.Copy(destFile, file); err != nil {
-			sendSSEError(w, "Error copying file content")
-			return
+	// Wait for an analysis slot, telling the client where it stands in the queue
+	release, err := analyses.acquire(ctx, queueTimeout(), func(position int) {
+		sink.send(ProgressEvent{
+			Type:    "queued",
+			Step:    "queue",
+			Message: fmt.Sprintf("Queued, position %d", position),
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrQueueTimeout) {
+			sendWSError(sink, "Server busy: too many analyses in progress, try again later")
 		}
+		return // Otherwise the client went away
 	}
+	defer release()
 
-	// Send progress update
-	sendSSEEvent(w, ProgressEvent{
+	sink.send(ProgressEvent{
 		Type:    "progress",
 		Step:    "init",
 		Message: "Initializing analysis engine...",
 	})
 
-	// Create and run streaming analysis
 	req := AnalyzeRequest{
-		ProjectPath: tempDir,
-		Question:    question,
+		ProjectPath:  tempDir,
+		Model:        request.Model,
+		MaxDepth:     request.MaxDepth,
+		SystemPrompt: request.SystemPrompt,
+		Language:     request.Language,
+		SingleFile:   isSingleFileUpload(len(request.Files), 0),
+	}.withQuestions(questions)
+	engine, err := NewStreamingAnalysisEngine(ctx, req)
+	if err != nil {
+		sendWSError(sink, fmt.Sprintf("Error initializing analysis engine: %v", err))
+		return
+	}
+
+	engine.RunStreamingAnalysis(sink)
+}
+
+// saveWSFiles validates every path before writing anything, like the
+// multipart handlers do, then writes the files into tempDir. It returns the
+// number of files skipped by isIgnoredUpload.
+func saveWSFiles(tempDir string, files []WSFile) (int, error) {
+	for _, file := range files {
+		if _, err := safeUploadPath(tempDir, file.Path); err != nil {
+			return 0, err
+		}
 	}
 
-	engine, err := NewStreamingAnalysisEngine(req)
+	skipped := 0
+	for _, file := range files {
+		if isIgnoredUpload(file.Path) {
+			skipped++
+			continue
+		}
+		destPath, _ := safeUploadPath(tempDir, file.Path)
+		var content io.Reader = strings.NewReader(file.Content)
+		switch file.Encoding {
+		case "":
+		case "base64":
+			content = base64.NewDecoder(base64.StdEncoding, content)
+		default:
+			return 0, fmt.Errorf("unsupported encoding '%s' for '%s'", file.Encoding, file.Path)
+		}
+		if err := writeUploadedFile(destPath, content); err != nil {
+			return 0, err
+		}
+	}
+	return skipped, nil
+}
+
+func sendWSError(sink wsSink, message string) {
+	sink.send(ProgressEvent{
+		Type:    "error",
+		Message: message,
+	})
+}
+
+// parseUploadForm parses the multipart upload while enforcing the configured
+// size and file count limits. It returns the HTTP status to answer with on error.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) (int, error) {
+	maxBytes := config.Get().Server.MaxUploadBytes
+	if maxBytes > 0 {
+		if r.ContentLength > maxBytes {
+			return http.StatusRequestEntityTooLarge, uploadTooLargeError(maxBytes, r.ContentLength)
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB max memory, the rest goes to disk
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return http.StatusRequestEntityTooLarge, uploadTooLargeError(maxBytes, r.ContentLength)
+		}
+		return http.StatusBadRequest, fmt.Errorf("Error parsing multipart form: %v", err)
+	}
+
+	maxFiles := config.Get().Server.MaxUploadFiles
+	if count := len(r.MultipartForm.File["files"]); maxFiles > 0 && count > maxFiles {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("Too many files: %d uploaded, the limit is %d", count, maxFiles)
+	}
+	return http.StatusOK, nil
+}
+
+// uploadTooLargeError describes an upload rejected by server.max_upload_bytes.
+// The attempted size is only known when the client sent a Content-Length.
+func uploadTooLargeError(maxBytes, attempted int64) error {
+	if attempted > 0 {
+		return fmt.Errorf("Upload too large: %d bytes sent, the limit is %d bytes", attempted, maxBytes)
+	}
+	return fmt.Errorf("Upload too large: the limit is %d bytes", maxBytes)
+}
+
+// maxRequestDepth caps the max_depth field of the analysis requests: deeper
+// structures would not fit in the prompt anyway.
+const maxRequestDepth = 20
+
+// parseMaxDepth parses the optional max_depth form field, which overrides
+// analysis.max_directory_depth for one request. It returns 0 when the field
+// is empty.
+func parseMaxDepth(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth <= 0 {
+		return 0, errors.New("Invalid 'max_depth' field, expected a positive integer")
+	}
+	return validateMaxDepth(depth)
+}
+
+// parseBoolField parses the optional boolean form field name, false when
+// value is empty.
+func parseBoolField(name, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
 	if err != nil {
-		sendSSEError(w, fmt.Sprintf("Error initializing analysis engine: %v", err))
-		return
+		return false, fmt.Errorf("Invalid '%s' field, expected true or false", name)
 	}
+	return parsed, nil
+}
 
-	// Run the streaming analysis
-	engine.RunStreamingAnalysis(w)
+// validateMaxDepth checks a max_depth override, 0 meaning none, and clamps it
+// to maxRequestDepth.
+func validateMaxDepth(depth int) (int, error) {
+	if depth < 0 {
+		return 0, errors.New("Invalid 'max_depth' field, expected a positive integer")
+	}
+	return min(depth, maxRequestDepth), nil
+}
+
+// maxSystemPromptLength caps the system_prompt field, in characters.
+const maxSystemPromptLength = 4000
+
+// validateSystemPrompt checks the optional system_prompt field, which
+// overrides analysis.final_system_prompt for one request. It returns the
+// trimmed prompt, empty when there is no override.
+func validateSystemPrompt(prompt string) (string, error) {
+	prompt = strings.TrimSpace(prompt)
+	if utf8.RuneCountInString(prompt) > maxSystemPromptLength {
+		return "", fmt.Errorf("Invalid 'system_prompt' field, longer than %d characters", maxSystemPromptLength)
+	}
+	return prompt, nil
+}
+
+// validateLanguage checks the optional language field, the code of the
+// language of the final answer among answerLanguages. It returns the
+// lowercase code, empty when there is none.
+func validateLanguage(language string) (string, error) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if _, ok := answerLanguages[language]; language != "" && !ok {
+		codes := make([]string, 0, len(answerLanguages))
+		for code := range answerLanguages {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		return "", fmt.Errorf("Invalid 'language' field '%s', expected one of %s", language, strings.Join(codes, ", "))
+	}
+	return language, nil
+}
+
+// safeUploadPath returns the destination of an uploaded file inside tempDir,
+// or an error if its (client-controlled) name would escape the directory.
+func safeUploadPath(tempDir, fileName string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(fileName))
+	destPath := filepath.Join(tempDir, cleaned)
+	if destPath == tempDir || !isWithinDir(tempDir, destPath) {
+		return "", fmt.Errorf("invalid file name '%s': path escapes the upload directory", fileName)
+	}
+	return destPath, nil
+}
+
+// isIgnoredUpload reports whether the uploaded file name lies in one of the
+// explorer.ignore_dirs (node_modules, build...) or has one of the
+// explorer.ignore_extensions. Such files would be hidden from the analysis
+// anyway, so they are not written at all.
+func isIgnoredUpload(fileName string) bool {
+	cfg := config.Get()
+	if hasIgnoredExtension(cfg, fileName) {
+		return true
+	}
+	parts := strings.Split(path.Clean(filepath.ToSlash(fileName)), "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if slices.Contains(cfg.Explorer.IgnoreDirs, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// skippedFilesEvent reports the uploaded files dropped by isIgnoredUpload.
+func skippedFilesEvent(skipped int) ProgressEvent {
+	return ProgressEvent{
+		Type:    "progress",
+		Step:    "upload",
+		Message: fmt.Sprintf("Skipped %d files in ignored directories or with ignored extensions", skipped),
+		Data:    strconv.Itoa(skipped),
+	}
+}
+
+// validateUploadPaths checks every uploaded file name with safeUploadPath.
+func validateUploadPaths(tempDir string, files []*multipart.FileHeader) error {
+	for _, fileHeader := range files {
+		if _, err := safeUploadPath(tempDir, fileHeader.Filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveUploadedFiles copies the uploaded files into tempDir, except those
+// matched by isIgnoredUpload, and returns how many were skipped. Each file is
+// closed as soon as it has been copied, so that large uploads don't hold one
+// descriptor per file until the handler returns.
+func saveUploadedFiles(tempDir string, files []*multipart.FileHeader) (int, error) {
+	skipped := 0
+	for _, fileHeader := range files {
+		if isIgnoredUpload(fileHeader.Filename) {
+			skipped++
+			continue
+		}
+		if err := saveUploadedFile(tempDir, fileHeader); err != nil {
+			return 0, err
+		}
+	}
+	return skipped, nil
+}
+
+func saveUploadedFile(tempDir string, fileHeader *multipart.FileHeader) error {
+	// The client side sends relative paths, so we need to create the directory structure
+	destPath, err := safeUploadPath(tempDir, fileHeader.Filename)
+	if err != nil {
+		return err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return errors.New("Error opening uploaded file")
+	}
+	defer file.Close()
+
+	return writeUploadedFile(destPath, file)
+}
+
+// writeUploadedFile writes content to destPath, creating its parent directories.
+func writeUploadedFile(destPath string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return errors.New("Error creating directory structure")
+	}
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return errors.New("Error creating file in temporary directory")
+	}
+	if _, err := io.Copy(destFile, content); err != nil {
+		destFile.Close()
+		return errors.New("Error copying file content")
+	}
+	if err := destFile.Close(); err != nil {
+		return errors.New("Error copying file content")
+	}
+	return nil
+}
+
+// progressSink receives the events of a streaming analysis, whatever the
+// transport (Server-Sent Events or WebSocket).
+type progressSink interface {
+	send(event ProgressEvent)
+}
+
+// sseSink sends progress events as Server-Sent Events.
+type sseSink struct {
+	w http.ResponseWriter
+}
+
+func (s sseSink) send(event ProgressEvent) {
+	sendSSEEvent(s.w, event)
 }
 
 // SSE helper functions
 func sendSSEEvent(w http.ResponseWriter, event ProgressEvent) {
+	event.RequestID = w.Header().Get(requestIDHeader)
 	data, _ := json.Marshal(event)
 	fmt.Fprintf(w, "data: %s\n\n", data)
 	if f, ok := w.(http.Flusher); ok {
@@ -259,30 +970,146 @@ func sendSSEError(w http.ResponseWriter, message string) {
 	})
 }
 
+// sendSSECancelled sends the last event of an analysis cancelled through
+// POST /cancel/{id}.
+func sendSSECancelled(w http.ResponseWriter) {
+	sendSSEEvent(w, ProgressEvent{
+		Type:    "cancelled",
+		Step:    "cancelled",
+		Message: "Analysis cancelled",
+	})
+}
+
+// modelsHandler lists the models installed on the configured Ollama server.
+func modelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	client, err := NewOllamaClient(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error initializing Ollama client: %v", err))
+		return
+	}
+
+	models, err := client.ListModels(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, ErrCodeOllamaUnavailable, fmt.Sprintf("Could not list models from the Ollama server at %s: %v", config.Get().Ollama.Host, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModelsResponse{
+		Models:  models,
+		Current: config.Get().Ollama.Model,
+	})
+}
+
+// HealthResponse defines the structure for the /health?deep=true response.
+type HealthResponse struct {
+	Status string       `json:"status"` // "ok" or "degraded"
+	Ollama OllamaHealth `json:"ollama"`
+}
+
+// OllamaHealth reports whether the configured Ollama server can serve analyses.
+type OllamaHealth struct {
+	Host           string `json:"host"`
+	Model          string `json:"model"`
+	Reachable      bool   `json:"reachable"`
+	ModelAvailable bool   `json:"model_available"`
+	Error          string `json:"error,omitempty"`
+}
+
+// healthCheckHandler is a cheap liveness probe. With ?deep=true it also checks
+// that Ollama is reachable and serves the configured model, answering 503 otherwise.
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	if r.URL.Query().Get("deep") != "true" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+
+	health := HealthResponse{
+		Status: "ok",
+		Ollama: checkOllamaHealth(r.Context()),
+	}
+	status := http.StatusOK
+	if !health.Ollama.Reachable || !health.Ollama.ModelAvailable {
+		health.Status = "degraded"
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// checkOllamaHealth lists the models of the configured Ollama server and looks
+// for the configured one.
+func checkOllamaHealth(ctx context.Context) OllamaHealth {
+	health := OllamaHealth{
+		Host:  config.Get().Ollama.Host,
+		Model: config.Get().Ollama.Model,
+	}
+
+	client, err := NewOllamaClient(ctx)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.Reachable = true
+
+	for _, m := range models {
+		// Ollama reports "llama3.2:latest" for a model configured as "llama3.2"
+		if m.Name == health.Model || m.Name == health.Model+":latest" {
+			health.ModelAvailable = true
+			return health
+		}
+	}
+	health.Error = fmt.Sprintf("model '%s' is not installed on the Ollama server", health.Model)
+	return health
 }
 
 func main() {
 	if err := config.LoadConfig(); err != nil {
 		logrus.Fatalf("Error loading configuration: %v", err)
 	}
+	if err := config.Get().Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
 
 	logging.InitLogger()
 
-	http.HandleFunc("/analyze", corsMiddleware(analyzeHandler))
-	http.HandleFunc("/analyze-stream", corsMiddleware(analyzeStreamHandler))
+	analyses = newAnalysisQueue(config.Get().Server.MaxConcurrentAnalyses)
+
+	http.HandleFunc("/analyze", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeHandler))))
+	http.HandleFunc("/analyze-stream", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeStreamHandler))))
+	http.HandleFunc("/analyze-incremental", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeIncrementalHandler))))
+	http.HandleFunc("/analyze/{id}/followup", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeFollowupHandler))))
+	http.HandleFunc("/analyze-git", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeGitHandler))))
+	http.HandleFunc("/analyze-async", corsMiddleware(requestIDMiddleware(recoverMiddleware(analyzeAsyncHandler))))
+	http.HandleFunc("/jobs/{id}", corsMiddleware(jobHandler))
+	http.HandleFunc("/jobs/{id}/result", corsMiddleware(jobResultHandler))
+	http.HandleFunc("/analyze-ws", requestIDMiddleware(recoverMiddleware(analyzeWSHandler)))
+	http.HandleFunc("/cancel/{id}", corsMiddleware(requestIDMiddleware(cancelHandler)))
 	http.HandleFunc("/health", corsMiddleware(healthCheckHandler))
+	http.HandleFunc("/models", corsMiddleware(modelsHandler))
+	http.HandleFunc("/admin/reload-config", requestIDMiddleware(reloadConfigHandler))
+	http.HandleFunc("/metrics", metricsHandler)
 
 	// Serve the frontend
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
 
-	port := fmt.Sprintf(":%d", config.AppConfig.Server.Port)
+	port := fmt.Sprintf(":%d", config.Get().Server.Port)
 	logrus.Infof("Starting server on port %s...", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	if err := http.ListenAndServe(port, metricsMiddleware(http.DefaultServeMux)); err != nil {
 		logrus.Fatalf("Failed to start server: %v", err)
 	}
 }
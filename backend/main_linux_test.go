@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// openFileDescriptors counts the descriptors currently open by the test process.
+func openFileDescriptors(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot list open descriptors: %v", err)
+	}
+	return len(entries)
+}
+
+func TestSaveUploadedFiles_ReleasesDescriptors(t *testing.T) {
+	setupUploadTest(0, 0)
+	const fileCount = 200
+	files := make(map[string]string, fileCount)
+	for i := 0; i < fileCount; i++ {
+		files[fmt.Sprintf("file%d.go", i)] = "package main"
+	}
+	req := newUploadRequest("/analyze", files)
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		t.Fatalf("ParseMultipartForm() returned error: %v", err)
+	}
+
+	// With a descriptor limit well below the number of files, keeping every
+	// file open until the end of the upload fails with "too many open files"
+	before := openFileDescriptors(t)
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatalf("Getrlimit() returned error: %v", err)
+	}
+	lowered := limit
+	lowered.Cur = uint64(before + 32)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skipf("cannot lower the descriptor limit: %v", err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+
+	tempDir := t.TempDir()
+	if _, err := saveUploadedFiles(tempDir, req.MultipartForm.File["files"]); err != nil {
+		t.Fatalf("saveUploadedFiles() returned error: %v", err)
+	}
+
+	if after := openFileDescriptors(t); after > before {
+		t.Errorf("expected descriptors to be released, %d open before and %d after", before, after)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "file13.go")); err != nil {
+		t.Errorf("expected uploaded file to be written: %v", err)
+	}
+}
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"debugagent/config"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -35,3 +46,485 @@ func TestHealthCheckHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestSafeUploadPath(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testCases := []struct {
+		name     string
+		fileName string
+		valid    bool
+	}{
+		{"simple file", "main.go", true},
+		{"nested relative path", "src/app/main.go", true},
+		{"dot segments staying inside", "src/../main.go", true},
+		{"absolute path is rooted in tempDir", "/etc/passwd", true},
+		{"parent traversal", "../../../../tmp/evil", false},
+		{"traversal after a directory", "src/../../evil", false},
+		{"parent directory itself", "..", false},
+		{"upload directory itself", ".", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			destPath, err := safeUploadPath(tempDir, tc.fileName)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("expected '%s' to be accepted, got: %v", tc.fileName, err)
+				}
+				if !strings.HasPrefix(destPath, tempDir+string(filepath.Separator)) {
+					t.Errorf("destination '%s' is outside '%s'", destPath, tempDir)
+				}
+			} else if err == nil {
+				t.Errorf("expected '%s' to be rejected, got '%s'", tc.fileName, destPath)
+			}
+		})
+	}
+}
+
+// setupUploadTest installs a configuration with the given upload limits.
+func setupUploadTest(maxBytes int64, maxFiles int) {
+	config.AppConfig = &config.Config{
+		Server: config.ServerConfig{
+			MaxUploadBytes: maxBytes,
+			MaxUploadFiles: maxFiles,
+		},
+	}
+}
+
+// newUploadRequest builds a multipart analysis request with the given files.
+func newUploadRequest(target string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	for name, content := range files {
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestAnalyzeHandler_RejectsTraversalFileName(t *testing.T) {
+	setupUploadTest(0, 0)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	part, _ := writer.CreateFormFile("files", "..")
+	part.Write([]byte("malicious"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	analyzeHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d (%s)", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAnalyzeStreamHandler_ReportsSkippedFiles(t *testing.T) {
+	setupUploadTest(0, 0)
+	config.AppConfig.Explorer.IgnoreExtensions = []string{".log"}
+	analyses = newAnalysisQueue(1)
+	t.Cleanup(func() { analyses = nil })
+	release, _ := analyses.acquire(t.Context(), 0, nil)
+	defer release()
+	config.AppConfig.Server.QueueTimeoutSeconds = 1 // Stop before the analysis, the slot is taken
+
+	req := newUploadRequest("/analyze-stream", map[string]string{
+		"main.go":   "package main",
+		"debug.log": "trace",
+		"build.log": "ok",
+	})
+	rr := httptest.NewRecorder()
+
+	analyzeStreamHandler(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `"message":"Skipped 2 files in ignored directories or with ignored extensions","iteration":0,"total":0,"data":"2"`) {
+		t.Errorf("expected an event reporting the 2 skipped files, got %s", rr.Body.String())
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	testCases := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"8", 8, false},
+		{"1000", maxRequestDepth, false},
+		{"0", 0, true},
+		{"-2", 0, true},
+		{"deep", 0, true},
+	}
+	for _, tc := range testCases {
+		got, err := parseMaxDepth(tc.value)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("parseMaxDepth(%q) = %d, %v; expected %d (error: %v)", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseBoolField(t *testing.T) {
+	testCases := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"true", true, false},
+		{"0", false, false},
+		{"yes", false, true},
+	}
+	for _, tc := range testCases {
+		got, err := parseBoolField("verbose", tc.value)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("parseBoolField(%q) = %v, %v; expected %v (error: %v)", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+	if _, err := parseBoolField("verbose", "yes"); err == nil || !strings.Contains(err.Error(), "'verbose'") {
+		t.Errorf("expected the field to be named in the error, got %v", err)
+	}
+}
+
+func TestValidateSystemPrompt(t *testing.T) {
+	if got, err := validateSystemPrompt("  Answer like a security auditor.\n"); got != "Answer like a security auditor." || err != nil {
+		t.Errorf("expected the trimmed prompt, got %q (%v)", got, err)
+	}
+	if _, err := validateSystemPrompt(strings.Repeat("x", maxSystemPromptLength+1)); err == nil {
+		t.Error("expected an error for a prompt over maxSystemPromptLength")
+	}
+}
+
+func TestValidateLanguage(t *testing.T) {
+	for value, want := range map[string]string{"": "", "fr": "fr", " EN ": "en"} {
+		if got, err := validateLanguage(value); got != want || err != nil {
+			t.Errorf("validateLanguage(%q) = %q, %v; expected %q", value, got, err, want)
+		}
+	}
+	if _, err := validateLanguage("klingon"); err == nil || !strings.Contains(err.Error(), "en, es, fr") {
+		t.Errorf("expected an error listing the supported languages, got %v", err)
+	}
+}
+
+func TestAnalyzeHandler_UploadTooLarge(t *testing.T) {
+	setupUploadTest(512, 0)
+	req := newUploadRequest("/analyze", map[string]string{"big.txt": strings.Repeat("x", 2048)})
+	rr := httptest.NewRecorder()
+
+	analyzeHandler(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	expected := fmt.Sprintf("%d bytes sent, the limit is 512 bytes", req.ContentLength)
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("expected the sizes in the message, got '%s'", rr.Body.String())
+	}
+}
+
+func TestAnalyzeHandler_UploadTooLargeWithoutContentLength(t *testing.T) {
+	setupUploadTest(512, 0)
+	req := newUploadRequest("/analyze", map[string]string{"big.txt": strings.Repeat("x", 2048)})
+	req.ContentLength = -1 // Chunked upload: only the body reader can enforce the limit
+	rr := httptest.NewRecorder()
+
+	analyzeHandler(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "the limit is 512 bytes") {
+		t.Errorf("expected the limit in the message, got '%s'", rr.Body.String())
+	}
+}
+
+func TestAnalyzeStreamHandler_TooManyFiles(t *testing.T) {
+	setupUploadTest(0, 2)
+	req := newUploadRequest("/analyze-stream", map[string]string{"a.go": "a", "b.go": "b", "c.go": "c"})
+	rr := httptest.NewRecorder()
+
+	analyzeStreamHandler(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "3 uploaded, the limit is 2") {
+		t.Errorf("expected an SSE error with the file count, got '%s'", rr.Body.String())
+	}
+}
+
+func TestRecoverMiddleware_CleansUpAfterPanic(t *testing.T) {
+	var tempDir string
+	handler := recoverMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		tempDir, _ = os.MkdirTemp("", "uploaded-project-")
+		defer os.RemoveAll(tempDir)
+		panic("engine failure")
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/analyze", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rr.Code)
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("expected temporary directory '%s' to be removed, stat returned %v", tempDir, err)
+	}
+
+	// Once streaming has started, the error is sent as an SSE event
+	streaming := recoverMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		panic("engine failure")
+	})
+	rr = httptest.NewRecorder()
+	streaming(rr, httptest.NewRequest(http.MethodPost, "/analyze-stream", nil))
+	if !strings.Contains(rr.Body.String(), `"type":"error"`) {
+		t.Errorf("expected an SSE error event, got %q", rr.Body.String())
+	}
+}
+
+func TestModelsHandler(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("expected a call to /api/tags, got %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"models":[{"name":"llama3.2:1b","size":1321098329,"details":{"family":"llama","parameter_size":"1.2B"}}]}`))
+	})
+	rr := httptest.NewRecorder()
+
+	modelsHandler(rr, httptest.NewRequest(http.MethodGet, "/models", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp ModelsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(resp.Models) != 1 || resp.Models[0].Name != "llama3.2:1b" || resp.Models[0].ParameterSize != "1.2B" {
+		t.Errorf("unexpected models: %+v", resp.Models)
+	}
+	if resp.Current != "test-model" {
+		t.Errorf("expected the configured model 'test-model', got '%s'", resp.Current)
+	}
+}
+
+func TestModelsHandler_OllamaUnreachable(t *testing.T) {
+	server := setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+	rr := httptest.NewRecorder()
+
+	modelsHandler(rr, httptest.NewRequest(http.MethodGet, "/models", nil))
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Could not list models from the Ollama server at "+server.URL) {
+		t.Errorf("expected a descriptive error, got '%s'", rr.Body.String())
+	}
+}
+
+func TestHealthCheckHandler_Deep(t *testing.T) {
+	testCases := []struct {
+		name           string
+		models         string
+		unreachable    bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"model available", `{"models":[{"name":"test-model"}]}`, false, http.StatusOK, `"status":"ok"`},
+		{"implicit latest tag", `{"models":[{"name":"test-model:latest"}]}`, false, http.StatusOK, `"model_available":true`},
+		{"model missing", `{"models":[{"name":"other-model"}]}`, false, http.StatusServiceUnavailable, "model 'test-model' is not installed"},
+		{"ollama down", "", true, http.StatusServiceUnavailable, `"reachable":false`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tc.models))
+			})
+			if tc.unreachable {
+				server.Close()
+			}
+			rr := httptest.NewRecorder()
+
+			healthCheckHandler(rr, httptest.NewRequest(http.MethodGet, "/health?deep=true", nil))
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain %s, got %s", tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+// fakeLLMServer is an OpenAI-compatible API for the analyses run through the
+// HTTP handlers: the planner reads main.go, the synthesis answers "About:"
+// followed by the question, every other call gets "Go CLI".
+type fakeLLMServer struct {
+	mu        sync.Mutex
+	synthesis []string // User prompt of every synthesis call
+}
+
+// finalQuestion extracts the question of a synthesis prompt.
+var finalQuestion = regexp.MustCompile(`answer to the user's initial question: "(.*)"`)
+
+// setupFakeLLMServer configures a short exploration answered by a
+// fakeLLMServer.
+func setupFakeLLMServer(t *testing.T) *fakeLLMServer {
+	t.Helper()
+	fake := &fakeLLMServer{}
+	setupOpenAITest(t, func(w http.ResponseWriter, r *http.Request) {
+		var request openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		system, prompt := request.Messages[0].Content, request.Messages[len(request.Messages)-1].Content
+
+		answer := "Go CLI"
+		switch system {
+		case plannerSystemPrompt:
+			answer = `[{"action": "READ_FILE", "argument": "main.go"}]`
+		case defaultFinalSystemPrompt:
+			fake.mu.Lock()
+			fake.synthesis = append(fake.synthesis, prompt)
+			fake.mu.Unlock()
+			answer = "About: " + finalQuestion.FindStringSubmatch(prompt)[1]
+		}
+
+		chunk := openAIChatResponse{Choices: make([]struct {
+			Message openAIMessage `json:"message"`
+			Delta   openAIMessage `json:"delta"`
+		}, 1)}
+		if !request.Stream {
+			chunk.Choices[0].Message = openAIMessage{Role: "assistant", Content: answer}
+			json.NewEncoder(w).Encode(chunk)
+			return
+		}
+		chunk.Choices[0].Delta = openAIMessage{Role: "assistant", Content: answer}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+	})
+	config.AppConfig.Analysis = config.AnalysisConfig{
+		MaxExplorationIterations: 1,
+		MaxDirectoryDepth:        3,
+		MaxFileReadSize:          10000,
+		MaxPromptLength:          8000,
+		MaxFileRetryAttempts:     3,
+	}
+	return fake
+}
+
+// synthesisPrompts returns the user prompts of the synthesis calls received.
+func (f *fakeLLMServer) synthesisPrompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.synthesis...)
+}
+
+// parseEvents decodes the SSE events of body.
+func parseEvents(t *testing.T, body string) []ProgressEvent {
+	t.Helper()
+	var events []ProgressEvent
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event ProgressEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// analysisFiles is the project uploaded by the tests running a whole analysis
+// through the handlers.
+var analysisFiles = map[string]string{
+	"go.mod":  "module example.com/demo\n\ngo 1.24\n",
+	"main.go": "package main\n\nfunc main() {}\n",
+}
+
+func TestAnalyzeHandlers_ShareTheAnalysisSteps(t *testing.T) {
+	fake := setupFakeLLMServer(t)
+
+	rr := httptest.NewRecorder()
+	analyzeHandler(rr, newUploadRequest("/analyze", analysisFiles))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d from /analyze, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp AnalyzeResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	analyzeStreamHandler(rr, newUploadRequest("/analyze-stream", analysisFiles))
+	events := parseEvents(t, rr.Body.String())
+	if len(events) == 0 || events[len(events)-1].Type != "result" {
+		t.Fatalf("expected /analyze-stream to end on a result event, got %+v", events)
+	}
+	result := events[len(events)-1]
+
+	// Both handlers explore the upload with the same steps of analysisState,
+	// so the model is given the same findings to answer from
+	prompts := fake.synthesisPrompts()
+	if len(prompts) != 2 {
+		t.Fatalf("expected one synthesis per handler, got %d", len(prompts))
+	}
+	uploadDir := regexp.MustCompile(`uploaded-project-\d+`)
+	for i := range prompts {
+		prompts[i] = uploadDir.ReplaceAllString(prompts[i], "uploaded-project")
+	}
+	if prompts[0] != prompts[1] {
+		t.Errorf("expected /analyze and /analyze-stream to collect the same context, got\n%s\nand\n%s", prompts[0], prompts[1])
+	}
+	if !strings.Contains(prompts[0], "func main() {}") {
+		t.Errorf("expected the file read by the planner in the context, got %s", prompts[0])
+	}
+	if resp.Answer != result.Data || !reflect.DeepEqual(resp.Sources, result.Sources) {
+		t.Errorf("expected the same answer and sources from both handlers, got %q %v and %q %v", resp.Answer, resp.Sources, result.Data, result.Sources)
+	}
+}
+
+func TestAnalyzeHandler_CachesOnlyLocalProjects(t *testing.T) {
+	setupFakeLLMServer(t)
+	cacheDir := t.TempDir()
+	config.AppConfig.Analysis.CacheDir = cacheDir
+	root := t.TempDir()
+	config.AppConfig.Server.AllowedRoots = []string{root}
+	config.AppConfig.Server.AllowLocalPaths = true
+	for name, content := range analysisFiles {
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+
+	// The uploaded project is deleted after the analysis, so is its knowledge base
+	rr := httptest.NewRecorder()
+	analyzeHandler(rr, newUploadRequest("/analyze", analysisFiles))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("expected no cached knowledge base for an upload, got %d files", len(entries))
+	}
+
+	rr = httptest.NewRecorder()
+	analyzeHandler(rr, newProjectPathRequest(root, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(knowledgeBaseCachePath(cacheDir, root)); err != nil {
+		t.Errorf("expected the knowledge base of the project_path to be cached, got %v", err)
+	}
+}
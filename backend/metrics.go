@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"debugagent/config"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics of the server are exposed by GET /metrics in the Prometheus
+// text format. The module does not depend on the Prometheus client library:
+// counterVec and histogramVec implement the few metric types needed.
+
+// Bucket upper bounds, in seconds. Analyses take minutes, so the defaults of
+// the client libraries (up to 10s) would put most of them in +Inf.
+var (
+	httpDurationBuckets     = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}
+	analysisDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+	llmDurationBuckets      = []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300}
+)
+
+var (
+	httpRequestsTotal = newCounterVec("debugagent_http_requests_total",
+		"HTTP requests answered, by route pattern and status code.", "handler", "code")
+	httpRequestDuration = newHistogramVec("debugagent_http_request_duration_seconds",
+		"Time taken to answer the HTTP requests, by route pattern.", httpDurationBuckets, "handler")
+	analysesTotal = newCounterVec("debugagent_analyses_total",
+		"Analyses run, by outcome: completed, partial (final answer failed) or cancelled.", "outcome")
+	analysisDuration = newHistogramVec("debugagent_analysis_duration_seconds",
+		"Duration of the analyses, final answer included.", analysisDurationBuckets)
+	llmRequestsTotal = newCounterVec("debugagent_llm_requests_total",
+		"Requests sent to the model server, by provider, kind (generate, chat, stream) and outcome.", "provider", "kind", "outcome")
+	llmRequestDuration = newHistogramVec("debugagent_llm_request_duration_seconds",
+		"Duration of the requests sent to the model server, by provider and kind.", llmDurationBuckets, "provider", "kind")
+)
+
+// registeredMetrics are written by metricsHandler, in this order.
+var registeredMetrics = []interface{ write(io.Writer) }{
+	httpRequestsTotal, httpRequestDuration,
+	analysesTotal, analysisDuration,
+	llmRequestsTotal, llmRequestDuration,
+}
+
+// Outcomes of an analysis, see analysesTotal.
+const (
+	analysisCompleted = "completed"
+	analysisPartial   = "partial"
+	analysisCancelled = "cancelled"
+)
+
+// metricsHandler answers GET /metrics.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, metric := range registeredMetrics {
+		metric.write(w)
+	}
+}
+
+// metricsMiddleware counts the requests answered by next, the server's mux,
+// and their duration. They are labelled by the pattern of the route that
+// matched rather than the path, which would give a series per job or session
+// ID.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// The mux sets the pattern on the request it was given
+		handler := r.Pattern
+		if handler == "" {
+			handler = "unmatched"
+		}
+		httpRequestsTotal.inc(handler, strconv.Itoa(recorder.status))
+		httpRequestDuration.observe(time.Since(start).Seconds(), handler)
+	})
+}
+
+// observeAnalysis records an analysis that started at start.
+func observeAnalysis(outcome string, start time.Time) {
+	analysesTotal.inc(outcome)
+	analysisDuration.observe(time.Since(start).Seconds())
+}
+
+// observeLLMRequest records a request of the given kind sent to the model
+// server at start, which failed with err if not nil.
+func observeLLMRequest(kind string, start time.Time, err error) {
+	provider := strings.ToLower(strings.TrimSpace(config.Get().LLM.Provider))
+	if provider == "" {
+		provider = "ollama"
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	llmRequestsTotal.inc(provider, kind, outcome)
+	llmRequestDuration.observe(time.Since(start).Seconds(), provider, kind)
+}
+
+// statusRecorder remembers the status code written through it. It keeps the
+// Flusher of the SSE endpoints and the Hijacker of /analyze-ws working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	r.status, r.wroteHeader = http.StatusSwitchingProtocols, true
+	return hijacker.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricSeries is the state of one combination of label values.
+type metricSeries struct {
+	labels []string
+	value  float64  // Counters
+	counts []uint64 // Histograms, observations per bucket (not cumulative)
+	sum    float64
+	count  uint64
+}
+
+// metricVec holds the series of a metric by label values.
+type metricVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// get returns the series of values, created if needed. The caller must hold
+// v.mu.
+func (v *metricVec) get(values []string) *metricSeries {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: %d label values for %d labels", v.name, len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	series, ok := v.series[key]
+	if !ok {
+		series = &metricSeries{labels: append([]string(nil), values...)}
+		v.series[key] = series
+	}
+	return series
+}
+
+// sorted returns the series ordered by label values, for a stable output.
+// The caller must hold v.mu.
+func (v *metricVec) sorted() []*metricSeries {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*metricSeries, len(keys))
+	for i, key := range keys {
+		series[i] = v.series[key]
+	}
+	return series
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (v *metricVec) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, metricType)
+}
+
+// labelPairs formats the labels of series, followed by extra pairs such as
+// le="0.5", as {name="value",...}; it returns "" when there are none.
+func (v *metricVec) labelPairs(series *metricSeries, extra ...string) string {
+	var pairs []string
+	for i, label := range v.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, labelValueEscaper.Replace(series.labels[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes the label values as the text format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatMetricValue formats a sample value.
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	metricVec
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{metricVec{name: name, help: help, labels: labels, series: make(map[string]*metricSeries)}}
+}
+
+// inc adds one to the series of values.
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, series := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(series), formatMetricValue(series.value))
+	}
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	metricVec
+	buckets []float64 // Upper bounds, ascending, +Inf excluded
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: metricVec{name: name, help: help, labels: labels, series: make(map[string]*metricSeries)},
+		buckets:   buckets,
+	}
+}
+
+// observe records value in the series of values.
+func (h *histogramVec) observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series := h.get(values)
+	if series.counts == nil {
+		series.counts = make([]uint64, len(h.buckets))
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, series := range h.sorted() {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(series, "le", formatMetricValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(series, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(series), formatMetricValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(series), series.count)
+	}
+}
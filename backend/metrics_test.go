@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// counterValue returns the current value of the series of values of c.
+func counterValue(c *counterVec, values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(values).value
+}
+
+func TestMetricVecs_TextFormat(t *testing.T) {
+	counter := newCounterVec("test_requests_total", "Requests.", "handler", "code")
+	counter.inc("/b", "200")
+	counter.inc("/a", "500")
+	counter.inc("/a", "500")
+	counter.inc(`/"quoted"`, "200")
+
+	histogram := newHistogramVec("test_duration_seconds", "Durations.", []float64{1, 5})
+	histogram.observe(0.5)
+	histogram.observe(1)
+	histogram.observe(7)
+
+	var out bytes.Buffer
+	counter.write(&out)
+	histogram.write(&out)
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{handler="/\"quoted\"",code="200"} 1
+test_requests_total{handler="/a",code="500"} 2
+test_requests_total{handler="/b",code="200"} 1
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="5"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 8.5
+test_duration_seconds_count 3
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-metrics/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected the response writer to still be a Flusher")
+		}
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "no such thing")
+	})
+	handler := metricsMiddleware(mux)
+	before := counterValue(httpRequestsTotal, "/test-metrics/{id}", "404")
+
+	for _, id := range []string{"1", "2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test-metrics/"+id, nil))
+	}
+
+	// Both IDs are counted under the route pattern
+	if got := counterValue(httpRequestsTotal, "/test-metrics/{id}", "404") - before; got != 2 {
+		t.Errorf("expected 2 requests counted, got %v", got)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	before := counterValue(analysesTotal, analysisCompleted)
+	chatsBefore := counterValue(llmRequestsTotal, "ollama", "chat", "success")
+
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "What is it?"}, &fakeLLMClient{answer: "A CLI"})
+	if _, err := engine.RunAnalysis(); err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+	if got := counterValue(analysesTotal, analysisCompleted) - before; got != 1 {
+		t.Errorf("expected 1 completed analysis counted, got %v", got)
+	}
+	if counterValue(llmRequestsTotal, "ollama", "chat", "success") == chatsBefore {
+		t.Error("expected the chat requests to the model to be counted")
+	}
+
+	rr := httptest.NewRecorder()
+	metricsHandler(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected a 200 text response, got %d (%s)", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		"# TYPE debugagent_http_requests_total counter",
+		"# TYPE debugagent_analysis_duration_seconds histogram",
+		`debugagent_analyses_total{outcome="completed"}`,
+		`debugagent_llm_request_duration_seconds_count{provider="ollama",kind="chat"}`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in the metrics, got:\n%s", expected, rr.Body.String())
+		}
+	}
+}
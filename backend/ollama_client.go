@@ -1,72 +1,421 @@
 package main
 
 import (
+	"context"
 	"debugagent/config"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/JexSrs/go-ollama"
-	"github.com/sirupsen/logrus"
 )
 
-
 // OllamaClient est une structure pour interagir avec l'API Ollama.
 type OllamaClient struct {
-	client *ollama.Ollama
-	model  string
+	client  *ollama.Ollama
+	hostURL url.URL
+	model   string
+}
+
+// contextTransport rattache un contexte à chaque requête HTTP sortante.
+// go-ollama construit ses requêtes sans contexte, c'est donc le seul moyen
+// d'interrompre un appel en cours.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip exécute la requête en la liant au contexte du transport.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
 
 // NewOllamaClient crée un nouveau client pour Ollama.
-func NewOllamaClient() (*OllamaClient, error) {
-	host := config.AppConfig.Ollama.Host
-	model := config.AppConfig.Ollama.Model
+// Le contexte fourni borne la durée de vie de toutes les requêtes du client.
+func NewOllamaClient(ctx context.Context) (*OllamaClient, error) {
+	return NewOllamaClientForModel(ctx, "")
+}
+
+// NewOllamaClientForModel crée un client utilisant model à la place du modèle
+// configuré ; un nom vide (ou composé d'espaces) revient au modèle par défaut.
+func NewOllamaClientForModel(ctx context.Context, model string) (*OllamaClient, error) {
+	cfg := config.FromContext(ctx)
+	host := cfg.Ollama.Host
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = cfg.Ollama.Model
+	}
 
 	ollamaURL, err := url.Parse(host)
 	if err != nil {
 		return nil, fmt.Errorf("URL Ollama invalide: %w", err)
 	}
 
-	client := ollama.New(*ollamaURL)
+	oc := &OllamaClient{
+		hostURL: *ollamaURL,
+		model:   model,
+	}
+	oc.client = oc.clientWithContext(ctx)
+
+	log := requestLogger(ctx)
+	log.Infof("Using Ollama client for host: %s", host)
+	log.Infof("Using Ollama model: %s", model)
+
+	return oc, nil
+}
+
+// clientWithContext construit un client go-ollama dont les requêtes HTTP
+// sont annulées en même temps que ctx.
+func (oc *OllamaClient) clientWithContext(ctx context.Context) *ollama.Ollama {
+	client := ollama.New(oc.hostURL)
+	client.Http = &http.Client{
+		Transport: &contextTransport{ctx: ctx, base: http.DefaultTransport},
+	}
+	return client
+}
+
+// retryBaseDelay est le délai avant la première nouvelle tentative ; il double
+// à chaque tentative suivante.
+var retryBaseDelay = 500 * time.Millisecond
+
+// statusCodeRegex extrait le code HTTP des erreurs renvoyées par go-ollama,
+// qui ne les expose que sous forme de texte.
+var statusCodeRegex = regexp.MustCompile(`status code: (\d+)`)
+
+// ChatMessage est un message d'une conversation avec le modèle.
+type ChatMessage struct {
+	Role    string // "system", "user" ou "assistant"
+	Content string
+}
+
+// Request envoie une requête à Ollama en utilisant la fonction Generate.
+// Si ctx est annulé, la requête HTTP en cours est interrompue et l'erreur
+// retournée enveloppe context.Canceled. Chaque appel est en outre borné par
+// ollama.request_timeout_seconds lorsque cette valeur est positive.
+// Les erreurs transitoires (connexion coupée, 503 pendant le chargement du
+// modèle...) sont retentées jusqu'à ollama.max_retries fois avec un délai
+// exponentiel.
+func (oc *OllamaClient) Request(ctx context.Context, systemMessage, userPrompt string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("requête Ollama annulée avant l'envoi: %w", err)
+	}
+
+	userPrompt = truncatePrompt(ctx, userPrompt)
+	logPromptTokens(ctx, "Ollama", estimateTokens(systemMessage)+estimateTokens(userPrompt))
+
+	return withRetries(ctx, "Ollama", func(reqCtx context.Context) (string, error) {
+		return oc.generate(reqCtx, systemMessage, userPrompt)
+	})
+}
+
+// ChatRequest envoie une conversation complète à l'endpoint chat d'Ollama et
+// retourne la réponse de l'assistant. Elle permet au moteur de conserver
+// l'historique des échanges d'un appel à l'autre. Annulation, timeout et
+// nouvelles tentatives se comportent comme pour Request.
+func (oc *OllamaClient) ChatRequest(ctx context.Context, messages []ChatMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("requête Ollama annulée avant l'envoi: %w", err)
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("conversation vide")
+	}
+
+	fitted := fitConversation(ctx, messages)
+	logPromptTokens(ctx, "Ollama", estimateMessagesTokens(fitted))
+
+	return withRetries(ctx, "Ollama", func(reqCtx context.Context) (string, error) {
+		return oc.chat(reqCtx, fitted)
+	})
+}
+
+// StreamRequest envoie une requête à Ollama en mode streaming et appelle
+// callback pour chaque fragment de réponse dès sa réception. Une fois des
+// fragments transmis, une erreur n'est plus retentée pour ne pas dupliquer
+// la sortie.
+func (oc *OllamaClient) StreamRequest(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("requête Ollama annulée avant l'envoi: %w", err)
+	}
 
-	logrus.Infof("Using Ollama client for host: %s", host)
-	logrus.Infof("Using Ollama model: %s", model)
+	userPrompt = truncatePrompt(ctx, userPrompt)
+	logPromptTokens(ctx, "Ollama", estimateTokens(systemMessage)+estimateTokens(userPrompt))
 
-	return &OllamaClient{
-		client: client,
-		model:  model,
-	}, nil
+	_, err := withRetries(ctx, "Ollama", func(reqCtx context.Context) (string, error) {
+		emitted := false
+		err := oc.generateStream(reqCtx, systemMessage, userPrompt, func(chunk string) {
+			emitted = true
+			callback(chunk)
+		})
+		if err != nil && emitted {
+			return "", fmt.Errorf("%w: %v", errPartialStream, err)
+		}
+		return "", err
+	})
+	return err
 }
 
-// ollamaRequest envoie une requête à Ollama en utilisant la fonction Generate.
-func (oc *OllamaClient) ollamaRequest(systemMessage, userPrompt string) (string, error) {
-	maxPromptLen := config.AppConfig.Analysis.MaxPromptLength
-	logrus.Debugf("Sending prompt of %d characters to Ollama (max: %d)", len(userPrompt), maxPromptLen)
-	
-	if len(userPrompt) > maxPromptLen {
-		logrus.Warnf("Prompt is being truncated from %d to %d characters.", len(userPrompt), maxPromptLen)
-		userPrompt = userPrompt[:maxPromptLen]
+// errPartialStream signale un flux interrompu après l'envoi de fragments.
+var errPartialStream = errors.New("flux de réponse interrompu après réception partielle")
+
+// truncatePrompt tronque un prompt au budget de tokens (voir promptTokenBudget).
+func truncatePrompt(ctx context.Context, prompt string) string {
+	budget := promptTokenBudget(config.FromContext(ctx))
+	if tokens := estimateTokens(prompt); tokens > budget {
+		requestLogger(ctx).Warnf("Prompt is being truncated from ~%d to %d tokens.", tokens, budget)
+		return truncateToTokens(prompt, budget)
 	}
+	return prompt
+}
 
+// ModelInfo décrit un modèle installé sur le serveur Ollama.
+type ModelInfo struct {
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	ModifiedAt    string `json:"modified_at"`
+	Family        string `json:"family,omitempty"`
+	ParameterSize string `json:"parameter_size,omitempty"`
+	Quantization  string `json:"quantization_level,omitempty"`
+}
+
+// ListModels retourne les modèles installés sur le serveur Ollama (API /api/tags).
+// L'appel n'est pas répété en cas d'échec : il sert à l'interface, qui doit
+// savoir rapidement si le serveur est joignable.
+func (oc *OllamaClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var models []ModelInfo
+	_, err := withTimeout(ctx, "Ollama", func(reqCtx context.Context) (string, error) {
+		res, err := oc.clientWithContext(reqCtx).Models.List()
+		if err != nil {
+			return "", fmt.Errorf("impossible de lister les modèles Ollama: %w", err)
+		}
+		models = make([]ModelInfo, 0, len(res.Models))
+		for _, m := range res.Models {
+			models = append(models, ModelInfo{
+				Name:          m.Name,
+				Size:          m.Size,
+				ModifiedAt:    m.ModifiedAt,
+				Family:        m.Details.Family,
+				ParameterSize: m.Details.ParameterSize,
+				Quantization:  m.Details.QuantizationLevel,
+			})
+		}
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// withRetries exécute call en retentant les erreurs transitoires avec un
+// délai exponentiel, et retourne l'erreur finale avec le nombre de tentatives.
+// provider ("Ollama", "OpenAI") n'apparaît que dans les messages d'erreur.
+func withRetries(ctx context.Context, provider string, call func(ctx context.Context) (string, error)) (string, error) {
+	maxAttempts := config.FromContext(ctx).Ollama.MaxRetries + 1
+	var lastErr error
+	attempts := 0
+	for attempts < maxAttempts {
+		if attempts > 0 {
+			delay := retryBaseDelay * time.Duration(1<<(attempts-1))
+			requestLogger(ctx).Debugf("Retrying %s request (attempt %d/%d) in %s after error: %v", provider, attempts+1, maxAttempts, delay, lastErr)
+			select {
+			case <-ctx.Done():
+				return "", fmt.Errorf("requête %s annulée avant la tentative %d: %w", provider, attempts+1, ctx.Err())
+			case <-time.After(delay):
+			}
+		}
+
+		attempts++
+		response, err := withTimeout(ctx, provider, call)
+		if err == nil {
+			return response, nil
+		}
+		lastErr = err
+		if !isRetryableOllamaError(err) {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("échec de la requête %s après %d tentative(s): %w", provider, attempts, lastErr)
+}
+
+// withTimeout exécute un unique appel borné par ollama.request_timeout_seconds
+// et distingue annulation, timeout et erreur de l'API.
+func withTimeout(ctx context.Context, provider string, call func(ctx context.Context) (string, error)) (string, error) {
+	reqCtx := ctx
+	timeoutSeconds := config.FromContext(ctx).Ollama.RequestTimeoutSeconds
+	if timeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	response, err := call(reqCtx)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("requête %s interrompue: %w", provider, ctxErr)
+		}
+		if errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%s request timed out after %ds: %w", strings.ToLower(provider), timeoutSeconds, context.DeadlineExceeded)
+		}
+		return "", err
+	}
+	return response, nil
+}
+
+// generate effectue un unique appel à l'API Generate d'Ollama. Certains
+// serveurs répondent en plusieurs fragments même sans streaming : ils sont
+// accumulés jusqu'au fragment final (done), et une réponse qui s'arrête avant
+// lui est traitée comme une connexion coupée, donc retentée.
+func (oc *OllamaClient) generate(ctx context.Context, systemMessage, userPrompt string) (string, error) {
+	client := oc.clientWithContext(ctx)
+
+	var response strings.Builder
+	var chunkErr error
+	done := false
 	// Utilisation de la fonction Generate qui est plus simple pour des requêtes uniques.
-	res, err := oc.client.Generate(
-		oc.client.Generate.WithModel(oc.model),
-		oc.client.Generate.WithSystem(systemMessage),
-		oc.client.Generate.WithPrompt(userPrompt),
-	)
+	// Le streaming reste désactivé : la fonction de flux ne sert qu'à voir passer les fragments.
+	builders := []func(*ollama.GenerateRequestBuilder){
+		client.Generate.WithModel(oc.model),
+		client.Generate.WithSystem(systemMessage),
+		client.Generate.WithPrompt(userPrompt),
+		client.Generate.WithStream(false, 512000, func(chunk *ollama.GenerateResponse, err error) {
+			if err != nil {
+				chunkErr = err
+				return
+			}
+			response.WriteString(chunk.Response)
+			done = done || chunk.Done
+		}),
+	}
+	if options, ok := ollamaOptions(ctx); ok {
+		builders = append(builders, client.Generate.WithOptions(options))
+	}
+	if keepAlive, ok := ollamaKeepAlive(ctx); ok {
+		builders = append(builders, client.Generate.WithKeepAlive(keepAlive))
+	}
+	_, err := client.Generate(builders...)
 
 	if err != nil {
 		return "", fmt.Errorf("erreur lors de l'appel à l'API Generate d'Ollama: %w", err)
 	}
+	if chunkErr != nil {
+		return "", fmt.Errorf("fragment de réponse Ollama illisible: %w", chunkErr)
+	}
+	if !done {
+		return "", fmt.Errorf("réponse d'Ollama interrompue avant le fragment final: %w", io.ErrUnexpectedEOF)
+	}
+	if response.Len() == 0 {
+		return "", fmt.Errorf("réponse d'Ollama vide mais marquée comme terminée")
+	}
+
+	requestLogger(ctx).Debug("Response received from Ollama.")
+	return cleanResponse(response.String()), nil
+}
 
-	if res.Done {
-		if res.Response != "" {
-			logrus.Debug("Response received from Ollama.")
-			// Nettoyer la réponse des "```" que le modèle ajoute parfois
-			return strings.TrimSpace(strings.Trim(res.Response, "```")), nil
+// generateStream effectue un unique appel à l'API Generate en mode streaming.
+func (oc *OllamaClient) generateStream(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error {
+	client := oc.clientWithContext(ctx)
+
+	var streamErr error
+	builders := []func(*ollama.GenerateRequestBuilder){
+		client.Generate.WithModel(oc.model),
+		client.Generate.WithSystem(systemMessage),
+		client.Generate.WithPrompt(userPrompt),
+		client.Generate.WithStream(true, 512000, func(chunk *ollama.GenerateResponse, err error) {
+			if err != nil {
+				streamErr = err
+				return
+			}
+			if chunk.Response != "" {
+				callback(chunk.Response)
+			}
+		}),
+	}
+	if options, ok := ollamaOptions(ctx); ok {
+		builders = append(builders, client.Generate.WithOptions(options))
+	}
+	if keepAlive, ok := ollamaKeepAlive(ctx); ok {
+		builders = append(builders, client.Generate.WithKeepAlive(keepAlive))
+	}
+	res, err := client.Generate(builders...)
+
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'appel à l'API Generate d'Ollama: %w", err)
+	}
+	if streamErr != nil {
+		return fmt.Errorf("fragment de réponse Ollama illisible: %w", streamErr)
+	}
+	if res.Response == "" {
+		return fmt.Errorf("réponse d'Ollama vide")
+	}
+
+	requestLogger(ctx).Debug("Streamed response received from Ollama.")
+	return nil
+}
+
+// chat effectue un unique appel à l'API Chat d'Ollama.
+func (oc *OllamaClient) chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	client := oc.clientWithContext(ctx)
+
+	options := []func(*ollama.ChatRequestBuilder){client.Chat.WithModel(oc.model)}
+	for _, msg := range messages {
+		role, content := msg.Role, msg.Content
+		options = append(options, client.Chat.WithMessage(ollama.Message{Role: &role, Content: &content}))
+	}
+	if generation, ok := ollamaOptions(ctx); ok {
+		options = append(options, client.Chat.WithOptions(generation))
+	}
+	if keepAlive, ok := ollamaKeepAlive(ctx); ok {
+		options = append(options, client.Chat.WithKeepAlive(keepAlive))
+	}
+
+	// Pas d'identifiant de chat : l'historique est géré par l'appelant.
+	res, err := client.Chat(nil, options...)
+	if err != nil {
+		return "", fmt.Errorf("erreur lors de l'appel à l'API Chat d'Ollama: %w", err)
+	}
+
+	if res.Message.Content == nil || *res.Message.Content == "" {
+		return "", fmt.Errorf("réponse d'Ollama vide")
+	}
+
+	requestLogger(ctx).Debug("Chat response received from Ollama.")
+	return cleanResponse(*res.Message.Content), nil
+}
+
+// cleanResponse nettoie la réponse des "```" que le modèle ajoute parfois.
+func cleanResponse(response string) string {
+	return strings.TrimSpace(strings.Trim(response, "```"))
+}
+
+// isRetryableOllamaError indique si une erreur est transitoire et mérite une
+// nouvelle tentative. Les annulations, les timeouts et les erreurs client
+// (modèle inconnu, requête invalide) échouent immédiatement.
+func isRetryableOllamaError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errPartialStream) {
+		return false
+	}
+
+	if matches := statusCodeRegex.FindStringSubmatch(err.Error()); matches != nil {
+		code, _ := strconv.Atoi(matches[1])
+		switch code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
 		}
-		return "", fmt.Errorf("réponse d'Ollama vide mais marquée comme terminée")
+		return false
 	}
 
-	return "", fmt.Errorf("la requête à Ollama n'est pas terminée (comportement de streaming inattendu)")
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
 }
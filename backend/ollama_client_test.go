@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupOllamaTest points the configuration at a fake Ollama server.
+func setupOllamaTest(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.AppConfig = &config.Config{
+		Ollama: config.OllamaConfig{
+			Host:  server.URL,
+			Model: "test-model",
+		},
+		Analysis: config.AnalysisConfig{
+			MaxPromptLength: 8000,
+		},
+	}
+	return server
+}
+
+func TestOllamaRequest_Success(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"test-model","response":"Hello","done":true}`))
+	})
+
+	client, err := NewOllamaClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewOllamaClient() failed: %v", err)
+	}
+
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("Request() returned error: %v", err)
+	}
+	if got != "Hello" {
+		t.Errorf("expected 'Hello', got '%s'", got)
+	}
+}
+
+func TestOllamaRequest_Cancelled(t *testing.T) {
+	released := make(chan struct{})
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a slow model: only return once the client has gone away.
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		close(released)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := NewOllamaClient(ctx)
+	if err != nil {
+		t.Fatalf("NewOllamaClient() failed: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = client.Request(ctx, "system", "prompt")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a wrapped context.Canceled error, got: %v", err)
+	}
+
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Error("the in-flight HTTP request was not aborted")
+	}
+}
+
+func TestOllamaRequest_Timeout(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	config.AppConfig.Ollama.RequestTimeoutSeconds = 1
+
+	client, err := NewOllamaClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewOllamaClient() failed: %v", err)
+	}
+
+	_, err = client.Request(context.Background(), "system", "prompt")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "ollama request timed out after 1s") {
+		t.Errorf("unexpected timeout message: %v", err)
+	}
+}
+
+func TestOllamaRequest_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, "model is loading", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"model":"test-model","response":"ready","done":true}`))
+	})
+	config.AppConfig.Ollama.MaxRetries = 3
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	client, _ := NewOllamaClient(context.Background())
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("expected success after retries, got: %v", err)
+	}
+	if got != "ready" || calls != 3 {
+		t.Errorf("expected 'ready' after 3 calls, got '%s' after %d calls", got, calls)
+	}
+}
+
+func TestOllamaRequest_DoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, `{"error":"model 'test-model' not found"}`, http.StatusNotFound)
+	})
+	config.AppConfig.Ollama.MaxRetries = 3
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	client, _ := NewOllamaClient(context.Background())
+	_, err := client.Request(context.Background(), "system", "prompt")
+	if err == nil {
+		t.Fatal("expected an error for an unknown model")
+	}
+	if calls != 1 {
+		t.Errorf("expected a single call for a non-retryable error, got %d", calls)
+	}
+	if !strings.Contains(err.Error(), "1 tentative(s)") {
+		t.Errorf("expected the attempt count in the error, got: %v", err)
+	}
+}
+
+func TestStreamRequest_ForwardsChunks(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":"Hel","done":false}` + "\n"))
+		w.Write([]byte(`{"response":"lo","done":false}` + "\n"))
+		w.Write([]byte(`{"response":"","done":true}` + "\n"))
+	})
+
+	client, _ := NewOllamaClient(context.Background())
+	var chunks []string
+	err := client.StreamRequest(context.Background(), "system", "prompt", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("StreamRequest() returned error: %v", err)
+	}
+	if strings.Join(chunks, "") != "Hello" || len(chunks) != 2 {
+		t.Errorf("expected chunks [Hel lo], got %v", chunks)
+	}
+}
+
+func TestOllamaRequest_AccumulatesStreamedChunks(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		// Answer in several chunks although streaming was not requested
+		w.Write([]byte(`{"model":"test-model","response":"Go ","done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`{"model":"test-model","response":"Backend","done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`{"model":"test-model","response":"","done":true}` + "\n"))
+	})
+
+	client, _ := NewOllamaClient(context.Background())
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("Request() returned error: %v", err)
+	}
+	if got != "Go Backend" {
+		t.Errorf("expected the chunks to be assembled into 'Go Backend', got '%s'", got)
+	}
+}
+
+func TestOllamaRequest_RetriesResponseWithoutFinalChunk(t *testing.T) {
+	calls := 0
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Write([]byte(`{"model":"test-model","response":"Go ","done":false}` + "\n"))
+			return
+		}
+		w.Write([]byte(`{"model":"test-model","response":"Go Backend","done":true}`))
+	})
+	config.AppConfig.Ollama.MaxRetries = 1
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	client, _ := NewOllamaClient(context.Background())
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("expected success after a retry, got: %v", err)
+	}
+	if got != "Go Backend" || calls != 2 {
+		t.Errorf("expected 'Go Backend' after 2 calls, got '%s' after %d calls", got, calls)
+	}
+}
+
+func TestOllamaRequest_SendsPhaseOptions(t *testing.T) {
+	var bodies []map[string]interface{}
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"model":"test-model","response":"Hello","done":true}`))
+	})
+	seed, planningTemperature := 42, 0.2
+	config.AppConfig.Ollama.Options = config.OptionsConfig{
+		GenerationOptions: config.GenerationOptions{Seed: &seed},
+		Planning:          config.GenerationOptions{Temperature: &planningTemperature},
+	}
+
+	client, _ := NewOllamaClient(context.Background())
+	client.Request(withGenerationPhase(context.Background(), phasePlanning), "system", "prompt")
+	client.Request(context.Background(), "system", "prompt")
+	config.AppConfig.Ollama.Options = config.OptionsConfig{}
+	client.Request(context.Background(), "system", "prompt")
+
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(bodies))
+	}
+	planning, _ := bodies[0]["options"].(map[string]interface{})
+	if planning["temperature"] != 0.2 || planning["seed"] != 42.0 {
+		t.Errorf("expected the planning temperature and the common seed, got %v", bodies[0]["options"])
+	}
+	common, _ := bodies[1]["options"].(map[string]interface{})
+	if common["temperature"] != nil || common["seed"] != 42.0 {
+		t.Errorf("expected only the common seed outside of a phase, got %v", bodies[1]["options"])
+	}
+	if bodies[2]["options"] != nil {
+		t.Errorf("expected no options when none is configured, got %v", bodies[2]["options"])
+	}
+}
+
+func TestOllamaRequest_SendsKeepAlive(t *testing.T) {
+	var keepAlives []interface{}
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		keepAlives = append(keepAlives, body["keep_alive"])
+		if strings.HasSuffix(r.URL.Path, "/chat") {
+			w.Write([]byte(`{"model":"test-model","message":{"role":"assistant","content":"Hello"},"done":true}`))
+			return
+		}
+		w.Write([]byte(`{"model":"test-model","response":"Hello","done":true}`))
+	})
+
+	client, _ := NewOllamaClient(context.Background())
+	config.AppConfig.Ollama.KeepAlive = "-1"
+	client.Request(context.Background(), "system", "prompt")
+	client.ChatRequest(context.Background(), []ChatMessage{{Role: "user", Content: "prompt"}})
+	config.AppConfig.Ollama.KeepAlive = "30m"
+	client.Request(context.Background(), "system", "prompt")
+	config.AppConfig.Ollama.KeepAlive = ""
+	client.Request(context.Background(), "system", "prompt")
+
+	expected := []interface{}{"-1s", "-1s", "30m", nil}
+	if !reflect.DeepEqual(keepAlives, expected) {
+		t.Errorf("expected keep_alive values %v, got %v", expected, keepAlives)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAIClient interroge une API compatible OpenAI (OpenAI, vLLM, LM Studio,
+// llama.cpp server...) via son endpoint /chat/completions.
+type OpenAIClient struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	model      string
+}
+
+// openAIMessage est un message au format de l'API /chat/completions.
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+// openAIChatResponse couvre à la fois les réponses complètes (Message) et les
+// fragments d'un flux (Delta).
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// NewOpenAIClient crée un client pour l'API configurée dans la section llm.
+// model remplace llm.model ; l'un des deux doit être renseigné.
+func NewOpenAIClient(ctx context.Context, model string) (*OpenAIClient, error) {
+	cfg := config.FromContext(ctx).LLM
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = cfg.Model
+	}
+	if model == "" {
+		return nil, fmt.Errorf("aucun modèle configuré pour le fournisseur openai (llm.model)")
+	}
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("URL de l'API OpenAI manquante (llm.base_url)")
+	}
+
+	log := requestLogger(ctx)
+	log.Infof("Using OpenAI-compatible client for: %s", baseURL)
+	log.Infof("Using model: %s", model)
+
+	return &OpenAIClient{
+		httpClient: &http.Client{},
+		baseURL:    baseURL,
+		apiKey:     cfg.APIKey,
+		model:      model,
+	}, nil
+}
+
+// Request envoie un message système et un prompt. Budget de tokens, annulation,
+// timeout et nouvelles tentatives se comportent comme pour OllamaClient.
+func (c *OpenAIClient) Request(ctx context.Context, systemMessage, userPrompt string) (string, error) {
+	return c.ChatRequest(ctx, []ChatMessage{
+		{Role: "system", Content: systemMessage},
+		{Role: "user", Content: userPrompt},
+	})
+}
+
+// ChatRequest envoie une conversation complète et retourne la réponse de l'assistant.
+func (c *OpenAIClient) ChatRequest(ctx context.Context, messages []ChatMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("requête OpenAI annulée avant l'envoi: %w", err)
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("conversation vide")
+	}
+
+	fitted := fitConversation(ctx, messages)
+	logPromptTokens(ctx, "OpenAI", estimateMessagesTokens(fitted))
+	request := c.chatRequest(fitted, false)
+	return withRetries(ctx, "OpenAI", func(reqCtx context.Context) (string, error) {
+		return c.complete(reqCtx, request)
+	})
+}
+
+// StreamRequest envoie une requête en mode streaming et appelle callback pour
+// chaque fragment reçu. Comme pour OllamaClient, une erreur survenue après
+// l'envoi de fragments n'est pas retentée.
+func (c *OpenAIClient) StreamRequest(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("requête OpenAI annulée avant l'envoi: %w", err)
+	}
+
+	fitted := fitConversation(ctx, []ChatMessage{
+		{Role: "system", Content: systemMessage},
+		{Role: "user", Content: userPrompt},
+	})
+	logPromptTokens(ctx, "OpenAI", estimateMessagesTokens(fitted))
+	request := c.chatRequest(fitted, true)
+	_, err := withRetries(ctx, "OpenAI", func(reqCtx context.Context) (string, error) {
+		emitted := false
+		err := c.stream(reqCtx, request, func(chunk string) {
+			emitted = true
+			callback(chunk)
+		})
+		if err != nil && emitted {
+			return "", fmt.Errorf("%w: %v", errPartialStream, err)
+		}
+		return "", err
+	})
+	return err
+}
+
+// chatRequest construit le corps d'une requête.
+func (c *OpenAIClient) chatRequest(messages []ChatMessage, stream bool) openAIChatRequest {
+	request := openAIChatRequest{Model: c.model, Stream: stream}
+	for _, msg := range messages {
+		request.Messages = append(request.Messages, openAIMessage{Role: msg.Role, Content: msg.Content})
+	}
+	return request
+}
+
+// post envoie request à /chat/completions. Les erreurs HTTP mentionnent le
+// code de statut sous la même forme que go-ollama, pour que
+// isRetryableOllamaError les classe de la même façon.
+func (c *OpenAIClient) post(ctx context.Context, request openAIChatRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'appel à l'API OpenAI: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("erreur de l'API OpenAI (status code: %d): %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// complete effectue un unique appel non streamé.
+func (c *OpenAIClient) complete(ctx context.Context, request openAIChatRequest) (string, error) {
+	resp, err := c.post(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var res openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("réponse de l'API OpenAI illisible: %w", err)
+	}
+	if len(res.Choices) == 0 || res.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("réponse de l'API OpenAI vide")
+	}
+
+	requestLogger(ctx).Debug("Response received from the OpenAI-compatible API.")
+	return cleanResponse(res.Choices[0].Message.Content), nil
+}
+
+// stream effectue un unique appel streamé : la réponse est une suite
+// d'événements SSE "data: {...}" terminée par "data: [DONE]".
+func (c *OpenAIClient) stream(ctx context.Context, request openAIChatRequest, callback func(string)) error {
+	resp, err := c.post(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("fragment de réponse OpenAI illisible: %w", err)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			received = true
+			callback(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("flux de l'API OpenAI interrompu: %w", err)
+	}
+	if !received {
+		return fmt.Errorf("réponse de l'API OpenAI vide")
+	}
+
+	requestLogger(ctx).Debug("Streamed response received from the OpenAI-compatible API.")
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupOpenAITest points the configuration at a fake OpenAI-compatible server.
+func setupOpenAITest(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.AppConfig = &config.Config{
+		LLM: config.LLMConfig{
+			Provider: "openai",
+			BaseURL:  server.URL + "/v1",
+			APIKey:   "test-key",
+			Model:    "test-model",
+		},
+		Analysis: config.AnalysisConfig{
+			MaxPromptLength: 8000,
+		},
+	}
+	return server
+}
+
+func TestOpenAIRequest_Success(t *testing.T) {
+	var received openAIChatRequest
+	setupOpenAITest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path '%s'", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+			t.Errorf("unexpected Authorization header '%s'", auth)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hello"}}]}`))
+	})
+
+	client, err := NewLLMClient(context.Background(), "")
+	if err != nil {
+		t.Fatalf("NewLLMClient() failed: %v", err)
+	}
+	if _, ok := client.(*OpenAIClient); !ok {
+		t.Fatalf("expected an OpenAIClient for llm.provider 'openai', got %T", client)
+	}
+
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("Request() returned error: %v", err)
+	}
+	if got != "Hello" {
+		t.Errorf("expected 'Hello', got '%s'", got)
+	}
+	if received.Model != "test-model" || received.Stream || len(received.Messages) != 2 ||
+		received.Messages[0].Role != "system" || received.Messages[1].Content != "prompt" {
+		t.Errorf("unexpected request body: %+v", received)
+	}
+}
+
+func TestOpenAIRequest_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	setupOpenAITest(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ready"}}]}`))
+	})
+	config.AppConfig.Ollama.MaxRetries = 3
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = 500 * time.Millisecond })
+
+	client, _ := NewOpenAIClient(context.Background(), "")
+	got, err := client.Request(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatalf("expected success after retries, got: %v", err)
+	}
+	if got != "ready" || calls != 3 {
+		t.Errorf("expected 'ready' after 3 calls, got '%s' after %d calls", got, calls)
+	}
+}
+
+func TestOpenAIRequest_DoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	setupOpenAITest(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+	})
+	config.AppConfig.Ollama.MaxRetries = 3
+
+	client, _ := NewOpenAIClient(context.Background(), "")
+	_, err := client.Request(context.Background(), "system", "prompt")
+	if err == nil {
+		t.Fatal("expected an error for an invalid API key")
+	}
+	if calls != 1 {
+		t.Errorf("expected a single call for a non-retryable error, got %d", calls)
+	}
+	if !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("expected the API error in the message, got: %v", err)
+	}
+}
+
+func TestOpenAIStreamRequest_ForwardsChunks(t *testing.T) {
+	setupOpenAITest(t, func(w http.ResponseWriter, r *http.Request) {
+		var received openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&received)
+		if !received.Stream {
+			t.Error("expected a streaming request")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+
+	client, _ := NewOpenAIClient(context.Background(), "")
+	var chunks []string
+	err := client.StreamRequest(context.Background(), "system", "prompt", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("StreamRequest() returned error: %v", err)
+	}
+	if strings.Join(chunks, "") != "Hello" || len(chunks) != 2 {
+		t.Errorf("expected chunks [Hel lo], got %v", chunks)
+	}
+}
+
+func TestNewLLMClient_Providers(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	client, err := NewLLMClient(context.Background(), "")
+	if err != nil {
+		t.Fatalf("NewLLMClient() failed: %v", err)
+	}
+	if _, ok := client.(*OllamaClient); !ok {
+		t.Errorf("expected Ollama by default, got %T", client)
+	}
+
+	config.AppConfig.LLM.Provider = "openai"
+	if _, err := NewLLMClient(context.Background(), ""); err == nil {
+		t.Error("expected an error when no model is configured for the openai provider")
+	}
+	config.AppConfig.LLM.BaseURL = "http://localhost:8000/v1"
+	client, err = NewLLMClient(context.Background(), "override-model")
+	if err != nil {
+		t.Fatalf("NewLLMClient() failed: %v", err)
+	}
+	if openai := client.(*OpenAIClient); openai.model != "override-model" {
+		t.Errorf("expected the request model to be used, got '%s'", openai.model)
+	}
+
+	config.AppConfig.LLM.Provider = "anthropic"
+	if _, err := NewLLMClient(context.Background(), ""); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The project overview is a few sentences on what the project does and how
+// it is organized, written by the model once the structure and the
+// documentation are known. It heads every context summary, so that the
+// planner and the final answer anchor on the same picture instead of
+// re-deriving it at each iteration. It lives in the knowledge base, hence in
+// sessions and in the analysis.cache_dir cache, and is only regenerated when
+// the facts it was written from change.
+
+// overviewSystemPrompt is the system prompt of the overview request.
+const overviewSystemPrompt = "You are a software architecture expert who writes concise project overviews."
+
+// maxOverviewTokens caps the overview kept, it is sent with every prompt.
+const maxOverviewTokens = 300
+
+// overviewFacts fingerprints the facts the overview of kb is written from:
+// the languages, the top-level entries, the dependency files, the entry
+// points and the documentation. The project type refined by the model is
+// left out, its wording changes from one run to the next.
+func (kb *KnowledgeBase) overviewFacts() string {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	var facts []string
+	facts = append(facts, "language:"+kb.PrimaryLanguage)
+	for lang := range kb.Languages {
+		facts = append(facts, "languages:"+lang)
+	}
+	for name := range kb.ProjectStructure {
+		facts = append(facts, "entry:"+name)
+	}
+	for depType, file := range kb.DependencyFiles {
+		facts = append(facts, "dependencies:"+depType+"="+file)
+	}
+	for _, entryPoint := range kb.EntryPoints {
+		facts = append(facts, "entry_point:"+entryPoint)
+	}
+	sort.Strings(facts)
+	facts = append(facts, "readme:"+contentHash(kb.ReadmeContent))
+	return contentHash(strings.Join(facts, "\n"))
+}
+
+// overviewPrompt builds the overview request from what the initial analysis
+// found.
+func overviewPrompt(kb *KnowledgeBase) string {
+	dependencies := make([]string, 0, len(kb.DependencyFiles))
+	for depType, file := range kb.DependencyFiles {
+		dependencies = append(dependencies, fmt.Sprintf("%s (%s)", file, depType))
+	}
+	sort.Strings(dependencies)
+
+	return fmt.Sprintf(`
+Project %s (Type: %s)
+Languages (files per language): %s
+Top-level entries:
+%s
+Dependency files: %s
+Likely entry points: %s
+Documentation (beginning): %s
+---
+Write an overview of this project in 3 to 5 sentences: what it does, its main components and the directories they live in, and how it is built or run.
+Only state what the context above supports. No title, no list.`,
+		filepath.Base(kb.ProjectPath), kb.ProjectType, kb.languageBreakdown(), topLevelEntries(kb.ProjectStructure),
+		strings.Join(dependencies, ", "), strings.Join(kb.EntryPoints, ", "), kb.ReadmeContent)
+}
+
+// refreshProjectOverview generates the overview of kb with client, unless kb
+// already holds one written from the same facts. generated is false when the
+// overview was reused.
+func refreshProjectOverview(ctx context.Context, client LLMClient, log *logrus.Entry, kb *KnowledgeBase) (generated bool, err error) {
+	facts := kb.overviewFacts()
+	if kb.ProjectOverview != "" && kb.OverviewFacts == facts {
+		log.Debug("Project overview reused, the facts it was written from did not change.")
+		return false, nil
+	}
+
+	overview, err := client.Request(ctx, overviewSystemPrompt, overviewPrompt(kb))
+	if err != nil {
+		return false, err
+	}
+	overview = strings.TrimSpace(overview)
+	if overview == "" {
+		return false, fmt.Errorf("empty overview")
+	}
+	if truncated := truncateToTokens(overview, maxOverviewTokens); truncated != overview {
+		overview = truncated + "..."
+	}
+	kb.SetProjectOverview(overview, facts)
+	log.Infof("Project overview generated (%d characters)", len(overview))
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunAnalysis_ProjectOverview(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	config.AppConfig.Analysis.ProjectOverview = true
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		overview:    "A demo service written in Go, started from main.go.",
+		answer:      "The entry point is in main.go",
+	}
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"}, client)
+
+	if _, err := engine.RunAnalysis(); err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+	if engine.kb.ProjectOverview != client.overview || engine.kb.OverviewFacts == "" {
+		t.Errorf("expected the overview in the knowledge base, got %q", engine.kb.ProjectOverview)
+	}
+	if !strings.Contains(client.finalPrompt, "Vue d'Ensemble du Projet:\n"+client.overview+"\n") {
+		t.Errorf("expected the overview in the final prompt, got:\n%s", client.finalPrompt)
+	}
+}
+
+func TestRefreshProjectOverview_OnlyWhenFactsChange(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	kb := NewKnowledgeBase(projectDir)
+	kb.ReadmeContent = "# Demo"
+	kb.DependencyFiles["go"] = "go.mod"
+	client := &fakeLLMClient{overview: "A demo service."}
+	log := kb.log
+
+	if generated, err := refreshProjectOverview(context.Background(), client, log, kb); err != nil || !generated {
+		t.Fatalf("expected a first overview, got generated=%v err=%v", generated, err)
+	}
+	if !strings.HasPrefix(kb.getContextSummary("question", 8000), "Vue d'Ensemble du Projet:\nA demo service.\n\nProblème utilisateur") {
+		t.Errorf("expected the overview at the top of the summary, got:\n%s", kb.getContextSummary("question", 8000))
+	}
+
+	// A knowledge base restored from the cache keeps its overview
+	cachePath := filepath.Join(t.TempDir(), "kb.json")
+	if err := kb.SaveToFile(cachePath); err != nil {
+		t.Fatalf("SaveToFile() returned error: %v", err)
+	}
+	restored := NewKnowledgeBase(projectDir)
+	if err := restored.LoadFromFile(cachePath); err != nil {
+		t.Fatalf("LoadFromFile() returned error: %v", err)
+	}
+	restored.ReadmeContent = "# Demo"
+	restored.DependencyFiles["go"] = "go.mod"
+	if generated, err := refreshProjectOverview(context.Background(), client, log, restored); err != nil || generated {
+		t.Errorf("expected the restored overview to be reused, got generated=%v err=%v", generated, err)
+	}
+	if len(client.phases) != 1 {
+		t.Errorf("expected a single overview request, got %d", len(client.phases))
+	}
+
+	client.overview = "A demo service with a web frontend."
+	restored.DependencyFiles["node"] = "web/package.json"
+	if generated, err := refreshProjectOverview(context.Background(), client, log, restored); err != nil || !generated || restored.ProjectOverview != client.overview {
+		t.Errorf("expected a new dependency file to rewrite the overview, got %q (generated=%v, err=%v)", restored.ProjectOverview, generated, err)
+	}
+}
+
+func TestRunStreamingAnalysis_ProjectOverviewEvent(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	config.AppConfig.Analysis.ProjectOverview = true
+	engine := NewStreamingAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"},
+		&fakeLLMClient{projectType: "Go CLI", overview: "A demo CLI.", chunks: []string{"In main.go"}})
+	rr := httptest.NewRecorder()
+	engine.RunStreamingAnalysis(sseSink{rr})
+
+	if body := rr.Body.String(); !strings.Contains(body, `"message":"Project overview written","iteration":0,"total":0,"data":"A demo CLI."`) {
+		t.Errorf("expected the overview event, got:\n%s", body)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// prefetchedRead is the outcome of a file read ahead by prefetchReads.
+type prefetchedRead struct {
+	content string
+	err     error
+}
+
+// prefetchedReads holds the files read ahead for the current plan, by
+// absolute path.
+type prefetchedReads map[string]prefetchedRead
+
+// prefetchReads reads the files requested by the READ_FILE steps of plan,
+// at most analysis.read_concurrency at once. The steps then consume the
+// results in plan order, so that the knowledge base and the progress events
+// do not depend on the scheduling; ANALYZE steps stay sequential. Files that
+// do not exist as requested, are forbidden or outside the project, or were
+// already read and are unchanged, are left to the step itself.
+func prefetchReads(ctx context.Context, log *logrus.Entry, kb *KnowledgeBase, plan []string) prefetchedReads {
+	concurrency := kb.cfg.Analysis.ReadConcurrency
+	var paths []string
+	seen := make(map[string]bool)
+	for _, step := range plan {
+		action, argument, _ := strings.Cut(step, " ")
+		if action != "READ_FILE" || isForbiddenFile(kb.cfg, argument) {
+			continue
+		}
+		fullPath, err := resolveProjectPath(kb.ProjectPath, argument)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() || seen[fullPath] || kb.IsFileUnchanged(fullPath, info) {
+			continue
+		}
+		seen[fullPath] = true
+		paths = append(paths, fullPath)
+	}
+	if concurrency <= 1 || len(paths) < 2 {
+		return nil
+	}
+
+	reads := make(prefetchedReads, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for _, fullPath := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(fullPath string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			content, err := readFileContent(kb.cfg, log, fullPath)
+			mu.Lock()
+			reads[fullPath] = prefetchedRead{content: content, err: err}
+			mu.Unlock()
+		}(fullPath)
+	}
+	wg.Wait()
+	return reads
+}
+
+// read returns the content of the file at fullPath, from the prefetched
+// reads if it is there, otherwise from the disk.
+func (p prefetchedReads) read(cfg *config.Config, log *logrus.Entry, fullPath string) (string, error) {
+	if read, ok := p[fullPath]; ok {
+		delete(p, fullPath)
+		return read.content, read.err
+	}
+	return readFileContent(cfg, log, fullPath)
+}
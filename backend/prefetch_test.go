@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestPrefetchReads(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	config.AppConfig.Analysis.ReadConcurrency = 2
+	kb := NewKnowledgeBase(projectDir)
+	log := logrus.NewEntry(logrus.StandardLogger())
+	plan := []string{"READ_FILE main.go", "ANALYZE the handler", "READ_FILE go.mod", "READ_FILE README.md", "READ_FILE missing.go", "READ_FILE main.go"}
+
+	reads := prefetchReads(context.Background(), log, kb, plan)
+
+	if len(reads) != 3 {
+		t.Fatalf("expected the 3 existing files to be read ahead, got %d", len(reads))
+	}
+	content, err := reads.read(config.AppConfig, log, filepath.Join(kb.ProjectPath, "go.mod"))
+	if err != nil || !strings.HasPrefix(content, "module example.com/demo") {
+		t.Errorf("expected the prefetched go.mod, got %q (%v)", content, err)
+	}
+	if _, ok := reads[filepath.Join(kb.ProjectPath, "go.mod")]; ok {
+		t.Error("expected a prefetched read to be consumed once")
+	}
+
+	config.AppConfig.Analysis.ReadConcurrency = 1
+	if reads := prefetchReads(context.Background(), log, kb, plan); reads != nil {
+		t.Errorf("expected no prefetching with read_concurrency 1, got %d reads", len(reads))
+	}
+}
+
+func TestRunStreamingAnalysis_ConcurrentReadsKeepPlanOrder(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	config.AppConfig.Analysis.ReadConcurrency = 4
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		plans:       []string{`["READ_FILE main.go", "READ_FILE go.mod", "READ_FILE missing.go"]`},
+		chunks:      []string{"Done"},
+	}
+
+	_, events := runStreamingEngine(t, projectDir, client)
+
+	var reads []string
+	for _, event := range events {
+		if event.Step == "read" && strings.HasPrefix(event.Message, "Successfully read") {
+			reads = append(reads, event.Message)
+		}
+	}
+	expected := []string{"Successfully read: main.go (29 bytes)", "Successfully read: go.mod (33 bytes)"}
+	if strings.Join(reads, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the reads in plan order %v, got %v", expected, reads)
+	}
+}
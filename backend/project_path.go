@@ -0,0 +1,81 @@
+package main
+
+import (
+	"debugagent/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathNotAllowed is returned when a project path lies outside every
+// directory listed in server.allowed_roots.
+var ErrPathNotAllowed = errors.New("project path is not within an allowed root")
+
+// resolveAllowedProjectPath resolves a client-supplied project path (making it
+// absolute, cleaning ".." segments and following symlinks) and checks that it
+// is contained in one of the configured server.allowed_roots. It returns the
+// resolved path on success and an error wrapping ErrPathNotAllowed otherwise.
+func resolveAllowedProjectPath(projectPath string) (string, error) {
+	if projectPath == "" {
+		return "", fmt.Errorf("empty project path")
+	}
+
+	absPath, err := filepath.Abs(projectPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid project path '%s': %w", projectPath, err)
+	}
+	resolvedPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", fmt.Errorf("project path '%s' cannot be resolved: %w", projectPath, err)
+	}
+
+	for _, root := range config.Get().Server.AllowedRoots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		resolvedRoot, err := filepath.EvalSymlinks(absRoot)
+		if err != nil {
+			continue
+		}
+		if isWithinDir(resolvedRoot, resolvedPath) {
+			return resolvedPath, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: '%s'", ErrPathNotAllowed, projectPath)
+}
+
+// localProjectPath resolves the project_path field of an analysis request,
+// a directory read in place instead of an upload. It requires
+// server.allow_local_paths and a directory under server.allowed_roots; on
+// failure it returns the status and the code of the error to answer.
+func localProjectPath(projectPath string) (string, int, string, error) {
+	if !config.Get().Server.AllowLocalPaths {
+		return "", http.StatusForbidden, ErrCodePathNotAllowed, fmt.Errorf("Invalid 'project_path' field, local project paths are disabled (server.allow_local_paths)")
+	}
+	resolvedPath, err := resolveAllowedProjectPath(projectPath)
+	if errors.Is(err, ErrPathNotAllowed) {
+		return "", http.StatusForbidden, ErrCodePathNotAllowed, fmt.Errorf("Invalid 'project_path' field, %w", err)
+	}
+	if err != nil {
+		return "", http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("Invalid 'project_path' field, %w", err)
+	}
+	if info, err := os.Stat(resolvedPath); err != nil || !info.IsDir() {
+		return "", http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("Invalid 'project_path' field, '%s' is not a directory", projectPath)
+	}
+	return resolvedPath, 0, "", nil
+}
+
+// isWithinDir reports whether path is dir itself or one of its descendants.
+// Both paths must be absolute and clean.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupAllowedRootsTest(t *testing.T) (root string, outside string) {
+	base := t.TempDir()
+	root = filepath.Join(base, "projects")
+	outside = filepath.Join(base, "secrets")
+	for _, dir := range []string{filepath.Join(root, "app", "src"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	config.AppConfig = &config.Config{
+		Server: config.ServerConfig{AllowedRoots: []string{root}},
+	}
+	return root, outside
+}
+
+func TestResolveAllowedProjectPath(t *testing.T) {
+	root, outside := setupAllowedRootsTest(t)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		path    string
+		allowed bool
+	}{
+		{"project inside root", filepath.Join(root, "app"), true},
+		{"root itself", root, true},
+		{"cleaned dot segments", filepath.Join(root, "app", "src", ".."), true},
+		{"parent traversal", filepath.Join(root, "app", "..", "..", "secrets"), false},
+		{"relative traversal", root + "/../../etc", false},
+		{"sibling directory", outside, false},
+		{"symlink escaping the root", filepath.Join(root, "link"), false},
+		{"filesystem root", "/", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := resolveAllowedProjectPath(tc.path)
+			if tc.allowed && err != nil {
+				t.Errorf("expected '%s' to be allowed, got: %v", tc.path, err)
+			}
+			if !tc.allowed && err == nil {
+				t.Errorf("expected '%s' to be rejected", tc.path)
+			}
+		})
+	}
+}
+
+func TestResolveAllowedProjectPath_NoRootsConfigured(t *testing.T) {
+	root, _ := setupAllowedRootsTest(t)
+	config.AppConfig.Server.AllowedRoots = nil
+
+	_, err := resolveAllowedProjectPath(root)
+	if !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("expected ErrPathNotAllowed when no roots are configured, got: %v", err)
+	}
+}
+
+func TestLocalProjectPath(t *testing.T) {
+	root, outside := setupAllowedRootsTest(t)
+	config.AppConfig.Server.AllowLocalPaths = true
+	file := filepath.Join(root, "app", "main.go")
+	if err := os.WriteFile(file, []byte("package main"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file, err)
+	}
+
+	testCases := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"project inside root", filepath.Join(root, "app"), 0, ""},
+		{"outside the roots", outside, http.StatusForbidden, ErrCodePathNotAllowed},
+		{"missing directory", filepath.Join(root, "missing"), http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"file", file, http.StatusBadRequest, ErrCodeInvalidRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, status, code, err := localProjectPath(tc.path)
+			if status != tc.status || code != tc.code {
+				t.Errorf("expected %d %q for '%s', got %d %q (%v)", tc.status, tc.code, tc.path, status, code, err)
+			}
+			if tc.status == 0 && (err != nil || dir != tc.path) {
+				t.Errorf("expected '%s' to be read in place, got %q (%v)", tc.path, dir, err)
+			}
+		})
+	}
+}
+
+func TestLocalProjectPath_Disabled(t *testing.T) {
+	root, _ := setupAllowedRootsTest(t)
+
+	_, status, code, err := localProjectPath(filepath.Join(root, "app"))
+	if err == nil || status != http.StatusForbidden || code != ErrCodePathNotAllowed {
+		t.Errorf("expected local paths to be refused without server.allow_local_paths, got %d %q (%v)", status, code, err)
+	}
+}
+
+// newProjectPathRequest builds an /analyze request reading projectPath, with
+// the given uploaded files.
+func newProjectPathRequest(projectPath string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("question", "What does this do?")
+	writer.WriteField("project_path", projectPath)
+	for name, content := range files {
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestAnalyzeHandler_ProjectPath(t *testing.T) {
+	root, outside := setupAllowedRootsTest(t)
+	config.AppConfig.Server.AllowLocalPaths = true
+
+	testCases := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+		code   string
+	}{
+		{"outside the roots", func() *http.Request { return newProjectPathRequest(outside, nil) }, http.StatusForbidden, ErrCodePathNotAllowed},
+		{"along with files", func() *http.Request {
+			return newProjectPathRequest(filepath.Join(root, "app"), map[string]string{"main.go": "package main"})
+		}, http.StatusBadRequest, ErrCodeInvalidRequest},
+	}
+
+	for _, tc := range testCases {
+		for name, handler := range map[string]http.HandlerFunc{"analyze": analyzeHandler, "analyze-async": analyzeAsyncHandler} {
+			t.Run(name+" "+tc.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler(rr, tc.req())
+
+				var body apiErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+					t.Fatalf("expected a JSON error, got %q (%v)", rr.Body.String(), err)
+				}
+				if rr.Code != tc.status || body.Error.Code != tc.code {
+					t.Errorf("expected %d %s, got %d %+v", tc.status, tc.code, rr.Code, body.Error)
+				}
+			})
+		}
+	}
+}
+
+func TestReceiveUpload_KeepsProjectPath(t *testing.T) {
+	root, _ := setupAllowedRootsTest(t)
+	config.AppConfig.Server.AllowLocalPaths = true
+	projectDir := filepath.Join(root, "app")
+
+	req := newProjectPathRequest(projectDir, nil)
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("ParseMultipartForm() returned error: %v", err)
+	}
+	upload, err := receiveUpload(req)
+	if err != nil {
+		t.Fatalf("receiveUpload() returned error: %v", err)
+	}
+	if upload.Dir != projectDir {
+		t.Errorf("expected '%s' to be read in place, got '%s'", projectDir, upload.Dir)
+	}
+
+	upload.remove()
+	if _, err := os.Stat(filepath.Join(projectDir, "src")); err != nil {
+		t.Errorf("expected the local project to be kept, got %v", err)
+	}
+}
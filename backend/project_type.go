@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProjectTypeGuess est le résultat de la détection du type de projet par règles.
+type ProjectTypeGuess struct {
+	Language   string  // Langage principal, par exemple "Go"
+	Label      string  // Type lisible, par exemple "Go project"
+	Confidence float64 // Entre 0 et 1
+}
+
+// projectTypeRules associe les types de fichiers de dépendances (voir
+// DependencyFileMapping) au langage et au type de projet qu'ils indiquent.
+var projectTypeRules = []struct {
+	depType  string
+	language string
+	label    string
+}{
+	{"go", "Go", "Go project"},
+	{"rust", "Rust", "Rust project"},
+	{"npm", "JavaScript", "Node.js project"},
+	{"python", "Python", "Python project"},
+	{"java", "Java", "Java project"},
+	{"composer", "PHP", "PHP project"},
+	{"ruby", "Ruby", "Ruby project"},
+	{"dotnet", "C#", ".NET project"},
+}
+
+// nonCodeLanguages ne suffisent pas à déterminer le type d'un projet.
+var nonCodeLanguages = map[string]bool{
+	"Markdown": true,
+	"JSON":     true,
+	"YAML":     true,
+	"TOML":     true,
+	"HTML":     true,
+	"CSS":      true,
+	"SCSS":     true,
+}
+
+// DetectProjectType devine le type du projet sans passer par le modèle, à
+// partir des fichiers de dépendances trouvés et de la répartition des
+// langages (DetectLanguages doit avoir été appelée). Le résultat est
+// enregistré dans la base, le modèle ne servant ensuite qu'à affiner le libellé.
+func (kb *KnowledgeBase) DetectProjectType() ProjectTypeGuess {
+	guess := kb.guessProjectType()
+	if guess.Language == "" {
+		return guess
+	}
+
+	kb.mu.Lock()
+	kb.PrimaryLanguage = guess.Language
+	kb.ProjectTypeConfidence = guess.Confidence
+	kb.mu.Unlock()
+	kb.SetProjectType(guess.Label)
+	kb.log.Infof("Rule-based project type: %s (%s, confidence %.0f%%)", guess.Label, guess.Language, guess.Confidence*100)
+	return guess
+}
+
+func (kb *KnowledgeBase) guessProjectType() ProjectTypeGuess {
+	// Un fichier de dépendances est l'indice le plus fiable
+	var candidates []ProjectTypeGuess
+	for _, rule := range projectTypeRules {
+		if _, ok := kb.DependencyFiles[rule.depType]; ok {
+			candidates = append(candidates, ProjectTypeGuess{Language: rule.language, Label: rule.label})
+		}
+	}
+	for i := range candidates {
+		// Un projet Node majoritairement en TypeScript
+		if candidates[i].Language == "JavaScript" && kb.Languages["TypeScript"] > kb.Languages["JavaScript"] {
+			candidates[i].Language = "TypeScript"
+			candidates[i].Label = "Node.js project (TypeScript)"
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		// Pas de fichier de dépendances : langage de code le plus représenté
+	case 1:
+		guess := candidates[0]
+		guess.Confidence = 0.8
+		if kb.topCodeLanguage() == guess.Language {
+			guess.Confidence = 0.95
+		}
+		return guess
+	default:
+		// Plusieurs écosystèmes (par exemple un backend Go et un frontend Node) :
+		// celui qui a le plus de fichiers l'emporte
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return kb.Languages[candidates[i].Language] > kb.Languages[candidates[j].Language]
+		})
+		guess := candidates[0]
+		others := make([]string, 0, len(candidates)-1)
+		for _, c := range candidates[1:] {
+			others = append(others, c.Language)
+		}
+		guess.Label = fmt.Sprintf("%s with %s", guess.Label, strings.Join(others, ", "))
+		guess.Confidence = 0.7
+		return guess
+	}
+
+	language := kb.topCodeLanguage()
+	if language == "" {
+		return ProjectTypeGuess{}
+	}
+	total := 0
+	for lang, count := range kb.Languages {
+		if !nonCodeLanguages[lang] {
+			total += count
+		}
+	}
+	return ProjectTypeGuess{
+		Language:   language,
+		Label:      fmt.Sprintf("%s project", language),
+		Confidence: 0.6 * float64(kb.Languages[language]) / float64(total), // Jamais aussi sûr qu'un manifeste
+	}
+}
+
+// topCodeLanguage retourne le langage de code ayant le plus de fichiers.
+func (kb *KnowledgeBase) topCodeLanguage() string {
+	best := ""
+	for lang, count := range kb.Languages {
+		if nonCodeLanguages[lang] {
+			continue
+		}
+		if best == "" || count > kb.Languages[best] || (count == kb.Languages[best] && lang < best) {
+			best = lang
+		}
+	}
+	return best
+}
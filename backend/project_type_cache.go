@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"debugagent/config"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// projectTypeCache garde en mémoire les types de projet estimés par le
+// modèle, pour ne pas lui reposer la question quand le même projet est
+// analysé plusieurs fois. Les entrées sont indexées par un hash du prompt de
+// détection, qui contient la structure, les langages et la détection par
+// règles : un projet modifié obtient donc une nouvelle entrée. Le cache est
+// borné par analysis.project_type_cache_size (éviction LRU) et les entrées
+// expirent après analysis.project_type_cache_ttl_minutes.
+type projectTypeCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List       // Du plus récemment utilisé au plus ancien
+	now     func() time.Time // Remplaçable dans les tests
+}
+
+// projectTypeEntry est un élément de projectTypeCache.order.
+type projectTypeEntry struct {
+	key         string
+	projectType string
+	storedAt    time.Time
+}
+
+// projectTypes est le cache partagé par toutes les analyses.
+var projectTypes = newProjectTypeCache()
+
+func newProjectTypeCache() *projectTypeCache {
+	return &projectTypeCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// projectTypeCacheKey retourne la clé du prompt de détection typePrompt
+// envoyé au modèle model ("" pour le modèle par défaut) du fournisseur
+// provider.
+func projectTypeCacheKey(provider, model, typePrompt string) string {
+	hash := sha256.New()
+	for _, part := range []string{strings.ToLower(provider), model, typePrompt} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// get retourne le type de projet enregistré sous key s'il n'a pas expiré.
+func (c *projectTypeCache) get(key string) (string, bool) {
+	size, ttl := projectTypeCacheLimits()
+	if size <= 0 {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*projectTypeEntry)
+	if ttl > 0 && c.now().Sub(entry.storedAt) >= ttl {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.projectType, true
+}
+
+// put enregistre projectType sous key, en évinçant les entrées les moins
+// récemment utilisées au-delà de la taille maximale.
+func (c *projectTypeCache) put(key, projectType string) {
+	size, _ := projectTypeCacheLimits()
+	if size <= 0 || projectType == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*projectTypeEntry)
+		entry.projectType = projectType
+		entry.storedAt = c.now()
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&projectTypeEntry{key: key, projectType: projectType, storedAt: c.now()})
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*projectTypeEntry).key)
+	}
+}
+
+// projectTypeCacheLimits retourne la taille maximale du cache (0 le
+// désactive) et la durée de vie de ses entrées (0 pour aucune expiration).
+func projectTypeCacheLimits() (int, time.Duration) {
+	cfg := config.Get()
+	if cfg == nil {
+		return 0, 0
+	}
+	analysis := cfg.Analysis
+	return analysis.ProjectTypeCacheSize, time.Duration(analysis.ProjectTypeCacheTTLMinutes) * time.Minute
+}
+
+// requestProjectType demande au modèle le type du projet décrit par
+// typePrompt, ou le reprend du cache si la même question a déjà été posée au
+// même modèle ; cached l'indique. Seules les réponses obtenues sont mises en
+// cache.
+func requestProjectType(ctx context.Context, client LLMClient, model, typePrompt string) (projectType string, cached bool, err error) {
+	key := projectTypeCacheKey(config.FromContext(ctx).LLM.Provider, model, typePrompt)
+	if projectType, ok := projectTypes.get(key); ok {
+		return projectType, true, nil
+	}
+	projectType, err = client.Request(ctx, "You are a software architecture expert.", typePrompt)
+	if err != nil {
+		return "", false, err
+	}
+	projectType = strings.TrimSpace(projectType)
+	projectTypes.put(key, projectType)
+	return projectType, false, nil
+}
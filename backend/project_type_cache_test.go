@@ -0,0 +1,106 @@
+package main
+
+import (
+	"debugagent/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupProjectTypeCacheTest replaces the shared cache with an empty one
+// limited to size entries of ttl minutes, for the duration of the test.
+func setupProjectTypeCacheTest(t *testing.T, size, ttl int) *projectTypeCache {
+	t.Helper()
+	config.AppConfig.Analysis.ProjectTypeCacheSize = size
+	config.AppConfig.Analysis.ProjectTypeCacheTTLMinutes = ttl
+	previous := projectTypes
+	projectTypes = newProjectTypeCache()
+	t.Cleanup(func() { projectTypes = previous })
+	return projectTypes
+}
+
+func TestProjectTypeCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	cache := setupProjectTypeCacheTest(t, 2, 0)
+
+	cache.put("a", "Go CLI")
+	cache.put("b", "React app")
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.put("c", "Python library") // Evicts b, less recently used than a
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for key, expected := range map[string]string{"a": "Go CLI", "c": "Python library"} {
+		if projectType, ok := cache.get(key); !ok || projectType != expected {
+			t.Errorf("expected %s to be cached as %q, got %q (%v)", key, expected, projectType, ok)
+		}
+	}
+}
+
+func TestProjectTypeCache_ExpiresEntries(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	cache := setupProjectTypeCacheTest(t, 10, 5)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.put("a", "Go CLI")
+	now = now.Add(4 * time.Minute)
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached before its expiry")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := cache.get("a"); ok {
+		t.Error("expected a to expire after 5 minutes")
+	}
+	if cache.order.Len() != 0 {
+		t.Errorf("expected the expired entry to be removed, %d left", cache.order.Len())
+	}
+}
+
+func TestProjectTypeCache_Disabled(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	cache := setupProjectTypeCacheTest(t, 0, 0)
+
+	cache.put("a", "Go CLI")
+	if _, ok := cache.get("a"); ok {
+		t.Error("expected nothing to be cached with a size of 0")
+	}
+}
+
+func TestRunStreamingAnalysis_ReusesCachedProjectType(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	setupProjectTypeCacheTest(t, 10, 60)
+
+	first := &fakeLLMClient{projectType: "Go CLI", chunks: []string{"Done"}}
+	runStreamingEngine(t, projectDir, first)
+	second := &fakeLLMClient{projectType: "Something else", chunks: []string{"Done"}}
+	_, events := runStreamingEngine(t, projectDir, second)
+
+	if len(second.phases) != len(first.phases)-1 {
+		t.Errorf("expected no type detection request on the second run, got phases %v then %v", first.phases, second.phases)
+	}
+	identified := false
+	for _, event := range events {
+		if event.Step == "type" && strings.HasPrefix(event.Message, "Identified as: Go CLI (cached)") {
+			identified = true
+		}
+	}
+	if !identified {
+		t.Errorf("expected the cached project type to be reported, got %+v", events)
+	}
+
+	// A different structure asks the model again
+	if err := os.WriteFile(filepath.Join(projectDir, "handler.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("could not write handler.go: %v", err)
+	}
+	third := &fakeLLMClient{projectType: "Go service", chunks: []string{"Done"}}
+	runStreamingEngine(t, projectDir, third)
+	if len(third.phases) != len(first.phases) {
+		t.Errorf("expected a type detection request for a changed structure, got phases %v", third.phases)
+	}
+}
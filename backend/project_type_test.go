@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectProjectType(t *testing.T) {
+	testCases := []struct {
+		name          string
+		dependencies  map[string]string
+		languages     map[string]int
+		expectedLang  string
+		expectedLabel string
+		minConfidence float64
+		maxConfidence float64
+	}{
+		{
+			name:          "go.mod matching the files",
+			dependencies:  map[string]string{"go": "go.mod"},
+			languages:     map[string]int{"Go": 12, "Markdown": 3},
+			expectedLang:  "Go",
+			expectedLabel: "Go project",
+			minConfidence: 0.9, maxConfidence: 1,
+		},
+		{
+			name:          "package.json with mostly TypeScript",
+			dependencies:  map[string]string{"npm": "package.json"},
+			languages:     map[string]int{"TypeScript": 20, "JavaScript": 2},
+			expectedLang:  "TypeScript",
+			expectedLabel: "Node.js project (TypeScript)",
+			minConfidence: 0.9, maxConfidence: 1,
+		},
+		{
+			name:          "several ecosystems",
+			dependencies:  map[string]string{"go": "go.mod", "npm": "package.json"},
+			languages:     map[string]int{"Go": 5, "JavaScript": 30},
+			expectedLang:  "JavaScript",
+			expectedLabel: "Node.js project with Go",
+			minConfidence: 0.7, maxConfidence: 0.7,
+		},
+		{
+			name:          "no manifest, languages only",
+			languages:     map[string]int{"Python": 3, "Shell": 1, "Markdown": 10},
+			expectedLang:  "Python",
+			expectedLabel: "Python project",
+			minConfidence: 0.4, maxConfidence: 0.6,
+		},
+		{
+			name:          "nothing recognisable",
+			languages:     map[string]int{"Markdown": 2},
+			expectedLabel: "Inconnu",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kb := setupKnowledgeBase(t)
+			for depType, file := range tc.dependencies {
+				kb.AddDependencyFile(depType, file)
+			}
+			kb.Languages = tc.languages
+
+			guess := kb.DetectProjectType()
+
+			if guess.Language != tc.expectedLang || kb.PrimaryLanguage != tc.expectedLang {
+				t.Errorf("expected language '%s', got '%s' (kb: '%s')", tc.expectedLang, guess.Language, kb.PrimaryLanguage)
+			}
+			if kb.ProjectType != tc.expectedLabel {
+				t.Errorf("expected project type '%s', got '%s'", tc.expectedLabel, kb.ProjectType)
+			}
+			if guess.Confidence < tc.minConfidence || guess.Confidence > tc.maxConfidence {
+				t.Errorf("expected a confidence in [%.2f, %.2f], got %.2f", tc.minConfidence, tc.maxConfidence, guess.Confidence)
+			}
+		})
+	}
+}
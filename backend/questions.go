@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A request may carry several questions about the same project. The
+// exploration runs once, oriented by all of them, then each question gets its
+// own final answer from the shared knowledge base: the expensive part of the
+// analysis is paid once.
+
+// maxQuestions caps the questions of a request, each one costs a synthesis call.
+const maxQuestions = 10
+
+// QuestionAnswer is the answer to one of the questions of a request, see
+// AnalyzeRequest.Questions.
+type QuestionAnswer struct {
+	Question   string   `json:"-"` // Key of the answer in the "answers" map
+	Answer     string   `json:"answer"`
+	Sources    []Source `json:"sources"`
+	Confidence string   `json:"confidence,omitempty"`
+	Unresolved string   `json:"unresolved,omitempty"`
+}
+
+// collectQuestions returns the questions of a request: question, then the
+// entries of questions, trimmed and without blanks nor duplicates.
+func collectQuestions(question string, questions []string) ([]string, error) {
+	var collected []string
+	seen := make(map[string]bool)
+	for _, q := range append([]string{question}, questions...) {
+		if q = strings.TrimSpace(q); q != "" && !seen[q] {
+			seen[q] = true
+			collected = append(collected, q)
+		}
+	}
+	if len(collected) > maxQuestions {
+		return nil, fmt.Errorf("Invalid 'questions' field, more than %d questions", maxQuestions)
+	}
+	return collected, nil
+}
+
+// errMissingQuestion is returned by requiredQuestions for a request without
+// any question.
+var errMissingQuestion = errors.New("Missing 'question' field")
+
+// requiredQuestions is collectQuestions for a request that must carry at
+// least one question. It is the check of requireQuestions, for the endpoints
+// that report errors as events rather than JSON.
+func requiredQuestions(question string, questions []string) ([]string, error) {
+	collected, err := collectQuestions(question, questions)
+	if err != nil {
+		return nil, err
+	}
+	if len(collected) == 0 {
+		return nil, errMissingQuestion
+	}
+	return collected, nil
+}
+
+// requireQuestions is requireQuestion for the requests that also take a
+// "questions" list. It answers 400 when there is no question or too many,
+// in which case it returns false.
+func requireQuestions(w http.ResponseWriter, question string, questions []string) ([]string, bool) {
+	collected, err := requiredQuestions(question, questions)
+	if errors.Is(err, errMissingQuestion) {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeMissingQuestion, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return nil, false
+	}
+	return collected, true
+}
+
+// withQuestions sets the question of req from questions, as returned by
+// collectQuestions. A single question is an ordinary request; several are
+// combined into the question that guides the exploration.
+func (req AnalyzeRequest) withQuestions(questions []string) AnalyzeRequest {
+	if len(questions) == 1 {
+		req.Question = questions[0]
+		return req
+	}
+	var combined strings.Builder
+	combined.WriteString("Several questions, explore what is needed to answer each of them:")
+	for i, question := range questions {
+		fmt.Fprintf(&combined, "\n%d. %s", i+1, question)
+	}
+	req.Question = combined.String()
+	req.Questions = questions
+	return req
+}
+
+// answersByQuestion returns the "answers" field of a response, nil for a
+// request with a single question.
+func answersByQuestion(answers []QuestionAnswer) map[string]QuestionAnswer {
+	if len(answers) == 0 {
+		return nil
+	}
+	byQuestion := make(map[string]QuestionAnswer, len(answers))
+	for _, answer := range answers {
+		byQuestion[answer.Question] = answer
+	}
+	return byQuestion
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectQuestions(t *testing.T) {
+	questions, err := collectQuestions(" Where does it start? ", []string{"", "Where does it start?", "How is it configured?\n"})
+	if err != nil || !reflect.DeepEqual(questions, []string{"Where does it start?", "How is it configured?"}) {
+		t.Errorf("expected the trimmed questions without blanks nor duplicates, got %q (%v)", questions, err)
+	}
+	if questions, err := collectQuestions("", nil); err != nil || len(questions) != 0 {
+		t.Errorf("expected no question, got %q (%v)", questions, err)
+	}
+
+	tooMany := make([]string, maxQuestions+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("?", i+1)
+	}
+	if _, err := collectQuestions("", tooMany); err == nil {
+		t.Errorf("expected an error above %d questions", maxQuestions)
+	}
+}
+
+func TestWithQuestions(t *testing.T) {
+	single := AnalyzeRequest{}.withQuestions([]string{"Where does it start?"})
+	if single.Question != "Where does it start?" || single.Questions != nil {
+		t.Errorf("expected an ordinary request for a single question, got %+v", single)
+	}
+
+	several := AnalyzeRequest{}.withQuestions([]string{"Where does it start?", "How is it configured?"})
+	if !strings.Contains(several.Question, "1. Where does it start?\n2. How is it configured?") || len(several.Questions) != 2 {
+		t.Errorf("expected the exploration to be guided by both questions, got %+v", several)
+	}
+}
+
+func TestRunAnalysis_MultipleQuestions(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		answer:      "See main.go\nConfidence: medium\nNot determined: nothing",
+		plans:       []string{`[{"action": "READ_FILE", "argument": "main.go"}]`},
+	}
+	questions := []string{"Where does it start?", "How is it configured?"}
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir}.withQuestions(questions), client)
+
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+
+	// A single exploration, then an answer per question
+	if client.planCalls != 2 {
+		t.Errorf("expected a single exploration, got %d planning calls", client.planCalls)
+	}
+	synthesis := 0
+	for _, phase := range client.phases {
+		if phase == phaseSynthesis {
+			synthesis++
+		}
+	}
+	if synthesis != len(questions) {
+		t.Errorf("expected a synthesis per question, got %d", synthesis)
+	}
+	if !strings.Contains(client.finalPrompt, `initial question: "How is it configured?"`) {
+		t.Errorf("expected the last synthesis to answer the last question alone, got:\n%s", client.finalPrompt)
+	}
+
+	answers := answersByQuestion(result.Answers)
+	if len(answers) != 2 || result.Answer != "See main.go" {
+		t.Fatalf("expected an answer per question, got %+v", result)
+	}
+	for _, question := range questions {
+		answer := answers[question]
+		if answer.Answer != "See main.go" || answer.Confidence != confidenceMedium || len(answer.Sources) == 0 {
+			t.Errorf("unexpected answer to %q: %+v", question, answer)
+		}
+	}
+}
+
+func TestRunStreamingAnalysis_MultipleQuestions(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		chunks:      []string{"See main.go\nConfidence: medium\nNot determined: nothing"},
+		plans:       []string{`[{"action": "READ_FILE", "argument": "main.go"}]`},
+	}
+	questions := []string{"Where does it start?", "How is it configured?"}
+	engine := NewStreamingAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir}.withQuestions(questions), client)
+	rr := httptest.NewRecorder()
+
+	engine.RunStreamingAnalysis(sseSink{rr})
+
+	if client.planCalls != 2 {
+		t.Errorf("expected a single exploration, got %d planning calls", client.planCalls)
+	}
+	events := parseEvents(t, rr.Body.String())
+	var announced []string
+	for _, event := range events {
+		if event.Type == "step" && event.Step == "question" {
+			announced = append(announced, event.Data)
+		}
+	}
+	if !reflect.DeepEqual(announced, questions) {
+		t.Errorf("expected a question step before each answer, got %v", announced)
+	}
+	result := events[len(events)-1]
+	if result.Type != "result" || result.Data != "See main.go" || len(result.Answers) != 2 {
+		t.Fatalf("expected a result event with an answer per question, got %+v", result)
+	}
+	for _, question := range questions {
+		if answer := result.Answers[question]; answer.Answer != "See main.go" || answer.Confidence != confidenceMedium {
+			t.Errorf("unexpected answer to %q: %+v", question, answer)
+		}
+	}
+}
+
+// newQuestionsRequest builds a multipart analysis request of analysisFiles
+// with a "questions" field per question.
+func newQuestionsRequest(target string, questions []string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, question := range questions {
+		writer.WriteField("questions", question)
+	}
+	for name, content := range analysisFiles {
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// checkAnswers fails unless answers holds the answer of fakeLLMServer to
+// each of questions.
+func checkAnswers(t *testing.T, answers map[string]QuestionAnswer, questions []string) {
+	t.Helper()
+	if len(answers) != len(questions) {
+		t.Fatalf("expected an answer per question, got %+v", answers)
+	}
+	for _, question := range questions {
+		if answer := answers[question]; answer.Answer != "About: "+question {
+			t.Errorf("unexpected answer to %q: %+v", question, answer)
+		}
+	}
+}
+
+func TestAnalyzeStreamHandler_MultipleQuestions(t *testing.T) {
+	fake := setupFakeLLMServer(t)
+	questions := []string{"Where does it start?", "How is it configured?"}
+	rr := httptest.NewRecorder()
+
+	analyzeStreamHandler(rr, newQuestionsRequest("/analyze-stream", questions))
+
+	events := parseEvents(t, rr.Body.String())
+	result := events[len(events)-1]
+	if result.Type != "result" || result.Data != "About: Where does it start?" {
+		t.Fatalf("expected a result event answering the first question, got %+v", result)
+	}
+	checkAnswers(t, result.Answers, questions)
+	if len(fake.synthesisPrompts()) != len(questions) {
+		t.Errorf("expected a synthesis per question, got %d", len(fake.synthesisPrompts()))
+	}
+}
+
+func TestAnalyzeIncrementalHandler_MultipleQuestions(t *testing.T) {
+	setupFakeLLMServer(t)
+	config.AppConfig.Analysis.CacheDir = t.TempDir()
+	questions := []string{"Where does it start?", "How is it configured?"}
+	rr := httptest.NewRecorder()
+
+	analyzeIncrementalHandler(rr, newQuestionsRequest("/analyze-incremental", questions))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+	}
+	var resp IncrementalAnalyzeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.Answer != "About: Where does it start?" {
+		t.Errorf("expected the top-level answer to be the first question's, got %q", resp.Answer)
+	}
+	checkAnswers(t, resp.Answers, questions)
+
+	// Each question is kept for the follow-up questions of the session
+	session, err := openSession(resp.CacheID)
+	if err != nil {
+		t.Fatalf("could not open the session: %v", err)
+	}
+	defer session.close()
+	kb := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: session.projectDir(), Cached: true}, &fakeLLMClient{}).kb
+	if len(kb.PreviousQuestions) != len(questions) {
+		t.Errorf("expected each question to be recorded, got %+v", kb.PreviousQuestions)
+	}
+}
+
+func TestAnalyzeWSHandler_MultipleQuestions(t *testing.T) {
+	setupFakeLLMServer(t)
+	server := httptest.NewServer(http.HandlerFunc(analyzeWSHandler))
+	defer server.Close()
+	questions := []string{"Where does it start?", "How is it configured?"}
+
+	client := dialWebSocket(t, server, "/analyze-ws")
+	client.writeFrame(true, wsText, []byte(`{"questions":["Where does it start?","How is it configured?"],"files":[
+		{"path":"go.mod","content":"module example.com/demo\n"},
+		{"path":"main.go","content":"package main\n\nfunc main() {}\n"}]}`))
+	events := client.readEvents(t)
+
+	result := events[len(events)-1]
+	if result.Type != "result" || result.Data != "About: Where does it start?" {
+		t.Fatalf("expected a result event answering the first question, got %+v", result)
+	}
+	checkAnswers(t, result.Answers, questions)
+}
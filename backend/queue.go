@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueTimeout is returned when an analysis waited too long for a slot.
+var ErrQueueTimeout = errors.New("timed out waiting for an analysis slot")
+
+// analysisQueue bounds the number of analyses running at once. Ollama usually
+// serves a single GPU, so concurrent explorations only make each other time
+// out; the extra requests wait in FIFO order instead.
+type analysisQueue struct {
+	mu      sync.Mutex
+	limit   int            // Maximum number of running analyses, 0 means unlimited
+	running int            // Analyses currently holding a slot
+	waiting []*queueTicket // Queued requests, oldest first
+}
+
+// queueTicket is a request waiting for a slot.
+type queueTicket struct {
+	ready chan struct{} // Closed when the slot is handed to the ticket
+	moved chan struct{} // Signaled when the ticket moves up in the queue
+}
+
+// analyses is the queue shared by the analysis handlers, created in main().
+// A nil queue doesn't limit anything.
+var analyses *analysisQueue
+
+// newAnalysisQueue creates a queue running at most limit analyses at once.
+func newAnalysisQueue(limit int) *analysisQueue {
+	return &analysisQueue{limit: limit}
+}
+
+// acquire waits for a slot and returns the function releasing it. onQueued is
+// called with the 1-based position of the request each time it changes while
+// it waits; it is not called when a slot is available right away. Waiting
+// stops with ErrQueueTimeout after timeout (0 waits indefinitely), or with the
+// context error if the client goes away.
+func (q *analysisQueue) acquire(ctx context.Context, timeout time.Duration, onQueued func(position int)) (func(), error) {
+	if q == nil || q.limit <= 0 {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.running < q.limit {
+		q.running++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	ticket := &queueTicket{ready: make(chan struct{}), moved: make(chan struct{}, 1)}
+	q.waiting = append(q.waiting, ticket)
+	position := len(q.waiting)
+	q.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		if onQueued != nil {
+			onQueued(position)
+		}
+		select {
+		case <-ticket.ready:
+			return q.release, nil
+		case <-ticket.moved:
+			q.mu.Lock()
+			position = q.position(ticket)
+			q.mu.Unlock()
+			if position == 0 { // Already handed a slot
+				<-ticket.ready
+				return q.release, nil
+			}
+		case <-expired:
+			return nil, q.abandon(ticket, ErrQueueTimeout)
+		case <-ctx.Done():
+			return nil, q.abandon(ticket, ctx.Err())
+		}
+	}
+}
+
+// release hands the slot to the oldest queued request, or frees it.
+func (q *analysisQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 {
+		q.running--
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next.ready)
+	q.notifyMoved(0)
+}
+
+// abandon removes a ticket that stopped waiting. If the slot was handed to it
+// in the meantime, the slot is passed on so that it isn't lost.
+func (q *analysisQueue) abandon(ticket *queueTicket, err error) error {
+	q.mu.Lock()
+	index := q.position(ticket) - 1
+	if index >= 0 {
+		q.waiting = append(q.waiting[:index], q.waiting[index+1:]...)
+		q.notifyMoved(index)
+		q.mu.Unlock()
+		return err
+	}
+	q.mu.Unlock()
+	q.release()
+	return err
+}
+
+// position returns the 1-based position of ticket, 0 if it is no longer
+// queued. The caller must hold q.mu.
+func (q *analysisQueue) position(ticket *queueTicket) int {
+	for i, t := range q.waiting {
+		if t == ticket {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// notifyMoved signals the tickets from index on that they moved up. The
+// caller must hold q.mu.
+func (q *analysisQueue) notifyMoved(index int) {
+	for _, t := range q.waiting[index:] {
+		select {
+		case t.moved <- struct{}{}:
+		default: // A signal is already pending
+		}
+	}
+}
+
+// queueTimeout returns the configured maximum time spent queued.
+func queueTimeout() time.Duration {
+	return time.Duration(config.Get().Server.QueueTimeoutSeconds) * time.Second
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAnalysisQueue_FIFO(t *testing.T) {
+	q := newAnalysisQueue(1)
+	release, err := q.acquire(context.Background(), 0, func(int) {
+		t.Error("expected the first request to get a slot right away")
+	})
+	if err != nil {
+		t.Fatalf("acquire() returned error: %v", err)
+	}
+
+	type grant struct {
+		id      int
+		release func()
+	}
+	granted := make(chan grant, 2)
+	positions := make(chan int, 10)
+	for i := 1; i <= 2; i++ {
+		i := i
+		queued := make(chan struct{})
+		var once sync.Once
+		go func() {
+			releaseNext, err := q.acquire(context.Background(), 0, func(position int) {
+				if i == 2 {
+					positions <- position
+				}
+				once.Do(func() { close(queued) })
+			})
+			if err != nil {
+				t.Errorf("acquire() returned error: %v", err)
+				return
+			}
+			granted <- grant{i, releaseNext}
+		}()
+		<-queued // Queue the requests in a known order
+	}
+	if position := <-positions; position != 2 {
+		t.Errorf("expected the second request to be queued at position 2, got %d", position)
+	}
+
+	release()
+	first := <-granted
+	if first.id != 1 {
+		t.Errorf("expected requests to run in arrival order, request %d ran first", first.id)
+	}
+	if position := <-positions; position != 1 {
+		t.Errorf("expected the second request to move up to position 1, got %d", position)
+	}
+	first.release()
+	if second := <-granted; second.id != 2 {
+		t.Errorf("expected request 2 to run next, got %d", second.id)
+	} else {
+		second.release()
+	}
+}
+
+func TestAnalysisQueue_Timeout(t *testing.T) {
+	q := newAnalysisQueue(1)
+	release, _ := q.acquire(context.Background(), 0, nil)
+
+	if _, err := q.acquire(context.Background(), 20*time.Millisecond, nil); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.acquire(ctx, 0, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Abandoned requests must neither keep their place nor leak the slot
+	release()
+	next, err := q.acquire(context.Background(), 20*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("expected the released slot to be available, got %v", err)
+	}
+	next()
+	if q.running != 0 || len(q.waiting) != 0 {
+		t.Errorf("expected an idle queue, got %d running and %d waiting", q.running, len(q.waiting))
+	}
+}
+
+func TestAnalysisQueue_Unlimited(t *testing.T) {
+	for _, q := range []*analysisQueue{nil, newAnalysisQueue(0)} {
+		for i := 0; i < 3; i++ {
+			if _, err := q.acquire(context.Background(), time.Millisecond, nil); err != nil {
+				t.Errorf("expected no limit, got %v", err)
+			}
+		}
+	}
+}
+
+func TestAnalyzeHandler_QueueTimeout(t *testing.T) {
+	setupUploadTest(0, 0)
+	config.AppConfig.Server.QueueTimeoutSeconds = 1
+
+	analyses = newAnalysisQueue(1)
+	defer func() { analyses = nil }()
+	release, _ := analyses.acquire(context.Background(), 0, nil)
+	defer release()
+
+	rr := httptest.NewRecorder()
+	analyzeHandler(rr, newUploadRequest("/analyze", map[string]string{"main.go": "package main"}))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
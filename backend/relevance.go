@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// relevanceStopWords sont les mots trop courants (anglais et français) pour
+// départager les fichiers. Les mots de moins de 3 lettres sont ignorés d'office.
+var relevanceStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "how": true, "what": true, "why": true,
+	"does": true, "this": true, "that": true, "with": true, "from": true, "are": true,
+	"les": true, "des": true, "une": true, "est": true, "que": true, "qui": true,
+	"dans": true, "pour": true, "comment": true, "quoi": true, "pourquoi": true,
+	"sur": true, "avec": true, "mon": true, "projet": true, "project": true,
+}
+
+// questionKeywords extrait les mots significatifs d'une question, en minuscules.
+func questionKeywords(question string) []string {
+	seen := make(map[string]bool)
+	var keywords []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len([]rune(word)) < 3 || relevanceStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+	}
+	return keywords
+}
+
+// pathTokens retourne les mots des composants de path (répertoires, nom et
+// extension du fichier), en minuscules. Un mot composé avec des "_" y figure
+// entier et découpé, pour répondre aux deux formes de questionKeywords.
+func pathTokens(path string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(path), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		tokens[word] = true
+		for _, part := range strings.Split(word, "_") {
+			tokens[part] = true
+		}
+	}
+	return tokens
+}
+
+// relevanceScore mesure le recouvrement entre un fichier et les mots-clés de
+// la question. Le chemin pèse le plus : un mot-clé qui nomme un répertoire ou
+// le fichier compte davantage qu'un mot-clé simplement contenu dans le chemin,
+// qui compte lui-même davantage qu'une occurrence dans le contenu, dont
+// l'apport est plafonné pour qu'un seul mot répété ne l'emporte pas sur
+// plusieurs mots-clés distincts.
+func relevanceScore(path, content string, keywords []string) int {
+	lowerPath := strings.ToLower(path)
+	tokens := pathTokens(path)
+	lowerContent := strings.ToLower(content)
+	score := 0
+	for _, keyword := range keywords {
+		if tokens[keyword] {
+			score += 15
+		} else if strings.Contains(lowerPath, keyword) {
+			score += 10
+		}
+		score += min(strings.Count(lowerContent, keyword), 5)
+	}
+	return score
+}
+
+// relevanceScanPrefix retourne le début de content examiné par le classement
+// des fichiers : ses maxBytes premiers octets, sans couper de caractère, ou
+// tout content si maxBytes vaut 0 (analysis.relevance_scan_bytes). Le chemin
+// apporte l'essentiel du signal, il est inutile de parcourir les gros fichiers
+// en entier.
+func relevanceScanPrefix(content string, maxBytes int) string {
+	if maxBytes <= 0 || len(content) <= maxBytes {
+		return content
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut]
+}
+
+// filesByRelevance retourne les chemins de FileContents du plus pertinent au
+// moins pertinent pour question, par ordre alphabétique à score égal.
+func (kb *KnowledgeBase) filesByRelevance(question string) []string {
+	keywords := questionKeywords(question)
+	scanBytes := kb.cfg.Analysis.RelevanceScanBytes
+	scores := make(map[string]int, len(kb.FileContents))
+	paths := make([]string, 0, len(kb.FileContents))
+	for path, content := range kb.FileContents {
+		scores[path] = relevanceScore(path, relevanceScanPrefix(content, scanBytes), keywords)
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if scores[paths[i]] != scores[paths[j]] {
+			return scores[paths[i]] > scores[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// declarationLine reconnaît les lignes qui ouvrent une déclaration (fonction,
+// classe, type...) dans les langages courants.
+var declarationLine = regexp.MustCompile(`^\s*(?:(?:export|default|public|private|protected|internal|static|final|abstract|async|pub(?:\([a-z]+\))?)\s+)*(?:func|function|def|class|interface|struct|enum|trait|impl|fn|module)\b|^\s*type\s+\w+\s+(?:struct|interface|func)\b`)
+
+// excerptLookback est le nombre maximal de lignes remontées depuis la ligne
+// contenant un mot-clé pour retrouver la déclaration qui l'englobe.
+const excerptLookback = 10
+
+// fileExcerpt retourne un extrait d'au plus maxChars caractères de content,
+// lignes et indentation conservées, avec les numéros de sa première et de sa
+// dernière ligne (à partir de 1). L'extrait commence autour de la première
+// ligne contenant un mot-clé de la question (à la déclaration qui la précède
+// si elle est proche), sinon à la première déclaration, sinon au début du
+// fichier : les en-têtes (package, imports) apprennent peu au modèle.
+func fileExcerpt(content string, keywords []string, maxChars int) (string, int, int) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	start := excerptStart(lines, keywords)
+
+	var excerpt []string
+	chars, last := 0, start
+	for end := start; end < len(lines); end++ {
+		line := []rune(strings.TrimRightFunc(lines[end], unicode.IsSpace))
+		if len(line) == 0 && (len(excerpt) == 0 || excerpt[len(excerpt)-1] == "") {
+			continue // Pas de ligne vide en tête ni à la suite d'une autre
+		}
+		if chars+len(line)+1 > maxChars {
+			if len(excerpt) == 0 && maxChars > 0 {
+				// Une première ligne trop longue est coupée plutôt qu'omise
+				excerpt = append(excerpt, string(line[:maxChars]))
+				last = end
+			}
+			break
+		}
+		excerpt = append(excerpt, string(line))
+		chars += len(line) + 1
+		if len(line) > 0 {
+			last = end
+		}
+	}
+	for len(excerpt) > 0 && excerpt[len(excerpt)-1] == "" {
+		excerpt = excerpt[:len(excerpt)-1]
+	}
+	return strings.Join(excerpt, "\n"), start + 1, last + 1
+}
+
+// excerptStart retourne l'indice de la ligne par laquelle fileExcerpt commence.
+func excerptStart(lines []string, keywords []string) int {
+	for i, line := range lines {
+		lower := strings.ToLower(line)
+		for _, keyword := range keywords {
+			if !strings.Contains(lower, keyword) {
+				continue
+			}
+			for j := i; j >= max(0, i-excerptLookback); j-- {
+				if declarationLine.MatchString(lines[j]) {
+					return j
+				}
+			}
+			return max(0, i-2)
+		}
+	}
+	for i, line := range lines {
+		if declarationLine.MatchString(line) {
+			return i
+		}
+	}
+	return 0
+}
+
+// formatExcerpt présente un extrait de fileExcerpt dans un bloc de code,
+// précédé des lignes qu'il couvre. Les ``` du fichier sont neutralisés pour
+// ne pas fermer le bloc.
+func formatExcerpt(excerpt string, first, last int) string {
+	return fmt.Sprintf("(lignes %d-%d):\n```\n%s\n```", first, last, strings.ReplaceAll(excerpt, "```", "'''"))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"debugagent/config"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuestionKeywords(t *testing.T) {
+	got := questionKeywords("How does the Auth middleware validate JWT tokens? Comment est géré le token_refresh ?")
+	expected := []string{"auth", "middleware", "validate", "jwt", "tokens", "géré", "token_refresh"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestFilesByRelevance(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "README.md"), "A small web server.")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "auth/middleware.go"), "func Middleware() { validateToken() }")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "handlers.go"), "// uses the token from the auth middleware")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "db.go"), "package db")
+
+	got := kb.filesByRelevance("How does the auth middleware check the token?")
+	expected := []string{"auth/middleware.go", "handlers.go", "README.md", "db.go"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRelevanceScore_PathComponents(t *testing.T) {
+	keywords := questionKeywords("Why does the billing retry fail?")
+	directory := relevanceScore("billing/client.go", "", keywords)
+	substring := relevanceScore("rebilling.go", "", keywords)
+	content := relevanceScore("client.go", "// billing", keywords)
+	if !(directory > substring && substring > content && content > 0) {
+		t.Errorf("expected a directory name to beat a path substring, itself beating the content, got %d, %d and %d", directory, substring, content)
+	}
+	if got := relevanceScore("payment_retry/queue.go", "", keywords); got != 15 {
+		t.Errorf("expected the parts of a snake_case directory to be path tokens, got %d", got)
+	}
+}
+
+func TestRelevanceScanPrefix(t *testing.T) {
+	if got := relevanceScanPrefix("héllo", 2); got != "h" {
+		t.Errorf("expected the cut not to split a character, got %q", got)
+	}
+	if got := relevanceScanPrefix("hello", 0); got != "hello" {
+		t.Errorf("expected 0 to scan the whole content, got %q", got)
+	}
+}
+
+func TestFilesByRelevance_ScanBytes(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.RelevanceScanBytes = 64
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "big.go"), strings.Repeat("// filler\n", 100)+"func invoice() {}")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "small.go"), "func invoice() {}")
+
+	got := kb.filesByRelevance("Why is the invoice wrong?")
+	if expected := []string{"small.go", "big.go"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the keyword beyond the scanned bytes to be ignored, got %v", got)
+	}
+}
+
+func TestGetContextSummary_LeadsWithRelevantFiles(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	for i := 0; i < 10; i++ {
+		kb.AddFileContent(filepath.Join(kb.ProjectPath, fmt.Sprintf("util%d.go", i)), "package util")
+	}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "payment/invoice.go"), "func ComputeInvoiceTotal() {}")
+
+	summary := kb.getContextSummary("Why is the invoice total wrong?", 2000)
+	invoice := strings.Index(summary, "`payment/invoice.go`")
+	if invoice < 0 {
+		t.Fatal("expected the most relevant file to be included in the summary")
+	}
+	if util := strings.Index(summary, "`util"); util >= 0 && util < invoice {
+		t.Error("expected the most relevant file to come first")
+	}
+}
+
+func TestFileExcerpt(t *testing.T) {
+	source := strings.Join([]string{
+		"package billing", // 1
+		"",
+		"import \"fmt\"",
+		"",
+		"// Invoice is a customer invoice.", // 5
+		"type Invoice struct {",
+		"\tLines []Line",
+		"}",
+		"",
+		"func (i Invoice) Total() int {", // 10
+		"\ttotal := 0",
+		"\tfor _, line := range i.Lines {",
+		"\t\ttotal += line.Amount",
+		"\t}",
+		"\treturn total", // 15
+		"}",
+	}, "\n")
+
+	testCases := []struct {
+		name        string
+		keywords    []string
+		maxChars    int
+		expected    string
+		first, last int
+	}{
+		{
+			name:     "first declaration without keywords",
+			maxChars: 60,
+			expected: "type Invoice struct {\n\tLines []Line\n}",
+			first:    6, last: 8,
+		},
+		{
+			name:     "enclosing declaration of a keyword",
+			keywords: []string{"amount"},
+			maxChars: 200,
+			expected: "func (i Invoice) Total() int {\n\ttotal := 0\n\tfor _, line := range i.Lines {\n\t\ttotal += line.Amount\n\t}\n\treturn total\n}",
+			first:    10, last: 16,
+		},
+		{
+			name:     "long first line cut",
+			keywords: []string{"total"},
+			maxChars: 10,
+			expected: "func (i In",
+			first:    10, last: 10,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			excerpt, first, last := fileExcerpt(source, tc.keywords, tc.maxChars)
+			if excerpt != tc.expected || first != tc.first || last != tc.last {
+				t.Errorf("expected lines %d-%d %q, got lines %d-%d %q", tc.first, tc.last, tc.expected, first, last, excerpt)
+			}
+		})
+	}
+}
+
+func TestGetContextSummary_ExcerptAroundKeywords(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	config.AppConfig.Analysis.MaxExcerptChars = 120
+	var content strings.Builder
+	content.WriteString("package server\n\nimport \"net/http\"\n\n")
+	for i := 0; i < 30; i++ {
+		content.WriteString(fmt.Sprintf("func helper%d() {}\n", i))
+	}
+	content.WriteString("func authenticate(r *http.Request) error {\n\treturn checkToken(r)\n}\n")
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "server.go"), content.String())
+
+	summary := kb.getContextSummary("Why does authenticate reject my token?", 4000)
+	if !strings.Contains(summary, "func authenticate(r *http.Request) error {\n\treturn checkToken(r)\n}") {
+		t.Errorf("expected the excerpt to show the authenticate function, got:\n%s", summary)
+	}
+	if strings.Contains(summary, "helper0") {
+		t.Errorf("expected the excerpt to skip the unrelated helpers, got:\n%s", summary)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen []string
+	handler := requestIDMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, requestIDFromContext(r.Context()))
+	})
+
+	var headers []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodPost, "/analyze", nil))
+		headers = append(headers, rr.Header().Get(requestIDHeader))
+	}
+
+	for i := range headers {
+		if headers[i] == "" || headers[i] != seen[i] {
+			t.Errorf("request %d: expected the header '%s' to match the context ID '%s'", i, headers[i], seen[i])
+		}
+	}
+	if headers[0] == headers[1] {
+		t.Errorf("expected distinct IDs, got '%s' twice", headers[0])
+	}
+}
+
+func TestAnalyzeStreamHandler_CorrelatesRequestID(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model 'test-model' not found"}`, http.StatusNotFound)
+	})
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	rr := httptest.NewRecorder()
+	req := newUploadRequest("/analyze-stream", map[string]string{"main.go": "package main"})
+	requestIDMiddleware(analyzeStreamHandler)(rr, req)
+
+	id := rr.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatal("expected an X-Request-ID header")
+	}
+
+	events := 0
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event ProgressEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		if event.RequestID != id {
+			t.Errorf("expected request_id '%s' in event %+v", id, event)
+		}
+		events++
+	}
+	if events == 0 {
+		t.Fatal("expected SSE events")
+	}
+
+	correlated := false
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "Using Ollama client") {
+			correlated = entry.Data["request_id"] == id
+		}
+	}
+	if !correlated {
+		t.Errorf("expected the engine logs to carry request_id '%s'", id)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"debugagent/config"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minIndexedWordLen est la longueur minimale (en caractères) des mots indexés,
+// comme pour les mots-clés de la question (voir questionKeywords).
+const minIndexedWordLen = 3
+
+// searchIndex est un index inversé (mot -> fichiers) des fichiers texte du
+// projet, construit une fois pendant l'analyse initiale. SEARCH n'ouvre plus
+// que les fichiers pouvant contenir le motif au lieu de relire tout le projet,
+// et le contexte du planificateur signale les fichiers non lus qui mentionnent
+// la question.
+//
+// La mémoire est bornée par analysis.search_index_max_entries : une fois la
+// limite atteinte, les fichiers suivants ne sont plus indexés mais restent
+// parcourus à chaque recherche.
+type searchIndex struct {
+	files    []string           // Fichiers cherchables (chemins relatifs), dans l'ordre du parcours
+	indexed  int                // files[:indexed] sont indexés, les suivants non
+	postings map[string][]int32 // Mot en minuscules -> indices croissants dans files
+	entries  int                // Nombre total d'entrées des postings
+}
+
+// buildSearchIndex indexe les fichiers que searchProject parcourt. Il retourne
+// nil si analysis.search_index_max_entries vaut 0.
+func buildSearchIndex(cfg *config.Config, log *logrus.Entry, rootDir string) *searchIndex {
+	maxEntries := cfg.Analysis.SearchIndexMaxEntries
+	if maxEntries <= 0 {
+		return nil
+	}
+
+	idx := &searchIndex{postings: make(map[string][]int32)}
+	full := false
+	walkSearchableFiles(cfg, rootDir, func(path, relPath string) error {
+		if !full {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			text, _, err := decodeText(cfg, content)
+			if err != nil {
+				return nil // Fichier binaire ou encodage non reconnu, ignoré aussi par SEARCH
+			}
+			words := indexWords(text)
+			if idx.entries+len(words) <= maxEntries {
+				id := int32(len(idx.files))
+				for _, word := range words {
+					idx.postings[word] = append(idx.postings[word], id)
+				}
+				idx.entries += len(words)
+				idx.files = append(idx.files, relPath)
+				idx.indexed++
+				return nil
+			}
+			full = true
+		}
+		idx.files = append(idx.files, relPath)
+		return nil
+	})
+
+	if full {
+		log.Warnf("Search index full (%d entries): %d of %d files indexed, the others are scanned at each search.", idx.entries, idx.indexed, len(idx.files))
+	} else {
+		log.Infof("Search index built: %d files, %d words, %d entries.", idx.indexed, len(idx.postings), idx.entries)
+	}
+	return idx
+}
+
+// isWordRune indique si r fait partie d'un mot indexé.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// indexWords retourne les mots distincts de text, en minuscules, d'au moins
+// minIndexedWordLen caractères.
+func indexWords(text string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) }) {
+		if utf8.RuneCountInString(word) < minIndexedWordLen || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// candidateFiles retourne, dans l'ordre du parcours, les fichiers pouvant
+// contenir une ligne correspondant à pattern : les fichiers indexés qui
+// contiennent les fragments obligatoires du motif, puis les fichiers non
+// indexés. Sans fragment exploitable, tous les fichiers sont retournés.
+func (idx *searchIndex) candidateFiles(pattern string) []string {
+	var ids []int32
+	constrained := false
+	for _, literal := range requiredLiterals(pattern) {
+		literalIDs, ok := idx.literalFiles(literal)
+		if !ok {
+			continue
+		}
+		if constrained {
+			ids = intersectSorted(ids, literalIDs)
+		} else {
+			ids, constrained = literalIDs, true
+		}
+	}
+	if !constrained {
+		return idx.files
+	}
+
+	files := make([]string, 0, len(ids)+len(idx.files)-idx.indexed)
+	for _, id := range ids {
+		files = append(files, idx.files[id])
+	}
+	return append(files, idx.files[idx.indexed:]...)
+}
+
+// requiredLiterals retourne les fragments de texte présents dans toute ligne
+// correspondant à pattern, interprété comme dans searchProject : expression
+// régulière, ou sous-chaîne si elle n'est pas valide.
+func requiredLiterals(pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return []string{pattern}
+	}
+	re = re.Simplify()
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpConcat:
+		var literals []string
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				literals = append(literals, string(sub.Rune))
+			}
+		}
+		return literals
+	}
+	return nil
+}
+
+// literalFiles retourne les indices croissants des fichiers indexés pouvant
+// contenir literal, et false si literal ne contient aucun mot assez long pour
+// restreindre la recherche. Un mot au bord du fragment peut se prolonger
+// au-delà : il est cherché comme suffixe (début du fragment), préfixe (fin) ou
+// sous-chaîne (les deux) des mots de l'index.
+func (idx *searchIndex) literalFiles(literal string) ([]int32, bool) {
+	literal = strings.ToLower(literal)
+	var ids []int32
+	constrained := false
+	start := -1
+	for i, r := range literal + " " {
+		if i < len(literal) && isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		word := literal[start:i]
+		atStart, atEnd := start == 0, i == len(literal)
+		start = -1
+		if utf8.RuneCountInString(word) < minIndexedWordLen {
+			continue // Les mots courts ne sont pas indexés
+		}
+
+		wordIDs := idx.wordFiles(word, atStart, atEnd)
+		if constrained {
+			ids = intersectSorted(ids, wordIDs)
+		} else {
+			ids, constrained = wordIDs, true
+		}
+	}
+	return ids, constrained
+}
+
+// wordFiles retourne les indices croissants des fichiers contenant word, ou
+// un mot qui le prolonge du côté des bords indiqués.
+func (idx *searchIndex) wordFiles(word string, atStart, atEnd bool) []int32 {
+	if !atStart && !atEnd {
+		return idx.postings[word]
+	}
+	seen := make(map[int32]bool)
+	var ids []int32
+	for indexed, postings := range idx.postings {
+		var matches bool
+		switch {
+		case atStart && atEnd:
+			matches = strings.Contains(indexed, word)
+		case atStart:
+			matches = strings.HasSuffix(indexed, word)
+		default:
+			matches = strings.HasPrefix(indexed, word)
+		}
+		if !matches {
+			continue
+		}
+		for _, id := range postings {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// intersectSorted retourne les éléments communs à deux listes croissantes.
+func intersectSorted(a, b []int32) []int32 {
+	var common []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			common = append(common, a[i])
+			i++
+			j++
+		}
+	}
+	return common
+}
+
+// filesMentioning retourne au plus limit fichiers indexés absents de read qui
+// contiennent des mots-clés de la question, les plus nombreux en premier,
+// puis dans l'ordre du parcours.
+func (idx *searchIndex) filesMentioning(keywords []string, read map[string]string, limit int) []string {
+	counts := make(map[int32]int)
+	for _, keyword := range keywords {
+		for _, id := range idx.postings[keyword] {
+			counts[id]++
+		}
+	}
+	var ids []int32
+	for id := range counts {
+		if _, ok := read[idx.files[id]]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if counts[ids[i]] != counts[ids[j]] {
+			return counts[ids[i]] > counts[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	files := make([]string, 0, min(len(ids), limit))
+	for _, id := range ids[:min(len(ids), limit)] {
+		files = append(files, filepath.ToSlash(idx.files[id]))
+	}
+	return files
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"debugagent/config"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+var searchIndexTestFiles = map[string]string{
+	"main.go":               "package main\n\nfunc main() {\n\tstartServer()\n}\n",
+	"server/server.go":      "package server\n\nfunc startServer() {}\n\nfunc handleLogin() {}\n",
+	"auth/session.go":       "package auth\n\n// Sessions expire after the login timeout.\nvar loginTimeout = 30\n",
+	"docs/notes.md":         "Nothing relevant here.\n",
+	"node_modules/lib/x.js": "function startServer() {}\n",
+	"image.bin":             "\x00\x01\x02\x03startServer\x00\x00\x00\x00",
+}
+
+// setupSearchIndexTest creates the test project and builds its search index.
+func setupSearchIndexTest(t *testing.T, maxEntries int) (string, *searchIndex) {
+	projectPath := setupExplorerTest(t, searchIndexTestFiles)
+	config.AppConfig.Analysis.SearchIndexMaxEntries = maxEntries
+	return projectPath, buildSearchIndex(config.AppConfig, logrus.NewEntry(logrus.New()), projectPath)
+}
+
+func TestBuildSearchIndex_DisabledWithoutBudget(t *testing.T) {
+	_, idx := setupSearchIndexTest(t, 0)
+	if idx != nil {
+		t.Fatalf("buildSearchIndex(config.AppConfig, ) = %+v, want nil when search_index_max_entries is 0", idx)
+	}
+}
+
+func TestBuildSearchIndex_SkipsIgnoredAndBinaryFiles(t *testing.T) {
+	_, idx := setupSearchIndexTest(t, 1000)
+
+	want := []string{"auth/session.go", "docs/notes.md", "main.go", "server/server.go"}
+	if !reflect.DeepEqual(idx.files, want) {
+		t.Errorf("files = %v, want %v", idx.files, want)
+	}
+	if idx.indexed != len(want) {
+		t.Errorf("indexed = %d, want %d", idx.indexed, len(want))
+	}
+}
+
+func TestSearchIndex_CandidateFiles(t *testing.T) {
+	_, idx := setupSearchIndexTest(t, 1000)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"func startServer", []string{"main.go", "server/server.go"}},
+		{"startServ", []string{"main.go", "server/server.go"}},
+		{"Timeout", []string{"auth/session.go"}},
+		{`func \w+Login`, []string{"server/server.go"}},
+		{"compute(a[", []string{}},
+		{`\w+\(\)`, []string{"auth/session.go", "docs/notes.md", "main.go", "server/server.go"}},
+	}
+	for _, tt := range tests {
+		got := idx.candidateFiles(tt.pattern)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("candidateFiles(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndex_BudgetKeepsRemainingFilesSearchable(t *testing.T) {
+	projectPath, idx := setupSearchIndexTest(t, 5)
+
+	if idx.indexed == len(idx.files) {
+		t.Fatalf("indexed = %d of %d files, want the budget to leave some unindexed", idx.indexed, len(idx.files))
+	}
+	if idx.entries > 5 {
+		t.Errorf("entries = %d, want at most 5", idx.entries)
+	}
+
+	withIndex, err := searchProject(config.AppConfig, projectPath, "startServer", 10, idx)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	withoutIndex, err := searchProject(config.AppConfig, projectPath, "startServer", 10, nil)
+	if err != nil {
+		t.Fatalf("searchProject(config.AppConfig, ) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(withIndex, withoutIndex) {
+		t.Errorf("searchProject(config.AppConfig, ) with index = %+v, want %+v", withIndex, withoutIndex)
+	}
+}
+
+func TestSearchProject_WithIndexMatchesWalk(t *testing.T) {
+	projectPath, idx := setupSearchIndexTest(t, 1000)
+
+	for _, pattern := range []string{"func startServer", "login", "Timeout", `func \w+\(`} {
+		withIndex, err := searchProject(config.AppConfig, projectPath, pattern, 10, idx)
+		if err != nil {
+			t.Fatalf("searchProject(config.AppConfig, %q) returned error: %v", pattern, err)
+		}
+		withoutIndex, err := searchProject(config.AppConfig, projectPath, pattern, 10, nil)
+		if err != nil {
+			t.Fatalf("searchProject(config.AppConfig, %q) returned error: %v", pattern, err)
+		}
+		if !reflect.DeepEqual(withIndex, withoutIndex) {
+			t.Errorf("searchProject(config.AppConfig, %q) with index = %+v, want %+v", pattern, withIndex, withoutIndex)
+		}
+	}
+}
+
+func TestSearchIndex_FilesMentioning(t *testing.T) {
+	_, idx := setupSearchIndexTest(t, 1000)
+
+	read := map[string]string{"server/server.go": "already read"}
+	got := idx.filesMentioning(questionKeywords("Why does the login timeout expire?"), read, 5)
+	if want := []string{"auth/session.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filesMentioning() = %v, want %v", got, want)
+	}
+}
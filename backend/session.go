@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"debugagent/config"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Incremental analysis sessions keep an uploaded project on disk under
+// <analysis.cache_dir>/sessions/<id>/project, next to the SHA-256 of each of
+// its files. Since the project path of a session never changes, its knowledge
+// base is restored and saved by the regular cache (see warmStartKnowledgeBase).
+// Sessions unused for analysis.session_ttl_minutes are deleted, along with
+// their cached knowledge base.
+
+// ErrSessionNotFound is returned for an unknown or malformed session ID.
+var ErrSessionNotFound = errors.New("analysis session not found")
+
+// ErrSessionBusy is returned while another analysis runs on the same session.
+var ErrSessionBusy = errors.New("analysis session is already in use")
+
+// errSessionsDisabled is returned when analysis.cache_dir is not set.
+var errSessionsDisabled = errors.New("incremental analysis requires analysis.cache_dir to be set")
+
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// sessionLocks holds a *sync.Mutex per session ID, so that two analyses never
+// modify the same project at once.
+var sessionLocks sync.Map
+
+// analysisSession is a session locked for the current request; close
+// releases it.
+type analysisSession struct {
+	ID     string
+	dir    string
+	hashes map[string]string // SHA-256 of each project file, by slash-separated relative path
+	mu     *sync.Mutex
+}
+
+// sessionChanges lists the files of an upload by slash-separated relative path.
+type sessionChanges struct {
+	Changed   []string // New files, or files whose content differs
+	Unchanged []string // Files sent again with the same content
+	Deleted   []string // Files removed from the project
+}
+
+// sessionsDir returns the directory holding the sessions.
+func sessionsDir() (string, error) {
+	cacheDir := config.Get().Analysis.CacheDir
+	if cacheDir == "" {
+		return "", errSessionsDisabled
+	}
+	return filepath.Join(cacheDir, "sessions"), nil
+}
+
+// newSession creates an empty session, after deleting the expired ones.
+func newSession() (*analysisSession, error) {
+	root, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	pruneExpiredSessions(root)
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	session := &analysisSession{ID: hex.EncodeToString(id), hashes: make(map[string]string)}
+	session.dir = filepath.Join(root, session.ID)
+	if err := os.MkdirAll(session.projectDir(), 0755); err != nil {
+		return nil, fmt.Errorf("could not create session directory: %w", err)
+	}
+	session.lock()
+	return session, nil
+}
+
+// openSession locks the existing session id and loads its file hashes.
+func openSession(id string) (*analysisSession, error) {
+	root, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	if !sessionIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: '%s'", ErrSessionNotFound, id)
+	}
+	session := &analysisSession{ID: id, dir: filepath.Join(root, id), hashes: make(map[string]string)}
+	if _, err := os.Stat(session.projectDir()); err != nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrSessionNotFound, id)
+	}
+	if !session.lock() {
+		return nil, ErrSessionBusy
+	}
+	if session.expired(time.Now()) {
+		session.remove()
+		return nil, fmt.Errorf("%w: '%s' expired", ErrSessionNotFound, id)
+	}
+	session.touch()
+
+	data, err := os.ReadFile(filepath.Join(session.dir, "hashes.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &session.hashes)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		session.close()
+		return nil, fmt.Errorf("could not read session file hashes: %w", err)
+	}
+	return session, nil
+}
+
+// lock takes the session's lock without waiting.
+func (s *analysisSession) lock() bool {
+	mu, _ := sessionLocks.LoadOrStore(s.ID, &sync.Mutex{})
+	s.mu = mu.(*sync.Mutex)
+	return s.mu.TryLock()
+}
+
+// close releases the session.
+func (s *analysisSession) close() {
+	s.mu.Unlock()
+}
+
+// sessionTTL returns how long an unused session is kept, 0 meaning forever.
+func sessionTTL() time.Duration {
+	return time.Duration(config.Get().Analysis.SessionTTLMinutes) * time.Minute
+}
+
+// expired reports whether the session was last used more than sessionTTL
+// before now. The last use is the modification time of its directory, see
+// touch.
+func (s *analysisSession) expired(now time.Time) bool {
+	ttl := sessionTTL()
+	if ttl <= 0 {
+		return false
+	}
+	info, err := os.Stat(s.dir)
+	return err == nil && now.Sub(info.ModTime()) > ttl
+}
+
+// touch records that the session is used now.
+func (s *analysisSession) touch() {
+	now := time.Now()
+	os.Chtimes(s.dir, now, now)
+}
+
+// remove deletes the files and the cached knowledge base of the locked
+// session, then releases it.
+func (s *analysisSession) remove() {
+	if cacheDir := config.Get().Analysis.CacheDir; cacheDir != "" {
+		os.Remove(knowledgeBaseCachePath(cacheDir, s.projectDir()))
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		logrus.Warnf("Could not delete expired session %s: %v", s.ID, err)
+	}
+	sessionLocks.Delete(s.ID)
+	s.close()
+}
+
+// pruneExpiredSessions deletes the expired sessions of root, except those in
+// use.
+func pruneExpiredSessions(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if !entry.IsDir() || !sessionIDPattern.MatchString(entry.Name()) {
+			continue
+		}
+		session := &analysisSession{ID: entry.Name(), dir: filepath.Join(root, entry.Name())}
+		if !session.expired(now) || !session.lock() {
+			continue
+		}
+		session.remove()
+	}
+}
+
+// projectDir returns the directory holding the session's project files.
+func (s *analysisSession) projectDir() string {
+	return filepath.Join(s.dir, "project")
+}
+
+// applyUpload copies the files of stagingDir whose content differs from the
+// session's project, then removes the deleted ones. Deleted names are
+// client-controlled and checked like uploaded file names.
+func (s *analysisSession) applyUpload(stagingDir string, deleted []string) (sessionChanges, error) {
+	var changes sessionChanges
+	err := filepath.WalkDir(stagingDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		hash, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if s.hashes[relPath] == hash {
+			changes.Unchanged = append(changes.Unchanged, relPath)
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := writeUploadedFile(filepath.Join(s.projectDir(), filepath.FromSlash(relPath)), file); err != nil {
+			return err
+		}
+		s.hashes[relPath] = hash
+		changes.Changed = append(changes.Changed, relPath)
+		return nil
+	})
+	if err != nil {
+		return sessionChanges{}, err
+	}
+
+	for _, name := range deleted {
+		destPath, err := safeUploadPath(s.projectDir(), name)
+		if err != nil {
+			return sessionChanges{}, err
+		}
+		relPath, _ := filepath.Rel(s.projectDir(), destPath)
+		relPath = filepath.ToSlash(relPath)
+		if _, ok := s.hashes[relPath]; !ok {
+			continue
+		}
+		if err := os.Remove(destPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return sessionChanges{}, fmt.Errorf("could not delete '%s': %w", name, err)
+		}
+		delete(s.hashes, relPath)
+		changes.Deleted = append(changes.Deleted, relPath)
+	}
+
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Unchanged)
+	sort.Strings(changes.Deleted)
+	return changes, nil
+}
+
+// save writes the file hashes of the session.
+func (s *analysisSession) save() error {
+	data, err := json.Marshal(s.hashes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, "hashes.json"), data, 0644)
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"debugagent/config"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeStagingFiles writes files into a new staging directory.
+func writeStagingFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestAnalysisSession_ApplyUpload(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{CacheDir: t.TempDir()}}
+
+	session, err := newSession()
+	if err != nil {
+		t.Fatalf("newSession() returned error: %v", err)
+	}
+	changes, err := session.applyUpload(writeStagingFiles(t, map[string]string{
+		"main.go":     "package main",
+		"pkg/util.go": "package pkg",
+	}), nil)
+	if err != nil {
+		t.Fatalf("applyUpload() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(changes.Changed, []string{"main.go", "pkg/util.go"}) {
+		t.Errorf("expected every file to be new, got %+v", changes)
+	}
+	session.save()
+	session.close()
+
+	session, err = openSession(session.ID)
+	if err != nil {
+		t.Fatalf("openSession() returned error: %v", err)
+	}
+	if _, err := openSession(session.ID); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("expected ErrSessionBusy while the session is open, got %v", err)
+	}
+	changes, err = session.applyUpload(writeStagingFiles(t, map[string]string{
+		"main.go": "package main",
+		"new.go":  "package main // new",
+	}), []string{"pkg/util.go", "missing.go"})
+	session.close()
+	if err != nil {
+		t.Fatalf("applyUpload() returned error: %v", err)
+	}
+
+	expected := sessionChanges{Changed: []string{"new.go"}, Unchanged: []string{"main.go"}, Deleted: []string{"pkg/util.go"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+	if _, err := os.Stat(filepath.Join(session.projectDir(), "pkg", "util.go")); !os.IsNotExist(err) {
+		t.Error("expected pkg/util.go to be deleted from the session")
+	}
+}
+
+func TestOpenSession_RejectsInvalidIDs(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{CacheDir: t.TempDir()}}
+
+	for _, id := range []string{"", "../../etc", strings.Repeat("0", 32)} {
+		if _, err := openSession(id); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("openSession(%q): expected ErrSessionNotFound, got %v", id, err)
+		}
+	}
+
+	config.AppConfig.Analysis.CacheDir = ""
+	if _, err := newSession(); !errors.Is(err, errSessionsDisabled) {
+		t.Errorf("expected sessions to require analysis.cache_dir, got %v", err)
+	}
+}
+
+func TestOpenSession_Expired(t *testing.T) {
+	cacheDir := t.TempDir()
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{CacheDir: cacheDir, SessionTTLMinutes: 60}}
+
+	newClosedSession := func(lastUse time.Time) *analysisSession {
+		t.Helper()
+		session, err := newSession()
+		if err != nil {
+			t.Fatalf("newSession() returned error: %v", err)
+		}
+		session.close()
+		os.Chtimes(session.dir, lastUse, lastUse)
+		return session
+	}
+
+	recent := newClosedSession(time.Now().Add(-30 * time.Minute))
+	expired := newClosedSession(time.Now().Add(-2 * time.Hour))
+	cachePath := knowledgeBaseCachePath(cacheDir, expired.projectDir())
+	os.WriteFile(cachePath, []byte("{}"), 0644)
+
+	if _, err := openSession(expired.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for an expired session, got %v", err)
+	}
+	for _, path := range []string{expired.dir, cachePath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got %v", path, err)
+		}
+	}
+
+	session, err := openSession(recent.ID)
+	if err != nil {
+		t.Fatalf("openSession() returned error for a session in use: %v", err)
+	}
+	session.close()
+
+	// A new session prunes the expired ones nobody opened
+	stale := newClosedSession(time.Now().Add(-2 * time.Hour))
+	newClosedSession(time.Now())
+	if _, err := os.Stat(stale.dir); !os.IsNotExist(err) {
+		t.Errorf("expected the expired session to be pruned, got %v", err)
+	}
+	if _, err := os.Stat(recent.dir); err != nil {
+		t.Errorf("expected the recent session to be kept, got %v", err)
+	}
+}
+
+func TestAnalyzeIncrementalHandler_ReusesUnchangedFindings(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/chat" {
+			w.Write([]byte(`{"message":{"role":"assistant","content":"[{\"action\":\"READ_FILE\",\"argument\":\"main.go\"}]"},"done":true}`))
+			return
+		}
+		w.Write([]byte(`{"response":"See main.go","done":true}`))
+	})
+	config.AppConfig.Analysis.CacheDir = t.TempDir()
+	config.AppConfig.Analysis.MaxExplorationIterations = 1
+	config.AppConfig.Analysis.IncrementalIterations = 1
+	config.AppConfig.Analysis.MaxFileReadSize = 10000
+
+	analyze := func(cacheID string, files map[string]string) IncrementalAnalyzeResponse {
+		t.Helper()
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("question", "What does this do?")
+		writer.WriteField("cache_id", cacheID)
+		for name, content := range files {
+			part, _ := writer.CreateFormFile("files", name)
+			part.Write([]byte(content))
+		}
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/analyze-incremental", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+
+		analyzeIncrementalHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d (%s)", rr.Code, rr.Body.String())
+		}
+		var resp IncrementalAnalyzeResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	first := analyze("", map[string]string{"README.md": "# Demo", "main.go": "package main"})
+	if first.CacheID == "" || len(first.Reused) != 0 {
+		t.Fatalf("expected a new session without reused findings, got %+v", first)
+	}
+
+	second := analyze(first.CacheID, map[string]string{"README.md": "# Demo v2", "main.go": "package main"})
+	if second.CacheID != first.CacheID {
+		t.Errorf("expected the same session, got '%s'", second.CacheID)
+	}
+	if !reflect.DeepEqual(second.Changed, []string{"README.md"}) || !reflect.DeepEqual(second.Unchanged, []string{"main.go"}) {
+		t.Errorf("expected README.md changed and main.go unchanged, got %+v", second)
+	}
+	if !reflect.DeepEqual(second.Reused, []string{"main.go"}) || !reflect.DeepEqual(second.Recomputed, []string{"README.md"}) {
+		t.Errorf("expected main.go reused and README.md recomputed, got %+v", second)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A question about a single uploaded file does not need the project phases:
+// there is no structure to map, no project type to guess and nothing left to
+// explore once the file is read. The analysis reads it and answers directly.
+
+// isSingleFileUpload reports whether an upload of files and archives is a
+// single file, see AnalyzeRequest.SingleFile. An archive is always a project.
+func isSingleFileUpload(fileCount, archiveCount int) bool {
+	return fileCount == 1 && archiveCount == 0
+}
+
+// singleFilePath returns the path, relative to the project, of the file of a
+// single-file request. It reports false when req is not one, or when the
+// project does not hold exactly one regular file (the upload was skipped for
+// instance), in which case the usual exploration runs.
+func singleFilePath(req AnalyzeRequest) (string, bool) {
+	if !req.SingleFile {
+		return "", false
+	}
+	var found []string
+	err := filepath.WalkDir(req.ProjectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			found = append(found, path)
+			if len(found) > 1 {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	if err != nil || len(found) != 1 {
+		return "", false
+	}
+	relPath, err := filepath.Rel(req.ProjectPath, found[0])
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(relPath), true
+}
+
+// readSingleFile reads the file relPath of a single-file request into kb, in
+// place of the initial analysis and the exploration. The project type is the
+// language of the file.
+func readSingleFile(log *logrus.Entry, kb *KnowledgeBase, relPath string) error {
+	if isForbiddenFile(kb.cfg, relPath) {
+		return fmt.Errorf("'%s' matches analysis.forbidden_files and may contain secrets", relPath)
+	}
+	fullPath := filepath.Join(kb.ProjectPath, filepath.FromSlash(relPath))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	content, err := readFileContent(kb.cfg, log, fullPath)
+	if err != nil {
+		return err
+	}
+	kb.AddFileContent(fullPath, content)
+	kb.RecordFileStamp(fullPath, info)
+	kb.SingleFile = relPath
+
+	if err := kb.DetectLanguages(); err != nil {
+		kb.AddNote(fmt.Sprintf("Language detection failed: %v", err))
+	}
+	kb.SetProjectType(singleFileType(relPath))
+	kb.AddHistory(fmt.Sprintf("Single file upload: '%s' read directly, the project exploration is skipped.", relPath))
+	return nil
+}
+
+// singleFileType is the project type of a single-file request: the language
+// of the file when it is known.
+func singleFileType(relPath string) string {
+	if lang := languageForFile(path.Base(relPath)); lang != "" {
+		return fmt.Sprintf("Single %s file", lang)
+	}
+	return "Single file"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupSingleFileTest writes a project made of the single file relPath.
+func setupSingleFileTest(t *testing.T, relPath, content string) string {
+	t.Helper()
+	config.AppConfig = &config.Config{
+		Analysis: config.AnalysisConfig{
+			MaxExplorationIterations: 2,
+			MaxDirectoryDepth:        3,
+			MaxFileReadSize:          10000,
+			MaxPromptLength:          8000,
+		},
+	}
+	projectDir := t.TempDir()
+	fullPath := filepath.Join(projectDir, filepath.FromSlash(relPath))
+	os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", relPath, err)
+	}
+	return projectDir
+}
+
+func TestSingleFilePath(t *testing.T) {
+	projectDir := setupSingleFileTest(t, "src/handler.go", "package src\n")
+
+	if path, ok := singleFilePath(AnalyzeRequest{ProjectPath: projectDir, SingleFile: true}); !ok || path != "src/handler.go" {
+		t.Errorf("expected src/handler.go, got %q (%v)", path, ok)
+	}
+	if _, ok := singleFilePath(AnalyzeRequest{ProjectPath: projectDir}); ok {
+		t.Error("expected a request without SingleFile to be explored")
+	}
+
+	// The upload turned out to hold more than one file
+	os.WriteFile(filepath.Join(projectDir, "other.go"), []byte("package main\n"), 0644)
+	if _, ok := singleFilePath(AnalyzeRequest{ProjectPath: projectDir, SingleFile: true}); ok {
+		t.Error("expected a project of two files to be explored")
+	}
+}
+
+func TestRunAnalysis_SingleFile(t *testing.T) {
+	projectDir := setupSingleFileTest(t, "handler.go", "package main\n\nfunc handle() {}\n")
+	client := &fakeLLMClient{answer: "handle does nothing.", plans: []string{`[{"action":"READ_FILE","argument":"handler.go"}]`}}
+
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{
+		ProjectPath: projectDir,
+		Question:    "What does handle do?",
+		SingleFile:  true,
+	}, client)
+	result, err := engine.RunAnalysis()
+	if err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+
+	// Only the final answer is asked: no project type, no planning
+	if !reflect.DeepEqual(client.phases, []string{phaseSynthesis}) {
+		t.Errorf("expected a single synthesis call, got %v", client.phases)
+	}
+	if result.Answer != "handle does nothing." || len(result.Sources) != 1 || result.Sources[0].Path != "handler.go" {
+		t.Errorf("expected an answer based on handler.go, got %+v", result)
+	}
+	if result.Stats.FilesRead != 1 {
+		t.Errorf("expected 1 file read, got %d", result.Stats.FilesRead)
+	}
+	for _, expected := range []string{"Fichier analysé seul: handler.go (Type: Single Go file)", "func handle()"} {
+		if !strings.Contains(client.finalPrompt, expected) {
+			t.Errorf("expected %q in the final prompt, got:\n%s", expected, client.finalPrompt)
+		}
+	}
+	if strings.Contains(client.finalPrompt, "Structure Projet") {
+		t.Error("expected no project structure in the final prompt")
+	}
+}
+
+func TestRunStreamingAnalysis_SingleFile(t *testing.T) {
+	projectDir := setupSingleFileTest(t, "query.sql", "SELECT 1;\n")
+	engine := NewStreamingAnalysisEngineWithClient(context.Background(), AnalyzeRequest{
+		ProjectPath: projectDir,
+		Question:    "What does it select?",
+		SingleFile:  true,
+	}, &fakeLLMClient{chunks: []string{"One."}})
+	rr := httptest.NewRecorder()
+	engine.RunStreamingAnalysis(sseSink{rr})
+
+	body := rr.Body.String()
+	for _, expected := range []string{"Single file upload", "Successfully read: query.sql (Single SQL file)", `"type":"result"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the stream, got:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "Analyzing directory structure") || strings.Contains(body, "Planning iteration") {
+		t.Errorf("expected the structure phase and the exploration to be skipped, got:\n%s", body)
+	}
+}
+
+func TestNewKnowledgeBase_FilePath(t *testing.T) {
+	projectDir := setupSingleFileTest(t, "main.go", "package main\n")
+
+	kb := NewKnowledgeBase(filepath.Join(projectDir, "main.go"))
+	if kb.ProjectPath != projectDir {
+		t.Errorf("expected the project path to be the directory of the file, got %s", kb.ProjectPath)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"strings"
+)
+
+// stallDetector spots planner loops: iterations that replay a plan already
+// executed without teaching the knowledge base anything new. It also forces
+// FINISH for the models that never emit it, once iterations keep reading the
+// same files and adding no notes, whatever their plans.
+type stallDetector struct {
+	threshold     int               // Consecutive stalled iterations before giving up, 0 disables detection
+	stalled       int               // Current number of consecutive stalled iterations
+	idleThreshold int               // Consecutive iterations learning nothing before forcing FINISH, 0 disables it
+	idle          int               // Current number of consecutive iterations learning nothing
+	plans         map[[32]byte]bool // Hashes of the plans executed so far
+	files         map[string]bool   // Files known to have been read
+	notes         map[string]bool   // Notes already recorded
+}
+
+// newStallDetector creates a detector giving up after threshold stalled iterations.
+func newStallDetector(threshold int) *stallDetector {
+	return &stallDetector{
+		threshold: threshold,
+		plans:     make(map[[32]byte]bool),
+		files:     make(map[string]bool),
+		notes:     make(map[string]bool),
+	}
+}
+
+// finishWhenIdle forces FINISH after iterations in a row without a new file
+// read nor a new note. The files and notes already in kb, from the initial
+// analysis, do not count as progress.
+func (d *stallDetector) finishWhenIdle(iterations int, kb *KnowledgeBase) {
+	d.idleThreshold = iterations
+	for path := range kb.FileContents {
+		d.files[path] = true
+	}
+	for _, note := range kb.AnalysisNotes {
+		d.notes[note] = true
+	}
+}
+
+// observe records an executed plan along with the resulting state of the
+// knowledge base, and reports whether the exploration should stop, either
+// because the planner stalled or because FINISH is forced.
+func (d *stallDetector) observe(plan []string, kb *KnowledgeBase) bool {
+	hash := sha256.Sum256([]byte(strings.Join(plan, "\n")))
+	repeated := d.plans[hash]
+	d.plans[hash] = true
+
+	learned := false
+	for path := range kb.FileContents {
+		if !d.files[path] {
+			d.files[path] = true
+			learned = true
+		}
+	}
+	for _, note := range kb.AnalysisNotes {
+		if !d.notes[note] {
+			d.notes[note] = true
+			learned = true
+		}
+	}
+
+	if repeated && !learned {
+		d.stalled++
+	} else {
+		d.stalled = 0
+	}
+	if learned {
+		d.idle = 0
+	} else {
+		d.idle++
+	}
+	return d.isStalled() || (d.idleThreshold > 0 && d.idle >= d.idleThreshold)
+}
+
+// isStalled reports whether observe stopped the exploration because the
+// planner stalled, rather than to force FINISH.
+func (d *stallDetector) isStalled() bool {
+	return d.threshold > 0 && d.stalled >= d.threshold
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"debugagent/config"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStallDetector(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	detector := newStallDetector(2)
+	plan := []string{"READ_FILE main.go", "ANALYZE main"}
+
+	// First execution: the plan is new and main.go was read
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "main.go"), "package main")
+	if detector.observe(plan, kb) {
+		t.Fatal("expected no stall after the first iteration")
+	}
+
+	// Same plan, nothing learned: first stalled iteration
+	if detector.observe(plan, kb) {
+		t.Fatal("expected no stall before the threshold is reached")
+	}
+
+	// Same plan again, but a new note resets the counter
+	kb.AddNote("main.go starts the HTTP server")
+	if detector.observe(plan, kb) || detector.stalled != 0 {
+		t.Fatalf("expected new notes to count as progress, stalled=%d", detector.stalled)
+	}
+
+	// Two more fruitless repetitions reach the threshold
+	detector.observe(plan, kb)
+	if !detector.observe(plan, kb) {
+		t.Errorf("expected a stall after 2 repeated iterations, stalled=%d", detector.stalled)
+	}
+}
+
+func TestStallDetector_NewPlanIsNotAStall(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	detector := newStallDetector(1)
+
+	detector.observe([]string{"LIST_DIR src"}, kb)
+	if detector.observe([]string{"LIST_DIR docs"}, kb) {
+		t.Error("expected a different plan not to be considered a stall")
+	}
+	if !detector.observe([]string{"LIST_DIR src"}, kb) {
+		t.Error("expected a previously executed plan that learns nothing to be a stall")
+	}
+}
+
+func TestStallDetector_Disabled(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	detector := newStallDetector(0)
+
+	for i := 0; i < 5; i++ {
+		if detector.observe([]string{"ANALYZE main"}, kb) {
+			t.Fatal("expected stall detection to be disabled with a threshold of 0")
+		}
+	}
+}
+
+func TestStallDetector_ForceFinish(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "README.md"), "# Demo")
+	detector := newStallDetector(0)
+	detector.finishWhenIdle(2, kb)
+
+	// Reading the README again teaches nothing, it was read before the loop
+	if detector.observe([]string{"READ_FILE README.md"}, kb) {
+		t.Fatal("expected no forced FINISH before the threshold is reached")
+	}
+	kb.AddFileContent(filepath.Join(kb.ProjectPath, "main.go"), "package main")
+	if detector.observe([]string{"READ_FILE main.go"}, kb) || detector.idle != 0 {
+		t.Fatalf("expected a new file to count as progress, idle=%d", detector.idle)
+	}
+
+	// Different plans that learn nothing still end the exploration
+	detector.observe([]string{"SEARCH handler"}, kb)
+	if !detector.observe([]string{"LIST_DIR src"}, kb) || detector.isStalled() {
+		t.Errorf("expected FINISH to be forced after 2 idle iterations, idle=%d stalled=%d", detector.idle, detector.stalled)
+	}
+}
+
+func TestRunStreamingAnalysis_ForceFinish(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, true)
+	config.AppConfig.Analysis.MaxExplorationIterations = 5
+	config.AppConfig.Analysis.ForceFinishIterations = 2
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		// The second read only adds the "already read" note, the next ones add nothing
+		plans:  slices.Repeat([]string{`[{"action": "READ_FILE", "argument": "main.go"}]`}, 5),
+		chunks: []string{"Done"},
+	}
+
+	sequence, events := runStreamingEngine(t, projectDir, client)
+
+	forced := slices.Index(sequence, "step/force_finish")
+	if forced < 0 || client.planCalls != 4 {
+		t.Fatalf("expected FINISH to be forced after the fourth plan, got %d plans and %v", client.planCalls, sequence)
+	}
+	if message := events[forced].Message; message != "No new file read nor note in the last 2 iterations, finishing the exploration" {
+		t.Errorf("unexpected message %q", message)
+	}
+}
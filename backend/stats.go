@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnalysisStats reports what an analysis cost, to help tune
+// analysis.max_exploration_iterations and the prompt budget.
+type AnalysisStats struct {
+	LLMCalls    int `json:"llm_calls"`    // Requests sent to the model
+	PromptChars int `json:"prompt_chars"` // Characters of the system messages and prompts sent, history included
+	Iterations  int `json:"iterations"`   // Exploration iterations started
+	FilesRead   int `json:"files_read"`   // Files added to the knowledge base, README and bootstrap files included
+}
+
+// Phases of a request timed in the Server-Timing header of /analyze.
+const (
+	timingUpload      = "upload"
+	timingQueue       = "queue"
+	timingInitial     = "initial"
+	timingExploration = "exploration"
+	timingSynthesis   = "synthesis"
+)
+
+// timingDescriptions are the descriptions of the phases shown by the browser
+// devtools.
+var timingDescriptions = map[string]string{
+	timingUpload:      "Upload processing",
+	timingQueue:       "Waiting for an analysis slot",
+	timingInitial:     "Initial analysis",
+	timingExploration: "Exploration",
+	timingSynthesis:   "Synthesis",
+}
+
+// PhaseTiming is the time spent in one phase of a request.
+type PhaseTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// usageStats accumulates the AnalysisStats of one analysis, and the time
+// spent in each of its phases. Each engine has its own, so the counters start
+// from zero for every request. A nil usageStats counts nothing.
+type usageStats struct {
+	mu      sync.Mutex
+	stats   AnalysisStats
+	timings []PhaseTiming // In the order the phases started
+}
+
+// addLLMCall counts a request to the model carrying promptChars characters.
+func (u *usageStats) addLLMCall(promptChars int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.LLMCalls++
+	u.stats.PromptChars += promptChars
+}
+
+// addIteration counts an exploration iteration.
+func (u *usageStats) addIteration() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.Iterations++
+}
+
+// addFilesRead counts n files added to the knowledge base.
+func (u *usageStats) addFilesRead(n int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.FilesRead += n
+}
+
+// addTiming adds d to the time spent in the phase name, which may run
+// several times (the synthesis of each question for instance).
+func (u *usageStats) addTiming(name string, d time.Duration) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range u.timings {
+		if u.timings[i].Name == name {
+			u.timings[i].Duration += d
+			return
+		}
+	}
+	u.timings = append(u.timings, PhaseTiming{Name: name, Duration: d})
+}
+
+// timingsSnapshot returns the time spent in each phase so far.
+func (u *usageStats) timingsSnapshot() []PhaseTiming {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]PhaseTiming(nil), u.timings...)
+}
+
+// serverTiming formats timings as the value of a Server-Timing header, the
+// durations in milliseconds.
+func serverTiming(timings []PhaseTiming) string {
+	metrics := make([]string, 0, len(timings))
+	for _, timing := range timings {
+		metric := fmt.Sprintf("%s;dur=%.1f", timing.Name, float64(timing.Duration.Microseconds())/1000)
+		if desc, ok := timingDescriptions[timing.Name]; ok {
+			metric += fmt.Sprintf(";desc=%q", desc)
+		}
+		metrics = append(metrics, metric)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// snapshot returns the current counters.
+func (u *usageStats) snapshot() AnalysisStats {
+	if u == nil {
+		return AnalysisStats{}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stats
+}
+
+// countingClient is an LLMClient that counts the requests sent to the
+// wrapped client in usage, failed ones included, and records their duration
+// in the server metrics.
+type countingClient struct {
+	LLMClient
+	usage *usageStats
+}
+
+func (c *countingClient) Request(ctx context.Context, systemMessage, userPrompt string) (string, error) {
+	c.usage.addLLMCall(len(systemMessage) + len(userPrompt))
+	start := time.Now()
+	response, err := c.LLMClient.Request(ctx, systemMessage, userPrompt)
+	observeLLMRequest("generate", start, err)
+	return response, err
+}
+
+func (c *countingClient) ChatRequest(ctx context.Context, messages []ChatMessage) (string, error) {
+	chars := 0
+	for _, message := range messages {
+		chars += len(message.Content)
+	}
+	c.usage.addLLMCall(chars)
+	start := time.Now()
+	response, err := c.LLMClient.ChatRequest(ctx, messages)
+	observeLLMRequest("chat", start, err)
+	return response, err
+}
+
+func (c *countingClient) StreamRequest(ctx context.Context, systemMessage, userPrompt string, callback func(string)) error {
+	c.usage.addLLMCall(len(systemMessage) + len(userPrompt))
+	start := time.Now()
+	err := c.LLMClient.StreamRequest(ctx, systemMessage, userPrompt, callback)
+	observeLLMRequest("stream", start, err)
+	return err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// La carte des symboles exportés (fonctions, méthodes, types) de chaque
+// fichier Go et JS/TS est relevée pendant l'analyse initiale, sans passer par
+// le modèle : le planificateur sait dans quel fichier, et à quelle ligne, se
+// trouve la fonction dont parle la question sans lire tous les fichiers.
+
+// Genres de symboles.
+const (
+	symbolFunc      = "func"
+	symbolMethod    = "method"
+	symbolType      = "type"
+	symbolClass     = "class"
+	symbolInterface = "interface"
+)
+
+// Symbol est une déclaration exportée d'un fichier source.
+type Symbol struct {
+	Name string // Nom, préfixé du type récepteur pour une méthode (Server.Start)
+	Kind string // symbolFunc, symbolMethod, symbolType...
+	Line int    // Ligne de la déclaration, à partir de 1
+}
+
+// maxSymbolsPerFile limite les symboles conservés par fichier, les fichiers
+// générés en déclarent des centaines.
+const maxSymbolsPerFile = 50
+
+// maxListedSymbolFiles limite les fichiers dont le résumé donne les symboles,
+// et maxListedSymbols les symboles montrés pour chacun.
+const (
+	maxListedSymbolFiles = 15
+	maxListedSymbols     = 10
+)
+
+// symbolExtractor retourne la fonction qui relève les symboles du fichier
+// name, nil pour les langages non pris en charge.
+func symbolExtractor(name string) func(src []byte) []Symbol {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".go":
+		return goSymbols
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts":
+		return jsSymbols
+	}
+	return nil
+}
+
+// goSymbols relève avec go/parser les fonctions, méthodes et types exportés
+// de src. Un fichier qui ne compile pas donne les déclarations lues avant
+// l'erreur.
+func goSymbols(src []byte) []Symbol {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if file == nil {
+		return nil
+	}
+
+	var symbols []Symbol
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !decl.Name.IsExported() {
+				continue
+			}
+			if decl.Recv == nil || len(decl.Recv.List) == 0 {
+				symbols = append(symbols, Symbol{Name: decl.Name.Name, Kind: symbolFunc, Line: fset.Position(decl.Pos()).Line})
+				continue
+			}
+			if receiver := receiverTypeName(decl.Recv.List[0].Type); ast.IsExported(receiver) {
+				symbols = append(symbols, Symbol{Name: receiver + "." + decl.Name.Name, Kind: symbolMethod, Line: fset.Position(decl.Pos()).Line})
+			}
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				if spec, ok := spec.(*ast.TypeSpec); ok && spec.Name.IsExported() {
+					kind := symbolType
+					if _, ok := spec.Type.(*ast.InterfaceType); ok {
+						kind = symbolInterface
+					}
+					symbols = append(symbols, Symbol{Name: spec.Name.Name, Kind: kind, Line: fset.Position(spec.Pos()).Line})
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+// receiverTypeName retourne le nom du type d'un récepteur de méthode : T pour
+// T, *T, T[K] ou *T[K].
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// jsExportDeclaration reconnaît les déclarations exportées d'un fichier
+// JavaScript ou TypeScript : fonctions, classes, interfaces, types, enums et
+// constantes affectées d'une fonction fléchée ou d'une expression function.
+var jsExportDeclaration = regexp.MustCompile(`^\s*export\s+(?:default\s+)?(?:declare\s+)?(?:(?:async\s+)?function\s*\*?\s*([A-Za-z_$][\w$]*)|(?:abstract\s+)?(class|interface|type|enum)\s+([A-Za-z_$][\w$]*)|(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[A-Za-z_$][\w$]*\s*=>))`)
+
+// jsSymbols relève les déclarations exportées de src ligne par ligne : une
+// passe d'expressions régulières, sans analyse syntaxique, qui manque les
+// exports répartis sur plusieurs lignes.
+func jsSymbols(src []byte) []Symbol {
+	var symbols []Symbol
+	for i, line := range strings.Split(string(src), "\n") {
+		match := jsExportDeclaration.FindStringSubmatch(line)
+		switch {
+		case match == nil:
+		case match[1] != "":
+			symbols = append(symbols, Symbol{Name: match[1], Kind: symbolFunc, Line: i + 1})
+		case match[3] != "":
+			kind := match[2]
+			if kind == "enum" {
+				kind = symbolType
+			}
+			symbols = append(symbols, Symbol{Name: match[3], Kind: kind, Line: i + 1})
+		case match[4] != "":
+			symbols = append(symbols, Symbol{Name: match[4], Kind: symbolFunc, Line: i + 1})
+		}
+	}
+	return symbols
+}
+
+// ExtractSymbols relève les symboles exportés des fichiers Go et JS/TS que
+// SEARCH parcourt, hors fichiers de test, dans Symbols. Il retourne le nombre
+// de fichiers qui en déclarent.
+func (kb *KnowledgeBase) ExtractSymbols() int {
+	symbols := make(map[string][]Symbol)
+	walkSearchableFiles(kb.cfg, kb.ProjectPath, func(path, relPath string) error {
+		extract := symbolExtractor(relPath)
+		if extract == nil || isTestFile(filepath.Base(relPath)) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		if found := extract(content); len(found) > 0 {
+			symbols[filepath.ToSlash(relPath)] = found[:min(len(found), maxSymbolsPerFile)]
+		}
+		return nil
+	})
+
+	kb.mu.Lock()
+	kb.Symbols = symbols
+	kb.mu.Unlock()
+	return len(symbols)
+}
+
+// symbolFilesByRelevance retourne les fichiers de Symbols, ceux dont le
+// chemin ou les symboles contiennent un mot-clé de la question en premier,
+// puis par ordre alphabétique.
+func (kb *KnowledgeBase) symbolFilesByRelevance(userProblem string) []string {
+	keywords := questionKeywords(userProblem)
+	scores := make(map[string]int, len(kb.Symbols))
+	files := make([]string, 0, len(kb.Symbols))
+	for path, symbols := range kb.Symbols {
+		names := make([]string, len(symbols))
+		for i, symbol := range symbols {
+			names[i] = symbol.Name
+		}
+		scores[path] = relevanceScore(path, strings.Join(names, " "), keywords)
+		files = append(files, path)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if scores[files[i]] != scores[files[j]] {
+			return scores[files[i]] > scores[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+// formatSymbols présente les symboles d'un fichier sur une ligne, au plus
+// maxListedSymbols d'entre eux.
+func formatSymbols(symbols []Symbol) string {
+	listed := make([]string, 0, min(len(symbols), maxListedSymbols))
+	for _, symbol := range symbols[:min(len(symbols), maxListedSymbols)] {
+		listed = append(listed, fmt.Sprintf("%s (%s, l.%d)", symbol.Name, symbol.Kind, symbol.Line))
+	}
+	line := strings.Join(listed, ", ")
+	if len(symbols) > maxListedSymbols {
+		line += fmt.Sprintf(", ... et %d autres", len(symbols)-maxListedSymbols)
+	}
+	return line
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGoSymbols(t *testing.T) {
+	source := strings.Join([]string{
+		"package server", // 1
+		"",
+		"type Server struct{}",
+		"",
+		"type handler struct{}", // 5
+		"",
+		"type Store[K comparable] interface{ Get(K) }",
+		"",
+		"func NewServer() *Server { return &Server{} }",
+		"", // 10
+		"func (s *Server) Start() error { return nil }",
+		"",
+		"func (h handler) ServeHTTP() {}",
+		"",
+		"func (s *Cache[K]) Evict(key K) {}", // 15
+		"",
+		"func helper() {}",
+	}, "\n")
+
+	got := goSymbols([]byte(source))
+	expected := []Symbol{
+		{Name: "Server", Kind: symbolType, Line: 3},
+		{Name: "Store", Kind: symbolInterface, Line: 7},
+		{Name: "NewServer", Kind: symbolFunc, Line: 9},
+		{Name: "Server.Start", Kind: symbolMethod, Line: 11},
+		{Name: "Cache.Evict", Kind: symbolMethod, Line: 15},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestGoSymbols_SyntaxError(t *testing.T) {
+	got := goSymbols([]byte("package broken\n\nfunc Parse() {}\n\nfunc Broken( {\n"))
+	if len(got) == 0 || got[0].Name != "Parse" {
+		t.Errorf("expected the declarations before the error to be kept, got %+v", got)
+	}
+}
+
+func TestJSSymbols(t *testing.T) {
+	source := strings.Join([]string{
+		"import { api } from './api';", // 1
+		"export async function fetchUser(id) {}",
+		"export default class UserStore {}",
+		"export interface User { id: string }",
+		"export type UserID = string;", // 5
+		"export const validateToken = (token: string): boolean => true;",
+		"export const MAX_RETRIES = 3;",
+		"function internal() {}",
+		"export enum Role { Admin }",
+	}, "\n")
+
+	got := jsSymbols([]byte(source))
+	expected := []Symbol{
+		{Name: "fetchUser", Kind: symbolFunc, Line: 2},
+		{Name: "UserStore", Kind: symbolClass, Line: 3},
+		{Name: "User", Kind: symbolInterface, Line: 4},
+		{Name: "UserID", Kind: symbolType, Line: 5},
+		{Name: "validateToken", Kind: symbolFunc, Line: 6},
+		{Name: "Role", Kind: symbolType, Line: 9},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestExtractSymbols(t *testing.T) {
+	kb := setupKnowledgeBase(t)
+	files := map[string]string{
+		"auth/token.go":      "package auth\n\nfunc ValidateToken() {}\n",
+		"auth/token_test.go": "package auth\n\nfunc TestValidateToken() {}\n",
+		"web/api.ts":         "export function login() {}\n",
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"README.md":          "export function notCode() {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(kb.ProjectPath, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+
+	if count := kb.ExtractSymbols(); count != 2 {
+		t.Errorf("expected the symbols of 2 files, got %d: %+v", count, kb.Symbols)
+	}
+	if symbols := kb.Symbols["auth/token.go"]; len(symbols) != 1 || symbols[0].Name != "ValidateToken" {
+		t.Errorf("expected the exported function of auth/token.go, got %+v", kb.Symbols)
+	}
+
+	summary := kb.getContextSummary("Where is the token validated?", 4000)
+	if !strings.Contains(summary, "Symboles Exportés") || !strings.Contains(summary, "- auth/token.go: ValidateToken (func, l.3)") {
+		t.Errorf("expected the symbol map in the summary, got:\n%s", summary)
+	}
+	if token, api := strings.Index(summary, "- auth/token.go:"), strings.Index(summary, "- web/api.ts:"); api < token {
+		t.Errorf("expected the file matching the question to come first, got:\n%s", summary)
+	}
+}
+
+func TestFormatSymbols_Truncated(t *testing.T) {
+	var symbols []Symbol
+	for i := range maxListedSymbols + 2 {
+		symbols = append(symbols, Symbol{Name: "F", Kind: symbolFunc, Line: i + 1})
+	}
+	if got := formatSymbols(symbols); !strings.HasSuffix(got, "... et 2 autres") {
+		t.Errorf("expected the symbols over the limit to be counted, got %q", got)
+	}
+}
+
+func TestRunStreamingAnalysis_SymbolsStep(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	config.AppConfig.Analysis.ExtractSymbols = true
+	if err := os.WriteFile(filepath.Join(projectDir, "handler.go"), []byte("package main\n\nfunc Handle() {}\n"), 0644); err != nil {
+		t.Fatalf("could not write handler.go: %v", err)
+	}
+
+	sequence, _ := runStreamingEngine(t, projectDir, &fakeLLMClient{projectType: "Go CLI", chunks: []string{"In handler.go"}})
+	if !slices.Contains(sequence, "step/symbols") {
+		t.Errorf("expected a symbols step, got %v", sequence)
+	}
+}
+
+func TestRunAnalysis_ExtractSymbols(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	config.AppConfig.Analysis.ExtractSymbols = true
+	if err := os.WriteFile(filepath.Join(projectDir, "handler.go"), []byte("package main\n\nfunc Handle() {}\n"), 0644); err != nil {
+		t.Fatalf("could not write handler.go: %v", err)
+	}
+	client := &fakeLLMClient{projectType: "Go CLI", answer: "In handler.go"}
+	engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where is Handle?"}, client)
+
+	if _, err := engine.RunAnalysis(); err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+	if !strings.Contains(client.finalPrompt, "- handler.go: Handle (func, l.3)") {
+		t.Errorf("expected the symbol map in the final prompt, got:\n%s", client.finalPrompt)
+	}
+}
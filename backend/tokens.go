@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsPerToken est le nombre moyen de caractères par token pour du code et
+// de l'anglais avec les tokenizers BPE courants. L'estimation est grossière
+// mais suffit à rester sous la fenêtre de contexte du modèle.
+const charsPerToken = 4
+
+// promptOverheadTokens est réservé aux instructions qui entourent le résumé du
+// contexte dans les prompts (format du plan, consignes de réponse...).
+const promptOverheadTokens = 500
+
+// estimateTokens estime le nombre de tokens de text.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// promptTokenBudget retourne le nombre maximal de tokens d'un prompt :
+// analysis.max_context_tokens, ou à défaut analysis.max_prompt_length converti
+// en tokens.
+func promptTokenBudget(cfg *config.Config) int {
+	analysis := cfg.Analysis
+	if analysis.MaxContextTokens > 0 {
+		return analysis.MaxContextTokens
+	}
+	return analysis.MaxPromptLength / charsPerToken
+}
+
+// contextTokenBudget retourne le budget du résumé du contexte, une fois
+// réservée la place des instructions du prompt.
+func contextTokenBudget(cfg *config.Config) int {
+	budget := promptTokenBudget(cfg) - promptOverheadTokens
+	if budget < promptOverheadTokens {
+		budget = promptOverheadTokens
+	}
+	return budget
+}
+
+// truncateToTokens tronque text pour qu'il tienne dans maxTokens, de
+// préférence sur une fin de mot plutôt qu'au milieu.
+func truncateToTokens(text string, maxTokens int) string {
+	maxChars := maxTokens * charsPerToken
+	if maxChars <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= maxChars {
+		return text
+	}
+
+	// Position en octets du maxChars-ième caractère
+	cut, count := 0, 0
+	for i := range text {
+		if count == maxChars {
+			cut = i
+			break
+		}
+		count++
+	}
+
+	truncated := text[:cut]
+	if space := strings.LastIndexFunc(truncated, unicode.IsSpace); space > cut*4/5 {
+		truncated = truncated[:space]
+	}
+	return truncated
+}
+
+// estimateMessagesTokens estime le nombre de tokens d'une conversation.
+func estimateMessagesTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg.Content)
+	}
+	return total
+}
+
+// fitConversation adapte une conversation au budget de tokens : les messages
+// les plus anciens (hors message système) sont retirés, puis le dernier
+// message est tronqué s'il dépasse encore à lui seul.
+func fitConversation(ctx context.Context, messages []ChatMessage) []ChatMessage {
+	budget := promptTokenBudget(config.FromContext(ctx))
+	fitted := append([]ChatMessage(nil), messages...)
+	first := 0
+	if len(fitted) > 0 && fitted[0].Role == "system" {
+		first = 1
+	}
+
+	for estimateMessagesTokens(fitted) > budget && len(fitted) > first+1 {
+		fitted = append(fitted[:first], fitted[first+1:]...)
+	}
+	if dropped := len(messages) - len(fitted); dropped > 0 {
+		requestLogger(ctx).Warnf("Dropped the %d oldest message(s) of the conversation to fit in %d tokens.", dropped, budget)
+	}
+
+	if total := estimateMessagesTokens(fitted); total > budget {
+		last := &fitted[len(fitted)-1]
+		remaining := budget - (total - estimateTokens(last.Content))
+		requestLogger(ctx).Warnf("Prompt is being truncated from ~%d to %d tokens.", estimateTokens(last.Content), remaining)
+		last.Content = truncateToTokens(last.Content, remaining)
+	}
+	return fitted
+}
+
+// logPromptTokens journalise la taille estimée d'une requête au modèle.
+func logPromptTokens(ctx context.Context, provider string, tokens int) {
+	requestLogger(ctx).Infof("Sending ~%d tokens to %s (budget: %d)", tokens, provider, promptTokenBudget(config.FromContext(ctx)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"debugagent/config"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"éééé", 1}, // Characters, not bytes
+	}
+	for _, tc := range testCases {
+		if got := estimateTokens(tc.text); got != tc.expected {
+			t.Errorf("estimateTokens(%q): expected %d, got %d", tc.text, tc.expected, got)
+		}
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog"
+
+	if got := truncateToTokens(text, 100); got != text {
+		t.Errorf("expected a short text to be kept, got %q", got)
+	}
+	// 5 tokens = 20 characters, cut back to the last word boundary
+	if got := truncateToTokens(text, 5); got != "The quick brown fox" {
+		t.Errorf("expected truncation on a word boundary, got %q", got)
+	}
+	// Without a nearby space, the text is cut at the limit
+	if got := truncateToTokens(strings.Repeat("x", 50), 5); got != strings.Repeat("x", 20) {
+		t.Errorf("expected a hard cut at 20 characters, got %q", got)
+	}
+	if got := truncateToTokens("ééééééééé", 1); got != "éééé" {
+		t.Errorf("expected multi-byte characters to be kept whole, got %q", got)
+	}
+}
+
+func TestPromptTokenBudget(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{MaxPromptLength: 8000}}
+	if got := promptTokenBudget(config.AppConfig); got != 2000 {
+		t.Errorf("expected max_prompt_length to be converted to 2000 tokens, got %d", got)
+	}
+	config.AppConfig.Analysis.MaxContextTokens = 4096
+	if got := promptTokenBudget(config.AppConfig); got != 4096 {
+		t.Errorf("expected max_context_tokens to take precedence, got %d", got)
+	}
+}
+
+func TestFitConversation(t *testing.T) {
+	config.AppConfig = &config.Config{Analysis: config.AnalysisConfig{MaxContextTokens: 100}}
+	messages := []ChatMessage{
+		{Role: "system", Content: "You are an assistant."},
+		{Role: "user", Content: strings.Repeat("old ", 100)},
+		{Role: "assistant", Content: strings.Repeat("answer ", 20)},
+		{Role: "user", Content: "latest question"},
+	}
+
+	fitted := fitConversation(context.Background(), messages)
+	if estimateMessagesTokens(fitted) > 100 {
+		t.Errorf("expected the conversation to fit in 100 tokens, got ~%d", estimateMessagesTokens(fitted))
+	}
+	if len(fitted) != 3 || fitted[0].Role != "system" || fitted[len(fitted)-1].Content != "latest question" {
+		t.Errorf("expected the oldest message to be dropped, got %+v", fitted)
+	}
+	if len(messages) != 4 || messages[1].Role != "user" {
+		t.Error("the original conversation must not be modified")
+	}
+
+	// A single oversized message is truncated
+	fitted = fitConversation(context.Background(), []ChatMessage{
+		{Role: "system", Content: "You are an assistant."},
+		{Role: "user", Content: strings.Repeat("long ", 200)},
+	})
+	if len(fitted) != 2 || estimateMessagesTokens(fitted) > 100 {
+		t.Errorf("expected the last message to be truncated to fit, got ~%d tokens", estimateMessagesTokens(fitted))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Each exploration iteration is logged as one structured entry: the plan
+// parsed from the planner, the outcome of every step and the notes the steps
+// added to the knowledge base. With analysis.trace_dir set, the entries are
+// also appended to a JSON Lines file per analysis, to replay what the agent
+// did after a bad answer. The entries are also kept in memory, for the
+// "verbose" requests that get them back along with the answer.
+
+// Outcomes of a plan step.
+const (
+	stepSucceeded = "succeeded"
+	stepFailed    = "failed"
+	stepCancelled = "cancelled"
+)
+
+// StepTrace is the outcome of one step of an exploration plan.
+type StepTrace struct {
+	Step   string   `json:"step"`
+	Status string   `json:"status"` // stepSucceeded, stepFailed or stepCancelled
+	Error  string   `json:"error,omitempty"`
+	Notes  []string `json:"notes,omitempty"` // Notes the step added to the knowledge base
+}
+
+// newStepTrace returns the outcome of step, which returned err and added notes.
+func newStepTrace(step string, err error, notes []string) StepTrace {
+	trace := StepTrace{Step: step, Status: stepSucceeded, Notes: notes}
+	if err != nil {
+		trace.Status = stepFailed
+		if isCancellation(err) {
+			trace.Status = stepCancelled
+		}
+		trace.Error = err.Error()
+	}
+	return trace
+}
+
+// IterationTrace is what the agent did in one exploration iteration. Steps
+// only holds the steps executed: the ones over analysis.max_steps_per_plan or
+// after a cancellation are in Plan only. An empty plan ended the exploration.
+type IterationTrace struct {
+	Iteration     int         `json:"iteration"`
+	Plan          []PlanStep  `json:"plan"`
+	Steps         []StepTrace `json:"steps,omitempty"`
+	PlanningError string      `json:"planning_error,omitempty"`
+}
+
+// planTracer records the IterationTrace of an analysis.
+type planTracer struct {
+	log        *logrus.Entry
+	path       string           // Trace file, empty when the iterations are only logged
+	iterations []IterationTrace // Iterations recorded so far, see recorded
+}
+
+// newPlanTracer returns the tracer of the analysis of ctx. dir is
+// analysis.trace_dir: the trace file is named after the start time and the
+// request ID of the analysis, so that it can be found from the logs.
+func newPlanTracer(ctx context.Context, log *logrus.Entry, dir string) *planTracer {
+	tracer := &planTracer{log: log}
+	if dir != "" {
+		id := requestIDFromContext(ctx)
+		if id == "" {
+			id = newRequestID()
+		}
+		tracer.path = filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", time.Now().Format("20060102-150405"), id))
+	}
+	return tracer
+}
+
+// record logs the iteration and appends it to the trace file. A tracer that
+// fails to write stops trying, the analysis goes on. A nil tracer does nothing.
+func (t *planTracer) record(iteration IterationTrace) {
+	if t == nil {
+		return
+	}
+	t.iterations = append(t.iterations, iteration)
+	succeeded, failed := 0, 0
+	for _, step := range iteration.Steps {
+		switch step.Status {
+		case stepSucceeded:
+			succeeded++
+		case stepFailed:
+			failed++
+		}
+	}
+	fields := logrus.Fields{
+		"iteration": iteration.Iteration,
+		"plan":      iteration.Plan,
+		"steps":     iteration.Steps,
+		"succeeded": succeeded,
+		"failed":    failed,
+	}
+	if iteration.PlanningError != "" {
+		fields["planning_error"] = iteration.PlanningError
+	}
+	t.log.WithFields(fields).Info("Exploration iteration traced")
+
+	if t.path == "" {
+		return
+	}
+	if err := appendTrace(t.path, iteration); err != nil {
+		t.log.Warnf("Could not write the plan trace %s, disabling it: %v", t.path, err)
+		t.path = ""
+	}
+}
+
+// recorded returns the iterations recorded so far, nil for a nil tracer.
+func (t *planTracer) recorded() []IterationTrace {
+	if t == nil {
+		return nil
+	}
+	return slices.Clone(t.iterations)
+}
+
+// appendTrace appends iteration as a JSON line to the file path.
+func appendTrace(path string, iteration IterationTrace) error {
+	line, err := json.Marshal(iteration)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"debugagent/config"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTrace decodes the single trace file written in dir.
+func readTrace(t *testing.T, dir string) []IterationTrace {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "*-trace-test.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expected a single trace file named after the request ID, got %v", files)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("could not open the trace: %v", err)
+	}
+	defer file.Close()
+
+	var iterations []IterationTrace
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var iteration IterationTrace
+		if err := json.Unmarshal(scanner.Bytes(), &iteration); err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		iterations = append(iterations, iteration)
+	}
+	return iterations
+}
+
+func TestRunAnalysis_WritesPlanTrace(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	traceDir := t.TempDir()
+	config.AppConfig.Analysis.TraceDir = traceDir
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		answer:      "The entry point is in main.go",
+		plans:       []string{`[{"action": "READ_FILE", "argument": "../outside.go", "reason": "the shared helpers"}, {"action": "SEARCH", "argument": "func main"}]`},
+	}
+	ctx := withRequestID(context.Background(), "trace-test")
+	engine := NewAnalysisEngineWithClient(ctx, AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"}, client)
+
+	if _, err := engine.RunAnalysis(); err != nil {
+		t.Fatalf("RunAnalysis() returned error: %v", err)
+	}
+
+	iterations := readTrace(t, traceDir)
+	if len(iterations) != 2 {
+		t.Fatalf("expected the plan and the empty plan ending the exploration, got %+v", iterations)
+	}
+	first := iterations[0]
+	if first.Iteration != 1 || len(first.Plan) != 2 || first.Plan[0] != (PlanStep{Action: "READ_FILE", Argument: "../outside.go", Reason: "the shared helpers"}) {
+		t.Errorf("expected the parsed plan and its reasons in the first iteration, got %+v", first)
+	}
+	if len(first.Steps) != 2 {
+		t.Fatalf("expected the outcome of both steps, got %+v", first.Steps)
+	}
+	if read := first.Steps[0]; read.Status != stepFailed || read.Error == "" || len(read.Notes) == 0 || !strings.Contains(read.Notes[0], "Refused step") {
+		t.Errorf("expected the read outside the project to fail with its note, got %+v", read)
+	}
+	if search := first.Steps[1]; search.Status != stepSucceeded || len(search.Notes) != 1 || !strings.Contains(search.Notes[0], "main.go:3: func main() {}") {
+		t.Errorf("expected the search to succeed with its matches, got %+v", search)
+	}
+	if last := iterations[1]; last.Iteration != 2 || last.Plan == nil || len(last.Plan) != 0 {
+		t.Errorf("expected an empty plan in the last iteration, got %+v", last)
+	}
+}
+
+func TestRunStreamingAnalysis_WritesPlanTrace(t *testing.T) {
+	projectDir := setupStreamingEngineTest(t, false)
+	traceDir := t.TempDir()
+	config.AppConfig.Analysis.TraceDir = traceDir
+	client := &fakeLLMClient{
+		projectType: "Go CLI",
+		chunks:      []string{"In main.go"},
+		plans:       []string{`[{"action": "READ_FILE", "argument": "main.go"}]`},
+	}
+	ctx := withRequestID(context.Background(), "trace-test")
+	engine := NewStreamingAnalysisEngineWithClient(ctx, AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?"}, client)
+	engine.RunStreamingAnalysis(sseSink{httptest.NewRecorder()})
+
+	iterations := readTrace(t, traceDir)
+	if len(iterations) == 0 || len(iterations[0].Steps) != 1 || iterations[0].Steps[0].Status != stepSucceeded {
+		t.Errorf("expected the read of main.go to succeed in the trace, got %+v", iterations)
+	}
+}
+
+func TestRunAnalysis_VerboseTrace(t *testing.T) {
+	for _, verbose := range []bool{true, false} {
+		projectDir := setupStreamingEngineTest(t, false)
+		client := &fakeLLMClient{
+			projectType: "Go CLI",
+			answer:      "The entry point is in main.go",
+			plans:       []string{`[{"action": "READ_FILE", "argument": "main.go", "reason": "the entry point"}]`},
+		}
+		engine := NewAnalysisEngineWithClient(context.Background(), AnalyzeRequest{ProjectPath: projectDir, Question: "Where does it start?", Verbose: verbose}, client)
+
+		result, err := engine.RunAnalysis()
+		if err != nil {
+			t.Fatalf("RunAnalysis() returned error: %v", err)
+		}
+		if !verbose {
+			if result.Trace != nil {
+				t.Errorf("expected no trace without verbose, got %+v", result.Trace)
+			}
+			continue
+		}
+		if len(result.Trace) != 2 {
+			t.Fatalf("expected the plan and the empty plan ending the exploration, got %+v", result.Trace)
+		}
+		first := result.Trace[0]
+		if len(first.Plan) != 1 || first.Plan[0].Reason != "the entry point" || len(first.Steps) != 1 || first.Steps[0].Status != stepSucceeded {
+			t.Errorf("expected the read of main.go and its outcome in the trace, got %+v", first)
+		}
+
+		// The trace is part of the JSON response
+		body, _ := json.Marshal(AnalyzeResponse{Answer: result.Answer, ExplorationTrace: result.Trace})
+		if !strings.Contains(string(body), `"exploration_trace":[{"iteration":1,"plan":[{"action":"READ_FILE"`) {
+			t.Errorf("expected the exploration_trace field, got %s", body)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// projectUpload is the project of an analysis request sent as a multipart
+// form: the uploaded files or archive saved to a temporary directory, or the
+// local directory of a project_path.
+type projectUpload struct {
+	Dir        string // Project directory
+	Skipped    int    // Uploaded files dropped by explorer.ignore_dirs and ignore_extensions
+	SingleFile bool   // A single file was uploaded, see isSingleFileUpload
+	Message    string // What was received, for the progress events
+	temp       bool   // Dir was created for the upload and is removed by remove
+}
+
+// uploadError is an upload refused by receiveUpload, with the status and the
+// code of the API error to answer.
+type uploadError struct {
+	status int
+	code   string
+	err    error
+}
+
+func (e *uploadError) Error() string {
+	return e.err.Error()
+}
+
+// receiveUpload stores the project of a request whose form parseUploadForm
+// parsed. A single archive takes precedence over individual files; a
+// project_path is read in place instead, see localProjectPath. The caller
+// removes the project with remove once the analysis is over.
+func receiveUpload(r *http.Request) (*projectUpload, *uploadError) {
+	files := r.MultipartForm.File["files"]
+	archives := r.MultipartForm.File["archive"]
+
+	// A project_path is read in place: nothing is copied, nor removed afterwards
+	if projectPath := r.FormValue("project_path"); projectPath != "" {
+		if len(files) > 0 || len(archives) > 0 {
+			return nil, &uploadError{http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("Invalid 'project_path' field, send either a project path or files")}
+		}
+		dir, status, code, err := localProjectPath(projectPath)
+		if err != nil {
+			return nil, &uploadError{status, code, err}
+		}
+		return &projectUpload{Dir: dir, Message: fmt.Sprintf("Reading local project '%s'", projectPath)}, nil
+	}
+
+	if len(files) == 0 && len(archives) == 0 {
+		return nil, &uploadError{http.StatusBadRequest, ErrCodeNoFiles, fmt.Errorf("No files uploaded")}
+	}
+
+	// Create a temporary directory to store the uploaded files
+	tempDir, err := os.MkdirTemp("", "uploaded-project-")
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, ErrCodeInternal, fmt.Errorf("Error creating temporary directory")}
+	}
+	upload := &projectUpload{
+		Dir:        tempDir,
+		SingleFile: isSingleFileUpload(len(files), len(archives)),
+		Message:    fmt.Sprintf("Processing %d uploaded files...", len(files)),
+		temp:       true,
+	}
+
+	if len(archives) > 0 {
+		if upload.Skipped, err = extractArchive(archives[0], tempDir); err != nil {
+			upload.remove()
+			return nil, &uploadError{archiveErrorStatus(err), archiveErrorCode(err), fmt.Errorf("Error extracting archive: %v", err)}
+		}
+		upload.Message = fmt.Sprintf("Extracted archive '%s'", archives[0].Filename)
+		files = nil
+	}
+
+	// Reject the whole upload before writing anything if a name escapes tempDir
+	if err := validateUploadPaths(tempDir, files); err != nil {
+		upload.remove()
+		return nil, &uploadError{http.StatusBadRequest, ErrCodeInvalidFileName, err}
+	}
+	skipped, err := saveUploadedFiles(tempDir, files)
+	if err != nil {
+		upload.remove()
+		return nil, &uploadError{http.StatusInternalServerError, ErrCodeInternal, err}
+	}
+	upload.Skipped += skipped
+	return upload, nil
+}
+
+// remove deletes the project of an upload, unless it is a local project_path.
+func (u *projectUpload) remove() {
+	if u.temp {
+		os.RemoveAll(u.Dir)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Minimal RFC 6455 server side, enough to stream progress events to a browser:
+// no extensions, no subprotocols. Frames from the client must be masked.
+
+// webSocketGUID is appended to the client key to compute the handshake answer.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooLarge      = 1009
+)
+
+// wsMaxMessageBytes bounds a message whatever server.max_upload_bytes says,
+// unlimited included: the request and the files it carries are kept in memory.
+const wsMaxMessageBytes = 128 << 20
+
+// ErrWebSocketClosed is returned by readMessage once the client closed the connection.
+var ErrWebSocketClosed = errors.New("websocket closed by the client")
+
+// errWebSocketTooLarge is returned for messages above the read limit.
+var errWebSocketTooLarge = errors.New("websocket message too large")
+
+// wsConn is an established WebSocket connection. Writes are serialized so
+// that the analysis and the answers to pings can share the connection.
+type wsConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeMu   sync.Mutex
+	readLimit int64 // Maximum size of a message, 0 means wsMaxMessageBytes
+}
+
+// upgradeWebSocket performs the opening handshake. On failure, an HTTP error
+// has already been written to the client.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeUnsupportedProtocol, "Expected a WebSocket upgrade request")
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, http.StatusUpgradeRequired, ErrCodeUnsupportedProtocol, "Unsupported WebSocket version")
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeUnsupportedProtocol, "Missing Sec-WebSocket-Key header")
+		return nil, errors.New("missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "WebSocket not supported")
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("could not take over the connection: %w", err)
+	}
+
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not complete the websocket handshake: %w", err)
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// maxMessageBytes returns the maximum size of a message: readLimit, within
+// wsMaxMessageBytes.
+func (c *wsConn) maxMessageBytes() int64 {
+	if c.readLimit > 0 && c.readLimit < wsMaxMessageBytes {
+		return c.readLimit
+	}
+	return wsMaxMessageBytes
+}
+
+// headerHasToken reports whether the comma-separated header contains token.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message, reassembling
+// fragments. Pings are answered and pongs ignored; a close frame is
+// acknowledged and reported as ErrWebSocketClosed. Messages above
+// maxMessageBytes fail with errWebSocketTooLarge, leaving the caller to explain and close.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code, "")
+			return nil, ErrWebSocketClosed
+		case wsText, wsBinary, wsContinuation:
+			if (opcode == wsContinuation) != (message != nil) {
+				c.close(wsCloseProtocolError, "unexpected continuation frame")
+				return nil, errors.New("unexpected websocket continuation frame")
+			}
+			if int64(len(message)+len(payload)) > c.maxMessageBytes() {
+				return nil, errWebSocketTooLarge
+			}
+			if message == nil {
+				message = []byte{}
+			}
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			c.close(wsCloseProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("unknown websocket opcode %d", opcode)
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks its payload. The payload is read
+// as it arrives rather than allocated from the announced length, which the
+// client controls.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		c.close(wsCloseProtocolError, "client frames must be masked")
+		return false, 0, nil, errors.New("unmasked websocket frame from the client")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > uint64(c.maxMessageBytes()) {
+		return false, 0, nil, errWebSocketTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	var buffer bytes.Buffer
+	if _, err := io.CopyN(&buffer, c.reader, int64(length)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, 0, nil, err
+	}
+	payload = buffer.Bytes()
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeJSON sends value as a text message.
+func (c *wsConn) writeJSON(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, data)
+}
+
+// close sends a close frame (errors are ignored, the peer may already be gone)
+// and closes the connection.
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// wsSink sends progress events as WebSocket text messages. The handshake
+// answer carries no custom header, so requestID is only sent in the events.
+type wsSink struct {
+	conn      *wsConn
+	requestID string
+}
+
+func (s wsSink) send(event ProgressEvent) {
+	event.RequestID = s.requestID
+	s.conn.writeJSON(event)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestClient is a minimal client side of the WebSocket protocol.
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWebSocket performs the opening handshake against server.
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Sample key and answer from RFC 6455, section 1.3
+	conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("could not read the handshake answer: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept '%s'", accept)
+	}
+	return &wsTestClient{conn: conn, reader: reader}
+}
+
+// writeFrame sends a masked frame, as clients must.
+func (c *wsTestClient) writeFrame(fin bool, opcode byte, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+// readFrame reads an unmasked frame from the server.
+func (c *wsTestClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatalf("could not read a frame: %v", err)
+	}
+	length := int(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint64(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatalf("could not read a frame payload: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// readEvents collects the progress events until the server closes the socket.
+func (c *wsTestClient) readEvents(t *testing.T) []ProgressEvent {
+	t.Helper()
+	var events []ProgressEvent
+	for {
+		opcode, payload := c.readFrame(t)
+		if opcode == wsClose {
+			return events
+		}
+		var event ProgressEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("invalid event %q: %v", payload, err)
+		}
+		events = append(events, event)
+	}
+}
+
+func TestWebSocket_Frames(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			t.Errorf("upgradeWebSocket() returned error: %v", err)
+			return
+		}
+		defer conn.close(wsCloseNormal, "")
+		message, err := conn.readMessage()
+		if err != nil {
+			t.Errorf("readMessage() returned error: %v", err)
+			return
+		}
+		received <- string(message)
+		conn.writeJSON(ProgressEvent{Type: "progress", Message: strings.Repeat("x", 300)})
+		if _, err := conn.readMessage(); err != ErrWebSocketClosed {
+			t.Errorf("expected ErrWebSocketClosed, got %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client := dialWebSocket(t, server, "/")
+	// A fragmented message with a ping in between
+	client.writeFrame(false, wsText, []byte("Hello, "))
+	client.writeFrame(true, wsPing, []byte("ping"))
+	client.writeFrame(true, wsContinuation, []byte("World"))
+
+	if opcode, payload := client.readFrame(t); opcode != wsPong || string(payload) != "ping" {
+		t.Errorf("expected a pong echoing the ping, got opcode %d with %q", opcode, payload)
+	}
+	if message := <-received; message != "Hello, World" {
+		t.Errorf("expected the fragments to be reassembled, got %q", message)
+	}
+	if opcode, payload := client.readFrame(t); opcode != wsText || !strings.Contains(string(payload), strings.Repeat("x", 300)) {
+		t.Errorf("expected a text frame with the event, got opcode %d", opcode)
+	}
+
+	client.writeFrame(true, wsClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	if opcode, _ := client.readFrame(t); opcode != wsClose {
+		t.Errorf("expected the close frame to be acknowledged, got opcode %d", opcode)
+	}
+}
+
+func TestUpgradeWebSocket_RejectsPlainRequests(t *testing.T) {
+	rr := httptest.NewRecorder()
+	analyzeWSHandler(rr, httptest.NewRequest(http.MethodGet, "/analyze-ws", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestAnalyzeWSHandler_RejectsInvalidRequests(t *testing.T) {
+	setupUploadTest(0, 1)
+	server := httptest.NewServer(http.HandlerFunc(analyzeWSHandler))
+	defer server.Close()
+
+	testCases := []struct {
+		request  string
+		expected string
+	}{
+		{`not json`, "Invalid analysis request"},
+		{`{"files":[{"path":"main.go","content":"package main"}]}`, "Missing 'question' field"},
+		{`{"question":"  ","files":[{"path":"main.go","content":"package main"}]}`, "Missing 'question' field"},
+		{`{"question":"Why?"}`, "No files uploaded"},
+		{`{"question":"Why?","files":[{"path":"a.go"},{"path":"b.go"}]}`, "Too many files"},
+		{`{"question":"Why?","files":[{"path":"../escape.go","content":"x"}]}`, "path escapes the upload directory"},
+	}
+
+	for _, tc := range testCases {
+		client := dialWebSocket(t, server, "/analyze-ws")
+		client.writeFrame(true, wsText, []byte(tc.request))
+		events := client.readEvents(t)
+		if len(events) == 0 || events[len(events)-1].Type != "error" || !strings.Contains(events[len(events)-1].Message, tc.expected) {
+			t.Errorf("request %s: expected an error event containing %q, got %+v", tc.request, tc.expected, events)
+		}
+	}
+}
+
+func TestAnalyzeWSHandler_RejectsHugeFrameHeaders(t *testing.T) {
+	setupUploadTest(0, 0) // Unlimited uploads
+	server := httptest.NewServer(http.HandlerFunc(analyzeWSHandler))
+	defer server.Close()
+
+	// A masked text frame announcing 2^63-1 bytes, without any payload
+	client := dialWebSocket(t, server, "/analyze-ws")
+	header := []byte{0x80 | wsText, 0x80 | 127}
+	header = binary.BigEndian.AppendUint64(header, 1<<63-1)
+	client.conn.Write(append(header, 1, 2, 3, 4))
+
+	opcode, payload := client.readFrame(t)
+	var event ProgressEvent
+	if err := json.Unmarshal(payload, &event); opcode != wsText || err != nil || event.Type != "error" || !strings.Contains(event.Message, "Upload too large") {
+		t.Fatalf("expected an error event about the size, got opcode %d: %s", opcode, payload)
+	}
+	opcode, payload = client.readFrame(t)
+	if opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseTooLarge {
+		t.Errorf("expected a close frame with code %d, got opcode %d: %v", wsCloseTooLarge, opcode, payload)
+	}
+}
+
+func TestAnalyzeWSHandler_StreamsEngineEvents(t *testing.T) {
+	setupOllamaTest(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model 'test-model' not found"}`, http.StatusNotFound)
+	})
+	server := httptest.NewServer(http.HandlerFunc(analyzeWSHandler))
+	defer server.Close()
+
+	client := dialWebSocket(t, server, "/analyze-ws")
+	client.writeFrame(true, wsText, []byte(`{"question":"What does this do?","files":[
+		{"path":"main.go","content":"package main"},
+		{"path":"README.md","content":"IyBEZW1v","encoding":"base64"}]}`))
+	events := client.readEvents(t)
+
+	if len(events) == 0 || events[0].Type != "progress" || events[0].Step != "upload" {
+		t.Fatalf("expected the upload progress event first, got %+v", events)
+	}
+	var initial, final bool
+	for _, event := range events {
+		initial = initial || (event.Type == "progress" && event.Step == "initial")
+		final = final || (event.Type == "error" && event.Step == "final")
+	}
+	if !initial || !final {
+		t.Errorf("expected the engine events up to the final answer error, got %+v", events)
+	}
+}
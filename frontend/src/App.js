@@ -2,10 +2,12 @@ import React, { useState, useRef, useEffect } from 'react';
 import { useTranslation } from 'react-i18next';
 
 function App() {
-  const { t } = useTranslation();
+  const { t, i18n } = useTranslation();
   const [question, setQuestion] = useState('');
   const [files, setFiles] = useState([]);
   const [answer, setAnswer] = useState('');
+  const [sources, setSources] = useState([]);
+  const [assessment, setAssessment] = useState(null);
   const [loading, setLoading] = useState(false);
   const [uploadProgress, setUploadProgress] = useState(0);
   const [isUploading, setIsUploading] = useState(false);
@@ -17,6 +19,7 @@ function App() {
   const fileInputRef = useRef();
   const eventSourceRef = useRef(null);
   const abortControllerRef = useRef(null);
+  const analysisIdRef = useRef(null);
 
   // Cleanup on component unmount
   useEffect(() => {
@@ -73,6 +76,8 @@ function App() {
 
     // Reset states
     setAnswer('');
+    setSources([]);
+    setAssessment(null);
     setStreamingProgress([]);
     setCurrentStep('');
     setAnalysisComplete(false);
@@ -84,10 +89,13 @@ function App() {
 
     // Create abort controller for this request
     abortControllerRef.current = new AbortController();
+    analysisIdRef.current = null;
 
     // First, upload files using regular POST
     const formData = new FormData();
     formData.append('question', question);
+    // The answer is written in the language of the interface
+    formData.append('language', i18n.language);
     for (const file of files) {
       formData.append('files', file, file.webkitRelativePath || file.name);
     }
@@ -100,7 +108,19 @@ function App() {
     })
     .then(response => {
       if (!response.ok) {
-        throw new Error(`HTTP error! status: ${response.status}`);
+        // The server explains rejected uploads (size, file count...) in an SSE error event
+        return response.text().then(body => {
+          let message = `HTTP error! status: ${response.status}`;
+          const line = body.split('\n').find(l => l.startsWith('data: '));
+          try {
+            if (line) message = JSON.parse(line.slice(6)).message || message;
+          } catch (e) {
+            // Keep the generic status message
+          }
+          const rejection = new Error(message);
+          rejection.rejected = true; // Resending the same upload would fail again
+          throw rejection;
+        });
       }
       
       setIsUploading(false);
@@ -158,7 +178,7 @@ function App() {
     setIsUploading(false);
 
     // Only close modal on critical errors, not transient ones
-    if (retryCount < 3 && !error.message.includes('abort')) {
+    if (retryCount < 3 && !error.rejected && !error.message.includes('abort')) {
       setRetryCount(prev => prev + 1);
       setCurrentStep(`Connection issue, retrying... (${retryCount + 1}/3)`);
 
@@ -174,19 +194,43 @@ function App() {
   };
 
   const handleStreamEvent = (eventData) => {
+    // Tokens of the final answer are appended as they arrive, not listed as progress
+    if (eventData.type === 'token') {
+      setAnswer(prev => prev + eventData.data);
+      return;
+    }
+
     console.log('Stream event:', eventData);
-    
+
     setStreamingProgress(prev => [...prev, eventData]);
     
     switch (eventData.type) {
+      case 'started':
+        // ID to send to /cancel when the user stops the analysis
+        analysisIdRef.current = eventData.data;
+        break;
+      case 'cancelled':
+        setCurrentStep('Analysis cancelled');
+        setLoading(false);
+        setShowAnalysisModal(false);
+        break;
       case 'progress':
         setCurrentStep(`${eventData.message}`);
         break;
       case 'step':
         setCurrentStep(`${eventData.message}`);
         break;
+      case 'finding':
+        // Intermediate analysis, its text is shown in the progress list
+        setCurrentStep(`${eventData.message}`);
+        break;
+      case 'queued':
+        setCurrentStep(`${eventData.message}`);
+        break;
       case 'result':
         setAnswer(eventData.data);
+        setSources(eventData.sources || []);
+        setAssessment(eventData.confidence ? { confidence: eventData.confidence, unresolved: eventData.unresolved } : null);
         setCurrentStep('Analysis completed!');
         setAnalysisComplete(true);
         setLoading(false);
@@ -331,6 +375,11 @@ function App() {
                   onClick={() => {
                     setShowAnalysisModal(false);
                     setLoading(false);
+                    // Ask the backend to stop, closing the stream alone is not always noticed
+                    if (analysisIdRef.current) {
+                      fetch(`http://localhost:8080/cancel/${analysisIdRef.current}`, { method: 'POST' }).catch(() => {});
+                      analysisIdRef.current = null;
+                    }
                     if (abortControllerRef.current) {
                       abortControllerRef.current.abort();
                     }
@@ -357,12 +406,16 @@ function App() {
                         event.type === 'error' ? 'bg-red-50 text-red-700 border-l-4 border-red-400' :
                         event.type === 'result' ? 'bg-green-50 text-green-700 border-l-4 border-green-400' :
                         event.type === 'step' ? 'bg-blue-50 text-blue-700 border-l-4 border-blue-400' :
+                        event.type === 'finding' ? 'bg-indigo-50 text-indigo-700 border-l-4 border-indigo-400' :
                         'bg-gray-50 text-gray-700 border-l-4 border-gray-400'
                       }`}>
                         {event.iteration > 0 && (
                           <span className="text-gray-500 text-xs mr-2 font-mono">[{event.iteration}/{event.total}]</span>
                         )}
                         <span className="font-semibold">{event.step}:</span> {event.message}
+                        {event.type === 'finding' && event.data && (
+                          <p className="mt-1 text-sm text-gray-700 whitespace-pre-wrap">{event.data}</p>
+                        )}
                       </div>
                     ))}
                   </div>
@@ -384,6 +437,31 @@ function App() {
               <div className="prose prose-sm max-w-none">
                 <p className="text-gray-800 leading-relaxed whitespace-pre-wrap">{answer}</p>
               </div>
+              {assessment && (
+                <div className="mt-4 pt-4 border-t border-green-200 text-sm text-gray-700">
+                  <p>
+                    <span className="font-semibold">{t('confidence')}:</span> {t(`confidenceLevels.${assessment.confidence}`)}
+                  </p>
+                  {assessment.unresolved && (
+                    <p className="mt-1">
+                      <span className="font-semibold">{t('unresolved')}:</span> {assessment.unresolved}
+                    </p>
+                  )}
+                </div>
+              )}
+              {sources.length > 0 && (
+                <div className="mt-4 pt-4 border-t border-green-200">
+                  <h3 className="text-sm font-semibold text-gray-700 mb-2">{t('sources')}</h3>
+                  <ul className="text-sm text-gray-600 space-y-1">
+                    {sources.map(source => (
+                      <li key={source.path} className="font-mono">
+                        {source.path}
+                        {source.cited && <span className="ml-2 text-xs text-green-700">({t('cited')})</span>}
+                      </li>
+                    ))}
+                  </ul>
+                </div>
+              )}
             </div>
           </div>
         )}